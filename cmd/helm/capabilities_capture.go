@@ -0,0 +1,73 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/action"
+)
+
+const capabilitiesCaptureHelp = `
+This command saves the Kubernetes version and the API versions of the current
+cluster to a file.
+
+    $ helm capabilities capture prod.yaml
+    $ helm template my-release ./chart --capabilities-file prod.yaml
+
+Objects read by the lookup function of a chart can be saved too, to be
+returned by lookup when the file is replayed, with
+'--object APIVERSION/KIND/NAMESPACE/NAME', leaving NAMESPACE empty for
+cluster-scoped objects:
+
+    $ helm capabilities capture prod.yaml --object v1/Secret/default/db-credentials
+
+As the saved objects may contain secret data, the file is only readable by its
+owner.
+`
+
+func newCapabilitiesCaptureCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	client := action.NewCaptureCapabilities(cfg)
+
+	cmd := &cobra.Command{
+		Use:   "capture FILE",
+		Short: "save the capabilities of the current cluster to a file",
+		Long:  capabilitiesCaptureHelp,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshot, err := client.Run()
+			if err != nil {
+				return err
+			}
+			if err := snapshot.Save(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Saved the capabilities of Kubernetes %s (%d API versions, %d objects) to %s\n",
+				snapshot.KubeVersion, len(snapshot.APIVersions), len(snapshot.Objects), args[0])
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringArrayVar(&client.Objects, "object", []string{}, "save an object for the lookup function, as APIVERSION/KIND/NAMESPACE/NAME (can specify multiple)")
+
+	return cmd
+}
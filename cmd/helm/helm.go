@@ -76,6 +76,7 @@ func main() {
 	// run when each command's execute method is called
 	cobra.OnInitialize(func() {
 		helmDriver := os.Getenv("HELM_DRIVER")
+		registerStorageDriverPlugin(helmDriver)
 		if err := actionConfig.Init(settings.RESTClientGetter(), settings.Namespace(), helmDriver, debug); err != nil {
 			log.Println(err)
 		}
@@ -108,7 +109,13 @@ func checkOCIFeatureGate() func(_ *cobra.Command, _ []string) error {
 
 // This function loads releases into the memory storage if the
 // environment variable is properly set.
+//
+// Deprecated: the releases loaded from HELM_MEMORY_DRIVER_DATA only live for
+// a single run. Use HELM_DRIVER=filesystem to keep releases in files.
 func loadReleasesInMemory(actionConfig *action.Configuration) {
+	if os.Getenv("HELM_MEMORY_DRIVER_DATA") != "" {
+		warning("HELM_MEMORY_DRIVER_DATA is deprecated, use HELM_DRIVER=filesystem to keep releases in files")
+	}
 	filePaths := strings.Split(os.Getenv("HELM_MEMORY_DRIVER_DATA"), ":")
 	if len(filePaths) == 0 {
 		return
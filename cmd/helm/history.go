@@ -49,6 +49,14 @@ The historical release set is printed as a formatted table, e.g:
     2           Mon Oct 3 10:15:13 2016     superseded      alpine-0.1.0      1.0             Upgraded successfully
     3           Mon Oct 3 10:15:13 2016     superseded      alpine-0.1.0      1.0             Rolled back to 2
     4           Mon Oct 3 10:15:13 2016     deployed        alpine-0.1.0      1.0             Upgraded successfully
+
+To compare two revisions of a release, use 'helm history diff':
+
+    $ helm history diff angry-bird 2 4
+
+To show the history of a release named "diff", separate its name with '--':
+
+    $ helm history -- diff
 `
 
 func newHistoryCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
@@ -81,6 +89,8 @@ func newHistoryCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	f.IntVar(&client.Max, "max", 256, "maximum number of revision to include in history")
 	bindOutputFlag(cmd, &outfmt)
 
+	cmd.AddCommand(newHistoryDiffCmd(cfg, out))
+
 	return cmd
 }
 
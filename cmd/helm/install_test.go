@@ -17,7 +17,9 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
@@ -58,6 +60,18 @@ func TestInstall(t *testing.T) {
 			cmd:    "install aeneas testdata/testcharts/empty --namespace default",
 			golden: "output/install.txt",
 		},
+		// Install, with user-defined labels
+		{
+			name:   "install with labels",
+			cmd:    "install aeneas testdata/testcharts/empty --namespace default --labels team=blue,tier=backend",
+			golden: "output/install.txt",
+		},
+		// Install, with a reserved label
+		{
+			name:      "install with reserved label",
+			cmd:       "install aeneas testdata/testcharts/empty --namespace default --labels owner=me",
+			wantError: true,
+		},
 
 		// Install, values from cli
 		{
@@ -297,3 +311,63 @@ func TestInstallFileCompletion(t *testing.T) {
 	checkFileCompletion(t, "install myname", true)
 	checkFileCompletion(t, "install myname mychart", false)
 }
+
+func TestInstallEnvironment(t *testing.T) {
+	chartPath, err := filepath.Abs("testdata/testcharts/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"environments.yaml": `layers:
+- name: base
+  files: [values.yaml]
+- name: env
+  files: ["values-${environment}.yaml"]
+  lists: append
+- name: cluster
+  files: ["clusters/${cluster}.yaml"]
+  optional: true
+environments:
+  prod:
+    cluster: prod-1
+`,
+		"values.yaml":      "replicas: 1\nhosts: [a]\n",
+		"values-prod.yaml": "replicas: 3\nhosts: [b]\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer testChdir(t, dir)()
+
+	_, out, err := executeActionCommand(fmt.Sprintf("install aeneas %s --environment prod -o json", chartPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rel struct {
+		Config       map[string]interface{} `json:"config"`
+		ValuesLayers []struct {
+			Name  string   `json:"name"`
+			Files []string `json:"files"`
+			Lists string   `json:"lists"`
+		} `json:"values_layers"`
+	}
+	if err := json.Unmarshal([]byte(out), &rel); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if rel.Config["replicas"] != float64(3) || fmt.Sprint(rel.Config["hosts"]) != "[a b]" {
+		t.Errorf("unexpected values %v", rel.Config)
+	}
+	if len(rel.ValuesLayers) != 2 || rel.ValuesLayers[0].Name != "base" || rel.ValuesLayers[1].Lists != "append" {
+		t.Fatalf("unexpected values layers %+v", rel.ValuesLayers)
+	}
+	if rel.ValuesLayers[1].Files[0] != "values-prod.yaml" {
+		t.Errorf("unexpected files of the env layer %v", rel.ValuesLayers[1].Files)
+	}
+
+	if _, _, err := executeActionCommand(fmt.Sprintf("install aeneas %s --environment staging", chartPath)); err == nil {
+		t.Error("expected an error for an undeclared environment")
+	}
+}
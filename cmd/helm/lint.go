@@ -43,6 +43,8 @@ or recommendation, it will emit [WARNING] messages.
 func newLintCmd(out io.Writer) *cobra.Command {
 	client := action.NewLint()
 	valueOpts := &values.Options{}
+	var capabilitiesFile string
+	var lookupFixtures []string
 
 	cmd := &cobra.Command{
 		Use:   "lint PATH",
@@ -68,7 +70,24 @@ func newLintCmd(out io.Writer) *cobra.Command {
 				}
 			}
 
+			var objects []map[string]interface{}
+			if capabilitiesFile != "" {
+				caps, snapshotObjects, err := loadCapabilitiesFile(capabilitiesFile)
+				if err != nil {
+					return err
+				}
+				client.Capabilities, objects = caps, snapshotObjects
+			}
+			lookupSource, err := newLookupSource(lookupFixtures, objects)
+			if err != nil {
+				return err
+			}
+			client.LookupSource = lookupSource
+
 			client.Namespace = settings.Namespace()
+			if len(paths) == 1 {
+				valueOpts.ChartPath = paths[0]
+			}
 			vals, err := valueOpts.MergeValues(getter.All(settings))
 			if err != nil {
 				return err
@@ -120,6 +139,8 @@ func newLintCmd(out io.Writer) *cobra.Command {
 	f := cmd.Flags()
 	f.BoolVar(&client.Strict, "strict", false, "fail on lint warnings")
 	f.BoolVar(&client.WithSubcharts, "with-subcharts", false, "lint dependent charts")
+	addCapabilitiesFileFlag(f, &capabilitiesFile)
+	addLookupFixturesFlag(f, &lookupFixtures)
 	addValueOptionsFlags(f, valueOpts)
 
 	return cmd
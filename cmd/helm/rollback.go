@@ -36,10 +36,15 @@ second is a revision (version) number. If this argument is omitted, it will
 roll back to the previous release.
 
 To see revision numbers, run 'helm history RELEASE'.
+
+Revisions installed or upgraded with encrypted values files cannot be rolled
+back to, since their decrypted values are not stored: run 'helm upgrade' with
+the encrypted values files of the revision instead.
 `
 
 func newRollbackCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	client := action.NewRollback(cfg)
+	var secretProviders []string
 
 	cmd := &cobra.Command{
 		Use:   "rollback <RELEASE> [REVISION]",
@@ -65,6 +70,9 @@ func newRollbackCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 				}
 				client.Version = ver
 			}
+			if err := setSecretProviders(cfg, secretProviders); err != nil {
+				return err
+			}
 
 			if err := client.Run(args[0]); err != nil {
 				return err
@@ -85,6 +93,7 @@ func newRollbackCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	f.BoolVar(&client.WaitForJobs, "wait-for-jobs", false, "if set and --wait enabled, will wait until all Jobs have been completed before marking the release as successful. It will wait for as long as --timeout")
 	f.BoolVar(&client.CleanupOnFail, "cleanup-on-fail", false, "allow deletion of new resources created in this rollback when rollback fails")
 	f.IntVar(&client.MaxHistory, "history-max", settings.MaxHistory, "limit the maximum number of revisions saved per release. Use 0 for no limit")
+	addSecretProviderFlag(f, &secretProviders)
 
 	return cmd
 }
@@ -47,19 +47,28 @@ Environment variables:
 
 | Name                               | Description                                                                       |
 |------------------------------------|-----------------------------------------------------------------------------------|
+| $HELM_AUDIT_ACTOR                  | set the actor recorded in audit records (default: the Kubernetes user)            |
+| $HELM_AUDIT_DIGEST_KEY             | set the key of the parameter digests in audit records, required to digest values  |
+| $HELM_AUDIT_SINK                   | set where audit records go: a JSONL file path, an http(s) URL or "storage"        |
 | $HELM_CACHE_HOME                   | set an alternative location for storing cached files.                             |
 | $HELM_CONFIG_HOME                  | set an alternative location for storing Helm configuration.                       |
 | $HELM_DATA_HOME                    | set an alternative location for storing Helm data.                                |
 | $HELM_DEBUG                        | indicate whether or not Helm is running in Debug mode                             |
-| $HELM_DRIVER                       | set the backend storage driver. Values are: configmap, secret, memory, postgres   |
-| $HELM_DRIVER_SQL_CONNECTION_STRING | set the connection string the SQL storage driver should use.                      |
+| $HELM_DRIVER                       | set the storage driver: configmap, secret, memory, sql, filesystem or a plugin    |
+| $HELM_DRIVER_SQL_CONNECTION_STRING | set the SQL driver connection string: PostgreSQL, mysql://... or sqlite://<path>  |
+| $HELM_DRIVER_ENCRYPTION_KEY        | set the comma-separated base64 encoded AES keys encrypting stored releases.       |
+| $HELM_DRIVER_ENCRYPTION_KEY_FILE   | set the path to a file holding the keys encrypting stored releases.               |
+| $HELM_DRIVER_FILESYSTEM_PATH       | set the directory of the filesystem driver (default "$HELM_DATA_HOME/releases")   |
 | $HELM_MAX_HISTORY                  | set the maximum number of helm release history.                                   |
 | $HELM_NAMESPACE                    | set the namespace used for the helm operations.                                   |
 | $HELM_NO_PLUGINS                   | disable plugins. Set HELM_NO_PLUGINS=1 to disable plugins.                        |
 | $HELM_PLUGINS                      | set the path to the plugins directory                                             |
 | $HELM_REGISTRY_CONFIG              | set the path to the registry config file.                                         |
+| $HELM_RENDER_PARALLELISM           | set the number of charts rendered at the same time (default 1)                    |
 | $HELM_REPOSITORY_CACHE             | set the path to the repository cache directory                                    |
 | $HELM_REPOSITORY_CONFIG            | set the path to the repositories file.                                            |
+| $HELM_SECRETS_KEYRING              | set the private keys decrypting values files (default "~/.gnupg/secring.gpg")     |
+| $HELM_SECRETS_PASSPHRASE           | set the passphrase of the private keys decrypting values files                    |
 | $KUBECONFIG                        | set an alternative Kubernetes configuration file (default "~/.kube/config")       |
 | $HELM_KUBEAPISERVER                | set the Kubernetes API Server Endpoint for authentication                         |
 | $HELM_KUBECAFILE                   | set the Kubernetes certificate authority file.                                    |
@@ -174,7 +183,9 @@ func newRootCmd(actionConfig *action.Configuration, out io.Writer, args []string
 		newLintCmd(out),
 		newPackageCmd(out),
 		newRepoCmd(out),
+		newSchemaCmd(out),
 		newSearchCmd(out),
+		newSecretsCmd(out),
 		newVerifyCmd(out),
 
 		// release commands
@@ -185,6 +196,8 @@ func newRootCmd(actionConfig *action.Configuration, out io.Writer, args []string
 		newReleaseTestCmd(actionConfig, out),
 		newRollbackCmd(actionConfig, out),
 		newStatusCmd(actionConfig, out),
+		newStorageCmd(actionConfig, out),
+		newCapabilitiesCmd(actionConfig, out),
 		newTemplateCmd(actionConfig, out),
 		newUninstallCmd(actionConfig, out),
 		newUpgradeCmd(actionConfig, out),
@@ -0,0 +1,98 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+const schemaGenerateDesc = `
+This command generates the values.schema.json file of a chart from its
+values.yaml file. The schema declares the type of every value, inferred from
+values.yaml, and the comments above a value, or on its line, describe it.
+Comment lines starting with an annotation add keywords to its schema:
+
+    # The pull policy of the image.
+    # @enum Always, IfNotPresent, Never
+    pullPolicy: IfNotPresent
+    # @pattern ^[0-9.]+$
+    # @required
+    tag: "1.21"
+    # @type string, null
+    nameOverride: ~
+
+When the chart already has a values.schema.json file, the generated schema is
+merged into it: the keywords generated from values.yaml replace the existing
+ones, while the other keywords and properties are kept.
+
+'helm lint' warns about the values of values.yaml that the schema does not
+declare.
+`
+
+func newSchemaGenerateCmd(out io.Writer) *cobra.Command {
+	var stdout bool
+
+	cmd := &cobra.Command{
+		Use:   "generate [CHART]",
+		Short: "generate the values schema of a chart from its values.yaml",
+		Long:  schemaGenerateDesc,
+		Args:  require.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartPath := "."
+			if len(args) > 0 {
+				chartPath = args[0]
+			}
+			values, err := ioutil.ReadFile(filepath.Join(chartPath, chartutil.ValuesfileName))
+			if err != nil {
+				return err
+			}
+			schemaPath := filepath.Join(chartPath, chartutil.SchemafileName)
+			existing, err := ioutil.ReadFile(schemaPath)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			schema, err := chartutil.GenerateSchema(values, existing)
+			if err != nil {
+				return errors.Wrapf(err, "cannot generate the schema of %s", chartPath)
+			}
+			if stdout {
+				_, err = out.Write(schema)
+				return err
+			}
+			if err := ioutil.WriteFile(schemaPath, schema, 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Wrote %s\n", schemaPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&stdout, "stdout", false, "print the schema instead of writing it to values.schema.json")
+
+	return cmd
+}
@@ -0,0 +1,68 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/action"
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+func TestSetSecretProviders(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "secrets.json")
+	if err := ioutil.WriteFile(jsonFile, []byte(`{"token": "t0k3n"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &action.Configuration{}
+	specs := []string{"env", "mounted=file:" + dir, "vault=json:" + jsonFile}
+	if err := setSecretProviders(cfg, specs); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.SecretProviders["env"].(engine.EnvSecrets); !ok {
+		t.Errorf("expected an env provider, got %v", cfg.SecretProviders["env"])
+	}
+	if p, ok := cfg.SecretProviders["mounted"].(engine.FileSecrets); !ok || p.Dir != dir {
+		t.Errorf("expected a file provider of %s, got %v", dir, cfg.SecretProviders["mounted"])
+	}
+	if v, err := cfg.SecretProviders["vault"].Secret("token"); err != nil || v != "t0k3n" {
+		t.Errorf("expected the token of the json provider, got %q, %v", v, err)
+	}
+
+	for spec, expect := range map[string]string{
+		"vault":         `unknown type "vault"`,
+		"env:HOME":      "env takes no argument",
+		"file":          "file requires a directory",
+		"keys=json":     "json requires a file",
+		"json:/no/file": "no such file",
+	} {
+		err := setSecretProviders(&action.Configuration{}, []string{spec})
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected an error containing %q, got %v", spec, expect, err)
+		}
+	}
+
+	err := setSecretProviders(&action.Configuration{}, []string{"env", "env"})
+	if err == nil || !strings.Contains(err.Error(), `secret provider "env" is set more than once`) {
+		t.Errorf("expected a duplicate provider error, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/cli/values"
+)
+
+const secretsHelp = `
+This command consists of multiple subcommands to work with encrypted values
+files.
+
+Encrypted values files use the layout of sops files: every value is encrypted
+with AES256-GCM by a data key, which is itself encrypted for one or more
+OpenPGP or age keys. Keys ending with "_unencrypted" are left in plaintext. The
+values files given with -f/--values are decrypted in memory when they are
+encrypted, with the private keys of the OpenPGP keyring given with
+--secrets-keyring (default $HELM_SECRETS_KEYRING or ~/.gnupg/secring.gpg) or
+of the age identities file given with --secrets-age-keys (default
+$HELM_SECRETS_AGE_KEYS or $SOPS_AGE_KEY_FILE). Protected OpenPGP keys are
+unlocked with the passphrase in $HELM_SECRETS_PASSPHRASE.
+
+The decrypted values are never written to disk by install, upgrade, template or
+lint, and are replaced by placeholders in the values printed by --debug. 'helm
+secrets edit' writes them to a temporary file while the editor runs; see its
+help.
+
+Releases are stored with the decrypted values replaced by placeholders, as in
+<encrypted:db.password>, in their values, so 'helm rollback' cannot restore
+them and refuses to roll back to their revisions. To return to such a revision,
+run 'helm upgrade' again with its encrypted values files. The manifests
+rendered from the decrypted values are stored as they are: set
+$HELM_DRIVER_ENCRYPTION_KEY to encrypt the stored releases.
+`
+
+func newSecretsCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "encrypt, decrypt and edit encrypted values files",
+		Long:  secretsHelp,
+		Args:  require.NoArgs,
+	}
+	cmd.AddCommand(
+		newSecretsEncryptCmd(out),
+		newSecretsDecryptCmd(out),
+		newSecretsEditCmd(out),
+	)
+	return cmd
+}
+
+// addSecretsKeyringFlag adds the flags selecting the OpenPGP keyring and the
+// age keys decrypting encrypted values files. The passphrase of the OpenPGP
+// keys is read from $HELM_SECRETS_PASSPHRASE.
+func addSecretsKeyringFlag(f *pflag.FlagSet, v *values.Options) {
+	f.StringVar(&v.SecretsKeyring, "secrets-keyring", defaultSecretsKeyring(), "location of the private keys used to decrypt encrypted values files")
+	f.StringVar(&v.SecretsAgeKeys, "secrets-age-keys", defaultSecretsAgeKeys(), "location of the age identities file used to decrypt encrypted values files")
+	if passphrase, ok := os.LookupEnv("HELM_SECRETS_PASSPHRASE"); ok {
+		v.SecretsPassphrase = []byte(passphrase)
+	}
+}
+
+// defaultSecretsKeyring returns the expanded path to the default keyring of
+// private keys.
+func defaultSecretsKeyring() string {
+	if v, ok := os.LookupEnv("HELM_SECRETS_KEYRING"); ok {
+		return v
+	}
+	if v, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(v, "secring.gpg")
+	}
+	return filepath.Join(homedir.HomeDir(), ".gnupg", "secring.gpg")
+}
+
+// defaultSecretsAgeKeys returns the age identities file given by the
+// environment, if any.
+func defaultSecretsAgeKeys() string {
+	if v, ok := os.LookupEnv("HELM_SECRETS_AGE_KEYS"); ok {
+		return v
+	}
+	return os.Getenv("SOPS_AGE_KEY_FILE")
+}
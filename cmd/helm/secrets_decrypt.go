@@ -0,0 +1,64 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/cli/values"
+)
+
+const secretsDecryptHelp = `
+This command prints the decrypted values of an encrypted values file. The
+decrypted values are not written to disk; redirect them at your own risk.
+`
+
+func newSecretsDecryptCmd(out io.Writer) *cobra.Command {
+	opts := &values.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "decrypt FILE",
+		Short: "print the decrypted values of an encrypted values file",
+		Long:  secretsDecryptHelp,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			identities, err := opts.SecretsIdentities()
+			if err != nil {
+				return err
+			}
+			decrypted, err := values.DecryptValues(data, identities)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decrypt %s", args[0])
+			}
+			_, err = out.Write(decrypted)
+			return err
+		},
+	}
+
+	addSecretsKeyringFlag(cmd.Flags(), opts)
+
+	return cmd
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/cli/values"
+)
+
+const secretsEditHelp = `
+This command opens the decrypted values of an encrypted values file in the
+editor named by $EDITOR (default "vi"), then encrypts the edited values again
+for the same keys.
+
+The decrypted values are written to a temporary file readable only by the
+current user, which is removed once the editor exits. The file is created in
+/dev/shm when it exists, so that the values stay in memory. Otherwise, as on
+macOS and Windows, it is created in the temporary directory of the system and
+the decrypted values are written to disk; a warning is printed then.
+`
+
+func newSecretsEditCmd(out io.Writer) *cobra.Command {
+	opts := &values.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "edit FILE",
+		Short: "edit an encrypted values file",
+		Long:  secretsEditHelp,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return editSecrets(args[0], opts, cmd.InOrStdin(), out)
+		},
+	}
+
+	addSecretsKeyringFlag(cmd.Flags(), opts)
+
+	return cmd
+}
+
+func editSecrets(filename string, opts *values.Options, in io.Reader, out io.Writer) error {
+	encrypted, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	identities, err := opts.SecretsIdentities()
+	if err != nil {
+		return err
+	}
+	decrypted, err := values.DecryptValues(encrypted, identities)
+	if err != nil {
+		return errors.Wrapf(err, "cannot decrypt %s", filename)
+	}
+
+	edited, err := runEditor(decrypted, in, out)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, decrypted) {
+		return nil
+	}
+	reencrypted, err := values.ReencryptValues(encrypted, edited, identities)
+	if err != nil {
+		return errors.Wrapf(err, "cannot encrypt %s", filename)
+	}
+	return writeFileKeepMode(filename, reencrypted)
+}
+
+// runEditor opens data in $EDITOR and returns the edited data.
+func runEditor(data []byte, in io.Reader, out io.Writer) ([]byte, error) {
+	dir := ""
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		dir = "/dev/shm"
+	} else {
+		warning("/dev/shm does not exist: the decrypted values are written to a temporary file in %s until the editor exits", os.TempDir())
+	}
+	// TempFile creates the file with mode 0600.
+	f, err := ioutil.TempFile(dir, "helm-secrets-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	c := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	c.Stdin = in
+	c.Stdout = out
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, errors.Wrapf(err, "editor %s failed", editor[0])
+	}
+	return ioutil.ReadFile(f.Name())
+}
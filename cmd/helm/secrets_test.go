@@ -0,0 +1,147 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// writeTestKeyrings writes the private and public keyrings of a new OpenPGP
+// key identified by test@example.com.
+func writeTestKeyrings(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secring, pubring bytes.Buffer
+	if err := entity.SerializePrivate(&secring, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(&pubring); err != nil {
+		t.Fatal(err)
+	}
+	secPath := filepath.Join(dir, "secring.gpg")
+	pubPath := filepath.Join(dir, "pubring.gpg")
+	if err := ioutil.WriteFile(secPath, secring.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubPath, pubring.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return secPath, pubPath
+}
+
+func TestSecretsCmd(t *testing.T) {
+	defer resetEnv()()
+
+	dir := t.TempDir()
+	secPath, pubPath := writeTestKeyrings(t, dir)
+	valuesFile := filepath.Join(dir, "secrets.yaml")
+	if err := ioutil.WriteFile(valuesFile, []byte("password: s3cr3t\nuser_unencrypted: admin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, out, err := executeActionCommand(fmt.Sprintf("secrets encrypt %s --pgp test@example.com --keyring %s", valuesFile, pubPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "s3cr3t") || !strings.Contains(out, "user_unencrypted: admin") {
+		t.Errorf("unexpected encrypted values:\n%s", out)
+	}
+	if _, _, err := executeActionCommand(fmt.Sprintf("secrets encrypt %s --pgp nobody@example.com --keyring %s", valuesFile, pubPath)); err == nil {
+		t.Error("expected an error encrypting for an unknown key")
+	}
+
+	if _, _, err := executeActionCommand(fmt.Sprintf("secrets encrypt -i %s --pgp test@example.com --keyring %s", valuesFile, pubPath)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(valuesFile); err != nil || strings.Contains(string(data), "s3cr3t") {
+		t.Fatalf("expected the file to be encrypted in place, got %s, %v", data, err)
+	}
+
+	_, out, err = executeActionCommand(fmt.Sprintf("secrets decrypt %s --secrets-keyring %s", valuesFile, secPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "password: s3cr3t\nuser_unencrypted: admin\n" {
+		t.Errorf("unexpected decrypted values:\n%s", out)
+	}
+
+	os.Setenv("EDITOR", "sed -i s/s3cr3t/changed/")
+	if _, _, err := executeActionCommand(fmt.Sprintf("secrets edit %s --secrets-keyring %s", valuesFile, secPath)); err != nil {
+		t.Fatal(err)
+	}
+	_, out, err = executeActionCommand(fmt.Sprintf("secrets decrypt %s --secrets-keyring %s", valuesFile, secPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "password: changed") {
+		t.Errorf("expected the edited password, got:\n%s", out)
+	}
+
+	// The decrypted values are redacted from the values printed by --debug.
+	_, out, err = executeActionCommand(fmt.Sprintf("install secrets testdata/testcharts/empty -f %s --secrets-keyring %s --debug", valuesFile, secPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "changed") || !strings.Contains(out, "password: <encrypted:password>") {
+		t.Errorf("expected the password to be redacted, got:\n%s", out)
+	}
+}
+
+func TestSecretsCmdAge(t *testing.T) {
+	defer resetEnv()()
+
+	dir := t.TempDir()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysPath := filepath.Join(dir, "keys.txt")
+	if err := ioutil.WriteFile(keysPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	valuesFile := filepath.Join(dir, "secrets.yaml")
+	if err := ioutil.WriteFile(valuesFile, []byte("password: s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := executeActionCommand(fmt.Sprintf("secrets encrypt -i %s --age %s", valuesFile, identity.Recipient())); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(valuesFile); err != nil || strings.Contains(string(data), "s3cr3t") {
+		t.Fatalf("expected the file to be encrypted in place, got %s, %v", data, err)
+	}
+
+	_, out, err := executeActionCommand(fmt.Sprintf("secrets decrypt %s --secrets-keyring %s --secrets-age-keys %s", valuesFile, filepath.Join(dir, "secring.gpg"), keysPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "password: s3cr3t\n" {
+		t.Errorf("unexpected decrypted values:\n%s", out)
+	}
+}
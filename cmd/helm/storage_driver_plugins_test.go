@@ -0,0 +1,46 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStorageDriverCommand(t *testing.T) {
+	dir := filepath.Join(string(filepath.Separator), "plugins", "driver")
+	os.Setenv("HELM_PLUGIN_DIR", dir)
+	defer os.Unsetenv("HELM_PLUGIN_DIR")
+
+	tests := []struct {
+		command string
+		prog    string
+		args    []string
+	}{
+		{"bin/driver", filepath.Join(dir, "bin", "driver"), []string{}},
+		{"$HELM_PLUGIN_DIR/bin/driver --verbose", filepath.Join(dir, "bin", "driver"), []string{"--verbose"}},
+		{"  bin/driver   --table  releases ", filepath.Join(dir, "bin", "driver"), []string{"--table", "releases"}},
+	}
+	for _, tt := range tests {
+		prog, args := storageDriverCommand(dir, os.ExpandEnv(tt.command))
+		if prog != tt.prog || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%q: expected %s %v, got %s %v", tt.command, tt.prog, tt.args, prog, args)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/action"
+	"github.com/huolunl/helm/v3/pkg/cli/output"
+)
+
+const storageMapAPIsHelp = `
+This command rewrites the Kubernetes API versions removed from the cluster in
+the latest stored revision of a release.
+
+When a Kubernetes upgrade removes an API version, for example
+extensions/v1beta1 Ingress, a release whose manifest still uses it can no
+longer be upgraded or rolled back. This command replaces such API versions in
+the manifest and hooks of the latest revision with their supported
+replacements and stores the result as a new revision. The resources in the
+cluster are not modified.
+
+    $ helm storage map-apis angry-bird --dry-run
+
+The mappings apply to the version of the cluster, or to '--kube-version'. The
+mappings shipped with Helm can be replaced with '--mapping-file', a YAML file
+of the form:
+
+    mappings:
+      - kind: Ingress
+        deprecatedAPIVersion: extensions/v1beta1
+        newAPIVersion: networking.k8s.io/v1
+        removedInVersion: v1.22
+`
+
+func newStorageMapAPIsCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	client := action.NewMapKubeAPIs(cfg)
+	var outfmt output.Format
+
+	cmd := &cobra.Command{
+		Use:   "map-apis RELEASE_NAME",
+		Short: "rewrite removed Kubernetes API versions in a stored release",
+		Long:  storageMapAPIsHelp,
+		Args:  require.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return compListReleases(toComplete, args, cfg)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			res, err := client.Run(args[0])
+			if err != nil {
+				return err
+			}
+			return outfmt.Write(out, &mapAPIsWriter{res, client.DryRun})
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVar(&client.DryRun, "dry-run", false, "show the changes without storing a new revision")
+	f.StringVar(&client.KubeVersion, "kube-version", "", "Kubernetes version to map the API versions for. Defaults to the version of the cluster")
+	f.StringVar(&client.MappingFile, "mapping-file", "", "file of API version mappings replacing the mappings shipped with Helm")
+	f.IntVar(&client.Context, "context", 3, "number of unchanged lines to show around each change")
+	bindOutputFlag(cmd, &outfmt)
+
+	return cmd
+}
+
+type mapAPIsWriter struct {
+	res    *action.MapKubeAPIsResult
+	dryRun bool
+}
+
+func (w *mapAPIsWriter) WriteTable(out io.Writer) error {
+	if len(w.res.Mapped) == 0 {
+		fmt.Fprintln(out, "No API versions to map")
+		return nil
+	}
+	for _, m := range w.res.Mapped {
+		fmt.Fprintf(out, "Mapped %s\n", m)
+	}
+	fmt.Fprintf(out, "\n%s\n", w.res.Diff)
+	if w.dryRun {
+		fmt.Fprintln(out, "Dry run: no revision stored")
+	} else {
+		fmt.Fprintf(out, "Stored revision %d of release %s\n", w.res.Release.Version, w.res.Release.Name)
+	}
+	return nil
+}
+
+func (w *mapAPIsWriter) WriteJSON(out io.Writer) error {
+	return output.EncodeJSON(out, w.res)
+}
+
+func (w *mapAPIsWriter) WriteYAML(out io.Writer) error {
+	return output.EncodeYAML(out, w.res)
+}
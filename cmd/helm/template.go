@@ -35,6 +35,7 @@ import (
 	"github.com/huolunl/helm/v3/pkg/action"
 	"github.com/huolunl/helm/v3/pkg/chartutil"
 	"github.com/huolunl/helm/v3/pkg/cli/values"
+	"github.com/huolunl/helm/v3/pkg/engine"
 	"github.com/huolunl/helm/v3/pkg/releaseutil"
 )
 
@@ -55,6 +56,10 @@ func newTemplateCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	var kubeVersion string
 	var extraAPIs []string
 	var showFiles []string
+	var capabilitiesFile string
+	var lookupFixtures []string
+	var secretProviders []string
+	var trace bool
 
 	cmd := &cobra.Command{
 		Use:   "template [NAME] [CHART]",
@@ -73,14 +78,41 @@ func newTemplateCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 				client.KubeVersion = parsedKubeVersion
 			}
 
+			var objects []map[string]interface{}
+			if capabilitiesFile != "" {
+				caps, snapshotObjects, err := loadCapabilitiesFile(capabilitiesFile)
+				if err != nil {
+					return err
+				}
+				client.Capabilities, objects = caps, snapshotObjects
+			}
+			lookupSource, err := newLookupSource(lookupFixtures, objects)
+			if err != nil {
+				return err
+			}
+			cfg.LookupSource = lookupSource
+			if err := setSecretProviders(cfg, secretProviders); err != nil {
+				return err
+			}
+
 			client.DryRun = true
 			client.ReleaseName = "RELEASE-NAME"
 			client.Replace = true // Skip the name check
 			client.ClientOnly = !validate
 			client.APIVersions = chartutil.VersionSet(extraAPIs)
 			client.IncludeCRDs = includeCrds
+			if trace {
+				cfg.SourceMap = engine.SourceMap{}
+			}
 			rel, err := runInstall(args, client, valueOpts, out)
 
+			if trace {
+				if err == nil {
+					printSourceMap(out, cfg.SourceMap)
+				}
+				return err
+			}
+
 			if err != nil && !settings.Debug {
 				if rel != nil {
 					return fmt.Errorf("%w\n\nUse --debug flag to render out invalid YAML", err)
@@ -182,6 +214,10 @@ func newTemplateCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	f.BoolVar(&client.IsUpgrade, "is-upgrade", false, "set .Release.IsUpgrade instead of .Release.IsInstall")
 	f.StringVar(&kubeVersion, "kube-version", "", "Kubernetes version used for Capabilities.KubeVersion")
 	f.StringArrayVarP(&extraAPIs, "api-versions", "a", []string{}, "Kubernetes api versions used for Capabilities.APIVersions")
+	addCapabilitiesFileFlag(f, &capabilitiesFile)
+	addLookupFixturesFlag(f, &lookupFixtures)
+	addSecretProviderFlag(f, &secretProviders)
+	f.BoolVar(&trace, "trace", false, "print each rendered line of the templates with the template line and include chain producing it, instead of the manifests. Errors show the template source")
 	f.BoolVar(&client.UseReleaseName, "release-name", false, "use release name in the output-dir path.")
 	bindPostRenderFlag(cmd, &client.PostRenderer)
 
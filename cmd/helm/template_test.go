@@ -84,6 +84,26 @@ func TestTemplateCmd(t *testing.T) {
 			cmd:    fmt.Sprintf("template --api-versions helm.k8s.io/test '%s'", chartPath),
 			golden: "output/template-with-api-version.txt",
 		},
+		{
+			name:   "check capabilities file",
+			cmd:    fmt.Sprintf("template --capabilities-file testdata/capabilities.yaml '%s'", chartPath),
+			golden: "output/template-with-capabilities-file.txt",
+		},
+		{
+			name:   "check lookup fixtures",
+			cmd:    "template --lookup-fixtures testdata/lookup-fixtures.yaml testdata/testcharts/chart-with-lookup",
+			golden: "output/template-with-lookup-fixtures.txt",
+		},
+		{
+			name:   "check lookup without fixtures",
+			cmd:    "template testdata/testcharts/chart-with-lookup",
+			golden: "output/template-without-lookup-fixtures.txt",
+		},
+		{
+			name:   "check trace",
+			cmd:    "template --trace testdata/testcharts/chart-with-lookup",
+			golden: "output/template-trace.txt",
+		},
 		{
 			name:   "template with CRDs",
 			cmd:    fmt.Sprintf("template '%s' --include-crds", chartPath),
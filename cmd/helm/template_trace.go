@@ -0,0 +1,54 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+// printSourceMap writes every non-empty rendered template, each line
+// preceded by the template line and include chain producing it.
+func printSourceMap(out io.Writer, sourceMap engine.SourceMap) {
+	names := make([]string, 0, len(sourceMap))
+	for name := range sourceMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lines := sourceMap[name]
+		width, empty := 0, true
+		for _, l := range lines {
+			if len(l.Origin.String()) > width {
+				width = len(l.Origin.String())
+			}
+			empty = empty && strings.TrimSpace(l.Text) == ""
+		}
+		if empty {
+			continue
+		}
+		fmt.Fprintf(out, "---\n# Source: %s\n", name)
+		for _, l := range lines {
+			fmt.Fprintf(out, "%-*s | %s\n", width, l.Origin, l.Text)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -35,9 +36,11 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/huolunl/helm/v3/internal/experimental/registry"
+	"github.com/huolunl/helm/v3/pkg/audit"
 	"github.com/huolunl/helm/v3/pkg/chart"
 	"github.com/huolunl/helm/v3/pkg/chartutil"
 	"github.com/huolunl/helm/v3/pkg/engine"
+	"github.com/huolunl/helm/v3/pkg/helmpath"
 	"github.com/huolunl/helm/v3/pkg/kube"
 	"github.com/huolunl/helm/v3/pkg/postrender"
 	"github.com/huolunl/helm/v3/pkg/release"
@@ -96,15 +99,50 @@ type Configuration struct {
 	// Capabilities describes the capabilities of the Kubernetes cluster.
 	Capabilities *chartutil.Capabilities
 
+	// LookupSource provides the objects returned by the lookup function of
+	// templates rendered without a cluster connection, as in dry runs.
+	LookupSource engine.LookupSource
+
+	// Engine renders the templates of installs, upgrades and 'helm
+	// template', for example with functions added by RegisterFuncs. A
+	// default engine is used if nil.
+	Engine *engine.Engine
+
+	// SourceMap, if not nil, is filled by renders with the template line
+	// and include chain producing every rendered line. Render errors and
+	// invalid YAML in rendered manifests then show their source.
+	SourceMap engine.SourceMap
+
+	// SecretProviders resolve the secrets referenced by the secretRef
+	// template function. Rollbacks use them to restore the secrets
+	// redacted in stored releases.
+	SecretProviders map[string]engine.SecretProvider
+
+	// Audit receives a record of every install, upgrade, rollback,
+	// uninstall and test. Auditing is disabled if nil.
+	Audit audit.Sink
+
+	// AuditActor identifies who performs the operations in audit records.
+	// If empty, the Kubernetes user of RESTClientGetter is recorded.
+	AuditActor string
+
+	// AuditDigestKey keys the digest of the parameters in audit records.
+	// The values supplied to operations are only digested with a key.
+	AuditDigestKey []byte
+
 	Log func(string, ...interface{})
+
+	// namespace is the namespace Init configured the storage driver for.
+	namespace string
 }
 
-// renderResources renders the templates in a chart
+// renderResources renders the templates in a chart. The secrets resolved by
+// secretRef are added to sensitive.
 //
 // TODO: This function is badly in need of a refactor.
 // TODO: As part of the refactor the duplicate code in cmd/helm/template.go should be removed
 //       This code has to do with writing files to disk.
-func (c *Configuration) renderResources(ch *chart.Chart, values chartutil.Values, releaseName, outputDir string, subNotes, useReleaseName, includeCrds bool, pr postrender.PostRenderer, dryRun bool) ([]*release.Hook, *bytes.Buffer, string, error) {
+func (c *Configuration) renderResources(ch *chart.Chart, values chartutil.Values, releaseName, outputDir string, subNotes, useReleaseName, includeCrds bool, pr postrender.PostRenderer, dryRun bool, sensitive map[string]string) ([]*release.Hook, *bytes.Buffer, string, error) {
 	hs := []*release.Hook{}
 	b := bytes.NewBuffer(nil)
 
@@ -127,14 +165,25 @@ func (c *Configuration) renderResources(ch *chart.Chart, values chartutil.Values
 	// is mocked. It is not up to the template author to decide when the user wants to
 	// connect to the cluster. So when the user says to dry run, respect the user's
 	// wishes and do not connect to the cluster.
+	e := c.renderEngine()
+	if c.SourceMap != nil {
+		e.SourceMap = c.SourceMap
+	}
+	if c.SecretProviders != nil {
+		e.SecretProviders = c.SecretProviders
+	}
+	e.Sensitive = sensitive
 	if !dryRun && c.RESTClientGetter != nil {
 		rest, err := c.RESTClientGetter.ToRESTConfig()
 		if err != nil {
 			return hs, b, "", err
 		}
-		files, err2 = engine.RenderWithClient(ch, values, rest)
+		files, err2 = e.RenderWithClient(ch, values, rest)
 	} else {
-		files, err2 = engine.Render(ch, values)
+		if e.LookupSource == nil {
+			e.LookupSource = c.LookupSource
+		}
+		files, err2 = e.Render(ch, values)
 	}
 
 	if err2 != nil {
@@ -177,6 +226,9 @@ func (c *Configuration) renderResources(ch *chart.Chart, values chartutil.Values
 			}
 			fmt.Fprintf(b, "---\n# Source: %s\n%s\n", name, content)
 		}
+		if c.SourceMap != nil {
+			err = manifestErrorContext(err, files, c.SourceMap)
+		}
 		return hs, b, "", err
 	}
 
@@ -377,7 +429,7 @@ func (c *Configuration) renderResources(ch *chart.Chart, values chartutil.Values
 
 	return hs, b, notes, nil
 }
-func (c *Configuration) renderResourcesForUpgrade(ch *chart.Chart, values chartutil.Values, releaseName, outputDir, name string, subNotes, useReleaseName, includeCrds bool, pr postrender.PostRenderer, dryRun bool) ([]*release.Hook, *bytes.Buffer, string, error) {
+func (c *Configuration) renderResourcesForUpgrade(ch *chart.Chart, values chartutil.Values, releaseName, outputDir, name string, subNotes, useReleaseName, includeCrds bool, pr postrender.PostRenderer, dryRun bool, sensitive map[string]string) ([]*release.Hook, *bytes.Buffer, string, error) {
 	hs := []*release.Hook{}
 	b := bytes.NewBuffer(nil)
 
@@ -400,14 +452,25 @@ func (c *Configuration) renderResourcesForUpgrade(ch *chart.Chart, values chartu
 	// is mocked. It is not up to the template author to decide when the user wants to
 	// connect to the cluster. So when the user says to dry run, respect the user's
 	// wishes and do not connect to the cluster.
+	e := c.renderEngine()
+	if c.SourceMap != nil {
+		e.SourceMap = c.SourceMap
+	}
+	if c.SecretProviders != nil {
+		e.SecretProviders = c.SecretProviders
+	}
+	e.Sensitive = sensitive
 	if !dryRun && c.RESTClientGetter != nil {
 		rest, err := c.RESTClientGetter.ToRESTConfig()
 		if err != nil {
 			return hs, b, "", err
 		}
-		files, err2 = engine.RenderWithClient(ch, values, rest)
+		files, err2 = e.RenderWithClient(ch, values, rest)
 	} else {
-		files, err2 = engine.Render(ch, values)
+		if e.LookupSource == nil {
+			e.LookupSource = c.LookupSource
+		}
+		files, err2 = e.Render(ch, values)
 	}
 
 	if err2 != nil {
@@ -450,6 +513,9 @@ func (c *Configuration) renderResourcesForUpgrade(ch *chart.Chart, values chartu
 			}
 			fmt.Fprintf(b, "---\n# Source: %s\n%s\n", name, content)
 		}
+		if c.SourceMap != nil {
+			err = manifestErrorContext(err, files, c.SourceMap)
+		}
 		return hs, b, "", err
 	}
 
@@ -661,6 +727,14 @@ type RESTClientGetter interface {
 // DebugLog sets the logger that writes debug strings
 type DebugLog func(format string, v ...interface{})
 
+// renderEngine returns a copy of the engine rendering templates.
+func (c *Configuration) renderEngine() engine.Engine {
+	if c.Engine == nil {
+		return engine.Engine{}
+	}
+	return *c.Engine
+}
+
 // capabilities builds a Capabilities from discovery information.
 func (c *Configuration) getCapabilities() (*chartutil.Capabilities, error) {
 	if c.Capabilities != nil {
@@ -787,8 +861,64 @@ func (c *Configuration) recordRelease(r *release.Release) {
 	}
 }
 
+// releaseConflictRetries is the number of times modifyRelease retries an
+// update rejected because the release was modified concurrently.
+const releaseConflictRetries = 5
+
+// updateRelease stores r. If the stored release was modified by another
+// operation since r was read, it is left untouched and an error saying so is
+// returned.
+func (c *Configuration) updateRelease(r *release.Release) error {
+	return conflictError(r, c.Releases.Update(r))
+}
+
+// modifyRelease applies modify to r and stores it. If the stored release was
+// modified by another operation since r was read, modify is applied to the
+// latest stored version instead, which r is reset to, so that neither change
+// is lost.
+func (c *Configuration) modifyRelease(r *release.Release, modify func(*release.Release)) error {
+	modify(r)
+	err := c.Releases.Update(r)
+	for i := 0; i < releaseConflictRetries && errors.Is(err, driver.ErrReleaseConflict); i++ {
+		c.Log("release %s revision %d was modified concurrently, retrying", r.Name, r.Version)
+		latest, getErr := c.Releases.Get(r.Name, r.Version)
+		if getErr != nil {
+			return getErr
+		}
+		modify(latest)
+		*r = *latest
+		err = c.Releases.Update(r)
+	}
+	return conflictError(r, err)
+}
+
+// supersedeRelease marks r as superseded.
+func (c *Configuration) supersedeRelease(r *release.Release) {
+	err := c.modifyRelease(r, func(r *release.Release) {
+		r.Info.Status = release.StatusSuperseded
+	})
+	if err != nil {
+		c.Log("warning: Failed to update release %s: %s", r.Name, err)
+	}
+}
+
+// conflictError explains the errors of updates of r rejected because it was
+// modified concurrently. Other errors are returned unchanged.
+func conflictError(r *release.Release, err error) error {
+	if errors.Is(err, driver.ErrReleaseConflict) {
+		return errors.Wrapf(err, "release %s revision %d was modified by another operation", r.Name, r.Version)
+	}
+	return err
+}
+
+// Namespace returns the namespace the configuration was initialized for.
+func (c *Configuration) Namespace() string {
+	return c.namespace
+}
+
 // Init initializes the action configuration
 func (c *Configuration) Init(getter genericclioptions.RESTClientGetter, namespace, helmDriver string, log DebugLog) error {
+	c.namespace = namespace
 	kc := kube.New(getter)
 	kc.Log = log
 
@@ -797,15 +927,22 @@ func (c *Configuration) Init(getter genericclioptions.RESTClientGetter, namespac
 		clientFn:  kc.Factory.KubernetesClientSet,
 	}
 
+	encryption, err := driver.KeyProviderFromEnv()
+	if err != nil {
+		return err
+	}
+
 	var store *storage.Storage
 	switch helmDriver {
 	case "secret", "secrets", "":
 		d := driver.NewSecrets(newSecretClient(lazyClient))
 		d.Log = log
+		d.Encryption = encryption
 		store = storage.Init(d)
 	case "configmap", "configmaps":
 		d := driver.NewConfigMaps(newConfigMapClient(lazyClient))
 		d.Log = log
+		d.Encryption = encryption
 		store = storage.Init(d)
 	case "memory":
 		var d *driver.Memory
@@ -822,6 +959,17 @@ func (c *Configuration) Init(getter genericclioptions.RESTClientGetter, namespac
 		}
 		d.SetNamespace(namespace)
 		store = storage.Init(d)
+	case "filesystem":
+		dir := os.Getenv("HELM_DRIVER_FILESYSTEM_PATH")
+		if dir == "" {
+			dir = helmpath.DataPath("releases")
+		}
+		d, err := driver.NewFilesystem(dir, namespace)
+		if err != nil {
+			return errors.Wrap(err, "unable to instantiate filesystem driver")
+		}
+		d.Log = log
+		store = storage.Init(d)
 	case "sql":
 		d, err := driver.NewSQL(
 			os.Getenv("HELM_DRIVER_SQL_CONNECTION_STRING"),
@@ -831,10 +979,50 @@ func (c *Configuration) Init(getter genericclioptions.RESTClientGetter, namespac
 		if err != nil {
 			panic(fmt.Sprintf("Unable to instantiate SQL driver: %v", err))
 		}
+		d.Encryption = encryption
 		store = storage.Init(d)
 	default:
-		// Not sure what to do here.
-		panic("Unknown driver in HELM_DRIVER: " + helmDriver)
+		factory, ok := driver.Lookup(helmDriver)
+		if !ok {
+			// Not sure what to do here.
+			panic("Unknown driver in HELM_DRIVER: " + helmDriver)
+		}
+		d, err := factory(namespace, log)
+		if err != nil {
+			return errors.Wrapf(err, "unable to instantiate storage driver %q", helmDriver)
+		}
+		store = storage.Init(d)
+	}
+
+	// the memory driver is not persisted, so it has nothing to encrypt
+	if encryption != nil {
+		switch store.Driver.(type) {
+		case *driver.Secrets, *driver.ConfigMaps, *driver.SQL, *driver.Memory:
+		default:
+			return errors.Errorf("the %s storage driver cannot encrypt releases, unset %s and %s", store.Name(), driver.EncryptionKeyEnvVar, driver.EncryptionKeyFileEnvVar)
+		}
+	}
+
+	switch sink := os.Getenv("HELM_AUDIT_SINK"); {
+	case sink == "":
+	case sink == "storage":
+		d, ok := store.Driver.(driver.Appender)
+		if !ok {
+			return errors.Errorf("the %s storage driver cannot store audit records", store.Name())
+		}
+		c.Audit = audit.NewStorageSink(d)
+	case strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+		c.Audit = audit.NewHTTPSink(sink)
+	default:
+		c.Audit = audit.NewFileSink(sink)
+	}
+	c.AuditActor = os.Getenv("HELM_AUDIT_ACTOR")
+	c.AuditDigestKey = []byte(os.Getenv("HELM_AUDIT_DIGEST_KEY"))
+
+	if n, err := strconv.Atoi(os.Getenv("HELM_RENDER_PARALLELISM")); err == nil && n > 1 {
+		e := c.renderEngine()
+		e.Parallelism = n
+		c.Engine = &e
 	}
 
 	c.RESTClientGetter = getter
@@ -25,6 +25,8 @@ import (
 	"testing"
 
 	dockerauth "github.com/deislabs/oras/pkg/auth/docker"
+	"github.com/pkg/errors"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	fakeclientset "k8s.io/client-go/kubernetes/fake"
 
 	"github.com/huolunl/helm/v3/internal/experimental/registry"
@@ -256,6 +258,12 @@ func withMultipleManifestTemplate() chartOption {
 	}
 }
 
+func withTemplate(name, data string) chartOption {
+	return func(opts *chartOptions) {
+		opts.Templates = append(opts.Templates, &chart.File{Name: name, Data: []byte(data)})
+	}
+}
+
 func withKube(version string) chartOption {
 	return func(opts *chartOptions) {
 		opts.Metadata.KubeVersion = version
@@ -319,3 +327,96 @@ func TestGetVersionSet(t *testing.T) {
 		t.Error("Non-existent version is reported found.")
 	}
 }
+
+// conflictingDriver rejects the next updates as if the releases were
+// modified concurrently.
+type conflictingDriver struct {
+	driver.Driver
+	conflicts int
+}
+
+func (d *conflictingDriver) Update(key string, rls *release.Release) error {
+	if d.conflicts > 0 {
+		d.conflicts--
+		return driver.ErrReleaseConflict
+	}
+	return d.Driver.Update(key, rls)
+}
+
+func TestModifyReleaseConflict(t *testing.T) {
+	cfg := actionConfigFixture(t)
+	d := &conflictingDriver{Driver: driver.NewMemory()}
+	cfg.Releases = storage.Init(d)
+
+	rel := releaseStub()
+	if err := cfg.Releases.Create(rel); err != nil {
+		t.Fatal(err)
+	}
+
+	// superseding is retried on the latest version of the release
+	d.conflicts = 2
+	cfg.supersedeRelease(rel)
+	got, err := cfg.Releases.Get(rel.Name, rel.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Info.Status != release.StatusSuperseded {
+		t.Errorf("Expected status %s, got %s", release.StatusSuperseded, got.Info.Status)
+	}
+
+	// updates of the release being deployed fail
+	d.conflicts = 1
+	rel.Info.Status = release.StatusDeployed
+	if err := cfg.updateRelease(rel); !errors.Is(err, driver.ErrReleaseConflict) {
+		t.Fatalf("Expected %v, got %v", driver.ErrReleaseConflict, err)
+	}
+
+	// retries are bounded
+	d.conflicts = releaseConflictRetries + 1
+	if err := cfg.modifyRelease(rel, func(*release.Release) {}); !errors.Is(err, driver.ErrReleaseConflict) {
+		t.Fatalf("Expected %v, got %v", driver.ErrReleaseConflict, err)
+	}
+}
+
+func TestUninstallReleaseConflict(t *testing.T) {
+	unAction := uninstallAction(t)
+	unAction.DisableHooks = true
+	d := &conflictingDriver{Driver: driver.NewMemory()}
+	unAction.cfg.Releases = storage.Init(d)
+
+	rel := releaseStub()
+	if err := unAction.cfg.Releases.Create(rel); err != nil {
+		t.Fatal(err)
+	}
+
+	d.conflicts = 1
+	if _, err := unAction.Run(rel.Name); !errors.Is(err, driver.ErrReleaseConflict) {
+		t.Fatalf("Expected %v, got %v", driver.ErrReleaseConflict, err)
+	}
+	// the release is left to the operation that modified it
+	if _, err := unAction.cfg.Releases.Get(rel.Name, rel.Version); err != nil {
+		t.Errorf("Expected release to be kept, got %v", err)
+	}
+}
+
+func TestInitStorageEncryption(t *testing.T) {
+	defer os.Setenv(driver.EncryptionKeyEnvVar, os.Getenv(driver.EncryptionKeyEnvVar))
+	os.Setenv(driver.EncryptionKeyEnvVar, "MDEyMzQ1Njc4OWFiY2RlZg==")
+	defer os.Setenv("HELM_DRIVER_FILESYSTEM_PATH", os.Getenv("HELM_DRIVER_FILESYSTEM_PATH"))
+	os.Setenv("HELM_DRIVER_FILESYSTEM_PATH", t.TempDir())
+	getter := genericclioptions.NewConfigFlags(false)
+
+	for _, d := range []string{"secret", "configmap", "memory"} {
+		var cfg Configuration
+		if err := cfg.Init(getter, "default", d, t.Logf); err != nil {
+			t.Errorf("Expected the %s driver to support encryption, got %s", d, err)
+		}
+	}
+
+	// the filesystem driver would store the releases unencrypted
+	var cfg Configuration
+	err := cfg.Init(getter, "default", "filesystem", t.Logf)
+	if err == nil || err.Error() != "the Filesystem storage driver cannot encrypt releases, unset HELM_DRIVER_ENCRYPTION_KEY and HELM_DRIVER_ENCRYPTION_KEY_FILE" {
+		t.Errorf("Expected the filesystem driver to refuse the encryption key, got %v", err)
+	}
+}
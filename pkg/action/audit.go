@@ -0,0 +1,117 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"os/user"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/huolunl/helm/v3/pkg/audit"
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+// recordAudit writes an audit record of an operation on the release name if
+// an audit sink is configured. rel is the release the operation produced or
+// acted on, if any. The "values" parameter is left out of the digest unless
+// AuditDigestKey is set. Failing to write the record is logged but does not
+// fail the operation.
+func (c *Configuration) recordAudit(operation, name, namespace string, rel *release.Release, params map[string]interface{}, dryRun bool, err error) {
+	if c.Audit == nil {
+		return
+	}
+
+	r := &audit.Record{
+		Time:      time.Now().UTC(),
+		Actor:     c.auditActor(),
+		Operation: operation,
+		Release:   name,
+		Namespace: namespace,
+		DryRun:    dryRun,
+		Outcome:   audit.OutcomeSuccess,
+	}
+	if rel != nil {
+		r.Namespace = rel.Namespace
+		r.Revision = rel.Version
+		r.Chart = chartRef(rel.Chart)
+	}
+	if _, ok := params["values"]; ok && len(c.AuditDigestKey) == 0 {
+		digested := make(map[string]interface{}, len(params))
+		for k, v := range params {
+			if k != "values" {
+				digested[k] = v
+			}
+		}
+		params = digested
+	}
+	if digest, derr := audit.Digest(c.AuditDigestKey, params); derr == nil {
+		r.ParamsDigest = digest
+	} else {
+		c.Log("warning: failed to digest the parameters of %s: %s", operation, derr)
+	}
+	if err != nil {
+		r.Outcome = audit.OutcomeFailure
+		r.Error = err.Error()
+	}
+
+	if werr := c.Audit.Write(r); werr != nil {
+		c.Log("warning: failed to write audit record for %s of %s: %s", operation, name, werr)
+	}
+}
+
+// auditActor returns the identity recorded as the actor of audit records:
+// AuditActor if set, otherwise the Kubernetes user the operations are
+// performed as, falling back to the local user.
+func (c *Configuration) auditActor() string {
+	if c.AuditActor != "" {
+		return c.AuditActor
+	}
+	if c.RESTClientGetter != nil {
+		if rc, err := c.RESTClientGetter.ToRESTConfig(); err == nil {
+			if rc.Impersonate.UserName != "" {
+				return rc.Impersonate.UserName
+			}
+			if rc.Username != "" {
+				return rc.Username
+			}
+		}
+		if g, ok := c.RESTClientGetter.(interface {
+			ToRawKubeConfigLoader() clientcmd.ClientConfig
+		}); ok {
+			if raw, err := g.ToRawKubeConfigLoader().RawConfig(); err == nil {
+				if ctx, ok := raw.Contexts[raw.CurrentContext]; ok && ctx.AuthInfo != "" {
+					return ctx.AuthInfo
+				}
+			}
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// chartRef returns the name and version of a chart as recorded in audit
+// records.
+func chartRef(ch *chart.Chart) string {
+	if ch == nil || ch.Metadata == nil {
+		return ""
+	}
+	return ch.Metadata.Name + "-" + ch.Metadata.Version
+}
@@ -0,0 +1,167 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/huolunl/helm/v3/pkg/audit"
+	"github.com/huolunl/helm/v3/pkg/release"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+type recordingSink struct {
+	records []*audit.Record
+}
+
+func (s *recordingSink) Write(r *audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func TestAuditRecords(t *testing.T) {
+	is := assert.New(t)
+	sink := &recordingSink{}
+
+	instAction := installAction(t)
+	instAction.cfg.Audit = sink
+	instAction.cfg.AuditActor = "alice"
+	vals := map[string]interface{}{"password": "hunter2"}
+	_, err := instAction.Run(buildChart(), vals)
+	is.NoError(err)
+
+	// the release name is now in use
+	_, err = instAction.Run(buildChart(), vals)
+	is.Error(err)
+
+	unAction := NewUninstall(instAction.cfg)
+	unAction.DryRun = true
+	_, err = unAction.Run(instAction.ReleaseName)
+	is.NoError(err)
+
+	is.Len(sink.records, 3)
+	installed, failed, uninstalled := sink.records[0], sink.records[1], sink.records[2]
+
+	is.Equal("install", installed.Operation)
+	is.Equal("alice", installed.Actor)
+	is.Equal("test-install-release", installed.Release)
+	is.Equal("spaced", installed.Namespace)
+	is.Equal(1, installed.Revision)
+	is.Equal("hello-0.1.0", installed.Chart)
+	is.Equal(audit.OutcomeSuccess, installed.Outcome)
+	is.Contains(installed.ParamsDigest, "sha256:")
+	is.NotContains(installed.ParamsDigest, "hunter2")
+
+	is.Equal(audit.OutcomeFailure, failed.Outcome)
+	is.Contains(failed.Error, "cannot re-use a name that is still in use")
+	is.Equal(installed.ParamsDigest, failed.ParamsDigest)
+
+	is.Equal("uninstall", uninstalled.Operation)
+	is.True(uninstalled.DryRun)
+	is.Equal(audit.OutcomeSuccess, uninstalled.Outcome)
+}
+
+// auditlessDriver is a storage driver that cannot store audit records.
+type auditlessDriver struct {
+	driver.Driver
+}
+
+func (d *auditlessDriver) Name() string { return "Auditless" }
+
+func TestInitStorageAuditSink(t *testing.T) {
+	os.Setenv("HELM_AUDIT_SINK", "storage")
+	defer os.Unsetenv("HELM_AUDIT_SINK")
+	driver.Register("auditless", func(string, func(string, ...interface{})) (driver.Driver, error) {
+		return &auditlessDriver{Driver: driver.NewMemory()}, nil
+	})
+	is := assert.New(t)
+	getter := genericclioptions.NewConfigFlags(false)
+
+	var cfg Configuration
+	is.NoError(cfg.Init(getter, "default", "memory", t.Logf))
+	is.IsType(&audit.StorageSink{}, cfg.Audit)
+	is.Equal("default", cfg.Namespace())
+
+	cfg = Configuration{}
+	is.EqualError(cfg.Init(getter, "default", "auditless", t.Logf), "the Auditless storage driver cannot store audit records")
+}
+
+func TestAuditDigestValues(t *testing.T) {
+	is := assert.New(t)
+	sink := &recordingSink{}
+
+	instAction := installAction(t)
+	instAction.DryRun = true
+	instAction.cfg.Audit = sink
+	for _, key := range []string{"", "audit-key"} {
+		instAction.cfg.AuditDigestKey = []byte(key)
+		for _, password := range []string{"hunter2", "hunter3"} {
+			_, err := instAction.Run(buildChart(), map[string]interface{}{"password": password})
+			is.NoError(err)
+		}
+	}
+
+	is.Len(sink.records, 4)
+	// without a key the values are left out of the digest
+	is.Contains(sink.records[0].ParamsDigest, "sha256:")
+	is.Equal(sink.records[0].ParamsDigest, sink.records[1].ParamsDigest)
+	// with a key they are part of an HMAC
+	is.Contains(sink.records[2].ParamsDigest, "hmac-sha256:")
+	is.NotEqual(sink.records[2].ParamsDigest, sink.records[3].ParamsDigest)
+}
+
+func TestAuditRollbackRecord(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+	sink := &recordingSink{}
+	config := actionConfigFixture(t)
+	config.Audit = sink
+	config.namespace = "spaced"
+
+	rel := namedReleaseStub("rolled", release.StatusSuperseded)
+	req.NoError(config.Releases.Create(rel))
+	current := namedReleaseStub("rolled", release.StatusDeployed)
+	current.Version = 2
+	req.NoError(config.Releases.Create(current))
+
+	rollback := NewRollback(config)
+	rollback.Version = 1
+	req.NoError(rollback.Run("rolled"))
+
+	// a release that does not exist has no target to record
+	is.Error(rollback.Run("missing"))
+
+	req.Len(sink.records, 2)
+	rolledBack, failed := sink.records[0], sink.records[1]
+
+	is.Equal("rollback", rolledBack.Operation)
+	is.Equal("rolled", rolledBack.Release)
+	is.Equal(rel.Namespace, rolledBack.Namespace)
+	is.Equal(3, rolledBack.Revision)
+	is.Equal(chartRef(rel.Chart), rolledBack.Chart)
+	is.Equal(audit.OutcomeSuccess, rolledBack.Outcome)
+
+	is.Equal("missing", failed.Release)
+	is.Equal("spaced", failed.Namespace)
+	is.Equal(0, failed.Revision)
+	is.Equal(audit.OutcomeFailure, failed.Outcome)
+}
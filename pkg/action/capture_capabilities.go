@@ -0,0 +1,94 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+// CaptureCapabilities is the action for saving the capabilities of a cluster.
+//
+// It provides the implementation of 'helm capabilities capture'.
+type CaptureCapabilities struct {
+	cfg *Configuration
+
+	// Objects selects cluster objects to capture for the lookup function,
+	// as APIVERSION/KIND/NAMESPACE/NAME. The namespace is empty for
+	// cluster-scoped objects.
+	Objects []string
+}
+
+// NewCaptureCapabilities creates a new CaptureCapabilities object with the
+// given configuration.
+func NewCaptureCapabilities(cfg *Configuration) *CaptureCapabilities {
+	return &CaptureCapabilities{
+		cfg: cfg,
+	}
+}
+
+// Run captures the version and API versions of the cluster and the selected
+// objects.
+func (c *CaptureCapabilities) Run() (*chartutil.CapabilitiesSnapshot, error) {
+	if err := c.cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+	caps, err := c.cfg.getCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := chartutil.NewCapabilitiesSnapshot(caps)
+	if len(c.Objects) == 0 {
+		return snapshot, nil
+	}
+
+	config, err := c.cfg.RESTClientGetter.ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate config for kubernetes client")
+	}
+	lookup := engine.NewLookupFunction(config)
+	for _, selector := range c.Objects {
+		apiVersion, kind, namespace, name, err := parseObjectSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := lookup(apiVersion, kind, namespace, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get object %s", selector)
+		}
+		if len(obj) == 0 {
+			return nil, errors.Errorf("object %s not found", selector)
+		}
+		snapshot.Objects = append(snapshot.Objects, obj)
+	}
+	return snapshot, nil
+}
+
+// parseObjectSelector splits APIVERSION/KIND/NAMESPACE/NAME. The API version
+// may contain a slash itself.
+func parseObjectSelector(selector string) (apiVersion, kind, namespace, name string, err error) {
+	parts := strings.Split(selector, "/")
+	n := len(parts)
+	if n < 4 || n > 5 || parts[n-1] == "" || parts[n-3] == "" {
+		return "", "", "", "", errors.Errorf("invalid object %q, expected APIVERSION/KIND/NAMESPACE/NAME", selector)
+	}
+	return strings.Join(parts[:n-3], "/"), parts[n-3], parts[n-2], parts[n-1], nil
+}
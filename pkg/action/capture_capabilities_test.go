@@ -0,0 +1,76 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+func TestParseObjectSelector(t *testing.T) {
+	tests := []struct {
+		selector                          string
+		apiVersion, kind, namespace, name string
+		wantErr                           bool
+	}{
+		{selector: "v1/Secret/default/db", apiVersion: "v1", kind: "Secret", namespace: "default", name: "db"},
+		{selector: "apps/v1/Deployment/web/frontend", apiVersion: "apps/v1", kind: "Deployment", namespace: "web", name: "frontend"},
+		{selector: "v1/Namespace//kube-system", apiVersion: "v1", kind: "Namespace", name: "kube-system"},
+		{selector: "v1/Secret/db", wantErr: true},
+		{selector: "v1/Secret/default/", wantErr: true},
+		{selector: "a/b/c/d/e/f", wantErr: true},
+	}
+	for _, tt := range tests {
+		apiVersion, kind, namespace, name, err := parseObjectSelector(tt.selector)
+		if tt.wantErr {
+			assert.Error(t, err, tt.selector)
+			continue
+		}
+		assert.NoError(t, err, tt.selector)
+		assert.Equal(t, []string{tt.apiVersion, tt.kind, tt.namespace, tt.name}, []string{apiVersion, kind, namespace, name}, tt.selector)
+	}
+}
+
+func TestCaptureCapabilities(t *testing.T) {
+	config := actionConfigFixture(t)
+	snapshot, err := NewCaptureCapabilities(config).Run()
+	assert.NoError(t, err)
+	caps, err := snapshot.Capabilities()
+	assert.NoError(t, err)
+	assert.Equal(t, chartutil.DefaultCapabilities.KubeVersion, caps.KubeVersion)
+	assert.Equal(t, chartutil.DefaultCapabilities.APIVersions, caps.APIVersions)
+}
+
+func TestInstallCapabilitiesClientOnly(t *testing.T) {
+	instAction := installAction(t)
+	instAction.ClientOnly = true
+	instAction.Capabilities = &chartutil.Capabilities{
+		KubeVersion: chartutil.KubeVersion{Version: "v1.22.3", Major: "1", Minor: "22"},
+		APIVersions: chartutil.VersionSet{"v1", "example.com/v1"},
+	}
+	instAction.APIVersions = chartutil.VersionSet{"extra/v1"}
+
+	_, err := instAction.Run(buildChart(), nil)
+	assert.NoError(t, err)
+	caps := instAction.cfg.Capabilities
+	assert.Equal(t, "v1.22.3", caps.KubeVersion.Version)
+	assert.Equal(t, chartutil.VersionSet{"v1", "example.com/v1", "extra/v1"}, caps.APIVersions)
+	assert.Equal(t, chartutil.VersionSet{"v1", "example.com/v1"}, instAction.Capabilities.APIVersions, "the given capabilities must not be modified")
+}
@@ -0,0 +1,466 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsinstall "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/install"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/kube"
+	"github.com/huolunl/helm/v3/pkg/releaseutil"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// crdScheme converts CRDs between the versions of apiextensions.k8s.io.
+var crdScheme = func() *runtime.Scheme {
+	s := runtime.NewScheme()
+	apiextensionsinstall.Install(s)
+	return s
+}()
+
+// newDynamicClient creates the client used to read the CRDs and custom
+// resources of the cluster.
+var newDynamicClient = func(getter RESTClientGetter) (dynamic.Interface, error) {
+	if getter == nil {
+		return nil, errors.New("no RESTClientGetter configured")
+	}
+	config, err := getter.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+// upgradeCRDs creates the CRDs of the crds/ directory of a chart that are
+// missing from the cluster and replaces the ones that differ from the chart's
+// copy.
+//
+// A CRD is only replaced if the change is safe: the chart must keep serving
+// every version the cluster serves, and fields removed from the schema of a
+// version must not be set in any of its custom resources. Nothing is applied
+// if dryRun is set, but the checks still run.
+//
+// The returned summary of what was done is meant for the release description.
+func (c *Configuration) upgradeCRDs(crds []chart.CRD, dryRun bool) (string, error) {
+	client, err := newDynamicClient(c.RESTClientGetter)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create a Kubernetes client for CRDs")
+	}
+
+	var created, upgraded, unchanged []string
+	var applied kube.ResourceList
+	for _, crd := range crds {
+		changed := false
+		manifests := releaseutil.SplitManifests(string(crd.File.Data))
+		keys := make([]string, 0, len(manifests))
+		for k := range manifests {
+			keys = append(keys, k)
+		}
+		sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+		for _, k := range keys {
+			desired := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(manifests[k]), &desired.Object); err != nil {
+				return "", errors.Wrapf(err, "failed to parse CRD %s", crd.Name)
+			}
+			if len(desired.Object) == 0 {
+				continue
+			}
+			name := desired.GetName()
+			// the live CRDs are read as v1, so compare them to v1 CRDs
+			desired, err := crdAsV1(desired)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to convert CRD %s", name)
+			}
+
+			live, err := client.Resource(crdResource).Get(context.Background(), name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+				created = append(created, name)
+				changed = true
+				continue
+			case err != nil:
+				return "", errors.Wrapf(err, "failed to get CRD %s", name)
+			}
+
+			if crdUnchanged(live, desired) {
+				unchanged = append(unchanged, name)
+				continue
+			}
+			inUse := func(version string, path []string) (string, error) {
+				return crdFieldInUse(client, desired, version, path)
+			}
+			if err := checkCRDUpgrade(live, desired, inUse); err != nil {
+				return "", errors.Wrapf(err, "refusing to upgrade CRD %s", name)
+			}
+			upgraded = append(upgraded, name)
+			changed = true
+		}
+
+		if !changed || dryRun {
+			continue
+		}
+		res, err := c.KubeClient.Build(bytes.NewBuffer(crd.File.Data), false)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to build CRD %s", crd.Name)
+		}
+		// replace rather than patch the CRDs, as kubectl replace would
+		if _, err := c.KubeClient.Update(res, res, true); err != nil {
+			return "", errors.Wrapf(err, "failed to apply CRD %s", crd.Name)
+		}
+		applied = append(applied, res...)
+	}
+
+	if len(applied) > 0 && c.RESTClientGetter != nil {
+		// Invalidate the local cache and capabilities, since they will not
+		// have the new CRDs present.
+		discoveryClient, err := c.RESTClientGetter.ToDiscoveryClient()
+		if err != nil {
+			return "", err
+		}
+		c.Log("Clearing discovery cache")
+		discoveryClient.Invalidate()
+		c.Capabilities = nil
+		// Give time for the CRD to be recognized.
+		if err := c.KubeClient.Wait(applied, 60*time.Second); err != nil {
+			return "", err
+		}
+		// Make sure to force a rebuild of the cache.
+		discoveryClient.ServerGroups()
+	}
+
+	return summarizeCRDs(created, upgraded, unchanged, nil), nil
+}
+
+// summarizeCRDs describes what was done with the CRDs of a chart, for the
+// release description.
+func summarizeCRDs(created, upgraded, unchanged, skipped []string) string {
+	var summary []string
+	for _, s := range []struct {
+		verb  string
+		names []string
+	}{{"created", created}, {"upgraded", upgraded}, {"unchanged", unchanged}, {"skipped", skipped}} {
+		if len(s.names) > 0 {
+			summary = append(summary, s.verb+" "+strings.Join(s.names, ", "))
+		}
+	}
+	if len(summary) == 0 {
+		return ""
+	}
+	return "CRDs " + strings.Join(summary, "; ")
+}
+
+// crdNames returns the names of the CRDs of the crds/ directory of a chart.
+// The file name stands for a CRD that cannot be parsed.
+func crdNames(crds []chart.CRD) []string {
+	var names []string
+	for _, crd := range crds {
+		manifests := releaseutil.SplitManifests(string(crd.File.Data))
+		keys := make([]string, 0, len(manifests))
+		for k := range manifests {
+			keys = append(keys, k)
+		}
+		sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+		for _, k := range keys {
+			obj := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(manifests[k]), &obj.Object); err != nil {
+				names = append(names, crd.Name)
+				continue
+			}
+			if len(obj.Object) > 0 {
+				names = append(names, obj.GetName())
+			}
+		}
+	}
+	return names
+}
+
+// withCRDSummary appends the summary of upgradeCRDs to a release description.
+func withCRDSummary(description, summary string) string {
+	if summary == "" {
+		return description
+	}
+	return description + " (" + summary + ")"
+}
+
+// crdAsV1 returns crd converted to apiextensions.k8s.io/v1 if it is a
+// v1beta1 CRD, as the API server would serve it. Other CRDs are returned
+// unchanged.
+func crdAsV1(crd *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if crd.GetAPIVersion() != apiextensionsv1beta1.SchemeGroupVersion.String() {
+		return crd, nil
+	}
+	var in apiextensionsv1beta1.CustomResourceDefinition
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(crd.Object, &in); err != nil {
+		return nil, err
+	}
+	var internal apiextensions.CustomResourceDefinition
+	if err := crdScheme.Convert(&in, &internal, nil); err != nil {
+		return nil, err
+	}
+	var out apiextensionsv1.CustomResourceDefinition
+	if err := crdScheme.Convert(&internal, &out, nil); err != nil {
+		return nil, err
+	}
+	out.SetGroupVersionKind(apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition"))
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&out)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// crdVersion is a version of a CRD.
+type crdVersion struct {
+	served bool
+	schema map[string]interface{}
+}
+
+// crdVersions returns the versions of a CRD of apiextensions.k8s.io/v1 or
+// v1beta1 by name.
+func crdVersions(crd *unstructured.Unstructured) map[string]crdVersion {
+	versions := map[string]crdVersion{}
+	// v1beta1 CRDs may define a single schema for all versions
+	common, _, _ := unstructured.NestedMap(crd.Object, "spec", "validation", "openAPIV3Schema")
+
+	list, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, item := range list {
+		v, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(v, "name")
+		served, _, _ := unstructured.NestedBool(v, "served")
+		s, ok, _ := unstructured.NestedMap(v, "schema", "openAPIV3Schema")
+		if !ok {
+			s = common
+		}
+		versions[name] = crdVersion{served: served, schema: s}
+	}
+	if name, ok, _ := unstructured.NestedString(crd.Object, "spec", "version"); ok && len(list) == 0 {
+		versions[name] = crdVersion{served: true, schema: common}
+	}
+	return versions
+}
+
+// checkCRDUpgrade returns an error if replacing the live CRD by the desired
+// one would stop serving a version or drop fields still in use. inUse
+// returns the name of a custom resource of a version setting the field at
+// path, or an empty string.
+func checkCRDUpgrade(live, desired *unstructured.Unstructured, inUse func(version string, path []string) (string, error)) error {
+	liveVersions, desiredVersions := crdVersions(live), crdVersions(desired)
+
+	names := make([]string, 0, len(liveVersions))
+	for name := range liveVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lv := liveVersions[name]
+		if !lv.served {
+			continue
+		}
+		dv, ok := desiredVersions[name]
+		if !ok || !dv.served {
+			return errors.Errorf("version %s is served by the cluster but not by the chart", name)
+		}
+		if lv.schema == nil || dv.schema == nil {
+			continue
+		}
+		for _, path := range removedSchemaFields(lv.schema, dv.schema) {
+			obj, err := inUse(name, path)
+			if err != nil {
+				return errors.Wrapf(err, "unable to check the use of field %s of version %s", strings.Join(path, "."), name)
+			}
+			if obj != "" {
+				return errors.Errorf("field %s of version %s is removed but still set by %s", strings.Join(path, "."), name, obj)
+			}
+		}
+	}
+	return nil
+}
+
+// removedSchemaFields returns the paths of the fields of the live schema
+// that are missing from the desired one. Paths use "[]" for array items and
+// "*" for the values of maps.
+func removedSchemaFields(live, desired map[string]interface{}) [][]string {
+	liveFields, desiredFields := map[string][]string{}, map[string][]string{}
+	schemaFields(live, nil, liveFields)
+	schemaFields(desired, nil, desiredFields)
+
+	var removed [][]string
+	for key, path := range liveFields {
+		if _, ok := desiredFields[key]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return strings.Join(removed[i], ".") < strings.Join(removed[j], ".")
+	})
+	return removed
+}
+
+func schemaFields(s map[string]interface{}, prefix []string, out map[string][]string) {
+	if props, ok := s["properties"].(map[string]interface{}); ok {
+		for name, prop := range props {
+			path := append(append([]string{}, prefix...), name)
+			out[strings.Join(path, ".")] = path
+			if p, ok := prop.(map[string]interface{}); ok {
+				schemaFields(p, path, out)
+			}
+		}
+	}
+	if items, ok := s["items"].(map[string]interface{}); ok {
+		schemaFields(items, append(append([]string{}, prefix...), "[]"), out)
+	}
+	if additional, ok := s["additionalProperties"].(map[string]interface{}); ok {
+		schemaFields(additional, append(append([]string{}, prefix...), "*"), out)
+	}
+}
+
+// crdFieldInUse returns the name of the first custom resource of a version
+// of crd setting the field at path.
+func crdFieldInUse(client dynamic.Interface, crd *unstructured.Unstructured, version string, path []string) (string, error) {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	gvr := schema.GroupVersionResource{Group: group, Version: version, Resource: plural}
+
+	list, err := client.Resource(gvr).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, item := range list.Items {
+		if fieldSet(item.Object, path) {
+			if ns := item.GetNamespace(); ns != "" {
+				return fmt.Sprintf("%s/%s", ns, item.GetName()), nil
+			}
+			return item.GetName(), nil
+		}
+	}
+	return "", nil
+}
+
+// fieldSet reports whether obj has a value at path.
+func fieldSet(obj interface{}, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	switch path[0] {
+	case "[]":
+		items, _ := obj.([]interface{})
+		for _, item := range items {
+			if fieldSet(item, path[1:]) {
+				return true
+			}
+		}
+	case "*":
+		m, _ := obj.(map[string]interface{})
+		for _, v := range m {
+			if fieldSet(v, path[1:]) {
+				return true
+			}
+		}
+	default:
+		m, _ := obj.(map[string]interface{})
+		if v, ok := m[path[0]]; ok {
+			return fieldSet(v, path[1:])
+		}
+	}
+	return false
+}
+
+// crdUnchanged reports whether the desired CRD has the same versions and
+// schemas as the live one and every other field of its spec has the same
+// value in the live one. Fields defaulted by the API server are ignored.
+func crdUnchanged(live, desired *unstructured.Unstructured) bool {
+	if live.GetAPIVersion() != desired.GetAPIVersion() && desired.GetAPIVersion() != "" {
+		return false
+	}
+	liveVersions, desiredVersions := crdVersions(live), crdVersions(desired)
+	if len(liveVersions) != len(desiredVersions) {
+		return false
+	}
+	for name, dv := range desiredVersions {
+		lv, ok := liveVersions[name]
+		if !ok || lv.served != dv.served || !reflect.DeepEqual(normalize(lv.schema), normalize(dv.schema)) {
+			return false
+		}
+	}
+	return isSubset(normalize(desired.Object["spec"]), normalize(live.Object["spec"]))
+}
+
+// normalize round trips v through JSON so that values decoded from YAML
+// and from the API server compare equal.
+func normalize(v interface{}) interface{} {
+	var out interface{}
+	b, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(b, &out) != nil {
+		return nil
+	}
+	return out
+}
+
+func isSubset(want, have interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !isSubset(v, h[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !isSubset(w[i], h[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(want, have)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+const crdV1 = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              size:
+                type: integer
+              color:
+                type: string
+`
+
+// crdV1beta1 is crdV1 written as a v1beta1 CRD.
+const crdV1beta1 = `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  version: v1
+  validation:
+    openAPIV3Schema:
+      type: object
+      properties:
+        spec:
+          type: object
+          properties:
+            size:
+              type: integer
+            color:
+              type: string
+`
+
+func crdObject(t *testing.T, manifest string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestCheckCRDUpgrade(t *testing.T) {
+	widget := func(color string) (string, error) { return color, nil }
+
+	tests := []struct {
+		name    string
+		desired string
+		inUse   string
+		wantErr string
+	}{
+		{
+			name:    "added version",
+			desired: strings.Replace(crdV1, "  versions:\n", "  versions:\n  - name: v2\n    served: true\n    storage: false\n", 1),
+		},
+		{
+			name:    "removed served version",
+			desired: strings.Replace(crdV1, "name: v1", "name: v2", 1),
+			wantErr: "version v1 is served by the cluster but not by the chart",
+		},
+		{
+			name:    "version no longer served",
+			desired: strings.Replace(crdV1, "served: true", "served: false", 1),
+			wantErr: "version v1 is served by the cluster but not by the chart",
+		},
+		{
+			name:    "removed unused field",
+			desired: strings.Replace(crdV1, "              color:\n                type: string\n", "", 1),
+		},
+		{
+			name:    "removed field in use",
+			desired: strings.Replace(crdV1, "              color:\n                type: string\n", "", 1),
+			inUse:   "default/blue",
+			wantErr: "field spec.color of version v1 is removed but still set by default/blue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCRDUpgrade(crdObject(t, crdV1), crdObject(t, tt.desired), func(string, []string) (string, error) {
+				return widget(tt.inUse)
+			})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFieldSet(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ports":  []interface{}{map[string]interface{}{"name": "http"}},
+			"labels": map[string]interface{}{"a": map[string]interface{}{"value": "b"}},
+		},
+	}
+	assert.True(t, fieldSet(obj, []string{"spec", "ports", "[]", "name"}))
+	assert.False(t, fieldSet(obj, []string{"spec", "ports", "[]", "port"}))
+	assert.True(t, fieldSet(obj, []string{"spec", "labels", "*", "value"}))
+	assert.False(t, fieldSet(obj, []string{"status"}))
+}
+
+func TestUpgradeCRDs(t *testing.T) {
+	is := assert.New(t)
+
+	live := crdObject(t, crdV1)
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "blue", "namespace": "default"},
+		"spec":       map[string]interface{}{"color": "blue"},
+	}}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "example.com", Version: "v1", Resource: "widgets"}: "WidgetList",
+		crdResource: "CustomResourceDefinitionList",
+	}, live, cr)
+
+	defer func(f func(RESTClientGetter) (dynamic.Interface, error)) { newDynamicClient = f }(newDynamicClient)
+	newDynamicClient = func(RESTClientGetter) (dynamic.Interface, error) { return client, nil }
+
+	crd := func(name, data string) chart.CRD {
+		return chart.CRD{Name: name, File: &chart.File{Name: name, Data: []byte(data)}}
+	}
+	gadgets := strings.NewReplacer("widgets", "gadgets", "Widget", "Gadget").Replace(crdV1)
+
+	cfg := actionConfigFixture(t)
+
+	summary, err := cfg.upgradeCRDs([]chart.CRD{crd("crds/widgets.yaml", crdV1), crd("crds/gadgets.yaml", gadgets)}, false)
+	is.NoError(err)
+	is.Equal("CRDs created gadgets.example.com; unchanged widgets.example.com", summary)
+
+	// a v1beta1 CRD is compared to the live one as the v1 CRD it converts to
+	summary, err = cfg.upgradeCRDs([]chart.CRD{crd("crds/widgets.yaml", crdV1beta1)}, false)
+	is.NoError(err)
+	is.Equal("CRDs unchanged widgets.example.com", summary)
+	summary, err = cfg.upgradeCRDs([]chart.CRD{crd("crds/widgets.yaml", strings.Replace(crdV1beta1, "scope: Namespaced", "scope: Cluster", 1))}, true)
+	is.NoError(err)
+	is.Equal("CRDs upgraded widgets.example.com", summary)
+
+	added := strings.Replace(crdV1, "              color:\n", "              shape:\n                type: string\n              color:\n", 1)
+	summary, err = cfg.upgradeCRDs([]chart.CRD{crd("crds/widgets.yaml", added)}, false)
+	is.NoError(err)
+	is.Equal("CRDs upgraded widgets.example.com", summary)
+
+	removed := strings.Replace(crdV1, "              color:\n                type: string\n", "", 1)
+	_, err = cfg.upgradeCRDs([]chart.CRD{crd("crds/widgets.yaml", removed)}, true)
+	is.EqualError(err, "refusing to upgrade CRD widgets.example.com: field spec.color of version v1 is removed but still set by default/blue")
+
+	is.Equal("Upgrade complete (CRDs upgraded widgets.example.com)", withCRDSummary("Upgrade complete", "CRDs upgraded widgets.example.com"))
+	is.Equal("Upgrade complete", withCRDSummary("Upgrade complete", ""))
+}
+
+func TestUpgradeCRDsWithoutRESTClientGetter(t *testing.T) {
+	cfg := actionConfigFixture(t)
+	cfg.RESTClientGetter = nil
+
+	_, err := cfg.upgradeCRDs([]chart.CRD{{Name: "crds/widgets.yaml", File: &chart.File{Name: "crds/widgets.yaml", Data: []byte(crdV1)}}}, false)
+	assert.Error(t, err)
+}
+
+func TestUpgradeSkipsCRDsByDefault(t *testing.T) {
+	is := assert.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Info.Status = release.StatusDeployed
+	is.NoError(upAction.cfg.Releases.Create(rel))
+
+	ch := buildChart()
+	ch.Files = append(ch.Files, &chart.File{Name: "crds/widgets.yaml", Data: []byte(crdV1)})
+	res, err := upAction.Run(rel.Name, ch, map[string]interface{}{})
+	is.NoError(err)
+	is.Equal("Upgrade complete (CRDs skipped widgets.example.com)", res.Info.Description)
+}
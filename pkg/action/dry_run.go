@@ -0,0 +1,52 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/huolunl/helm/v3/pkg/kube"
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+// serverDryRun submits the resources of rel to the Kubernetes API server with
+// dryRun=All. Nothing is persisted, neither in the cluster nor in the release
+// storage. The status and description of rel are updated with the outcome.
+func (c *Configuration) serverDryRun(rel *release.Release, current, target kube.ResourceList) (*kube.DryRunResult, error) {
+	runner, ok := c.KubeClient.(kube.ServerDryRunner)
+	if !ok {
+		return nil, errors.Errorf("server dry run is not supported by the Kubernetes client %T", c.KubeClient)
+	}
+
+	c.Log("server dry run for %s", rel.Name)
+	res, err := runner.ServerDryRun(current, target)
+	if err != nil {
+		rel.SetStatus(release.StatusFailed, fmt.Sprintf("Server dry run failed: %s", err))
+		return res, errors.Wrap(err, "server dry run failed")
+	}
+	for _, w := range res.Warnings() {
+		c.Log("warning: %s", w)
+	}
+	if err := res.Err(); err != nil {
+		rel.SetStatus(release.StatusFailed, err.Error())
+		return res, err
+	}
+	rel.Info.Description = "Server dry run complete"
+	return res, nil
+}
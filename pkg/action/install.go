@@ -33,6 +33,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/cli-runtime/pkg/resource"
 	"sigs.k8s.io/yaml"
 
@@ -98,12 +99,40 @@ type Install struct {
 	// (for things like templating). These are ignored if ClientOnly is false
 	KubeVersion *chartutil.KubeVersion
 	APIVersions chartutil.VersionSet
+	// Capabilities replaces the default capabilities in client only mode,
+	// for example with a snapshot of a cluster. KubeVersion and APIVersions
+	// are applied on top of them.
+	Capabilities *chartutil.Capabilities
 	// Used by helm template to render charts with .Release.IsUpgrade. Ignored if Dry-Run is false
 	IsUpgrade bool
 	// Used by helm template to add the release as part of OutputDir path
 	// OutputDir/<ReleaseName>
 	UseReleaseName bool
 	PostRenderer   postrender.PostRenderer
+	// ServerDryRun sends every resource to the API server with dryRun=All
+	// so that admission and validation errors are reported without
+	// persisting anything. It implies DryRun.
+	ServerDryRun bool
+	// DryRunResult is populated by Run with the per-resource outcome of a
+	// server dry run.
+	DryRunResult *kube.DryRunResult
+	// Labels are user-defined labels stored on the release records. They
+	// can be used to select releases with 'helm list --selector'.
+	Labels map[string]string
+	// ValuesLayers are the values layers of the environment the values were
+	// merged from. They are recorded on the release.
+	ValuesLayers []release.ValuesLayer
+	// RedactValues, if set, returns a copy of the values with their secrets
+	// replaced by placeholders, or nil if they hold none. The release is
+	// stored and printed with the redacted values. Its manifests are stored as
+	// rendered, so the decrypted values are only protected in the storage by
+	// the encryption of its driver.
+	RedactValues func(map[string]interface{}) (map[string]interface{}, error)
+	// UpgradeCRDs replaces the CRDs of the crds/ directory that already
+	// exist in the cluster when the change is safe, instead of skipping them.
+	UpgradeCRDs bool
+
+	crdSummary string
 }
 
 // ChartPathOptions captures common options used for controlling chart paths
@@ -128,14 +157,15 @@ func NewInstall(cfg *Configuration) *Install {
 	}
 }
 
-func (i *Install) installCRDs(crds []chart.CRD) error {
+func (i *Install) installCRDs(crds []chart.CRD) (string, error) {
 	// We do these one file at a time in the order they were read.
 	totalItems := []*resource.Info{}
+	var created, skipped []string
 	for _, obj := range crds {
 		// Read in the resources
 		res, err := i.cfg.KubeClient.Build(bytes.NewBuffer(obj.File.Data), false)
 		if err != nil {
-			return errors.Wrapf(err, "failed to install CRD %s", obj.Name)
+			return "", errors.Wrapf(err, "failed to install CRD %s", obj.Name)
 		}
 
 		// Send them to Kube
@@ -144,9 +174,13 @@ func (i *Install) installCRDs(crds []chart.CRD) error {
 			if apierrors.IsAlreadyExists(err) {
 				crdName := res[0].Name
 				i.cfg.Log("CRD %s is already present. Skipping.", crdName)
+				skipped = append(skipped, crdName)
 				continue
 			}
-			return errors.Wrapf(err, "failed to install CRD %s", obj.Name)
+			return "", errors.Wrapf(err, "failed to install CRD %s", obj.Name)
+		}
+		for _, r := range res {
+			created = append(created, r.Name)
 		}
 		totalItems = append(totalItems, res...)
 	}
@@ -155,26 +189,49 @@ func (i *Install) installCRDs(crds []chart.CRD) error {
 		// present.
 		discoveryClient, err := i.cfg.RESTClientGetter.ToDiscoveryClient()
 		if err != nil {
-			return err
+			return "", err
 		}
 		i.cfg.Log("Clearing discovery cache")
 		discoveryClient.Invalidate()
 		// Give time for the CRD to be recognized.
 
 		if err := i.cfg.KubeClient.Wait(totalItems, 60*time.Second); err != nil {
-			return err
+			return "", err
 		}
 
 		// Make sure to force a rebuild of the cache.
 		discoveryClient.ServerGroups()
 	}
-	return nil
+	return summarizeCRDs(created, nil, nil, skipped), nil
 }
 
 // Run executes the installation
 //
 // If DryRun is set to true, this will prepare the release, but not install it
 func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
+	rel, err := i.run(chrt, vals)
+	i.cfg.recordAudit("install", i.ReleaseName, i.Namespace, rel, map[string]interface{}{
+		"chart":      chartRef(chrt),
+		"values":     vals,
+		"labels":     i.Labels,
+		"replace":    i.Replace,
+		"wait":       i.Wait,
+		"atomic":     i.Atomic,
+		"dryRun":     i.isDryRun(),
+		"clientOnly": i.ClientOnly,
+	}, i.isDryRun(), err)
+	return rel, err
+}
+
+func (i *Install) run(chrt *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
+	if i.ServerDryRun && i.ClientOnly {
+		return nil, errors.New("server dry run cannot be used in client-only mode")
+	}
+
+	if err := validateCustomLabels(i.Labels); err != nil {
+		return nil, err
+	}
+
 	// Check reachability of cluster unless in client-only mode (e.g. `helm template` without `--validate`)
 	if !i.ClientOnly {
 		if err := i.cfg.KubeClient.IsReachable(); err != nil {
@@ -188,12 +245,24 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 
 	// Pre-install anything in the crd/ directory. We do this before Helm
 	// contacts the upstream server and builds the capabilities object.
-	if crds := chrt.CRDObjects(); !i.ClientOnly && !i.SkipCRDs && len(crds) > 0 {
+	if crds := chrt.CRDObjects(); !i.ClientOnly && i.SkipCRDs && len(crds) > 0 {
+		i.crdSummary = summarizeCRDs(nil, nil, nil, crdNames(crds))
+	} else if !i.ClientOnly && len(crds) > 0 {
 		// On dry run, bail here
-		if i.DryRun {
+		if i.UpgradeCRDs {
+			summary, err := i.cfg.upgradeCRDs(crds, i.isDryRun())
+			if err != nil {
+				return nil, err
+			}
+			i.crdSummary = summary
+		} else if i.isDryRun() {
 			i.cfg.Log("WARNING: This chart or one of its subcharts contains CRDs. Rendering may fail or contain inaccuracies.")
-		} else if err := i.installCRDs(crds); err != nil {
-			return nil, err
+		} else {
+			summary, err := i.installCRDs(crds)
+			if err != nil {
+				return nil, err
+			}
+			i.crdSummary = summary
 		}
 	}
 
@@ -201,6 +270,9 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 		// Add mock objects in here so it doesn't use Kube API server
 		// NOTE(bacongobbler): used for `helm template`
 		i.cfg.Capabilities = chartutil.DefaultCapabilities.Copy()
+		if i.Capabilities != nil {
+			i.cfg.Capabilities = i.Capabilities.Copy()
+		}
 		if i.KubeVersion != nil {
 			i.cfg.Capabilities.KubeVersion = *i.KubeVersion
 		}
@@ -228,7 +300,7 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 	}
 
 	//special case for helm template --is-upgrade
-	isUpgrade := i.IsUpgrade && i.DryRun
+	isUpgrade := i.IsUpgrade && i.isDryRun()
 	options := chartutil.ReleaseOptions{
 		Name:      i.ReleaseName,
 		Namespace: i.Namespace,
@@ -240,15 +312,25 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 	if err != nil {
 		return nil, err
 	}
+	var redactedVals map[string]interface{}
+	if i.RedactValues != nil {
+		if redactedVals, err = i.RedactValues(vals); err != nil {
+			return nil, err
+		}
+	}
 
 	rel := i.createRelease(chrt, vals)
 
 	var manifestDoc *bytes.Buffer
-	rel.Hooks, manifestDoc, rel.Info.Notes, err = i.cfg.renderResources(chrt, valuesToRender, i.ReleaseName, i.OutputDir, i.SubNotes, i.UseReleaseName, i.IncludeCRDs, i.PostRenderer, i.DryRun)
+	sensitive := map[string]string{}
+	rel.Hooks, manifestDoc, rel.Info.Notes, err = i.cfg.renderResources(chrt, valuesToRender, i.ReleaseName, i.OutputDir, i.SubNotes, i.UseReleaseName, i.IncludeCRDs, i.PostRenderer, i.DryRun && !i.ServerDryRun, sensitive)
 	// Even for errors, attach this if available
 	if manifestDoc != nil {
 		rel.Manifest = manifestDoc.String()
 	}
+	if err == nil {
+		i.cfg.redactRelease(rel, sensitive, redactedVals)
+	}
 	// Check error from render
 	if err != nil {
 		rel.SetStatus(release.StatusFailed, fmt.Sprintf("failed to render resource: %s", err.Error()))
@@ -285,7 +367,11 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 	}
 
 	// Bail out here if it is a dry run
-	if i.DryRun {
+	if i.ServerDryRun {
+		i.DryRunResult, err = i.cfg.serverDryRun(rel, toBeAdopted, resources)
+		return rel, err
+	}
+	if i.isDryRun() {
 		rel.Info.Description = "Dry run complete"
 		return rel, nil
 	}
@@ -343,11 +429,11 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 	// do an update, but it's not clear whether we WANT to do an update if the re-use is set
 	// to true, since that is basically an upgrade operation.
 	if len(toBeAdopted) == 0 && len(resources) > 0 {
-		if _, err := i.cfg.KubeClient.Create(resources); err != nil {
+		if _, err := i.cfg.createResources(resources, i.Timeout); err != nil {
 			return i.failRelease(rel, err)
 		}
 	} else if len(resources) > 0 {
-		if _, err := i.cfg.KubeClient.Update(toBeAdopted, resources, false); err != nil {
+		if _, err := i.cfg.updateResources(toBeAdopted, resources, false, i.Timeout); err != nil {
 			return i.failRelease(rel, err)
 		}
 	}
@@ -373,7 +459,7 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 	if len(i.Description) > 0 {
 		rel.SetStatus(release.StatusDeployed, i.Description)
 	} else {
-		rel.SetStatus(release.StatusDeployed, "Install complete")
+		rel.SetStatus(release.StatusDeployed, withCRDSummary("Install complete", i.crdSummary))
 	}
 
 	// This is a tricky case. The release has been created, but the result
@@ -407,6 +493,12 @@ func (i *Install) failRelease(rel *release.Release, err error) (*release.Release
 	return rel, err
 }
 
+// isDryRun reports whether the installation is only prepared, as it is for
+// server dry runs.
+func (i *Install) isDryRun() bool {
+	return i.DryRun || i.ServerDryRun
+}
+
 // availableName tests whether a name is available
 //
 // Roughly, this will return an error if name is
@@ -425,7 +517,7 @@ func (i *Install) availableName() error {
 		return errors.Errorf("release name %q exceeds max length of %d", start, releaseNameMaxLen)
 	}
 
-	if i.DryRun {
+	if i.isDryRun() {
 		return nil
 	}
 
@@ -446,24 +538,43 @@ func (i *Install) availableName() error {
 func (i *Install) createRelease(chrt *chart.Chart, rawVals map[string]interface{}) *release.Release {
 	ts := i.cfg.Now()
 	return &release.Release{
-		Name:      i.ReleaseName,
-		Namespace: i.Namespace,
-		Chart:     chrt,
-		Config:    rawVals,
+		Name:         i.ReleaseName,
+		Namespace:    i.Namespace,
+		Chart:        chrt,
+		Config:       rawVals,
+		ValuesLayers: i.ValuesLayers,
 		Info: &release.Info{
 			FirstDeployed: ts,
 			LastDeployed:  ts,
 			Status:        release.StatusUnknown,
 		},
 		Version: 1,
+		Labels:  i.Labels,
+	}
+}
+
+// validateCustomLabels checks that the user-defined labels are valid
+// Kubernetes labels and do not override the labels set by the storage drivers.
+func validateCustomLabels(lbs map[string]string) error {
+	if driver.ContainsSystemLabels(lbs) {
+		return errors.Errorf("user-defined labels must not contain the reserved labels %s", strings.Join(driver.GetSystemLabels(), ", "))
 	}
+	for k, v := range lbs {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			return errors.Errorf("invalid label key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+			return errors.Errorf("invalid value for label %q: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
 }
 
 // recordRelease with an update operation in case reuse has been set.
 func (i *Install) recordRelease(r *release.Release) error {
 	// This is a legacy function which has been reduced to a oneliner. Could probably
 	// refactor it out.
-	return i.cfg.Releases.Update(r)
+	return i.cfg.updateRelease(r)
 }
 
 // replaceRelease replaces an older release with this one
@@ -488,8 +599,9 @@ func (i *Install) replaceRelease(rel *release.Release) error {
 	}
 
 	// For any other status, mark it as superseded and store the old record
-	last.SetStatus(release.StatusSuperseded, "superseded by new release")
-	return i.recordRelease(last)
+	return i.cfg.modifyRelease(last, func(r *release.Release) {
+		r.SetStatus(release.StatusSuperseded, "superseded by new release")
+	})
 }
 
 // write the <data> to <output-dir>/<name>. <append> controls if the file is created or content will be appended
@@ -17,20 +17,33 @@ limitations under the License.
 package action
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"text/template"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	restfake "k8s.io/client-go/rest/fake"
 
 	"github.com/huolunl/helm/v3/internal/test"
 	"github.com/huolunl/helm/v3/pkg/chart"
 	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/engine"
+	"github.com/huolunl/helm/v3/pkg/kube"
 	kubefake "github.com/huolunl/helm/v3/pkg/kube/fake"
 	"github.com/huolunl/helm/v3/pkg/release"
 	"github.com/huolunl/helm/v3/pkg/storage/driver"
@@ -241,6 +254,49 @@ func TestInstallRelease_DryRun(t *testing.T) {
 	is.Equal(res.Info.Description, "Dry run complete")
 }
 
+func TestInstallRelease_ServerDryRun(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.ServerDryRun = true
+	vals := map[string]interface{}{}
+	res, err := instAction.Run(buildChart(withSampleTemplates()), vals)
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+
+	is.False(instAction.DryRun, "server dry run must not change the dry run setting")
+	is.NotNil(instAction.DryRunResult)
+	is.Equal("Server dry run complete", res.Info.Description)
+	_, err = instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.Error(err)
+
+	// the same action then installs for real
+	instAction.ServerDryRun = false
+	res, err = instAction.Run(buildChart(withSampleTemplates()), vals)
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.Equal("Install complete", res.Info.Description)
+	_, err = instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+}
+
+func TestInstallRelease_ServerDryRunFailure(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.ServerDryRun = true
+	failer := instAction.cfg.KubeClient.(*kubefake.FailingKubeClient)
+	failer.ServerDryRunError = fmt.Errorf("admission webhook denied the request")
+	instAction.cfg.KubeClient = failer
+	vals := map[string]interface{}{}
+	res, err := instAction.Run(buildChart(withSampleTemplates()), vals)
+	is.Error(err)
+	is.Contains(err.Error(), "admission webhook denied the request")
+	is.Equal(release.StatusFailed, res.Info.Status)
+	_, err = instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.Error(err)
+}
+
 // Regression test for #7955: Lookup must not connect to Kubernetes on a dry-run.
 func TestInstallRelease_DryRun_Lookup(t *testing.T) {
 	is := assert.New(t)
@@ -308,6 +364,80 @@ func TestInstallRelease_FailedHooks(t *testing.T) {
 	is.Equal(release.StatusFailed, res.Info.Status)
 }
 
+// existingConfigMapKubeClient builds every manifest into a single ConfigMap
+// that already exists in the cluster without Helm's ownership metadata.
+type existingConfigMapKubeClient struct {
+	kubefake.PrintingKubeClient
+	strategy string
+	updated  kube.ResourceList
+}
+
+func (c *existingConfigMapKubeClient) Build(_ io.Reader, _ bool) (kube.ResourceList, error) {
+	existing := &v1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: "spaced"},
+	}
+	desired := existing.DeepCopy()
+	if c.strategy != "" {
+		desired.Annotations = map[string]string{kube.UpdateStrategyAnno: c.strategy}
+	}
+	return kube.ResourceList{{
+		Client: &restfake.RESTClient{
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+			Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+				if req.Method != "GET" || req.URL.Path != "/namespaces/spaced/configmaps/bootstrap" {
+					return nil, errors.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+				}
+				header := http.Header{}
+				header.Set("Content-Type", runtime.ContentTypeJSON)
+				body := runtime.EncodeOrDie(scheme.Codecs.LegacyCodec(v1.SchemeGroupVersion), existing)
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+			}),
+		},
+		Mapping: &meta.RESTMapping{
+			Resource:         v1.SchemeGroupVersion.WithResource("configmaps"),
+			GroupVersionKind: v1.SchemeGroupVersion.WithKind("ConfigMap"),
+			Scope:            meta.RESTScopeNamespace,
+		},
+		Namespace: "spaced",
+		Name:      "bootstrap",
+		Object:    desired,
+	}}, nil
+}
+
+func (c *existingConfigMapKubeClient) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	c.updated = original
+	return c.PrintingKubeClient.Update(original, target, force)
+}
+
+func TestInstallRelease_ExistingResourceUpdateStrategy(t *testing.T) {
+	is := assert.New(t)
+
+	// Without an update strategy the unowned ConfigMap cannot be adopted.
+	instAction := installAction(t)
+	instAction.DisableHooks = true
+	instAction.cfg.KubeClient = &existingConfigMapKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	_, err := instAction.Run(buildChart(), map[string]interface{}{})
+	is.Error(err)
+	is.Contains(err.Error(), "exists and cannot be imported into the current release")
+
+	// With skip-if-exists it is handed to the update, which leaves it alone.
+	instAction = installAction(t)
+	instAction.DisableHooks = true
+	client := &existingConfigMapKubeClient{
+		PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		strategy:           kube.UpdateStrategySkipIfExists,
+	}
+	instAction.cfg.KubeClient = client
+	res, err := instAction.Run(buildChart(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.Equal(release.StatusDeployed, res.Info.Status)
+	is.Len(client.updated, 1)
+	is.Equal("bootstrap", client.updated[0].Name)
+}
+
 func TestInstallRelease_ReplaceRelease(t *testing.T) {
 	is := assert.New(t)
 	instAction := installAction(t)
@@ -668,3 +798,207 @@ func TestNameAndChartGenerateName(t *testing.T) {
 		})
 	}
 }
+
+func TestInstallRelease_WithCustomLabels(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.Labels = map[string]string{"team": "blue", "example.com/tier": "backend"}
+
+	res, err := instAction.Run(buildChart(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.Equal(instAction.Labels, res.Labels)
+
+	rel, err := instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+	is.Equal(instAction.Labels, rel.Labels)
+}
+
+func TestInstallRelease_WithValuesLayers(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.ValuesLayers = []release.ValuesLayer{
+		{Name: "base", Files: []string{"values-base.yaml"}, Lists: "replace", Maps: "merge"},
+		{Name: "env", Files: []string{"values-prod.yaml"}, Lists: "append", Maps: "merge"},
+	}
+
+	res, err := instAction.Run(buildChart(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+
+	rel, err := instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+	is.Equal(instAction.ValuesLayers, rel.ValuesLayers)
+}
+
+func TestInstallRelease_WithInvalidCustomLabels(t *testing.T) {
+	is := assert.New(t)
+
+	instAction := installAction(t)
+	instAction.Labels = map[string]string{"owner": "me"}
+	_, err := instAction.Run(buildChart(), map[string]interface{}{})
+	is.Error(err)
+	is.Contains(err.Error(), "reserved labels")
+
+	instAction = installAction(t)
+	instAction.Labels = map[string]string{"team": "not a valid value"}
+	_, err = instAction.Run(buildChart(), map[string]interface{}{})
+	is.Error(err)
+	is.Contains(err.Error(), `invalid value for label "team"`)
+}
+
+func TestInstallRelease_CustomTemplateFuncs(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	e := &engine.Engine{}
+	is.NoError(e.RegisterFuncs(template.FuncMap{"platformDomain": func() string { return "apps.example.com" }}))
+	instAction.cfg.Engine = e
+
+	res, err := instAction.Run(buildChart(withTemplate("templates/host", "host: {{ .Release.Name }}.{{ platformDomain }}\n")), map[string]interface{}{})
+	is.NoError(err)
+	is.Contains(res.Manifest, "host: test-install-release.apps.example.com")
+}
+
+func TestInstallRelease_SecretRef(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.cfg.SecretProviders = map[string]engine.SecretProvider{
+		"vault": engine.JSONSecrets{"db": map[string]interface{}{"password": "p4ss"}},
+	}
+
+	chrt := buildChart(withTemplate("templates/secret", "password: {{ secretRef \"vault\" \"db/password\" | b64enc }}\n"))
+	res, err := instAction.Run(chrt, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.Contains(res.Manifest, "password: cDRzcw==")
+	is.Contains(res.Redacted().Manifest, "password: <redacted-base64:vault:db/password>\n")
+	is.False(res.Unrestorable)
+
+	rel, err := instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+	is.Contains(rel.Manifest, "password: <redacted-base64:vault:db/password>")
+	is.NotContains(rel.Manifest, "cDRzcw==")
+
+	instAction = installAction(t)
+	_, err = instAction.Run(chrt, map[string]interface{}{})
+	is.Error(err)
+	is.Contains(err.Error(), `secret provider "vault" is not configured`)
+}
+
+func TestInstallRelease_SecretRefRedactsRender(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.cfg.SecretProviders = map[string]engine.SecretProvider{
+		"vault": engine.JSONSecrets{"replicas": "1", "password": "p4ss", "hook": "true"},
+	}
+
+	// the stored manifest is the applied one with the secrets replaced, even
+	// for random values and hooks selected by a secret, but not the text
+	// that merely contains a secret
+	chrt := buildChart(
+		withTemplate("templates/config", "replicas: {{ secretRef \"vault\" \"replicas\" }}\nport: 8{{ secretRef \"vault\" \"replicas\" }}\nimage: registry/p4ss-app:1\ntoken: {{ randAlphaNum 16 }}\n"),
+		withTemplate("templates/digest", "digest: {{ secretRef \"vault\" \"password\" | sha256sum }}\n"),
+		withTemplate("templates/hook", `{{ if eq (secretRef "vault" "hook") "true" }}kind: ConfigMap
+metadata:
+  name: test-cm
+  annotations:
+    "helm.sh/hook": post-install
+data:
+  password: {{ secretRef "vault" "password" }}
+{{ end }}`),
+	)
+	res, err := instAction.Run(chrt, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.False(res.Unrestorable)
+	is.Len(res.Hooks, 2)
+
+	rel, err := instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+	is.Contains(rel.Manifest, "replicas: <redacted:vault:replicas>\nport: 81\nimage: registry/p4ss-app:1\n")
+	is.Equal("hello/templates/hook", rel.Hooks[0].Path)
+	is.Contains(rel.Hooks[0].Manifest, "password: <redacted:vault:password>")
+	is.False(rel.Unrestorable)
+
+	restored, err := engine.RestoreSecrets(rel.Manifest, instAction.cfg.SecretProviders)
+	is.NoError(err)
+	is.Equal(res.Manifest, restored)
+	is.Contains(restored, fmt.Sprintf("digest: %x", sha256.Sum256([]byte("p4ss"))))
+}
+
+// redactPassword redacts the value of password like values.Options does for
+// the values decrypted from encrypted values files.
+func redactPassword(vals map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := vals["password"]; !ok {
+		return nil, nil
+	}
+	redacted := map[string]interface{}{}
+	for k, v := range vals {
+		redacted[k] = v
+	}
+	redacted["password"] = "<encrypted:password>"
+	return redacted, nil
+}
+
+func TestInstallRelease_RedactValues(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.RedactValues = redactPassword
+
+	// short decrypted values are common substrings of the manifests, which
+	// are stored as rendered
+	chrt := buildChart(withTemplate("templates/secret", `apiVersion: apps/v1
+password: {{ .Values.password | b64enc }}
+port: 80
+image: app:{{ .Values.password }}
+`))
+	res, err := instAction.Run(chrt, map[string]interface{}{"password": "v1", "user": "app"})
+	if err != nil {
+		t.Fatalf("Failed install: %s", err)
+	}
+	is.Equal("v1", res.Config["password"])
+
+	rel, err := instAction.cfg.Releases.Get(res.Name, res.Version)
+	is.NoError(err)
+	is.Equal(map[string]interface{}{"password": "<encrypted:password>", "user": "app"}, rel.Config)
+	is.Equal(res.Manifest, rel.Manifest)
+	is.Contains(rel.Manifest, "apiVersion: apps/v1\npassword: djE=\nport: 80\nimage: app:v1\n")
+	is.True(rel.Unrestorable)
+
+	// the release can still be upgraded
+	upAction := NewUpgrade(instAction.cfg)
+	upAction.Namespace = instAction.Namespace
+	upAction.RedactValues = redactPassword
+	upgraded, err := upAction.Run(res.Name, chrt, map[string]interface{}{"password": "80"})
+	is.NoError(err)
+	is.Contains(upgraded.Manifest, "password: ODA=\nport: 80\nimage: app:80\n")
+
+	instAction = installAction(t)
+	instAction.RedactValues = func(map[string]interface{}) (map[string]interface{}, error) {
+		return nil, errors.New("the value of password is the placeholder <encrypted:password> of an encrypted value")
+	}
+	_, err = instAction.Run(chrt, map[string]interface{}{"password": "<encrypted:password>"})
+	is.Error(err)
+	is.Contains(err.Error(), "placeholder <encrypted:password>")
+}
+
+func TestInstallRelease_SourceMapYAMLError(t *testing.T) {
+	is := assert.New(t)
+	instAction := installAction(t)
+	instAction.cfg.SourceMap = engine.SourceMap{}
+
+	chrt := buildChart(
+		withTemplate("templates/_helpers.tpl", `{{ define "labels" }}app: web
+ bad: indent{{ end }}`),
+		withTemplate("templates/cm.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n  labels:\n    {{- include \"labels\" . | nindent 4 }}\n"),
+	)
+	_, err := instAction.Run(chrt, map[string]interface{}{})
+	is.Error(err)
+	is.Contains(err.Error(), "YAML parse error on hello/templates/cm.yaml")
+	is.Contains(err.Error(), "\t# hello/templates/_helpers.tpl:2 (\"labels\") <- hello/templates/cm.yaml:6\n")
+	is.Len(instAction.cfg.SourceMap["hello/templates/cm.yaml"], 8)
+}
@@ -25,7 +25,9 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/engine"
 	"github.com/huolunl/helm/v3/pkg/lint"
+	"github.com/huolunl/helm/v3/pkg/lint/rules"
 	"github.com/huolunl/helm/v3/pkg/lint/support"
 )
 
@@ -36,6 +38,11 @@ type Lint struct {
 	Strict        bool
 	Namespace     string
 	WithSubcharts bool
+	// Capabilities are used to render the templates instead of the
+	// default ones, for example a snapshot of a cluster.
+	Capabilities *chartutil.Capabilities
+	// LookupSource provides the objects returned by the lookup function.
+	LookupSource engine.LookupSource
 }
 
 // LintResult is the result of Lint
@@ -58,7 +65,10 @@ func (l *Lint) Run(paths []string, vals map[string]interface{}) *LintResult {
 	}
 	result := &LintResult{}
 	for _, path := range paths {
-		linter, err := lintChart(path, vals, l.Namespace, l.Strict)
+		linter, err := lintChart(path, vals, l.Namespace, l.Strict, rules.TemplateOptions{
+			Capabilities: l.Capabilities,
+			LookupSource: l.LookupSource,
+		})
 		if err != nil {
 			result.Errors = append(result.Errors, err)
 			continue
@@ -75,7 +85,7 @@ func (l *Lint) Run(paths []string, vals map[string]interface{}) *LintResult {
 	return result
 }
 
-func lintChart(path string, vals map[string]interface{}, namespace string, strict bool) (support.Linter, error) {
+func lintChart(path string, vals map[string]interface{}, namespace string, strict bool, opts rules.TemplateOptions) (support.Linter, error) {
 	var chartPath string
 	linter := support.Linter{}
 
@@ -114,5 +124,5 @@ func lintChart(path string, vals map[string]interface{}, namespace string, stric
 		return linter, errors.Wrap(err, "unable to check Chart.yaml file in chart")
 	}
 
-	return lint.All(chartPath, vals, namespace, strict), nil
+	return lint.AllWithOptions(chartPath, vals, namespace, strict, opts), nil
 }
@@ -18,6 +18,8 @@ package action
 
 import (
 	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/lint/rules"
 )
 
 var (
@@ -78,7 +80,7 @@ func TestLintChart(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := lintChart(tt.chartPath, map[string]interface{}{}, namespace, strict)
+			_, err := lintChart(tt.chartPath, map[string]interface{}{}, namespace, strict, rules.TemplateOptions{})
 			switch {
 			case err != nil && !tt.err:
 				t.Errorf("%s", err)
@@ -19,11 +19,14 @@ package action
 import (
 	"path"
 	"regexp"
+	"strconv"
 
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 
 	"github.com/huolunl/helm/v3/pkg/release"
 	"github.com/huolunl/helm/v3/pkg/releaseutil"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
 )
 
 // ListStates represents zero or more status codes that a list item may have set
@@ -80,6 +83,27 @@ func (s ListStates) FromName(str string) ListStates {
 	return ListUnknown
 }
 
+// statuses returns the release statuses matched by the state mask.
+func (s ListStates) statuses() []release.Status {
+	var statuses []release.Status
+	for _, status := range []release.Status{
+		release.StatusUnknown,
+		release.StatusDeployed,
+		release.StatusUninstalled,
+		release.StatusSuperseded,
+		release.StatusFailed,
+		release.StatusUninstalling,
+		release.StatusPendingInstall,
+		release.StatusPendingUpgrade,
+		release.StatusPendingRollback,
+	} {
+		if s&s.FromName(status.String()) != 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
 // ListAll is a convenience for enabling all list filters
 const ListAll = ListDeployed | ListUninstalled | ListUninstalling | ListPendingInstall | ListPendingRollback | ListPendingUpgrade | ListSuperseded | ListFailed
 
@@ -158,7 +182,18 @@ func (l *List) Run() ([]*release.Release, error) {
 		}
 	}
 
-	results, err := l.cfg.Releases.List(func(rel *release.Release) bool {
+	selectorObj, err := labels.Parse(l.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave the filtering, sorting and pagination to the storage driver if
+	// it supports it. Label selectors are only evaluated here.
+	if pager, ok := l.cfg.Releases.Driver.(driver.Paginator); ok && selectorObj.Empty() {
+		return l.listPage(pager)
+	}
+
+	results, err := l.listReleases(selectorObj, func(rel *release.Release) bool {
 		// Skip anything that doesn't match the filter.
 		if filter != nil && !filter.MatchString(rel.Name) {
 			return false
@@ -187,18 +222,19 @@ func (l *List) Run() ([]*release.Release, error) {
 	results = l.filterStateMask(results)
 
 	// Skip anything that doesn't match the selector
-	selectorObj, err := labels.Parse(l.Selector)
-	if err != nil {
-		return nil, err
-	}
 	results = l.filterSelector(results, selectorObj)
 
 	// Unfortunately, we have to sort before truncating, which can incur substantial overhead
 	l.sort(results)
 
+	return l.paginate(results), nil
+}
+
+// paginate truncates the sorted results according to the limit and offset.
+func (l *List) paginate(results []*release.Release) []*release.Release {
 	// Guard on offset
 	if l.Offset >= len(results) {
-		return []*release.Release{}, nil
+		return []*release.Release{}
 	}
 
 	// Calculate the limit and offset, and then truncate results if necessary.
@@ -210,9 +246,135 @@ func (l *List) Run() ([]*release.Release, error) {
 	if l := len(results); l < last {
 		last = l
 	}
-	results = results[l.Offset:last]
+	return results[l.Offset:last]
+}
+
+// listPage lists the releases through a driver able to filter and paginate
+// in the storage backend. Releases sorted by date are still sorted and
+// paginated here since the drivers only order by name.
+func (l *List) listPage(pager driver.Paginator) ([]*release.Release, error) {
+	opts := driver.ListOptions{
+		Statuses:    l.StateMask.statuses(),
+		NamePattern: l.Filter,
+		// by definition, superseded releases are never shown if
+		// only the latest releases are returned. so if requested statemask
+		// is _only_ ListSuperseded, skip the latest release filter
+		LatestOnly: l.StateMask != ListSuperseded,
+	}
+	if len(opts.Statuses) == 0 {
+		return []*release.Release{}, nil
+	}
+
+	if l.ByDate || l.Sort == ByDateAsc || l.Sort == ByDateDesc {
+		results, err := pager.ListPage(opts)
+		if err != nil {
+			return nil, err
+		}
+		l.sort(results)
+		return l.paginate(results), nil
+	}
 
-	return results, err
+	opts.SortDescending = l.SortReverse || l.Sort == ByNameDesc
+	opts.Limit = l.Limit
+	opts.Offset = l.Offset
+	results, err := pager.ListPage(opts)
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		return []*release.Release{}, nil
+	}
+	return results, nil
+}
+
+// listReleases fetches the releases matching filter from the storage. If the
+// selector only consists of equality requirements, it is handed to the
+// storage driver so that non-matching releases are not even fetched.
+//
+// When the latest revisions are picked afterwards, all the revisions of the
+// releases with a matching revision are fetched: picking the latest among the
+// matching revisions only would return an older revision when the labels
+// changed since.
+func (l *List) listReleases(selector labels.Selector, filter func(*release.Release) bool) ([]*release.Release, error) {
+	query, ok := equalitySelector(selector)
+	if !ok {
+		return l.cfg.Releases.List(filter)
+	}
+
+	query["owner"] = "helm"
+	rels, err := l.queryReleases(query)
+	if err != nil {
+		return nil, err
+	}
+	if l.StateMask != ListSuperseded {
+		if rels, err = l.releaseRevisions(rels); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []*release.Release
+	for _, rel := range rels {
+		if filter(rel) {
+			results = append(results, rel)
+		}
+	}
+	return results, nil
+}
+
+// releaseRevisions fetches all the revisions of the releases of rels.
+func (l *List) releaseRevisions(rels []*release.Release) ([]*release.Release, error) {
+	var revisions []*release.Release
+	seen := map[string]bool{}
+	for _, rel := range rels {
+		if seen[rel.Name] {
+			continue
+		}
+		seen[rel.Name] = true
+		history, err := l.queryReleases(map[string]string{"name": rel.Name, "owner": "helm"})
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, history...)
+	}
+	return revisions, nil
+}
+
+// queryReleases queries the storage, returning no releases rather than
+// driver.ErrReleaseNotFound when none match.
+func (l *List) queryReleases(query map[string]string) ([]*release.Release, error) {
+	rels, err := l.cfg.Releases.Query(query)
+	if err == driver.ErrReleaseNotFound {
+		return nil, nil
+	}
+	return rels, err
+}
+
+// equalitySelector converts a selector made only of equality requirements
+// into a map of labels. ok is false if the selector is empty or uses any
+// other operator.
+func equalitySelector(selector labels.Selector) (query map[string]string, ok bool) {
+	reqs, selectable := selector.Requirements()
+	if !selectable || len(reqs) == 0 {
+		return nil, false
+	}
+
+	query = make(map[string]string, len(reqs))
+	for _, req := range reqs {
+		switch req.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+		case selection.In:
+			if req.Values().Len() != 1 {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		if _, exists := query[req.Key()]; exists {
+			return nil, false
+		}
+		query[req.Key()] = req.Values().List()[0]
+	}
+	return query, true
 }
 
 // sort is an in-place sort where order is based on the value of a.Sort
@@ -279,7 +441,7 @@ func (l *List) filterSelector(releases []*release.Release, selector labels.Selec
 	desiredStateReleases := make([]*release.Release, 0)
 
 	for _, rls := range releases {
-		if selector.Matches(labels.Set(rls.Labels)) {
+		if selector.Matches(releaseLabels(rls)) {
 			desiredStateReleases = append(desiredStateReleases, rls)
 		}
 	}
@@ -287,6 +449,22 @@ func (l *List) filterSelector(releases []*release.Release, selector labels.Selec
 	return desiredStateReleases
 }
 
+// releaseLabels returns the user-defined labels of rls together with the
+// labels the storage drivers set on every release record.
+func releaseLabels(rls *release.Release) labels.Set {
+	set := labels.Set{}
+	for k, v := range rls.Labels {
+		set[k] = v
+	}
+	set["name"] = rls.Name
+	set["owner"] = "helm"
+	set["version"] = strconv.Itoa(rls.Version)
+	if rls.Info != nil {
+		set["status"] = rls.Info.Status.String()
+	}
+	return set
+}
+
 // SetStateMask calculates the state mask based on parameters.
 func (l *List) SetStateMask() {
 	if l.All {
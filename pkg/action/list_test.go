@@ -17,12 +17,18 @@ limitations under the License.
 package action
 
 import (
+	"fmt"
+	"path/filepath"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
 
 	"github.com/huolunl/helm/v3/pkg/release"
 	"github.com/huolunl/helm/v3/pkg/storage"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
 )
 
 func TestListStates(t *testing.T) {
@@ -365,4 +371,245 @@ func TestSelectorList(t *testing.T) {
 		expectedFilteredList := []*release.Release{r2, r3}
 		assert.ElementsMatch(t, expectedFilteredList, res)
 	})
+
+	t.Run("should select releases by system and custom labels", func(t *testing.T) {
+		lister.Selector = "key=value2,status=deployed"
+		res, _ := lister.Run()
+
+		expectedFilteredList := []*release.Release{r2}
+		assert.ElementsMatch(t, expectedFilteredList, res)
+	})
+
+	t.Run("should return no release when nothing matches the label", func(t *testing.T) {
+		lister.Selector = "key=value3"
+		res, err := lister.Run()
+
+		assert.NoError(t, err)
+		assert.Empty(t, res)
+	})
+}
+
+func TestSelectorListLabelsChanged(t *testing.T) {
+	r1 := namedReleaseStub("r1", release.StatusSuperseded)
+	r1.Labels = map[string]string{"team": "a"}
+	r2 := namedReleaseStub("r1", release.StatusDeployed)
+	r2.Version = 2
+	r2.Labels = map[string]string{"team": "b"}
+
+	lister := newListFixture(t)
+	lister.StateMask = ListAll
+	for _, rel := range []*release.Release{r1, r2} {
+		if err := lister.cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("should not select an older revision matching the label", func(t *testing.T) {
+		for _, selector := range []string{"team=a", "version=1"} {
+			lister.Selector = selector
+			res, err := lister.Run()
+
+			assert.NoError(t, err)
+			assert.Empty(t, res, selector)
+		}
+	})
+
+	t.Run("should select the latest revision matching the label", func(t *testing.T) {
+		lister.Selector = "team=b"
+		res, err := lister.Run()
+
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []*release.Release{r2}, res)
+	})
+
+	t.Run("should select superseded revisions matching the label", func(t *testing.T) {
+		lister.Selector = "team=a"
+		lister.StateMask = ListSuperseded
+		res, err := lister.Run()
+
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []*release.Release{r1}, res)
+	})
+}
+
+func TestListPushDown(t *testing.T) {
+	// The SQL driver filters and paginates in the database, its results
+	// must match the in-memory implementation used by the other drivers.
+	sqlDriver, err := driver.NewSQL("sqlite://"+filepath.Join(t.TempDir(), "helm.db"), t.Logf, "default")
+	require.NoError(t, err)
+
+	memLister := newListFixture(t)
+	sqlLister := newListFixture(t)
+	sqlLister.cfg.Releases = storage.Init(sqlDriver)
+	makeMeSomeReleasesWithStaleFailure(memLister.cfg.Releases, t)
+	makeMeSomeReleasesWithStaleFailure(sqlLister.cfg.Releases, t)
+
+	names := func(rels []*release.Release) []string {
+		names := []string{}
+		for _, rel := range rels {
+			names = append(names, fmt.Sprintf("%s.v%d", rel.Name, rel.Version))
+		}
+		return names
+	}
+
+	for _, tt := range []struct {
+		desc  string
+		apply func(l *List)
+		// the fixtures share a deployment date, so sorting by date
+		// leaves the order undefined
+		unordered bool
+	}{
+		{"defaults", func(l *List) {}, false},
+		{"all states", func(l *List) { l.StateMask = ListAll }, false},
+		{"superseded only", func(l *List) { l.StateMask = ListSuperseded }, false},
+		{"failed", func(l *List) { l.StateMask = ListFailed }, false},
+		{"no state", func(l *List) { l.StateMask = 0 }, false},
+		{"filter", func(l *List) { l.StateMask = ListAll; l.Filter = "^d" }, false},
+		{"reverse", func(l *List) { l.StateMask = ListAll; l.SortReverse = true }, false},
+		{"limit", func(l *List) { l.StateMask = ListAll; l.Limit = 2 }, false},
+		{"offset", func(l *List) { l.StateMask = ListAll; l.Offset = 1 }, false},
+		{"limit and offset", func(l *List) { l.StateMask = ListAll; l.Limit = 1; l.Offset = 1 }, false},
+		{"offset too large", func(l *List) { l.StateMask = ListAll; l.Offset = 10 }, false},
+		{"by date", func(l *List) { l.StateMask = ListAll; l.ByDate = true }, true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			mem := NewList(memLister.cfg)
+			tt.apply(mem)
+			expected, err := mem.Run()
+			require.NoError(t, err)
+
+			sql := NewList(sqlLister.cfg)
+			tt.apply(sql)
+			got, err := sql.Run()
+			require.NoError(t, err)
+
+			if tt.unordered {
+				assert.ElementsMatch(t, names(expected), names(got))
+			} else {
+				assert.Equal(t, names(expected), names(got))
+			}
+		})
+	}
+}
+
+// queryOnlyDriver fails listing all the releases, so that only the releases
+// matching a query are fetched.
+type queryOnlyDriver struct {
+	driver.Driver
+}
+
+func (d *queryOnlyDriver) List(filter func(*release.Release) bool) ([]*release.Release, error) {
+	return nil, errors.New("releases listed instead of queried")
+}
+
+func TestSelectorListPushDown(t *testing.T) {
+	r1 := namedReleaseStub("r1", release.StatusSuperseded)
+	r1.Labels = map[string]string{"team": "a"}
+	r2 := namedReleaseStub("r1", release.StatusDeployed)
+	r2.Version = 2
+	r2.Labels = map[string]string{"team": "b"}
+	r3 := namedReleaseStub("r3", release.StatusDeployed)
+	r3.Labels = map[string]string{"team": "b"}
+
+	lister := newListFixture(t)
+	lister.cfg.Releases = storage.Init(&queryOnlyDriver{Driver: driver.NewMemory()})
+	for _, rel := range []*release.Release{r1, r2, r3} {
+		if err := lister.cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		selector  string
+		stateMask ListStates
+		expected  []*release.Release
+	}{
+		{"team=b", ListDeployed, []*release.Release{r2, r3}},
+		{"team=a", ListAll, []*release.Release{}},
+		{"team=a", ListSuperseded, []*release.Release{r1}},
+		{"name=r1,team=b", ListAll, []*release.Release{r2}},
+		{"team=c", ListAll, []*release.Release{}},
+	} {
+		lister.Selector = tt.selector
+		lister.StateMask = tt.stateMask
+		res, err := lister.Run()
+
+		assert.NoError(t, err, tt.selector)
+		assert.ElementsMatch(t, tt.expected, res, tt.selector)
+	}
+}
+
+func TestSelectorListLatestRevisions(t *testing.T) {
+	// Every driver must select among the latest revisions of the releases,
+	// whether it filters in the storage backend or not.
+	fixtures := []struct {
+		name    string
+		version int
+		status  release.Status
+		team    string
+	}{
+		{"app", 1, release.StatusSuperseded, "a"},
+		{"app", 2, release.StatusSuperseded, "a"},
+		{"app", 3, release.StatusDeployed, "a"},
+		{"broken", 1, release.StatusSuperseded, "a"},
+		{"broken", 2, release.StatusFailed, "a"},
+		{"gone", 1, release.StatusUninstalled, "a"},
+		// the latest revision of moved no longer matches the selector
+		{"moved", 1, release.StatusSuperseded, "a"},
+		{"moved", 2, release.StatusDeployed, "b"},
+		{"other", 1, release.StatusDeployed, "b"},
+	}
+
+	sqlDriver, err := driver.NewSQL("sqlite://"+filepath.Join(t.TempDir(), "helm.db"), t.Logf, "default")
+	require.NoError(t, err)
+	drivers := map[string]driver.Driver{
+		"memory":  driver.NewMemory(),
+		"sql":     sqlDriver,
+		"secrets": driver.NewSecrets(fakeclientset.NewSimpleClientset().CoreV1().Secrets("default")),
+	}
+	for _, d := range drivers {
+		store := storage.Init(d)
+		for _, f := range fixtures {
+			rel := namedReleaseStub(f.name, f.status)
+			rel.Version = f.version
+			rel.Labels = map[string]string{"team": f.team}
+			require.NoError(t, store.Create(rel))
+		}
+	}
+
+	for _, tt := range []struct {
+		desc      string
+		stateMask ListStates
+		expected  []string
+	}{
+		{"deployed", ListDeployed, []string{"app.v3"}},
+		{"uninstalled", ListUninstalled, []string{"gone.v1"}},
+		{"uninstalling", ListUninstalling, []string{}},
+		{"pending-install", ListPendingInstall, []string{}},
+		{"pending-upgrade", ListPendingUpgrade, []string{}},
+		{"pending-rollback", ListPendingRollback, []string{}},
+		// superseded revisions are never the latest ones, so they are
+		// listed on their own
+		{"superseded", ListSuperseded, []string{"app.v1", "app.v2", "broken.v1", "moved.v1"}},
+		{"failed", ListFailed, []string{"broken.v2"}},
+		{"deployed and superseded", ListDeployed | ListSuperseded, []string{"app.v3"}},
+		{"all", ListAll, []string{"app.v3", "broken.v2", "gone.v1"}},
+	} {
+		for name, d := range drivers {
+			t.Run(name+"/"+tt.desc, func(t *testing.T) {
+				lister := newListFixture(t)
+				lister.cfg.Releases = storage.Init(d)
+				lister.Selector = "team=a"
+				lister.StateMask = tt.stateMask
+
+				res, err := lister.Run()
+				require.NoError(t, err)
+				got := []string{}
+				for _, rel := range res {
+					got = append(got, fmt.Sprintf("%s.v%d", rel.Name, rel.Version))
+				}
+				assert.ElementsMatch(t, tt.expected, got)
+			})
+		}
+	}
 }
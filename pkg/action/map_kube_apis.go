@@ -0,0 +1,273 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	_ "embed" // for the default Kubernetes API mappings
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/release"
+	helmtime "github.com/huolunl/helm/v3/pkg/time"
+)
+
+//go:embed kube_api_mappings.yaml
+var defaultKubeAPIMappings []byte
+
+// KubeAPIMapping maps a Kubernetes API version removed from the API server
+// to the version replacing it.
+type KubeAPIMapping struct {
+	Kind                 string `json:"kind"`
+	DeprecatedAPIVersion string `json:"deprecatedAPIVersion"`
+	NewAPIVersion        string `json:"newAPIVersion"`
+	// RemovedInVersion is the first Kubernetes version not serving
+	// DeprecatedAPIVersion.
+	RemovedInVersion string `json:"removedInVersion"`
+}
+
+// String implements fmt.Stringer
+func (m KubeAPIMapping) String() string {
+	return fmt.Sprintf("%s %s -> %s", m.Kind, m.DeprecatedAPIVersion, m.NewAPIVersion)
+}
+
+// LoadKubeAPIMappings reads a mapping file. An empty path returns the
+// mappings shipped with Helm.
+func LoadKubeAPIMappings(path string) ([]KubeAPIMapping, error) {
+	data := defaultKubeAPIMappings
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, errors.Wrap(err, "failed to read the mapping file")
+		}
+	}
+	var file struct {
+		Mappings []KubeAPIMapping `json:"mappings"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the mapping file %s", path)
+	}
+	for i, m := range file.Mappings {
+		if m.Kind == "" || m.DeprecatedAPIVersion == "" || m.NewAPIVersion == "" {
+			return nil, errors.Errorf("mapping %d: kind, deprecatedAPIVersion and newAPIVersion are required", i)
+		}
+		if _, err := semver.NewVersion(m.RemovedInVersion); err != nil {
+			return nil, errors.Wrapf(err, "mapping %d: invalid removedInVersion %q", i, m.RemovedInVersion)
+		}
+	}
+	return file.Mappings, nil
+}
+
+// MapKubeAPIs is the action for rewriting the removed Kubernetes API
+// versions of a stored release.
+//
+// It provides the implementation of 'helm storage map-apis'. The manifest
+// and hooks of the latest revision are rewritten to use the replacing API
+// versions and stored as a new revision. The cluster resources are left
+// untouched: the API server already serves them under the new versions.
+type MapKubeAPIs struct {
+	cfg *Configuration
+
+	// DryRun computes the mapped revision without storing it.
+	DryRun bool
+	// KubeVersion is the Kubernetes version the release is mapped for. It
+	// defaults to the version of the cluster.
+	KubeVersion string
+	// MappingFile is the path of a mapping file replacing the mappings
+	// shipped with Helm.
+	MappingFile string
+	// Context is the number of unchanged lines shown around each change of
+	// the diff.
+	Context int
+}
+
+// MapKubeAPIsResult is the outcome of mapping a release.
+type MapKubeAPIsResult struct {
+	// Release is the new revision, or nil if nothing was mapped.
+	Release *release.Release `json:"release,omitempty"`
+	// Mapped lists the applied mappings, once per rewritten resource.
+	Mapped []KubeAPIMapping `json:"mapped"`
+	// Diff is a unified diff of the manifest and hooks.
+	Diff string `json:"diff,omitempty"`
+}
+
+// NewMapKubeAPIs creates a new MapKubeAPIs object with the given configuration.
+func NewMapKubeAPIs(cfg *Configuration) *MapKubeAPIs {
+	return &MapKubeAPIs{
+		cfg:     cfg,
+		Context: 3,
+	}
+}
+
+// Run maps the latest revision of the named release.
+func (m *MapKubeAPIs) Run(name string) (*MapKubeAPIsResult, error) {
+	if err := chartutil.ValidateReleaseName(name); err != nil {
+		return nil, errors.Errorf("map-apis: Release name is invalid: %s", name)
+	}
+
+	mappings, err := LoadKubeAPIMappings(m.MappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeVersion := m.KubeVersion
+	if kubeVersion == "" {
+		caps, err := m.cfg.getCapabilities()
+		if err != nil {
+			return nil, err
+		}
+		kubeVersion = caps.KubeVersion.Version
+	}
+	target, err := semver.NewVersion(kubeVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Kubernetes version %q", kubeVersion)
+	}
+	var applicable []KubeAPIMapping
+	for _, mapping := range mappings {
+		removedIn, _ := semver.NewVersion(mapping.RemovedInVersion)
+		// compare major.minor only so that pre-releases of the removing
+		// version are mapped too
+		if target.Major() > removedIn.Major() || target.Major() == removedIn.Major() && target.Minor() >= removedIn.Minor() {
+			applicable = append(applicable, mapping)
+		}
+	}
+
+	current, err := m.cfg.Releases.Last(name)
+	if err != nil {
+		return nil, err
+	}
+	if current.Info.Status.IsPending() {
+		return nil, errPending
+	}
+
+	res := &MapKubeAPIsResult{}
+	manifest, mapped := mapManifestAPIs(current.Manifest, applicable)
+	res.Mapped = append(res.Mapped, mapped...)
+	hooks := make([]*release.Hook, len(current.Hooks))
+	for i, h := range current.Hooks {
+		hook := *h
+		hook.Manifest, mapped = mapManifestAPIs(h.Manifest, applicable)
+		res.Mapped = append(res.Mapped, mapped...)
+		hooks[i] = &hook
+	}
+	if len(res.Mapped) == 0 {
+		return res, nil
+	}
+
+	from := fmt.Sprintf("%s revision %d", name, current.Version)
+	to := fmt.Sprintf("%s revision %d", name, current.Version+1)
+	if res.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(hooksAndManifest(current.Hooks, current.Manifest)),
+		B:        difflib.SplitLines(hooksAndManifest(hooks, manifest)),
+		FromFile: from,
+		ToFile:   to,
+		Context:  m.Context,
+	}); err != nil {
+		return nil, err
+	}
+
+	rel := &release.Release{
+		Name:         current.Name,
+		Namespace:    current.Namespace,
+		Chart:        current.Chart,
+		Config:       current.Config,
+		ValuesLayers: current.ValuesLayers,
+		Info: &release.Info{
+			FirstDeployed: current.Info.FirstDeployed,
+			LastDeployed:  helmtime.Now(),
+			Status:        current.Info.Status,
+			Notes:         current.Info.Notes,
+			Description:   fmt.Sprintf("Kubernetes APIs mapped for %s", kubeVersion),
+		},
+		Version:  current.Version + 1,
+		Manifest: manifest,
+		Hooks:    hooks,
+		Labels:   current.Labels,
+		// the manifest still holds the placeholders of the secrets that
+		// cannot be restored
+		Unrestorable: current.Unrestorable,
+	}
+	res.Release = rel
+
+	if m.DryRun {
+		m.cfg.Log("dry run for %s", name)
+		return res, nil
+	}
+
+	if current.Info.Status == release.StatusDeployed {
+		m.cfg.supersedeRelease(current)
+	}
+	if err := m.cfg.Releases.Create(rel); err != nil {
+		return res, errors.Wrapf(err, "failed to store revision %d of release %s", rel.Version, name)
+	}
+	return res, nil
+}
+
+var (
+	manifestSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+	apiVersionLine    = regexp.MustCompile(`(?m)^apiVersion:[ \t]*["']?([^"'\s]+)["']?[ \t]*$`)
+	kindLine          = regexp.MustCompile(`(?m)^kind:[ \t]*["']?([^"'\s]+)["']?[ \t]*$`)
+)
+
+// mapManifestAPIs rewrites the apiVersion of every document of manifest
+// matching a mapping. The rest of the text, including comments and
+// formatting, is kept as is.
+func mapManifestAPIs(manifest string, mappings []KubeAPIMapping) (string, []KubeAPIMapping) {
+	var mapped []KubeAPIMapping
+	var b strings.Builder
+	last := 0
+	bounds := append(manifestSeparator.FindAllStringIndex(manifest, -1), []int{len(manifest), len(manifest)})
+	for _, bound := range bounds {
+		doc := manifest[last:bound[0]]
+		b.WriteString(mapDocumentAPI(doc, mappings, &mapped))
+		b.WriteString(manifest[bound[0]:bound[1]])
+		last = bound[1]
+	}
+	return b.String(), mapped
+}
+
+func mapDocumentAPI(doc string, mappings []KubeAPIMapping, mapped *[]KubeAPIMapping) string {
+	apiVersion, kind := apiVersionLine.FindStringSubmatchIndex(doc), kindLine.FindStringSubmatch(doc)
+	if apiVersion == nil || kind == nil {
+		return doc
+	}
+	for _, mapping := range mappings {
+		if mapping.Kind == kind[1] && mapping.DeprecatedAPIVersion == doc[apiVersion[2]:apiVersion[3]] {
+			*mapped = append(*mapped, mapping)
+			return doc[:apiVersion[2]] + mapping.NewAPIVersion + doc[apiVersion[3]:]
+		}
+	}
+	return doc
+}
+
+// hooksAndManifest joins the manifests of hooks and the manifest of a
+// release the way 'helm get manifest' and 'helm get hooks' print them.
+func hooksAndManifest(hooks []*release.Hook, manifest string) string {
+	var b strings.Builder
+	for _, h := range hooks {
+		fmt.Fprintf(&b, "---\n# Source: %s\n%s\n", h.Path, h.Manifest)
+	}
+	b.WriteString(manifest)
+	return b.String()
+}
@@ -0,0 +1,144 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+const deprecatedManifest = `---
+# Source: hello/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: hello
+---
+# Source: hello/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: hello
+---
+# Source: hello/templates/cronjob.yaml
+apiVersion: "batch/v1beta1"
+kind: CronJob
+metadata:
+  name: hello
+`
+
+func TestMapKubeAPIs(t *testing.T) {
+	is := assert.New(t)
+	config := actionConfigFixture(t)
+	rel := namedReleaseStub("mapped", release.StatusDeployed)
+	rel.Manifest = deprecatedManifest
+	rel.Hooks[0].Manifest = "apiVersion: rbac.authorization.k8s.io/v1beta1\nkind: Role\nmetadata:\n  name: hook\n"
+	require.NoError(t, config.Releases.Create(rel))
+
+	client := NewMapKubeAPIs(config)
+	client.KubeVersion = "v1.22.3"
+	client.DryRun = true
+	res, err := client.Run("mapped")
+	require.NoError(t, err)
+	is.Len(res.Mapped, 2)
+	is.Contains(res.Diff, "-apiVersion: extensions/v1beta1\n+apiVersion: networking.k8s.io/v1\n")
+	is.Contains(res.Diff, "+apiVersion: rbac.authorization.k8s.io/v1\n")
+	_, err = config.Releases.Get("mapped", 2)
+	is.Error(err, "a dry run must not store a revision")
+
+	client.KubeVersion = "v1.25.0"
+	client.DryRun = false
+	res, err = client.Run("mapped")
+	require.NoError(t, err)
+	is.Equal([]string{
+		"Ingress extensions/v1beta1 -> networking.k8s.io/v1",
+		"CronJob batch/v1beta1 -> batch/v1",
+		"Role rbac.authorization.k8s.io/v1beta1 -> rbac.authorization.k8s.io/v1",
+	}, mappingNames(res.Mapped))
+
+	stored, err := config.Releases.Get("mapped", 2)
+	require.NoError(t, err)
+	is.Equal(release.StatusDeployed, stored.Info.Status)
+	is.Equal("Kubernetes APIs mapped for v1.25.0", stored.Info.Description)
+	is.Contains(stored.Manifest, "# Source: hello/templates/ingress.yaml\napiVersion: networking.k8s.io/v1\nkind: Ingress\n")
+	is.Contains(stored.Manifest, "apiVersion: \"batch/v1\"\nkind: CronJob\n")
+	is.Contains(stored.Manifest, "apiVersion: v1\nkind: Service\n")
+	is.Contains(stored.Hooks[0].Manifest, "apiVersion: rbac.authorization.k8s.io/v1\n")
+
+	previous, err := config.Releases.Get("mapped", 1)
+	require.NoError(t, err)
+	is.Equal(release.StatusSuperseded, previous.Info.Status)
+	is.Equal(deprecatedManifest, previous.Manifest)
+
+	// nothing left to map
+	res, err = client.Run("mapped")
+	require.NoError(t, err)
+	is.Empty(res.Mapped)
+	is.Nil(res.Release)
+}
+
+func TestMapKubeAPIsKeepsReleaseState(t *testing.T) {
+	is := assert.New(t)
+	config := actionConfigFixture(t)
+	rel := namedReleaseStub("mapped", release.StatusDeployed)
+	rel.Manifest = deprecatedManifest
+	rel.ValuesLayers = []release.ValuesLayer{{Name: "env", Files: []string{"envs/prod.yaml"}}}
+	rel.Unrestorable = true
+	require.NoError(t, config.Releases.Create(rel))
+
+	client := NewMapKubeAPIs(config)
+	client.KubeVersion = "v1.25.0"
+	_, err := client.Run("mapped")
+	require.NoError(t, err)
+
+	// like the revision it was mapped from, the mapped revision cannot be
+	// rolled back to
+	stored, err := config.Releases.Get("mapped", 2)
+	require.NoError(t, err)
+	is.True(stored.Unrestorable)
+	is.Equal(rel.ValuesLayers, stored.ValuesLayers)
+}
+
+func TestLoadKubeAPIMappings(t *testing.T) {
+	mappings, err := LoadKubeAPIMappings("")
+	require.NoError(t, err)
+	assert.NotEmpty(t, mappings)
+
+	path := filepath.Join(t.TempDir(), "mappings.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("mappings:\n- kind: Widget\n  deprecatedAPIVersion: example.com/v1alpha1\n  newAPIVersion: example.com/v1\n  removedInVersion: v1.20\n"), 0644))
+	mappings, err = LoadKubeAPIMappings(path)
+	require.NoError(t, err)
+	assert.Equal(t, []KubeAPIMapping{{Kind: "Widget", DeprecatedAPIVersion: "example.com/v1alpha1", NewAPIVersion: "example.com/v1", RemovedInVersion: "v1.20"}}, mappings)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("mappings:\n- kind: Widget\n  removedInVersion: v1.20\n"), 0644))
+	_, err = LoadKubeAPIMappings(path)
+	assert.EqualError(t, err, "mapping 0: kind, deprecatedAPIVersion and newAPIVersion are required")
+}
+
+func mappingNames(mappings []KubeAPIMapping) []string {
+	names := make([]string, len(mappings))
+	for i, m := range mappings {
+		names[i] = m.String()
+	}
+	return names
+}
@@ -0,0 +1,135 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"time"
+
+	"github.com/huolunl/helm/v3/pkg/kube"
+)
+
+// createResources creates resources phase by phase, as ordered by their
+// kube.PhaseAnno and kube.DependsOnAnno annotations, waiting for the
+// resources of each phase to be ready before creating the next one.
+func (c *Configuration) createResources(resources kube.ResourceList, timeout time.Duration) (*kube.Result, error) {
+	phases, err := kube.Phases(resources)
+	if err != nil {
+		return &kube.Result{}, err
+	}
+	if len(phases) <= 1 {
+		return c.KubeClient.Create(resources)
+	}
+
+	res := &kube.Result{}
+	for i, phase := range phases {
+		c.Log("creating phase %d of %d", i+1, len(phases))
+		r, err := c.KubeClient.Create(phase)
+		mergeResults(res, r)
+		if err != nil {
+			return res, err
+		}
+		if err := c.waitForPhase(i, phases, timeout); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// updateResources updates current to target phase by phase, like
+// createResources. Resources of current that are not part of target are
+// deleted once every phase has been applied.
+func (c *Configuration) updateResources(current, target kube.ResourceList, force bool, timeout time.Duration) (*kube.Result, error) {
+	phases, err := kube.Phases(target)
+	if err != nil {
+		return &kube.Result{}, err
+	}
+	if len(phases) <= 1 {
+		return c.KubeClient.Update(current, target, force)
+	}
+
+	res := &kube.Result{}
+	for i, phase := range phases {
+		c.Log("updating phase %d of %d", i+1, len(phases))
+		// only pass the current resources of the phase so that the
+		// resources of the other phases are not deleted
+		r, err := c.KubeClient.Update(current.Intersect(phase), phase, force)
+		mergeResults(res, r)
+		if err != nil {
+			return res, err
+		}
+		if err := c.waitForPhase(i, phases, timeout); err != nil {
+			return res, err
+		}
+	}
+	if removed := current.Difference(target); len(removed) > 0 {
+		r, err := c.KubeClient.Update(removed, kube.ResourceList{}, force)
+		mergeResults(res, r)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// deleteResources deletes resources phase by phase in reverse order. If the
+// Kubernetes client supports it, the deletion of each phase completes before
+// the previous phase is deleted.
+func (c *Configuration) deleteResources(resources kube.ResourceList, timeout time.Duration) (*kube.Result, []error) {
+	phases, err := kube.Phases(resources)
+	if err != nil {
+		// never prevent the deletion of a release
+		c.Log("warning: unable to order the deletion of the resources: %s", err)
+		phases = nil
+	}
+	if len(phases) <= 1 {
+		return c.KubeClient.Delete(resources)
+	}
+
+	res := &kube.Result{}
+	var errs []error
+	for i := len(phases) - 1; i >= 0; i-- {
+		c.Log("deleting phase %d of %d", i+1, len(phases))
+		r, phaseErrs := c.KubeClient.Delete(phases[i])
+		mergeResults(res, r)
+		errs = append(errs, phaseErrs...)
+		if waiter, ok := c.KubeClient.(kube.DeletionWaiter); ok && i > 0 && r != nil && len(r.Deleted) > 0 {
+			if err := waiter.WaitForDelete(r.Deleted, timeout); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return res, errs
+}
+
+// waitForPhase waits for the resources of the phase with index i to be
+// ready, unless it is the last phase.
+func (c *Configuration) waitForPhase(i int, phases []kube.ResourceList, timeout time.Duration) error {
+	if i == len(phases)-1 {
+		return nil
+	}
+	c.Log("waiting for phase %d of %d to be ready", i+1, len(phases))
+	return c.KubeClient.WaitWithJobs(phases[i], timeout)
+}
+
+func mergeResults(res, r *kube.Result) {
+	if r == nil {
+		return
+	}
+	res.Created = append(res.Created, r.Created...)
+	res.Updated = append(res.Updated, r.Updated...)
+	res.Deleted = append(res.Deleted, r.Deleted...)
+}
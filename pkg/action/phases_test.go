@@ -0,0 +1,146 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/huolunl/helm/v3/pkg/kube"
+	kubefake "github.com/huolunl/helm/v3/pkg/kube/fake"
+)
+
+// phaseRecordingKubeClient records the calls made to the Kubernetes client.
+type phaseRecordingKubeClient struct {
+	kubefake.PrintingKubeClient
+	calls []string
+}
+
+func (c *phaseRecordingKubeClient) record(op string, resources kube.ResourceList) {
+	var names []string
+	for _, info := range resources {
+		names = append(names, info.Name)
+	}
+	c.calls = append(c.calls, fmt.Sprintf("%s %s", op, strings.Join(names, ",")))
+}
+
+func (c *phaseRecordingKubeClient) Create(resources kube.ResourceList) (*kube.Result, error) {
+	c.record("create", resources)
+	return c.PrintingKubeClient.Create(resources)
+}
+
+func (c *phaseRecordingKubeClient) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	c.record("update", target)
+	res := &kube.Result{Updated: target, Deleted: original.Difference(target)}
+	return res, nil
+}
+
+func (c *phaseRecordingKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	c.record("delete", resources)
+	return c.PrintingKubeClient.Delete(resources)
+}
+
+func (c *phaseRecordingKubeClient) WaitWithJobs(resources kube.ResourceList, _ time.Duration) error {
+	c.record("wait", resources)
+	return nil
+}
+
+func (c *phaseRecordingKubeClient) WaitForDelete(resources kube.ResourceList, _ time.Duration) error {
+	c.record("wait-delete", resources)
+	return nil
+}
+
+func phasedInfo(kind, name string, annotations map[string]string) *resource.Info {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetAnnotations(annotations)
+	return &resource.Info{
+		Name:      name,
+		Namespace: "default",
+		Mapping:   &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Kind: kind}},
+		Object:    obj,
+	}
+}
+
+func TestPhasedResources(t *testing.T) {
+	is := assert.New(t)
+	cfg := actionConfigFixture(t)
+	client := &phaseRecordingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg.KubeClient = client
+
+	db := phasedInfo("StatefulSet", "db", nil)
+	migrate := phasedInfo("Job", "migrate", map[string]string{kube.DependsOnAnno: "StatefulSet/db"})
+	app := phasedInfo("Deployment", "app", map[string]string{kube.DependsOnAnno: "Job/migrate"})
+	old := phasedInfo("ConfigMap", "old", nil)
+	resources := kube.ResourceList{app, migrate, db}
+
+	res, err := cfg.createResources(resources, time.Minute)
+	is.NoError(err)
+	is.Len(res.Created, 3)
+	is.Equal([]string{
+		"create db",
+		"wait db",
+		"create migrate",
+		"wait migrate",
+		"create app",
+	}, client.calls)
+
+	client.calls = nil
+	res, err = cfg.updateResources(kube.ResourceList{db, old}, resources, false, time.Minute)
+	is.NoError(err)
+	is.Len(res.Updated, 3)
+	is.Len(res.Deleted, 1)
+	is.Equal([]string{
+		"update db",
+		"wait db",
+		"update migrate",
+		"wait migrate",
+		"update app",
+		"update ",
+	}, client.calls)
+
+	client.calls = nil
+	_, errs := cfg.deleteResources(resources, time.Minute)
+	is.Empty(errs)
+	is.Equal([]string{
+		"delete app",
+		"wait-delete app",
+		"delete migrate",
+		"wait-delete migrate",
+		"delete db",
+	}, client.calls)
+
+	// resources without phases are applied at once
+	client.calls = nil
+	_, err = cfg.createResources(kube.ResourceList{db, old}, time.Minute)
+	is.NoError(err)
+	is.Equal([]string{"create db,old"}, client.calls)
+
+	// invalid dependencies fail the operation
+	_, err = cfg.createResources(kube.ResourceList{migrate}, time.Minute)
+	is.Error(err)
+}
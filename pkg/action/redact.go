@@ -0,0 +1,91 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"github.com/huolunl/helm/v3/pkg/engine"
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+// redactRelease sets the redaction of rel when its templates resolved the
+// secrets of sensitive or its values were redacted as config. The secrets are
+// replaced with their placeholders where they are whole values of the
+// rendered manifest and hooks, or whole words of the notes, so the templates
+// are not rendered again. The values decrypted from encrypted values files are
+// only redacted in config: the manifests rendered from them are stored as they
+// are, protected by the encryption of the storage driver if configured. The
+// release is marked unrestorable if its values were redacted or if the
+// placeholders do not give back its manifests.
+func (c *Configuration) redactRelease(rel *release.Release, sensitive map[string]string, config map[string]interface{}) {
+	if len(sensitive) == 0 && config == nil {
+		return
+	}
+	placeholders := map[string]string{}
+	for placeholder, v := range sensitive {
+		if p, ok := placeholders[v]; !ok || placeholder < p {
+			placeholders[v] = placeholder
+		}
+	}
+
+	redaction := &release.Redaction{
+		Config:   config,
+		Manifest: engine.RedactSecrets(rel.Manifest, placeholders),
+		Notes:    engine.RedactSecretWords(rel.Info.Notes, placeholders),
+		Hooks:    make([]string, len(rel.Hooks)),
+	}
+	for i, h := range rel.Hooks {
+		redaction.Hooks[i] = engine.RedactSecrets(h.Manifest, placeholders)
+	}
+	rel.Redaction = redaction
+	rel.Unrestorable = config != nil
+	if !restorable(rel, engine.RecordedSecrets(sensitive)) {
+		c.Log("warning: the secrets of release %s cannot be restored from their placeholders, it will not be possible to roll back to this revision", rel.Name)
+		rel.Unrestorable = true
+	}
+}
+
+// restorable reports whether the secrets of providers restore the redaction
+// of rel to its manifests, hooks and notes.
+func restorable(rel *release.Release, providers map[string]engine.SecretProvider) bool {
+	same := func(redacted, text string) bool {
+		restored, err := engine.RestoreSecrets(redacted, providers)
+		return err == nil && restored == text
+	}
+	if !same(rel.Redaction.Manifest, rel.Manifest) || !same(rel.Redaction.Notes, rel.Info.Notes) {
+		return false
+	}
+	for i, h := range rel.Hooks {
+		if !same(rel.Redaction.Hooks[i], h.Manifest) {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreAppliedSecrets restores the secrets redacted in the stored release
+// rel with c.SecretProviders, when they can be, so that its manifest is the
+// one applied, as the three-way merge of upgrades and rollbacks starts from.
+func (c *Configuration) restoreAppliedSecrets(rel *release.Release) {
+	restored := *rel
+	info := *rel.Info
+	restored.Info = &info
+	if err := c.restoreSecrets(&restored); err != nil {
+		c.Log("cannot restore the secrets of release %s: %s", rel.Name, err)
+		return
+	}
+	*rel = restored
+}
@@ -50,6 +50,14 @@ func NewReleaseTesting(cfg *Configuration) *ReleaseTesting {
 
 // Run executes 'helm test' against the given release.
 func (r *ReleaseTesting) Run(name string) (*release.Release, error) {
+	rel, err := r.run(name)
+	r.cfg.recordAudit("test", name, r.cfg.Namespace(), rel, map[string]interface{}{
+		"filters": r.Filters,
+	}, false, err)
+	return rel, err
+}
+
+func (r *ReleaseTesting) run(name string) (*release.Release, error) {
 	if err := r.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,66 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+var (
+	yamlErrorLine     = regexp.MustCompile(`line (\d+)`)
+	documentSeparator = regexp.MustCompile(`^---\s*$`)
+)
+
+// manifestErrorContext adds to the error of a rendered manifest that is not
+// valid YAML the offending lines and the template lines producing them.
+func manifestErrorContext(err error, files map[string]string, sourceMap engine.SourceMap) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lines := strings.Split(files[name], "\n")
+		start := 0
+		for i := 0; i <= len(lines); i++ {
+			if i < len(lines) && !documentSeparator.MatchString(lines[i]) {
+				continue
+			}
+			doc := strings.Join(lines[start:i], "\n")
+			var obj map[string]interface{}
+			if docErr := yaml.Unmarshal([]byte(doc), &obj); docErr != nil {
+				line := start + 1
+				if m := yamlErrorLine.FindStringSubmatch(docErr.Error()); m != nil {
+					n, _ := strconv.Atoi(m[1])
+					line = start + n
+				}
+				return errors.Errorf("%s\n\n%s:\n%s", err, name, sourceMap.Context(name, line, 2))
+			}
+			start = i + 1
+		}
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/release"
+	"github.com/huolunl/helm/v3/pkg/releaseutil"
+)
+
+// RevisionDiff is the action for comparing two revisions of a release.
+//
+// It provides the implementation of 'helm history diff'. Only the release
+// storage is consulted, so it works against every storage driver without
+// needing access to the Kubernetes API.
+type RevisionDiff struct {
+	cfg *Configuration
+
+	// RedactSecrets replaces the data of Secret manifests with a digest
+	// so that changes remain visible without revealing their content.
+	RedactSecrets bool
+	// Context is the number of unchanged lines shown around each change.
+	Context int
+}
+
+// RevisionDiffResult holds the unified diffs between two release revisions.
+//
+// A field is empty when that part of the release did not change.
+type RevisionDiffResult struct {
+	Name string `json:"name"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+
+	Chart          string `json:"chart,omitempty"`
+	Values         string `json:"values,omitempty"`
+	ComputedValues string `json:"computed_values,omitempty"`
+	Manifest       string `json:"manifest,omitempty"`
+}
+
+// HasChanges reports whether the two revisions differ at all.
+func (r *RevisionDiffResult) HasChanges() bool {
+	return r.Chart != "" || r.Values != "" || r.ComputedValues != "" || r.Manifest != ""
+}
+
+// NewRevisionDiff creates a new RevisionDiff object with the given configuration.
+func NewRevisionDiff(cfg *Configuration) *RevisionDiff {
+	return &RevisionDiff{
+		cfg:     cfg,
+		Context: 3,
+	}
+}
+
+// Run executes 'helm history diff' against the given release and revisions.
+func (d *RevisionDiff) Run(name string, from, to int) (*RevisionDiffResult, error) {
+	if err := chartutil.ValidateReleaseName(name); err != nil {
+		return nil, errors.Errorf("release name is invalid: %s", name)
+	}
+	if from <= 0 || to <= 0 {
+		return nil, errInvalidRevision
+	}
+
+	d.cfg.Log("comparing revisions %d and %d of release %s", from, to, name)
+	oldRel, err := d.cfg.Releases.Get(name, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "revision %d", from)
+	}
+	newRel, err := d.cfg.Releases.Get(name, to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "revision %d", to)
+	}
+
+	res := &RevisionDiffResult{Name: name, From: from, To: to}
+	fromLabel := fmt.Sprintf("%s (revision %d)", name, from)
+	toLabel := fmt.Sprintf("%s (revision %d)", name, to)
+
+	if res.Chart, err = d.unifiedDiff(chartSummary(oldRel.Chart), chartSummary(newRel.Chart), fromLabel, toLabel); err != nil {
+		return nil, err
+	}
+
+	oldValues, err := valuesYAML(oldRel.Config)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := valuesYAML(newRel.Config)
+	if err != nil {
+		return nil, err
+	}
+	if res.Values, err = d.unifiedDiff(oldValues, newValues, fromLabel, toLabel); err != nil {
+		return nil, err
+	}
+
+	oldComputed, err := computedValuesYAML(oldRel)
+	if err != nil {
+		return nil, err
+	}
+	newComputed, err := computedValuesYAML(newRel)
+	if err != nil {
+		return nil, err
+	}
+	if res.ComputedValues, err = d.unifiedDiff(oldComputed, newComputed, fromLabel, toLabel); err != nil {
+		return nil, err
+	}
+
+	oldManifest, newManifest := oldRel.Manifest, newRel.Manifest
+	if d.RedactSecrets {
+		oldManifest = redactSecretManifests(oldManifest)
+		newManifest = redactSecretManifests(newManifest)
+	}
+	if res.Manifest, err = d.unifiedDiff(oldManifest, newManifest, fromLabel, toLabel); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (d *RevisionDiff) unifiedDiff(a, b, fromLabel, toLabel string) (string, error) {
+	if a == b {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(strings.TrimSuffix(a, "\n")),
+		B:        difflib.SplitLines(strings.TrimSuffix(b, "\n")),
+		FromFile: fromLabel,
+		ToFile:   toLabel,
+		Context:  d.Context,
+	})
+}
+
+func chartSummary(c *chart.Chart) string {
+	if c == nil || c.Metadata == nil {
+		return "MISSING\n"
+	}
+	return fmt.Sprintf("name: %s\nversion: %s\nappVersion: %s\n", c.Name(), c.Metadata.Version, c.AppVersion())
+}
+
+func valuesYAML(vals map[string]interface{}) (string, error) {
+	if len(vals) == 0 {
+		return "", nil
+	}
+	b, err := yaml.Marshal(vals)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal values")
+	}
+	return string(b), nil
+}
+
+func computedValuesYAML(rel *release.Release) (string, error) {
+	if rel.Chart == nil {
+		return valuesYAML(rel.Config)
+	}
+	vals, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+	if err != nil {
+		return "", err
+	}
+	return valuesYAML(vals)
+}
+
+// redactSecretManifests replaces every value under data and stringData of
+// Secret documents with a short digest of the original value.
+func redactSecretManifests(manifest string) string {
+	docs := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString("---\n")
+		b.WriteString(redactSecret(docs[k]))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func redactSecret(doc string) string {
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || obj["kind"] != "Secret" {
+		return doc
+	}
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range data {
+			sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+			data[k] = fmt.Sprintf("REDACTED (sha256:%x)", sum[:6])
+		}
+	}
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return doc
+	}
+	// Preserve the "# Source:" comment that identifies the template.
+	var comments []string
+	for _, line := range strings.Split(doc, "\n") {
+		if strings.HasPrefix(line, "#") {
+			comments = append(comments, line)
+		}
+	}
+	if len(comments) > 0 {
+		return strings.Join(comments, "\n") + "\n" + strings.TrimSpace(string(out))
+	}
+	return strings.TrimSpace(string(out))
+}
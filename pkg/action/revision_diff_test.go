@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+const secretManifest = `---
+# Source: hello/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+data:
+  password: %s
+`
+
+func TestRevisionDiff(t *testing.T) {
+	is := assert.New(t)
+	config := actionConfigFixture(t)
+
+	rel1 := releaseStub()
+	rel1.Name = "diffy"
+	rel1.Version = 1
+	rel1.Config = map[string]interface{}{"replicas": 1}
+	rel1.Manifest = strings.Replace(secretManifest, "%s", "b2xk", 1)
+	is.NoError(config.Releases.Create(rel1))
+
+	rel2 := releaseStub()
+	rel2.Name = "diffy"
+	rel2.Version = 2
+	rel2.Chart.Metadata.Version = "0.2.0"
+	rel2.Config = map[string]interface{}{"replicas": 2}
+	rel2.Manifest = strings.Replace(secretManifest, "%s", "bmV3", 1)
+	is.NoError(config.Releases.Create(rel2))
+
+	client := NewRevisionDiff(config)
+	res, err := client.Run("diffy", 1, 2)
+	is.NoError(err)
+	is.True(res.HasChanges())
+	is.Contains(res.Chart, "-version: 0.1.0")
+	is.Contains(res.Chart, "+version: 0.2.0")
+	is.Contains(res.Values, "-replicas: 1")
+	is.Contains(res.Values, "+replicas: 2")
+	is.Contains(res.ComputedValues, "+replicas: 2")
+	is.Contains(res.Manifest, "+  password: bmV3")
+
+	client.RedactSecrets = true
+	res, err = client.Run("diffy", 1, 2)
+	is.NoError(err)
+	is.NotContains(res.Manifest, "bmV3")
+	is.NotContains(res.Manifest, "b2xk")
+	is.Contains(res.Manifest, "REDACTED (sha256:")
+	is.Contains(res.Manifest, "# Source: hello/templates/secret.yaml")
+
+	res, err = client.Run("diffy", 2, 2)
+	is.NoError(err)
+	is.False(res.HasChanges())
+}
+
+func TestRevisionDiffErrors(t *testing.T) {
+	is := assert.New(t)
+	config := actionConfigFixture(t)
+	is.NoError(config.Releases.Create(namedReleaseStub("diffy", release.StatusDeployed)))
+
+	client := NewRevisionDiff(config)
+	_, err := client.Run("diffy", 0, 1)
+	is.Equal(errInvalidRevision, err)
+
+	_, err = client.Run("diffy", 1, 5)
+	is.Error(err)
+	is.Contains(err.Error(), "revision 5")
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/engine"
 	"github.com/huolunl/helm/v3/pkg/release"
 	helmtime "github.com/huolunl/helm/v3/pkg/time"
 )
@@ -56,8 +57,22 @@ func NewRollback(cfg *Configuration) *Rollback {
 
 // Run executes 'helm rollback' against the given release.
 func (r *Rollback) Run(name string) error {
+	rel, err := r.run(name)
+	r.cfg.recordAudit("rollback", name, r.cfg.Namespace(), rel, map[string]interface{}{
+		"version":  r.Version,
+		"force":    r.Force,
+		"recreate": r.Recreate,
+		"wait":     r.Wait,
+		"dryRun":   r.DryRun,
+	}, r.DryRun, err)
+	return err
+}
+
+// run performs the rollback and returns the release it rolls back to, if
+// it got that far.
+func (r *Rollback) run(name string) (*release.Release, error) {
 	if err := r.cfg.KubeClient.IsReachable(); err != nil {
-		return err
+		return nil, err
 	}
 
 	r.cfg.Releases.MaxHistory = r.MaxHistory
@@ -65,28 +80,28 @@ func (r *Rollback) Run(name string) error {
 	r.cfg.Log("preparing rollback of %s", name)
 	currentRelease, targetRelease, err := r.prepareRollback(name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !r.DryRun {
 		r.cfg.Log("creating rolled back release for %s", name)
 		if err := r.cfg.Releases.Create(targetRelease); err != nil {
-			return err
+			return targetRelease, err
 		}
 	}
 
 	r.cfg.Log("performing rollback of %s", name)
 	if _, err := r.performRollback(currentRelease, targetRelease); err != nil {
-		return err
+		return targetRelease, err
 	}
 
 	if !r.DryRun {
 		r.cfg.Log("updating status for rolled back release for %s", name)
-		if err := r.cfg.Releases.Update(targetRelease); err != nil {
-			return err
+		if err := r.cfg.updateRelease(targetRelease); err != nil {
+			return targetRelease, err
 		}
 	}
-	return nil
+	return targetRelease, nil
 }
 
 // prepareRollback finds the previous release and prepares a new release object with
@@ -104,6 +119,7 @@ func (r *Rollback) prepareRollback(name string) (*release.Release, *release.Rele
 	if err != nil {
 		return nil, nil, err
 	}
+	r.cfg.restoreAppliedSecrets(currentRelease)
 
 	previousVersion := r.Version
 	if r.Version == 0 {
@@ -117,12 +133,17 @@ func (r *Rollback) prepareRollback(name string) (*release.Release, *release.Rele
 		return nil, nil, err
 	}
 
+	if previousRelease.Unrestorable {
+		return nil, nil, errors.Errorf("cannot roll back release %s to revision %d: its secrets cannot be restored from the stored release", name, previousVersion)
+	}
+
 	// Store a new release object with previous release's configuration
 	targetRelease := &release.Release{
-		Name:      name,
-		Namespace: currentRelease.Namespace,
-		Chart:     previousRelease.Chart,
-		Config:    previousRelease.Config,
+		Name:         name,
+		Namespace:    currentRelease.Namespace,
+		Chart:        previousRelease.Chart,
+		Config:       previousRelease.Config,
+		ValuesLayers: previousRelease.ValuesLayers,
 		Info: &release.Info{
 			FirstDeployed: currentRelease.Info.FirstDeployed,
 			LastDeployed:  helmtime.Now(),
@@ -135,11 +156,49 @@ func (r *Rollback) prepareRollback(name string) (*release.Release, *release.Rele
 		Version:  currentRelease.Version + 1,
 		Manifest: previousRelease.Manifest,
 		Hooks:    previousRelease.Hooks,
+		Labels:   previousRelease.Labels,
+	}
+	if err := r.cfg.restoreSecrets(targetRelease); err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot restore the secrets of release %s", name)
 	}
 
 	return currentRelease, targetRelease, nil
 }
 
+// restoreSecrets replaces the placeholders of the secrets redacted when rel
+// was stored with their values, resolved again by c.SecretProviders. The
+// stored manifests, hooks and notes are kept as the redaction of rel.
+func (c *Configuration) restoreSecrets(rel *release.Release) error {
+	redaction := &release.Redaction{
+		Manifest: rel.Manifest,
+		Notes:    rel.Info.Notes,
+		Hooks:    make([]string, len(rel.Hooks)),
+	}
+	restore := func(text string) (string, error) {
+		return engine.RestoreSecrets(text, c.SecretProviders)
+	}
+
+	var err error
+	if rel.Manifest, err = restore(rel.Manifest); err != nil {
+		return err
+	}
+	if rel.Info.Notes, err = restore(rel.Info.Notes); err != nil {
+		return err
+	}
+	hooks := make([]*release.Hook, len(rel.Hooks))
+	for i, h := range rel.Hooks {
+		redaction.Hooks[i] = h.Manifest
+		hook := *h
+		if hook.Manifest, err = restore(h.Manifest); err != nil {
+			return err
+		}
+		hooks[i] = &hook
+	}
+	rel.Hooks = hooks
+	rel.Redaction = redaction
+	return nil
+}
+
 func (r *Rollback) performRollback(currentRelease, targetRelease *release.Release) (*release.Release, error) {
 	if r.DryRun {
 		r.cfg.Log("dry run for %s", targetRelease.Name)
@@ -164,7 +223,7 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 		r.cfg.Log("rollback hooks disabled for %s", targetRelease.Name)
 	}
 
-	results, err := r.cfg.KubeClient.Update(current, target, r.Force)
+	results, err := r.cfg.updateResources(current, target, r.Force, r.Timeout)
 
 	if err != nil {
 		msg := fmt.Sprintf("Rollback %q failed: %s", targetRelease.Name, err)
@@ -231,8 +290,7 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 	// Supersede all previous deployments, see issue #2941.
 	for _, rel := range deployed {
 		r.cfg.Log("superseding previous deployment %d", rel.Version)
-		rel.Info.Status = release.StatusSuperseded
-		r.cfg.recordRelease(rel)
+		r.cfg.supersedeRelease(rel)
 	}
 
 	targetRelease.Info.Status = release.StatusDeployed
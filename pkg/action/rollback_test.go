@@ -0,0 +1,109 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/huolunl/helm/v3/pkg/engine"
+	"github.com/huolunl/helm/v3/pkg/release"
+)
+
+func TestRollbackRestoresSecrets(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+	config := actionConfigFixture(t)
+
+	rel := namedReleaseStub("secrets", release.StatusSuperseded)
+	rel.Manifest = "password: <redacted-base64:vault:db/password>\n"
+	rel.Hooks[0].Manifest = "url: postgres://app:<redacted:vault:db/password>@db\n"
+	req.NoError(config.Releases.Create(rel))
+	current := namedReleaseStub("secrets", release.StatusDeployed)
+	current.Version = 2
+	req.NoError(config.Releases.Create(current))
+
+	rollback := NewRollback(config)
+	rollback.Version = 1
+	_, _, err := rollback.prepareRollback("secrets")
+	is.Error(err)
+	is.Contains(err.Error(), `secret provider "vault" of the redacted secret "db/password" is not configured`)
+
+	config.SecretProviders = map[string]engine.SecretProvider{
+		"vault": engine.JSONSecrets{"db": map[string]interface{}{"password": "p4ss"}},
+	}
+	_, target, err := rollback.prepareRollback("secrets")
+	req.NoError(err)
+	is.Equal("password: cDRzcw==\n", target.Manifest)
+	is.Equal("url: postgres://app:p4ss@db\n", target.Hooks[0].Manifest)
+	is.Equal("url: postgres://app:<redacted:vault:db/password>@db\n", target.Redacted().Hooks[0].Manifest)
+
+	// the stored release is left unchanged
+	stored, err := config.Releases.Get("secrets", 1)
+	req.NoError(err)
+	is.Equal("url: postgres://app:<redacted:vault:db/password>@db\n", stored.Hooks[0].Manifest)
+}
+
+func TestRollbackUnrestorableSecrets(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+	config := actionConfigFixture(t)
+	config.SecretProviders = map[string]engine.SecretProvider{
+		"vault": engine.JSONSecrets{"db": map[string]interface{}{"password": "p4ss"}},
+	}
+
+	rel := namedReleaseStub("secrets", release.StatusSuperseded)
+	rel.Manifest = "digest: 6c2e1a\n"
+	rel.Unrestorable = true
+	req.NoError(config.Releases.Create(rel))
+	current := namedReleaseStub("secrets", release.StatusDeployed)
+	current.Version = 2
+	req.NoError(config.Releases.Create(current))
+
+	rollback := NewRollback(config)
+	rollback.Version = 1
+	_, _, err := rollback.prepareRollback("secrets")
+	is.Error(err)
+	is.Contains(err.Error(), "cannot roll back release secrets to revision 1: its secrets cannot be restored")
+}
+
+func TestRollbackEncryptedValues(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	instAction := installAction(t)
+	instAction.RedactValues = redactPassword
+	chrt := buildChart(withTemplate("templates/secret", "password: {{ .Values.password }}\n"))
+	res, err := instAction.Run(chrt, map[string]interface{}{"password": "s3cr3t"})
+	req.NoError(err)
+	is.True(res.Unrestorable)
+
+	current := namedReleaseStub(res.Name, release.StatusDeployed)
+	current.Namespace = res.Namespace
+	current.Version = 2
+	req.NoError(instAction.cfg.Releases.Update(res))
+	req.NoError(instAction.cfg.Releases.Create(current))
+
+	// the decrypted values are not stored, so the revision cannot be restored
+	rollback := NewRollback(instAction.cfg)
+	rollback.Version = 1
+	_, _, err = rollback.prepareRollback(res.Name)
+	is.Error(err)
+	is.Contains(err.Error(), "cannot roll back release test-install-release to revision 1")
+}
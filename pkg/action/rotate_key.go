@@ -0,0 +1,111 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/huolunl/helm/v3/pkg/release"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+// RotateKey is the action for re-encrypting stored releases.
+//
+// It provides the implementation of 'helm storage rotate-key'. Every stored
+// revision is read, which decrypts it with whichever configured key it was
+// written with, and written back, which encrypts it with the current key.
+// When no encryption key is configured the revisions are written back
+// unencrypted.
+type RotateKey struct {
+	cfg *Configuration
+}
+
+// NewRotateKey creates a new RotateKey object with the given configuration.
+func NewRotateKey(cfg *Configuration) *RotateKey {
+	return &RotateKey{
+		cfg: cfg,
+	}
+}
+
+// Run rewrites every stored revision and returns the revisions rewritten.
+//
+// Listing releases skips the revisions that cannot be decoded, so the
+// revisions are read one by one when the storage driver can list their keys.
+// Run then fails, naming them, if some revisions cannot be read, e.g.
+// because the key they were encrypted with is no longer configured.
+func (r *RotateKey) Run() ([]*release.Release, error) {
+	if err := r.cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+
+	lister, ok := r.cfg.Releases.Driver.(driver.KeyLister)
+	if !ok {
+		rels, err := r.cfg.Releases.ListReleases()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list releases")
+		}
+		for i, rel := range rels {
+			if err := r.rewrite(rel); err != nil {
+				return rels[:i], err
+			}
+		}
+		return rels, nil
+	}
+
+	keys, err := lister.Keys()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+	sort.Strings(keys)
+
+	var rels []*release.Release
+	var unreadable []string
+	for _, key := range keys {
+		rel, err := r.cfg.Releases.Driver.Get(key)
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			// deleted since it was listed
+			continue
+		}
+		if err != nil {
+			unreadable = append(unreadable, fmt.Sprintf("%s: %s", key, err))
+			continue
+		}
+		if err := r.rewrite(rel); err != nil {
+			return rels, err
+		}
+		rels = append(rels, rel)
+	}
+	if len(unreadable) > 0 {
+		return rels, errors.Errorf("failed to read %d stored revision(s), they may be encrypted with a key that is not configured:\n%s",
+			len(unreadable), strings.Join(unreadable, "\n"))
+	}
+	return rels, nil
+}
+
+// rewrite writes rel back to the storage, encrypting it with the current key.
+func (r *RotateKey) rewrite(rel *release.Release) error {
+	// rewriting the latest version of a revision modified concurrently is
+	// enough
+	if err := r.cfg.modifyRelease(rel, func(*release.Release) {}); err != nil {
+		return errors.Wrapf(err, "failed to re-encrypt revision %d of release %s", rel.Version, rel.Name)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/huolunl/helm/v3/pkg/release"
+	"github.com/huolunl/helm/v3/pkg/storage"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+func TestRotateKey(t *testing.T) {
+	is := assert.New(t)
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	keys := func(keys ...[]byte) driver.KeyProvider {
+		p, err := driver.NewAESKeyProvider(keys...)
+		require.NoError(t, err)
+		return p
+	}
+
+	sqlDriver, err := driver.NewSQL("sqlite://"+filepath.Join(t.TempDir(), "helm.db"), t.Logf, "default")
+	require.NoError(t, err)
+	sqlDriver.Encryption = keys(oldKey)
+
+	config := actionConfigFixture(t)
+	config.Releases = storage.Init(sqlDriver)
+	rel1 := namedReleaseStub("rotated", release.StatusSuperseded)
+	rel2 := namedReleaseStub("rotated", release.StatusDeployed)
+	rel2.Version = 2
+	is.NoError(config.Releases.Create(rel1))
+	is.NoError(config.Releases.Create(rel2))
+
+	sqlDriver.Encryption = keys(newKey, oldKey)
+	rels, err := NewRotateKey(config).Run()
+	is.NoError(err)
+	is.Len(rels, 2)
+
+	// the old key is no longer needed
+	sqlDriver.Encryption = keys(newKey)
+	for _, version := range []int{1, 2} {
+		rel, err := config.Releases.Get("rotated", version)
+		is.NoError(err)
+		is.Equal(version, rel.Version)
+	}
+}
+
+func TestRotateKeyUnknownKey(t *testing.T) {
+	is := assert.New(t)
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	unknownKey := bytes.Repeat([]byte("u"), 32)
+	keys := func(keys ...[]byte) driver.KeyProvider {
+		p, err := driver.NewAESKeyProvider(keys...)
+		require.NoError(t, err)
+		return p
+	}
+
+	sqlDriver, err := driver.NewSQL("sqlite://"+filepath.Join(t.TempDir(), "helm.db"), t.Logf, "default")
+	require.NoError(t, err)
+
+	config := actionConfigFixture(t)
+	config.Releases = storage.Init(sqlDriver)
+	rel1 := namedReleaseStub("rotated", release.StatusSuperseded)
+	rel2 := namedReleaseStub("rotated", release.StatusDeployed)
+	rel2.Version = 2
+	sqlDriver.Encryption = keys(unknownKey)
+	is.NoError(config.Releases.Create(rel1))
+	sqlDriver.Encryption = keys(oldKey)
+	is.NoError(config.Releases.Create(rel2))
+
+	// the revision encrypted with a key that is not configured is reported
+	sqlDriver.Encryption = keys(newKey, oldKey)
+	rels, err := NewRotateKey(config).Run()
+	is.Error(err)
+	is.Contains(err.Error(), "failed to read 1 stored revision(s)")
+	is.Contains(err.Error(), "sh.helm.release.v1.rotated.v1")
+	is.Len(rels, 1)
+	is.Equal(2, rels[0].Version)
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/huolunl/helm/v3/pkg/chartutil"
 	"github.com/huolunl/helm/v3/pkg/release"
 	"github.com/huolunl/helm/v3/pkg/releaseutil"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
 	helmtime "github.com/huolunl/helm/v3/pkg/time"
 )
 
@@ -50,6 +51,20 @@ func NewUninstall(cfg *Configuration) *Uninstall {
 
 // Run uninstalls the given release.
 func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error) {
+	res, err := u.run(name)
+	var rel *release.Release
+	if res != nil {
+		rel = res.Release
+	}
+	u.cfg.recordAudit("uninstall", name, u.cfg.Namespace(), rel, map[string]interface{}{
+		"keepHistory":  u.KeepHistory,
+		"disableHooks": u.DisableHooks,
+		"dryRun":       u.DryRun,
+	}, u.DryRun, err)
+	return res, err
+}
+
+func (u *Uninstall) run(name string) (*release.UninstallReleaseResponse, error) {
 	if err := u.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -106,7 +121,12 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 
 	// From here on out, the release is currently considered to be in StatusUninstalling
 	// state.
-	if err := u.cfg.Releases.Update(rel); err != nil {
+	if err := u.cfg.updateRelease(rel); err != nil {
+		// do not delete the resources of a release another operation is
+		// working on
+		if errors.Is(err, driver.ErrReleaseConflict) {
+			return res, err
+		}
 		u.cfg.Log("uninstall: Failed to store updated release: %s", err)
 	}
 
@@ -145,7 +165,7 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 		return res, nil
 	}
 
-	if err := u.cfg.Releases.Update(rel); err != nil {
+	if err := u.cfg.updateRelease(rel); err != nil {
 		u.cfg.Log("uninstall: Failed to store updated release: %s", err)
 	}
 
@@ -206,7 +226,7 @@ func (u *Uninstall) deleteRelease(rel *release.Release) (string, []error) {
 		return "", []error{errors.Wrap(err, "unable to build kubernetes objects for delete")}
 	}
 	if len(resources) > 0 {
-		_, errs = u.cfg.KubeClient.Delete(resources)
+		_, errs = u.cfg.deleteResources(resources, u.Timeout)
 	}
 	return kept, errs
 }
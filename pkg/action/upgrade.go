@@ -60,6 +60,9 @@ type Upgrade struct {
 	Namespace string
 	// SkipCRDs skips installing CRDs when install flag is enabled during upgrade
 	SkipCRDs bool
+	// UpgradeCRDs creates and, when the change is safe, replaces the CRDs
+	// of the crds/ directory before the templates are rendered.
+	UpgradeCRDs bool
 	// Timeout is the timeout for this operation
 	Timeout time.Duration
 	// Wait determines whether the wait operation should be performed after the upgrade is requested.
@@ -71,6 +74,13 @@ type Upgrade struct {
 	// DryRun controls whether the operation is prepared, but not executed.
 	// If `true`, the upgrade is prepared but not performed.
 	DryRun bool
+	// ServerDryRun sends every create and patch to the API server with
+	// dryRun=All so that admission and validation errors are reported
+	// without persisting anything. It implies DryRun.
+	ServerDryRun bool
+	// DryRunResult is populated by Run with the per-resource outcome of a
+	// server dry run.
+	DryRunResult *kube.DryRunResult
 	// Force will, if set to `true`, ignore certain warnings and perform the upgrade anyway.
 	//
 	// This should be used with caution.
@@ -98,6 +108,20 @@ type Upgrade struct {
 	PostRenderer postrender.PostRenderer
 	// DisableOpenAPIValidation controls whether OpenAPI validation is enforced.
 	DisableOpenAPIValidation bool
+	// Labels are user-defined labels merged into the labels of the previous
+	// revision. A label with an empty value is removed.
+	Labels map[string]string
+	// ValuesLayers are the values layers of the environment the values were
+	// merged from. They are recorded on the release.
+	ValuesLayers []release.ValuesLayer
+	// RedactValues, if set, returns a copy of the values with their secrets
+	// replaced by placeholders, or nil if they hold none. The release is
+	// stored and printed with the redacted values. Its manifests are stored as
+	// rendered, so the decrypted values are only protected in the storage by
+	// the encryption of its driver.
+	RedactValues func(map[string]interface{}) (map[string]interface{}, error)
+
+	crdSummary string
 }
 
 // NewUpgrade creates a new Upgrade object with the given configuration.
@@ -109,6 +133,22 @@ func NewUpgrade(cfg *Configuration) *Upgrade {
 
 // Run executes the upgrade on the given release.
 func (u *Upgrade) Run(name string, chart *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
+	rel, err := u.run(name, chart, vals)
+	u.cfg.recordAudit("upgrade", name, u.Namespace, rel, map[string]interface{}{
+		"chart":       chartRef(chart),
+		"values":      vals,
+		"labels":      u.Labels,
+		"resetValues": u.ResetValues,
+		"reuseValues": u.ReuseValues,
+		"force":       u.Force,
+		"wait":        u.Wait,
+		"atomic":      u.Atomic,
+		"dryRun":      u.isDryRun(),
+	}, u.isDryRun(), err)
+	return rel, err
+}
+
+func (u *Upgrade) run(name string, chart *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
 	if err := u.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -134,9 +174,9 @@ func (u *Upgrade) Run(name string, chart *chart.Chart, vals map[string]interface
 		return res, err
 	}
 
-	if !u.DryRun {
+	if !u.isDryRun() {
 		u.cfg.Log("updating status for upgraded release for %s", name)
-		if err := u.cfg.Releases.Update(upgradedRelease); err != nil {
+		if err := u.cfg.updateRelease(upgradedRelease); err != nil {
 			return res, err
 		}
 	}
@@ -144,12 +184,22 @@ func (u *Upgrade) Run(name string, chart *chart.Chart, vals map[string]interface
 	return res, nil
 }
 
+// isDryRun reports whether the upgrade is only prepared, as it is for server
+// dry runs.
+func (u *Upgrade) isDryRun() bool {
+	return u.DryRun || u.ServerDryRun
+}
+
 // prepareUpgrade builds an upgraded release for an upgrade operation.
 func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[string]interface{}) (*release.Release, *release.Release, error) {
 	if chart == nil {
 		return nil, nil, errMissingChart
 	}
 
+	if err := validateCustomLabels(u.Labels); err != nil {
+		return nil, nil, err
+	}
+
 	// finds the last non-deleted release with the given name
 	lastRelease, err := u.cfg.Releases.Last(name)
 	if err != nil {
@@ -182,6 +232,8 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 		}
 	}
 
+	u.cfg.restoreAppliedSecrets(currentRelease)
+
 	// determine if values will be reused
 	vals, err = u.reuseValues(chart, currentRelease, vals)
 	if err != nil {
@@ -203,6 +255,19 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 		IsUpgrade: true,
 	}
 
+	// Upgrade the CRDs before the capabilities are built so that templates
+	// see the new versions.
+	if crds := chart.CRDObjects(); u.UpgradeCRDs && !u.SkipCRDs && len(crds) > 0 {
+		u.crdSummary, err = u.cfg.upgradeCRDs(crds, u.isDryRun())
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if len(crds) > 0 {
+		// the CRDs of the crds/ directory are only installed, never upgraded,
+		// by default
+		u.crdSummary = summarizeCRDs(nil, nil, nil, crdNames(crds))
+	}
+
 	caps, err := u.cfg.getCapabilities()
 	if err != nil {
 		return nil, nil, err
@@ -211,18 +276,26 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 	if err != nil {
 		return nil, nil, err
 	}
+	var redactedVals map[string]interface{}
+	if u.RedactValues != nil {
+		if redactedVals, err = u.RedactValues(vals); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	hooks, manifestDoc, notesTxt, err := u.cfg.renderResourcesForUpgrade(chart, valuesToRender, "", "", name, u.SubNotes, false, false, u.PostRenderer, u.DryRun)
+	sensitive := map[string]string{}
+	hooks, manifestDoc, notesTxt, err := u.cfg.renderResourcesForUpgrade(chart, valuesToRender, "", "", name, u.SubNotes, false, false, u.PostRenderer, u.DryRun && !u.ServerDryRun, sensitive)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Store an upgraded release.
 	upgradedRelease := &release.Release{
-		Name:      name,
-		Namespace: currentRelease.Namespace,
-		Chart:     chart,
-		Config:    vals,
+		Name:         name,
+		Namespace:    currentRelease.Namespace,
+		Chart:        chart,
+		Config:       vals,
+		ValuesLayers: u.ValuesLayers,
 		Info: &release.Info{
 			FirstDeployed: currentRelease.Info.FirstDeployed,
 			LastDeployed:  Timestamper(),
@@ -232,11 +305,13 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 		Version:  revision,
 		Manifest: manifestDoc.String(),
 		Hooks:    hooks,
+		Labels:   mergeCustomLabels(lastRelease.Labels, u.Labels),
 	}
 
 	if len(notesTxt) > 0 {
 		upgradedRelease.Info.Notes = notesTxt
 	}
+	u.cfg.redactRelease(upgradedRelease, sensitive, redactedVals)
 	err = validateManifest(u.cfg.KubeClient, manifestDoc.Bytes(), !u.DisableOpenAPIValidation)
 	return currentRelease, upgradedRelease, err
 }
@@ -290,7 +365,12 @@ func (u *Upgrade) performUpgrade(originalRelease, upgradedRelease *release.Relea
 		return nil
 	})
 
-	if u.DryRun {
+	if u.ServerDryRun {
+		u.DryRunResult, err = u.cfg.serverDryRun(upgradedRelease, current, target)
+		return upgradedRelease, err
+	}
+
+	if u.isDryRun() {
 		u.cfg.Log("dry run for %s", upgradedRelease.Name)
 		if len(u.Description) > 0 {
 			upgradedRelease.Info.Description = u.Description
@@ -314,7 +394,7 @@ func (u *Upgrade) performUpgrade(originalRelease, upgradedRelease *release.Relea
 		u.cfg.Log("upgrade hooks disabled for %s", upgradedRelease.Name)
 	}
 
-	results, err := u.cfg.KubeClient.Update(current, target, u.Force)
+	results, err := u.cfg.updateResources(current, target, u.Force, u.Timeout)
 	if err != nil {
 		u.cfg.recordRelease(originalRelease)
 		return u.failRelease(upgradedRelease, results.Created, err)
@@ -351,14 +431,13 @@ func (u *Upgrade) performUpgrade(originalRelease, upgradedRelease *release.Relea
 		}
 	}
 
-	originalRelease.Info.Status = release.StatusSuperseded
-	u.cfg.recordRelease(originalRelease)
+	u.cfg.supersedeRelease(originalRelease)
 
 	upgradedRelease.Info.Status = release.StatusDeployed
 	if len(u.Description) > 0 {
 		upgradedRelease.Info.Description = u.Description
 	} else {
-		upgradedRelease.Info.Description = "Upgrade complete"
+		upgradedRelease.Info.Description = withCRDSummary("Upgrade complete", u.crdSummary)
 	}
 
 	return upgradedRelease, nil
@@ -462,6 +541,26 @@ func (u *Upgrade) reuseValues(chart *chart.Chart, current *release.Release, newV
 	return newVals, nil
 }
 
+// mergeCustomLabels returns the labels of the previous revision updated with
+// the user-supplied labels. Labels with an empty value are removed.
+func mergeCustomLabels(current, desired map[string]string) map[string]string {
+	labels := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		labels[k] = v
+	}
+	for k, v := range desired {
+		if v == "" {
+			delete(labels, k)
+			continue
+		}
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
 func validateManifest(c kube.Interface, manifest []byte, openAPIValidation bool) error {
 	_, err := c.Build(bytes.NewReader(manifest), openAPIValidation)
 	return err
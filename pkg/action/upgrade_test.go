@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/engine"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -296,3 +297,115 @@ func TestUpgradeRelease_Pending(t *testing.T) {
 	_, err := upAction.Run(rel.Name, buildChart(), vals)
 	req.Contains(err.Error(), "progress", err)
 }
+
+func TestUpgradeRelease_ServerDryRun(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "dry-run-away"
+	rel.Info.Status = release.StatusDeployed
+	upAction.cfg.Releases.Create(rel)
+
+	upAction.ServerDryRun = true
+	vals := map[string]interface{}{}
+
+	res, err := upAction.Run(rel.Name, buildChart(), vals)
+	req.NoError(err)
+	is.NotNil(upAction.DryRunResult)
+	is.Equal("Server dry run complete", res.Info.Description)
+
+	_, err = upAction.cfg.Releases.Get(rel.Name, 2)
+	is.Error(err, "server dry run must not store a new revision")
+	last, err := upAction.cfg.Releases.Last(rel.Name)
+	req.NoError(err)
+	is.Equal(release.StatusDeployed, last.Info.Status)
+
+	// the same action then upgrades for real
+	is.False(upAction.DryRun, "server dry run must not change the dry run setting")
+	upAction.ServerDryRun = false
+	_, err = upAction.Run(rel.Name, buildChart(), vals)
+	req.NoError(err)
+	_, err = upAction.cfg.Releases.Get(rel.Name, 2)
+	is.NoError(err, "upgrade must store a new revision")
+}
+
+func TestUpgradeRelease_CustomLabels(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "labelled"
+	rel.Info.Status = release.StatusDeployed
+	rel.Labels = map[string]string{"team": "blue", "tier": "backend", "stage": "dev"}
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	upAction.Labels = map[string]string{"stage": "prod", "tier": ""}
+	res, err := upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.NoError(err)
+
+	expected := map[string]string{"team": "blue", "stage": "prod"}
+	is.Equal(expected, res.Labels)
+
+	updated, err := upAction.cfg.Releases.Get(res.Name, res.Version)
+	req.NoError(err)
+	is.Equal(expected, updated.Labels)
+
+	previous, err := upAction.cfg.Releases.Get(rel.Name, rel.Version)
+	req.NoError(err)
+	is.Equal(release.StatusSuperseded, previous.Info.Status)
+	is.Equal(map[string]string{"team": "blue", "tier": "backend", "stage": "dev"}, previous.Labels)
+}
+
+func TestMergeCustomLabels(t *testing.T) {
+	is := assert.New(t)
+	is.Nil(mergeCustomLabels(nil, nil))
+	is.Nil(mergeCustomLabels(map[string]string{"a": "b"}, map[string]string{"a": ""}))
+	is.Equal(map[string]string{"a": "b", "c": "d"}, mergeCustomLabels(map[string]string{"a": "b"}, map[string]string{"c": "d"}))
+	is.Equal(map[string]string{"a": "c"}, mergeCustomLabels(map[string]string{"a": "b"}, map[string]string{"a": "c"}))
+}
+
+func TestUpgradeRelease_RedactValues(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "redacted"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	upAction.RedactValues = redactPassword
+	chrt := buildChart(withTemplate("templates/secret", "password: {{ .Values.password }}\n"))
+	res, err := upAction.Run(rel.Name, chrt, map[string]interface{}{"password": "s3cr3t"})
+	req.NoError(err)
+	is.Contains(res.Manifest, "password: s3cr3t")
+
+	updated, err := upAction.cfg.Releases.Get(res.Name, res.Version)
+	req.NoError(err)
+	is.Equal("<encrypted:password>", updated.Config["password"])
+	is.Contains(updated.Manifest, "password: s3cr3t")
+	is.True(updated.Unrestorable)
+}
+
+func TestUpgradeRelease_RestoresAppliedSecrets(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	upAction.cfg.SecretProviders = map[string]engine.SecretProvider{
+		"vault": engine.JSONSecrets{"db": map[string]interface{}{"password": "p4ss"}},
+	}
+	rel := releaseStub()
+	rel.Name = "secrets"
+	rel.Manifest = "password: <redacted-base64:vault:db/password>\n"
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	// the three-way merge starts from the applied manifest
+	current, _, err := upAction.prepareUpgrade(rel.Name, buildChart(), map[string]interface{}{})
+	req.NoError(err)
+	is.Equal("password: cDRzcw==\n", current.Manifest)
+	is.Equal("password: <redacted-base64:vault:db/password>\n", current.Redacted().Manifest)
+}
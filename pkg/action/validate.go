@@ -54,6 +54,18 @@ func existingResourceConflict(resources kube.ResourceList, releaseName, releaseN
 			return errors.Wrap(err, "could not get information about the resource")
 		}
 
+		// Resources annotated with an update strategy other than merge are
+		// updated as it selects, or skipped, whoever manages them.
+		strategy, err := kube.UpdateStrategy(info)
+		if err != nil {
+			return err
+		}
+		switch strategy {
+		case kube.UpdateStrategySkipIfExists, kube.UpdateStrategyReplace, kube.UpdateStrategyRecreate:
+			requireUpdate.Append(info)
+			return nil
+		}
+
 		// Allow adoption of the resource if it is managed by Helm and is annotated with correct release name and namespace.
 		if err := checkOwnership(existing, releaseName, releaseNamespace); err != nil {
 			return fmt.Errorf("%s exists and cannot be imported into the current release: %s", resourceString(info), err)
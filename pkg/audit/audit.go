@@ -0,0 +1,96 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package audit records the operations performed on releases.
+
+Every install, upgrade, rollback, uninstall and test emits a Record, whether
+it succeeds, fails or is only a dry run, to a Sink. Sinks only ever append
+records.
+*/
+package audit // import "github.com/huolunl/helm/v3/pkg/audit"
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Outcome is the result of an audited operation.
+type Outcome string
+
+// Outcomes of audited operations.
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// Record describes a single operation performed on a release.
+type Record struct {
+	// Time is when the operation completed.
+	Time time.Time `json:"time"`
+	// Actor identifies who performed the operation.
+	Actor string `json:"actor"`
+	// Operation is the name of the operation, e.g. "install".
+	Operation string `json:"operation"`
+	// Release is the name of the release.
+	Release string `json:"release"`
+	// Namespace is the namespace of the release.
+	Namespace string `json:"namespace,omitempty"`
+	// Revision is the revision of the release the operation produced or
+	// acted on, if known.
+	Revision int `json:"revision,omitempty"`
+	// Chart is the name and version of the chart, if known.
+	Chart string `json:"chart,omitempty"`
+	// DryRun is set for operations that did not change anything.
+	DryRun bool `json:"dryRun,omitempty"`
+	// ParamsDigest is a digest of the parameters of the operation. It allows
+	// comparing the parameters of operations without revealing them. The
+	// values supplied are only part of it when it is keyed.
+	ParamsDigest string `json:"paramsDigest,omitempty"`
+	// Outcome is the result of the operation.
+	Outcome Outcome `json:"outcome"`
+	// Error is the error the operation failed with.
+	Error string `json:"error,omitempty"`
+}
+
+// Sink receives audit records.
+type Sink interface {
+	// Write appends a record to the audit trail.
+	Write(r *Record) error
+}
+
+// Digest returns the HMAC-SHA256 of the JSON encoding of params keyed with
+// key, or its plain SHA-256 digest if key is empty. Map keys are sorted by
+// the encoding, so equal parameters always have the same digest.
+//
+// Without a key, guessable parameters can be recovered from their digest by
+// brute force, so secrets must not be digested without one.
+func Digest(key []byte, params interface{}) (string, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		sum := sha256.Sum256(b)
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
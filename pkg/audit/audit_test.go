@@ -0,0 +1,159 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+func testRecord(operation string) *Record {
+	return &Record{
+		Time:      time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC),
+		Actor:     "alice",
+		Operation: operation,
+		Release:   "smug-pigeon",
+		Namespace: "default",
+		Revision:  1,
+		Outcome:   OutcomeSuccess,
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a, err := Digest(nil, map[string]interface{}{"b": 1, "a": map[string]interface{}{"c": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Digest(nil, map[string]interface{}{"a": map[string]interface{}{"c": true}, "b": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("Expected equal parameters to have the same digest, got %s and %s", a, b)
+	}
+	c, _ := Digest(nil, map[string]interface{}{"b": 2})
+	if a == c {
+		t.Errorf("Expected different parameters to have different digests")
+	}
+
+	keyed, _ := Digest([]byte("k1"), map[string]interface{}{"b": 2})
+	if !strings.HasPrefix(keyed, "hmac-sha256:") {
+		t.Errorf("Expected a keyed digest to be an HMAC, got %s", keyed)
+	}
+	if keyed == c {
+		t.Errorf("Expected the keyed digest to differ from the plain one")
+	}
+	if other, _ := Digest([]byte("k2"), map[string]interface{}{"b": 2}); other == keyed {
+		t.Errorf("Expected digests with different keys to differ")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "helm.jsonl")
+	sink := NewFileSink(path)
+	for _, op := range []string{"install", "upgrade"} {
+		if err := sink.Write(testRecord(op)); err != nil {
+			t.Fatalf("Failed to write record: %s", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ops []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid record %q: %s", scanner.Text(), err)
+		}
+		ops = append(ops, r.Operation)
+	}
+	if len(ops) != 2 || ops[0] != "install" || ops[1] != "upgrade" {
+		t.Errorf("Expected records to be appended in order, got %v", ops)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got Record
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(srv.URL)
+	if err := sink.Write(testRecord("install")); err == nil {
+		t.Error("Expected error for a rejected record")
+	}
+	sink.Header.Set("Authorization", "Bearer token")
+	if err := sink.Write(testRecord("install")); err != nil {
+		t.Fatalf("Failed to post record: %s", err)
+	}
+	if got.Operation != "install" || got.Actor != "alice" {
+		t.Errorf("Unexpected record received: %+v", got)
+	}
+}
+
+func TestStorageSink(t *testing.T) {
+	client := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+	sink := NewStorageSink(driver.NewConfigMaps(client))
+	failed := testRecord("uninstall")
+	failed.Outcome = OutcomeFailure
+	failed.Error = "boom"
+	for _, r := range []*Record{testRecord("install"), failed} {
+		r.Time = r.Time.Add(time.Duration(len(r.Operation)))
+		if err := sink.Write(r); err != nil {
+			t.Fatalf("Failed to store record: %s", err)
+		}
+	}
+
+	list, err := client.List(context.Background(), metav1.ListOptions{LabelSelector: "owner=helm-audit,outcome=failure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("Expected 1 failed record, got %d", len(list.Items))
+	}
+	var r Record
+	if err := json.Unmarshal([]byte(list.Items[0].Data["audit"]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Operation != "uninstall" || r.Error != "boom" {
+		t.Errorf("Unexpected record stored: %+v", r)
+	}
+}
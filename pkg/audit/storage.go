@@ -0,0 +1,56 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+// StorageSink stores every record through the storage driver of the releases,
+// e.g. in its own ConfigMap with the ConfigMaps driver. Records are labeled
+// with owner=helm-audit and the name of the release, operation and outcome.
+type StorageSink struct {
+	driver driver.Appender
+}
+
+// NewStorageSink creates a StorageSink storing records through d.
+func NewStorageSink(d driver.Appender) *StorageSink {
+	return &StorageSink{driver: d}
+}
+
+// Write stores a record.
+func (s *StorageSink) Write(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	name := r.Release
+	if name == "" {
+		// e.g. an install that failed before a release name was generated
+		name = "unnamed"
+	}
+	key := fmt.Sprintf("sh.helm.audit.v1.%s.%d", name, r.Time.UnixNano())
+	return s.driver.Append("audit", key, map[string]string{
+		"owner":     "helm-audit",
+		"name":      r.Release,
+		"operation": r.Operation,
+		"outcome":   string(r.Outcome),
+	}, b)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// CapabilitiesSnapshot is a capture of the capabilities of a cluster, saved
+// to a file so that charts can be rendered offline as they would be against
+// that cluster.
+type CapabilitiesSnapshot struct {
+	// KubeVersion is the version reported by the cluster.
+	KubeVersion string `json:"kubeVersion"`
+	// APIVersions are the API versions served by the cluster.
+	APIVersions VersionSet `json:"apiVersions"`
+	// Objects are cluster objects captured for the lookup function. They are
+	// typically Secrets, so a snapshot may contain secret data.
+	Objects []map[string]interface{} `json:"objects,omitempty"`
+}
+
+// NewCapabilitiesSnapshot captures caps.
+func NewCapabilitiesSnapshot(caps *Capabilities) *CapabilitiesSnapshot {
+	return &CapabilitiesSnapshot{
+		KubeVersion: caps.KubeVersion.Version,
+		APIVersions: caps.APIVersions,
+	}
+}
+
+// LoadCapabilitiesSnapshot reads a capabilities snapshot file.
+func LoadCapabilitiesSnapshot(filename string) (*CapabilitiesSnapshot, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &CapabilitiesSnapshot{}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse capabilities file %s", filename)
+	}
+	if _, err := s.Capabilities(); err != nil {
+		return nil, errors.Wrapf(err, "invalid capabilities file %s", filename)
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to a file. The file is only readable by its owner,
+// as the captured objects may contain secret data.
+func (s *CapabilitiesSnapshot) Save(filename string) error {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// an existing file keeps its mode when opened
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Capabilities returns the captured capabilities. The Helm version is the
+// one of the running Helm.
+func (s *CapabilitiesSnapshot) Capabilities() (*Capabilities, error) {
+	kubeVersion, err := ParseKubeVersion(s.KubeVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid kubeVersion %q", s.KubeVersion)
+	}
+	// keep the version exactly as reported by the cluster
+	kubeVersion.Version = s.KubeVersion
+	caps := DefaultCapabilities.Copy()
+	caps.KubeVersion = *kubeVersion
+	caps.APIVersions = s.APIVersions
+	return caps, nil
+}
@@ -16,6 +16,9 @@ limitations under the License.
 package chartutil
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -82,3 +85,49 @@ func TestParseKubeVersion(t *testing.T) {
 		t.Errorf("Expected parsed KubeVersion.Minor to be 16, got %q", kv.Minor)
 	}
 }
+
+func TestCapabilitiesSnapshot(t *testing.T) {
+	caps := DefaultCapabilities.Copy()
+	caps.KubeVersion = KubeVersion{Version: "v1.22.3-gke.1500", Major: "1", Minor: "22"}
+	caps.APIVersions = VersionSet{"v1", "networking.k8s.io/v1"}
+
+	snapshot := NewCapabilitiesSnapshot(caps)
+	snapshot.Objects = []map[string]interface{}{{"apiVersion": "v1", "kind": "Secret"}}
+	filename := filepath.Join(t.TempDir(), "capabilities.yaml")
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filename); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected the snapshot to be only readable by its owner, got mode %v", fi.Mode().Perm())
+	}
+
+	loaded, err := LoadCapabilitiesSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Objects) != 1 {
+		t.Errorf("Expected 1 object, got %d", len(loaded.Objects))
+	}
+	replayed, err := loaded.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.KubeVersion != caps.KubeVersion {
+		t.Errorf("Expected kube version %v, got %v", caps.KubeVersion, replayed.KubeVersion)
+	}
+	if !replayed.APIVersions.Has("networking.k8s.io/v1") || replayed.APIVersions.Has("apps/v1") {
+		t.Errorf("Expected the captured API versions, got %v", replayed.APIVersions)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("kubeVersion: latest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCapabilitiesSnapshot(filename); err == nil {
+		t.Error("Expected an error for an invalid kube version")
+	}
+}
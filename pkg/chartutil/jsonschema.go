@@ -18,70 +18,208 @@ package chartutil
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/pkg/errors"
-	"github.com/xeipuuv/gojsonschema"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"sigs.k8s.io/yaml"
 
 	"github.com/huolunl/helm/v3/pkg/chart"
 )
 
+// Values schemas may use the keywords of the JSON Schema drafts 4 to 2020-12,
+// those without $schema following the draft 7.
+// Their $ref may refer to the other JSON or YAML files of the chart, by path
+// relative to the referring schema, and to the files of the subcharts, which
+// are under charts/<subchart name>/. A library chart can thus export schema
+// definitions that the charts depending on it refer to:
+//
+//	"image": {"$ref": "charts/common/schemas/image.json#/$defs/image"}
+//
+// The schemas are identified by "chart:" URIs whose path is the path of their
+// file in the chart, such as chart:///charts/common/values.schema.json.
+
+// schemaURIScheme is the URI scheme of the files of a chart.
+const schemaURIScheme = "chart"
+
 // ValidateAgainstSchema checks that values does not violate the structure laid out in schema
+//
+// The failures are returned as SchemaErrors, whose paths are the JSON
+// pointers of the values in the top-level values, but for the globals.
 func ValidateAgainstSchema(chrt *chart.Chart, values map[string]interface{}) error {
-	var sb strings.Builder
+	c := newSchemaCompiler(func(name string) ([]byte, error) {
+		return readChartFile(chrt, name)
+	})
+	var errs SchemaErrors
+	if err := validateAgainstSchema(c, chrt, values, "/", "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateAgainstSchema(c *jsonschema.Compiler, chrt *chart.Chart, values map[string]interface{}, dir, prefix string, errs *SchemaErrors) error {
 	if chrt.Schema != nil {
-		err := ValidateAgainstSingleSchema(values, chrt.Schema)
+		failures, err := validateValues(c, values, dir+SchemafileName, prefix)
 		if err != nil {
-			sb.WriteString(fmt.Sprintf("%s:\n", chrt.Name()))
-			sb.WriteString(err.Error())
+			return errors.Wrapf(err, "cannot validate the values of chart %s", chrt.Name())
+		}
+		for _, f := range failures {
+			f.Chart = chrt.Name()
 		}
+		*errs = append(*errs, failures...)
 	}
 
 	// For each dependency, recursively call this function with the coalesced values
 	for _, subchart := range chrt.Dependencies() {
-		subchartValues := values[subchart.Name()].(map[string]interface{})
-		if err := ValidateAgainstSchema(subchart, subchartValues); err != nil {
-			sb.WriteString(err.Error())
+		subchartValues, ok := values[subchart.Name()].(map[string]interface{})
+		if !ok {
+			// The globals are validated even without values for the subchart.
+			subchartValues = map[string]interface{}{}
+			if globals, ok := values[GlobalKey]; ok {
+				subchartValues[GlobalKey] = globals
+			}
+		}
+		if err := validateAgainstSchema(c, subchart, subchartValues, dir+"charts/"+subchart.Name()+"/", prefix+"/"+escapePointer(subchart.Name()), errs); err != nil {
+			return err
 		}
 	}
-
-	if sb.Len() > 0 {
-		return errors.New(sb.String())
-	}
-
 	return nil
 }
 
 // ValidateAgainstSingleSchema checks that values does not violate the structure laid out in this schema
 func ValidateAgainstSingleSchema(values Values, schemaJSON []byte) error {
-	valuesData, err := yaml.Marshal(values)
+	return ValidateAgainstSchemaFiles(values, schemaJSON, func(name string) ([]byte, error) {
+		return nil, errors.Errorf("cannot read %s: the schema cannot refer to other files", name)
+	})
+}
+
+// ValidateAgainstSchemaFiles checks that values does not violate the
+// structure laid out in schemaJSON, whose $ref refer to the files read with
+// readFile, given their slash-separated path in the chart.
+func ValidateAgainstSchemaFiles(values Values, schemaJSON []byte, readFile func(name string) ([]byte, error)) error {
+	c := newSchemaCompiler(func(name string) ([]byte, error) {
+		if name == SchemafileName {
+			return schemaJSON, nil
+		}
+		return readFile(name)
+	})
+	errs, err := validateValues(c, values, "/"+SchemafileName, "")
 	if err != nil {
 		return err
 	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// newSchemaCompiler returns a compiler of the schemas of the files of a chart,
+// read with readFile. Schemas without $schema follow the draft 7, and formats
+// are asserted, as they were before the later drafts were supported.
+func newSchemaCompiler(readFile func(name string) ([]byte, error)) *jsonschema.Compiler {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft7
+	c.AssertFormat = true
+	c.LoadURL = schemaLoader(readFile)
+	return c
+}
+
+// validateValues validates values against the schema in the chart file name,
+// prefixing the paths of the failures with prefix, but for the globals.
+func validateValues(c *jsonschema.Compiler, values Values, name, prefix string) (SchemaErrors, error) {
+	valuesData, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
 	valuesJSON, err := yaml.YAMLToJSON(valuesData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if bytes.Equal(valuesJSON, []byte("null")) {
 		valuesJSON = []byte("{}")
 	}
-	schemaLoader := gojsonschema.NewBytesLoader(schemaJSON)
-	valuesLoader := gojsonschema.NewBytesLoader(valuesJSON)
+	decoder := json.NewDecoder(bytes.NewReader(valuesJSON))
+	decoder.UseNumber()
+	var instance interface{}
+	if err := decoder.Decode(&instance); err != nil {
+		return nil, err
+	}
 
-	result, err := gojsonschema.Validate(schemaLoader, valuesLoader)
+	schema, err := c.Compile(schemaURIScheme + "://" + name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = schema.Validate(instance)
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil, err
 	}
 
-	if !result.Valid() {
-		var sb strings.Builder
-		for _, desc := range result.Errors() {
-			sb.WriteString(fmt.Sprintf("- %s\n", desc))
+	errs := schemaErrors(verr, schema, instance)
+	for _, e := range errs {
+		if prefix != "" && !isGlobalPath(e.Path) {
+			e.Path = prefix + e.Path
 		}
-		return errors.New(sb.String())
 	}
+	return errs, nil
+}
 
-	return nil
+func isGlobalPath(p string) bool {
+	return p == "/"+GlobalKey || strings.HasPrefix(p, "/"+GlobalKey+"/")
+}
+
+// schemaLoader returns the loader of the schemas of the files of a chart,
+// which may be YAML.
+func schemaLoader(readFile func(name string) ([]byte, error)) func(uri string) (io.ReadCloser, error) {
+	return func(uri string) (io.ReadCloser, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != schemaURIScheme {
+			return nil, errors.Errorf("only the files of the chart can be referred to")
+		}
+		name := strings.TrimPrefix(path.Clean(u.Path), "/")
+		data, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		switch path.Ext(name) {
+		case ".yaml", ".yml":
+			if data, err = yaml.YAMLToJSON(data); err != nil {
+				return nil, err
+			}
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// readChartFile reads a file of a chart or of its subcharts.
+func readChartFile(chrt *chart.Chart, name string) ([]byte, error) {
+	if strings.HasPrefix(name, "charts/") {
+		parts := strings.SplitN(strings.TrimPrefix(name, "charts/"), "/", 2)
+		for _, dep := range chrt.Dependencies() {
+			if len(parts) == 2 && dep.Name() == parts[0] {
+				return readChartFile(dep, parts[1])
+			}
+		}
+	}
+	if name == SchemafileName && chrt.Schema != nil {
+		return chrt.Schema, nil
+	}
+	for _, f := range chrt.Files {
+		if f.Name == name {
+			return f.Data, nil
+		}
+	}
+	return nil, errors.Wrapf(os.ErrNotExist, "no file %s in chart %s", name, chrt.Name())
 }
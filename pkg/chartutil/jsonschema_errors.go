@@ -0,0 +1,384 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaError is a value that does not meet the schema of its chart.
+type SchemaError struct {
+	// Chart is the name of the chart whose schema the value does not meet,
+	// empty when validating against a single schema.
+	Chart string
+	// Path is the JSON pointer of the value. The values of subcharts have
+	// the pointers of their parent values, and the globals their pointers in
+	// the top-level values.
+	Path string
+	// Value is the value, decoded from JSON with numbers as json.Number, or
+	// nil when missing.
+	Value interface{}
+
+	// at is the JSON pointer of the value, or of its array or object when
+	// it is not allowed there, in the values of the chart.
+	at          string
+	description string
+}
+
+// Error returns the field of the value, relative to the values of its chart,
+// and the failure, as in "age: Must be greater than or equal to 0".
+func (e *SchemaError) Error() string {
+	return pointerField(e.at) + ": " + e.description
+}
+
+// SchemaErrors are the values that do not meet the schemas of a chart and of
+// its subcharts.
+type SchemaErrors []*SchemaError
+
+// Error lists the failures, under the name of their chart.
+func (e SchemaErrors) Error() string {
+	var sb strings.Builder
+	chart := ""
+	for _, err := range e {
+		if err.Chart != chart {
+			sb.WriteString(fmt.Sprintf("%s:\n", err.Chart))
+			chart = err.Chart
+		}
+		sb.WriteString(fmt.Sprintf("- %s\n", err))
+	}
+	return sb.String()
+}
+
+var validationIndexesRegex = regexp.MustCompile(`\d+`)
+
+// schemaErrors returns the failures of the validation of instance against
+// schema, ordered by field.
+func schemaErrors(verr *jsonschema.ValidationError, schema *jsonschema.Schema, instance interface{}) SchemaErrors {
+	schemas := map[string]*jsonschema.Schema{}
+	indexSchemas(schema, schemas)
+
+	var errs SchemaErrors
+	var walk func(ve *jsonschema.ValidationError)
+	walk = func(ve *jsonschema.ValidationError) {
+		keyword := ve.KeywordLocation[strings.LastIndex(ve.KeywordLocation, "/")+1:]
+		if len(ve.Causes) > 0 && keyword != "anyOf" && keyword != "oneOf" {
+			for _, c := range ve.Causes {
+				walk(c)
+			}
+			return
+		}
+		value := lookupPointer(instance, ve.InstanceLocation)
+		at := ve.InstanceLocation
+		var descriptions []string
+		if s := schemas[ve.AbsoluteKeywordLocation]; s != nil && s.Always != nil && !*s.Always {
+			// The false schemas of the items and properties fail on the
+			// values they do not allow, reported on the array or object.
+			parent := ve.InstanceLocation[:strings.LastIndex(ve.InstanceLocation, "/")+1]
+			at = strings.TrimSuffix(parent, "/")
+			descriptions = []string{describeDisallowed(keyword, unescapePointer(ve.InstanceLocation[len(parent):]), ve.Message)}
+		} else {
+			descriptions = describeFailure(ve, keyword, keywordSchema(schemas, ve.AbsoluteKeywordLocation), value, pointerField(at))
+		}
+		for _, description := range descriptions {
+			errs = append(errs, &SchemaError{
+				Path:        ve.InstanceLocation,
+				Value:       value,
+				at:          at,
+				description: description,
+			})
+		}
+	}
+	walk(verr)
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].at < errs[j].at })
+	return errs
+}
+
+// describeFailure describes the failure ve of the value at field against the
+// keyword of schema s, as gojsonschema did before, so that the messages of
+// the charts validated with draft 7 or earlier stay the same.
+func describeFailure(ve *jsonschema.ValidationError, keyword string, s *jsonschema.Schema, value interface{}, field string) []string {
+	if s == nil {
+		return []string{ve.Message}
+	}
+	object, _ := value.(map[string]interface{})
+	if tokens := strings.Split(ve.KeywordLocation, "/"); len(tokens) >= 3 {
+		// The properties required by a property are reported by index.
+		switch tokens[len(tokens)-3] {
+		case "dependencies", "dependentRequired":
+			if p, ok := dependency(s, unescapePointer(tokens[len(tokens)-2]), keyword); ok {
+				return []string{fmt.Sprintf("Has a dependency on %s", p)}
+			}
+		}
+	}
+	switch keyword {
+	case "type":
+		expected := s.Types[0]
+		if len(s.Types) > 1 {
+			expected = fmt.Sprintf("[%s]", strings.Join(s.Types, ","))
+		}
+		return []string{fmt.Sprintf("Invalid type. Expected: %s, given: %s", expected, valueType(value))}
+	case "required":
+		var descriptions []string
+		for _, p := range s.Required {
+			if _, ok := object[p]; !ok {
+				descriptions = append(descriptions, fmt.Sprintf("%s is required", p))
+			}
+		}
+		return descriptions
+	case "additionalProperties":
+		var descriptions []string
+		for _, p := range sortedKeys(object) {
+			if _, ok := s.Properties[p]; ok || matchesPattern(s, p) {
+				continue
+			}
+			descriptions = append(descriptions, fmt.Sprintf("Additional property %s is not allowed", p))
+		}
+		return descriptions
+	case "const":
+		return []string{fmt.Sprintf("%s does not match: %s", field, marshalValue(s.Constant[0]))}
+	case "enum":
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			allowed[i] = marshalValue(v)
+		}
+		return []string{fmt.Sprintf("%s must be one of the following: %s", field, strings.Join(allowed, ", "))}
+	case "minimum":
+		return []string{"Must be greater than or equal to " + ratString(s.Minimum)}
+	case "exclusiveMinimum":
+		return []string{"Must be greater than " + ratString(s.ExclusiveMinimum)}
+	case "maximum":
+		return []string{"Must be less than or equal to " + ratString(s.Maximum)}
+	case "exclusiveMaximum":
+		return []string{"Must be less than " + ratString(s.ExclusiveMaximum)}
+	case "multipleOf":
+		return []string{"Must be a multiple of " + ratString(s.MultipleOf)}
+	case "minLength":
+		return []string{fmt.Sprintf("String length must be greater than or equal to %d", s.MinLength)}
+	case "maxLength":
+		return []string{fmt.Sprintf("String length must be less than or equal to %d", s.MaxLength)}
+	case "pattern":
+		return []string{fmt.Sprintf("Does not match pattern '%s'", s.Pattern)}
+	case "format":
+		return []string{fmt.Sprintf("Does not match format '%s'", s.Format)}
+	case "minItems":
+		return []string{fmt.Sprintf("Array must have at least %d items", s.MinItems)}
+	case "maxItems":
+		return []string{fmt.Sprintf("Array must have at most %d items", s.MaxItems)}
+	case "uniqueItems":
+		if indexes := validationIndexesRegex.FindAllString(ve.Message, 2); len(indexes) == 2 {
+			return []string{fmt.Sprintf("array items[%s,%s] must be unique", indexes[0], indexes[1])}
+		}
+	case "additionalItems", "items":
+		return []string{"No additional items allowed on array"}
+	case "contains", "minContains":
+		return []string{"At least one of the items must match"}
+	case "minProperties":
+		return []string{fmt.Sprintf("Must have at least %d properties", s.MinProperties)}
+	case "maxProperties":
+		return []string{fmt.Sprintf("Must have at most %d properties", s.MaxProperties)}
+	case "anyOf":
+		return []string{"Must validate at least one schema (anyOf)"}
+	case "oneOf":
+		return []string{"Must validate one and only one schema (oneOf)"}
+	case "not":
+		return []string{"Must not validate the schema (not)"}
+	}
+	return []string{ve.Message}
+}
+
+// describeDisallowed describes the failure of the value name of an array or
+// object against the false schema of keyword.
+func describeDisallowed(keyword, name, message string) string {
+	// The validator names the unevaluated keywords in uppercase.
+	switch strings.ToLower(keyword) {
+	case "additionalproperties", "unevaluatedproperties":
+		return fmt.Sprintf("Additional property %s is not allowed", name)
+	case "items", "additionalitems", "unevaluateditems":
+		return "No additional items allowed on array"
+	}
+	return message
+}
+
+// indexSchemas indexes by location s and the schemas it refers to.
+func indexSchemas(s *jsonschema.Schema, schemas map[string]*jsonschema.Schema) {
+	if s == nil || schemas[s.Location] != nil {
+		return
+	}
+	schemas[s.Location] = s
+	children := []*jsonschema.Schema{s.Ref, s.RecursiveRef, s.DynamicRef, s.Not, s.If, s.Then, s.Else,
+		s.PropertyNames, s.UnevaluatedProperties, s.Items2020, s.Contains, s.UnevaluatedItems, s.ContentSchema}
+	children = append(children, s.AllOf...)
+	children = append(children, s.AnyOf...)
+	children = append(children, s.OneOf...)
+	children = append(children, s.PrefixItems...)
+	for _, c := range s.Properties {
+		children = append(children, c)
+	}
+	for _, c := range s.PatternProperties {
+		children = append(children, c)
+	}
+	for _, c := range s.DependentSchemas {
+		children = append(children, c)
+	}
+	for _, c := range s.Dependencies {
+		if c, ok := c.(*jsonschema.Schema); ok {
+			children = append(children, c)
+		}
+	}
+	for _, c := range []interface{}{s.AdditionalProperties, s.AdditionalItems, s.Items} {
+		switch c := c.(type) {
+		case *jsonschema.Schema:
+			children = append(children, c)
+		case []*jsonschema.Schema:
+			children = append(children, c...)
+		}
+	}
+	for _, c := range children {
+		indexSchemas(c, schemas)
+	}
+}
+
+// keywordSchema returns the schema of the keyword at location, whose
+// pointer may end with the indexes of the keyword's values.
+func keywordSchema(schemas map[string]*jsonschema.Schema, location string) *jsonschema.Schema {
+	for i := strings.LastIndex(location, "/"); i >= 0; i = strings.LastIndex(location, "/") {
+		location = location[:i]
+		if s, ok := schemas[location]; ok {
+			return s
+		}
+		if s, ok := schemas[strings.TrimSuffix(location, "#")]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// dependency returns the property at index of the properties required by the
+// property name in s.
+func dependency(s *jsonschema.Schema, name, index string) (string, bool) {
+	required, ok := s.Dependencies[name].([]string)
+	if !ok {
+		required = s.DependentRequired[name]
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(required) {
+		return "", false
+	}
+	return required[i], true
+}
+
+func matchesPattern(s *jsonschema.Schema, name string) bool {
+	for re := range s.PatternProperties {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pointerField returns the field of the JSON pointer ptr, as in "image.tag",
+// or "(root)".
+func pointerField(ptr string) string {
+	if ptr == "" {
+		return "(root)"
+	}
+	tokens := strings.Split(strings.TrimPrefix(ptr, "/"), "/")
+	for i, t := range tokens {
+		tokens[i] = unescapePointer(t)
+	}
+	return strings.Join(tokens, ".")
+}
+
+// lookupPointer returns the value at the JSON pointer ptr of v, or nil.
+func lookupPointer(v interface{}, ptr string) interface{} {
+	if ptr == "" {
+		return v
+	}
+	for _, t := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		switch current := v.(type) {
+		case map[string]interface{}:
+			v = current[unescapePointer(t)]
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(current) {
+				return nil
+			}
+			v = current[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+func unescapePointer(s string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(s)
+}
+
+// valueType returns the JSON type of v, distinguishing the integers.
+func valueType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func marshalValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func ratString(r *big.Rat) string {
+	return new(big.Float).SetRat(r).String()
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,303 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// SchemaDraft07 is the JSON Schema dialect of the generated schemas.
+const SchemaDraft07 = "http://json-schema.org/draft-07/schema#"
+
+// GenerateSchema infers a JSON Schema from the types and the structure of a
+// values.yaml file.
+//
+// The comment lines above a value, and its inline comment, describe it. Lines
+// starting with an annotation add keywords to its schema:
+//
+//	# @enum Always, IfNotPresent, Never
+//	# @pattern ^[a-z]+$
+//	# @type string, null
+//	# @required
+//	# @description The pull policy of the image.
+//
+// @required adds the value to the required properties of its parent. @type
+// overrides the inferred type, which is left unset for null values.
+//
+// When existing is not empty, the generated schema is merged into it: the
+// generated keywords replace the existing ones, the other keywords and
+// properties of the existing schema are kept, and the required properties are
+// merged.
+func GenerateSchema(values, existing []byte) ([]byte, error) {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(values, &doc); err != nil {
+		return nil, errors.Wrap(err, "cannot parse values")
+	}
+
+	generated := map[string]interface{}{"type": "object"}
+	if len(doc.Content) > 0 {
+		root := doc.Content[0]
+		if root.Kind != yaml3.MappingNode {
+			return nil, errors.New("the values are not a map")
+		}
+		var err error
+		if generated, err = schemaOfNode(root); err != nil {
+			return nil, err
+		}
+	}
+	generated["$schema"] = SchemaDraft07
+
+	schema := generated
+	if len(strings.TrimSpace(string(existing))) > 0 {
+		var current map[string]interface{}
+		if err := json.Unmarshal(existing, &current); err != nil {
+			return nil, errors.Wrap(err, "cannot parse the existing schema")
+		}
+		if _, ok := current["$schema"]; ok {
+			delete(generated, "$schema")
+		}
+		schema = mergeSchemas(current, generated)
+	}
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// schemaOfNode infers the schema of a values node, without its annotations.
+func schemaOfNode(node *yaml3.Node) (map[string]interface{}, error) {
+	if node.Kind == yaml3.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml3.MappingNode:
+		properties := map[string]interface{}{}
+		var required []interface{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				continue
+			}
+			schema, err := schemaOfNode(value)
+			if err != nil {
+				return nil, err
+			}
+			isRequired, err := annotateSchema(schema, key, value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", key.Line)
+			}
+			properties[key.Value] = schema
+			if isRequired {
+				required = append(required, key.Value)
+			}
+		}
+		schema := map[string]interface{}{"type": "object"}
+		if len(properties) > 0 {
+			schema["properties"] = properties
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+	case yaml3.SequenceNode:
+		schema := map[string]interface{}{"type": "array"}
+		if len(node.Content) > 0 {
+			items, err := schemaOfNode(node.Content[0])
+			if err != nil {
+				return nil, err
+			}
+			schema["items"] = items
+		}
+		return schema, nil
+	case yaml3.ScalarNode:
+		switch node.ShortTag() {
+		case "!!str", "!!binary", "!!timestamp":
+			return map[string]interface{}{"type": "string"}, nil
+		case "!!int":
+			return map[string]interface{}{"type": "integer"}, nil
+		case "!!float":
+			return map[string]interface{}{"type": "number"}, nil
+		case "!!bool":
+			return map[string]interface{}{"type": "boolean"}, nil
+		}
+	}
+	return map[string]interface{}{}, nil
+}
+
+// annotateSchema adds the description and the annotations of the comments of
+// a value to its schema, and returns whether it is required.
+func annotateSchema(schema map[string]interface{}, key, value *yaml3.Node) (bool, error) {
+	var description []string
+	required := false
+	comments := []string{key.HeadComment, key.LineComment, value.LineComment}
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+			if line == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, "@") {
+				description = append(description, line)
+				continue
+			}
+
+			name, arg := line[1:], ""
+			if i := strings.IndexAny(name, " \t"); i >= 0 {
+				name, arg = name[:i], strings.TrimSpace(name[i:])
+			}
+			switch name {
+			case "required":
+				required = true
+			case "description":
+				description = append(description, arg)
+			case "pattern":
+				if arg == "" {
+					return false, errors.New("@pattern requires a regular expression")
+				}
+				schema["pattern"] = arg
+			case "enum":
+				enum, err := parseAnnotationList(arg)
+				if err != nil || len(enum) == 0 {
+					return false, errors.Errorf("invalid @enum %q", arg)
+				}
+				schema["enum"] = enum
+			case "type":
+				var types []interface{}
+				for _, t := range strings.Split(strings.Trim(arg, "[]"), ",") {
+					if t = strings.TrimSpace(t); t != "" {
+						types = append(types, t)
+					}
+				}
+				if len(types) == 0 {
+					return false, errors.New("@type requires a type")
+				}
+				if len(types) == 1 {
+					schema["type"] = types[0]
+				} else {
+					schema["type"] = types
+				}
+			default:
+				return false, errors.Errorf("unknown annotation @%s", name)
+			}
+		}
+	}
+	if len(description) > 0 {
+		schema["description"] = strings.Join(description, " ")
+	}
+	return required, nil
+}
+
+// parseAnnotationList parses a comma-separated list of YAML scalars, which
+// may be enclosed in brackets.
+func parseAnnotationList(arg string) ([]interface{}, error) {
+	if !strings.HasPrefix(arg, "[") {
+		arg = "[" + arg + "]"
+	}
+	var list []interface{}
+	err := yaml3.Unmarshal([]byte(arg), &list)
+	return list, err
+}
+
+// mergeSchemas merges a generated schema into an existing schema.
+func mergeSchemas(existing, generated map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(existing)+len(generated))
+	for k, v := range existing {
+		out[k] = v
+	}
+	for k, v := range generated {
+		switch k {
+		case "properties":
+			current, _ := existing[k].(map[string]interface{})
+			properties := make(map[string]interface{}, len(current))
+			for name, schema := range current {
+				properties[name] = schema
+			}
+			for name, schema := range v.(map[string]interface{}) {
+				if c, ok := properties[name].(map[string]interface{}); ok {
+					properties[name] = mergeSchemas(c, schema.(map[string]interface{}))
+				} else {
+					properties[name] = schema
+				}
+			}
+			out[k] = properties
+		case "items":
+			if c, ok := existing[k].(map[string]interface{}); ok {
+				out[k] = mergeSchemas(c, v.(map[string]interface{}))
+			} else {
+				out[k] = v
+			}
+		case "required":
+			current, _ := existing[k].([]interface{})
+			seen := map[interface{}]bool{}
+			var required []interface{}
+			for _, name := range append(current[:len(current):len(current)], v.([]interface{})...) {
+				if !seen[name] {
+					seen[name] = true
+					required = append(required, name)
+				}
+			}
+			out[k] = required
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// ValuesSchemaDrift returns the keys of values that the properties of schema
+// do not declare, sorted. Objects of the schema without properties, or that
+// allow additional properties with a schema or with patterns, are not
+// checked.
+func ValuesSchemaDrift(values map[string]interface{}, schemaJSON []byte) ([]string, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		return nil, errors.Wrap(err, "cannot parse schema")
+	}
+	var drift []string
+	valuesSchemaDrift(values, schema, "", &drift)
+	sort.Strings(drift)
+	return drift, nil
+}
+
+func valuesSchemaDrift(values map[string]interface{}, schema map[string]interface{}, prefix string, drift *[]string) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	_, hasPatterns := schema["patternProperties"]
+	_, additional := schema["additionalProperties"].(map[string]interface{})
+	for key, value := range values {
+		path := prefix + key
+		property, ok := properties[key].(map[string]interface{})
+		if !ok {
+			if _, declared := properties[key]; !declared && !hasPatterns && !additional {
+				*drift = append(*drift, path)
+			}
+			continue
+		}
+		if v, ok := value.(map[string]interface{}); ok {
+			valuesSchemaDrift(v, property, path+".", drift)
+		}
+	}
+}
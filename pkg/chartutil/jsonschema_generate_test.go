@@ -0,0 +1,148 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSchema(t *testing.T) {
+	values, err := ioutil.ReadFile("./testdata/annotated-values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadFile("./testdata/annotated-values.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := GenerateSchema(values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(schema) != string(expect) {
+		t.Errorf("expected schema:\n%s\ngot:\n%s", expect, schema)
+	}
+
+	vals, err := ReadValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateAgainstSingleSchema(vals, schema); err != nil {
+		t.Errorf("expected the values to be valid against the generated schema: %s", err)
+	}
+	vals["image"].(map[string]interface{})["pullPolicy"] = "Sometimes"
+	if err := ValidateAgainstSingleSchema(vals, schema); err == nil {
+		t.Error("expected a pull policy outside of the enum to be invalid")
+	}
+}
+
+func TestGenerateSchemaMerge(t *testing.T) {
+	values, err := ioutil.ReadFile("./testdata/test-values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := ioutil.ReadFile("./testdata/test-values.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := GenerateSchema(values, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keywords and the required properties of the existing schema are kept.
+	if schema["title"] != "Values" {
+		t.Errorf("expected the title to be kept, got %v", schema["title"])
+	}
+	age := schema["properties"].(map[string]interface{})["age"].(map[string]interface{})
+	if age["minimum"] != 0.0 || age["description"] != "Age" || age["type"] != "integer" {
+		t.Errorf("expected the age schema to be kept, got %v", age)
+	}
+	expectRequired := []interface{}{"firstname", "lastname", "addresses", "employmentInfo"}
+	if !reflect.DeepEqual(schema["required"], expectRequired) {
+		t.Errorf("expected required %v, got %v", expectRequired, schema["required"])
+	}
+
+	vals, err := ReadValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateAgainstSingleSchema(vals, out); err != nil {
+		t.Errorf("expected the values to be valid against the merged schema: %s", err)
+	}
+}
+
+func TestGenerateSchemaErrors(t *testing.T) {
+	for values, expect := range map[string]string{
+		"- a\n- b\n":                  "the values are not a map",
+		"# @minimum 1\nreplicas: 1\n": "line 2: unknown annotation @minimum",
+		"# @enum\npolicy: Always\n":   `invalid @enum ""`,
+		"name: x # @pattern\n":        "@pattern requires a regular expression",
+	} {
+		_, err := GenerateSchema([]byte(values), nil)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%q: expected an error containing %q, got %v", values, expect, err)
+		}
+	}
+
+	if _, err := GenerateSchema([]byte("a: 1\n"), []byte("{")); err == nil {
+		t.Error("expected an error merging into an invalid schema")
+	}
+}
+
+func TestValuesSchemaDrift(t *testing.T) {
+	schema := []byte(`{
+  "type": "object",
+  "properties": {
+    "image": {
+      "type": "object",
+      "properties": {"repository": {"type": "string"}}
+    },
+    "labels": {
+      "type": "object",
+      "additionalProperties": {"type": "string"},
+      "properties": {"app": {"type": "string"}}
+    },
+    "any": {}
+  }
+}`)
+	values := map[string]interface{}{
+		"image":  map[string]interface{}{"repository": "nginx", "tag": "1.21"},
+		"labels": map[string]interface{}{"app": "web", "tier": "front"},
+		"any":    map[string]interface{}{"x": 1},
+		"extra":  true,
+	}
+
+	drift, err := ValuesSchemaDrift(values, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"extra", "image.tag"}
+	if !reflect.DeepEqual(drift, expect) {
+		t.Errorf("expected drift %v, got %v", expect, drift)
+	}
+}
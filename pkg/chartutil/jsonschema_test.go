@@ -17,6 +17,7 @@ limitations under the License.
 package chartutil
 
 import (
+	"fmt"
 	"io/ioutil"
 	"testing"
 
@@ -141,3 +142,158 @@ func TestValidateAgainstSchemaNegative(t *testing.T) {
 		t.Errorf("Error string :\n`%s`\ndoes not match expected\n`%s`", errString, expectedErrString)
 	}
 }
+
+func TestValidateAgainstSchemaRefs(t *testing.T) {
+	// A library chart exporting schema definitions.
+	library := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "common", Type: "library"},
+		Files: []*chart.File{
+			{Name: "schemas/image.json", Data: []byte(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$defs": {
+    "image": {
+      "type": "object",
+      "properties": {
+        "repository": {"type": "string"},
+        "pullPolicy": {"$ref": "policy.yaml"}
+      },
+      "required": ["repository"],
+      "unevaluatedProperties": false
+    }
+  }
+}`)},
+			{Name: "schemas/policy.yaml", Data: []byte("enum: [Always, IfNotPresent, Never]\n")},
+		},
+	}
+	subchart := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "sub"},
+		Schema: []byte(`{
+  "$schema": "https://json-schema.org/draft/2019-09/schema",
+  "properties": {
+    "replicas": {"$ref": "#/$defs/replicas"},
+    "global": {"properties": {"domain": {"type": "string", "format": "hostname"}}}
+  },
+  "$defs": {"replicas": {"type": "integer", "minimum": 1}}
+}`),
+	}
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "parent"},
+		Schema: []byte(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "properties": {
+    "image": {"$ref": "charts/common/schemas/image.json#/$defs/image"},
+    "sub": {"$ref": "charts/sub/values.schema.json"},
+    "ports": {"prefixItems": [{"type": "integer"}], "items": false}
+  }
+}`),
+	}
+	chrt.AddDependency(library)
+	chrt.AddDependency(subchart)
+
+	vals := map[string]interface{}{
+		"image":  map[string]interface{}{"repository": "nginx", "pullPolicy": "Always"},
+		"ports":  []interface{}{80},
+		"global": map[string]interface{}{"domain": "example.com"},
+		"sub":    map[string]interface{}{"replicas": 2, "global": map[string]interface{}{"domain": "example.com"}},
+	}
+	if err := ValidateAgainstSchema(chrt, vals); err != nil {
+		t.Errorf("Error validating Values against Schema: %s", err)
+	}
+
+	vals = map[string]interface{}{
+		"image":  map[string]interface{}{"pullPolicy": "Sometimes", "tag": "1.21"},
+		"ports":  []interface{}{80, 443},
+		"global": map[string]interface{}{"domain": "not a hostname"},
+		"sub":    map[string]interface{}{"replicas": 0, "global": map[string]interface{}{"domain": "not a hostname"}},
+	}
+	err := ValidateAgainstSchema(chrt, vals)
+	if err == nil {
+		t.Fatal("Expected an error, but got nil")
+	}
+	expectedErrString := `parent:
+- image: repository is required
+- image: Additional property tag is not allowed
+- image.pullPolicy: image.pullPolicy must be one of the following: "Always", "IfNotPresent", "Never"
+- ports: No additional items allowed on array
+- sub.global.domain: Does not match format 'hostname'
+- sub.replicas: Must be greater than or equal to 1
+sub:
+- global.domain: Does not match format 'hostname'
+- replicas: Must be greater than or equal to 1
+`
+	if err.Error() != expectedErrString {
+		t.Errorf("Error string :\n`%s`\ndoes not match expected\n`%s`", err, expectedErrString)
+	}
+
+	// The values of the subchart have the pointers of the parent values, but
+	// for the globals.
+	errs, ok := err.(SchemaErrors)
+	if !ok {
+		t.Fatalf("expected SchemaErrors, got %T", err)
+	}
+	expectedPaths := []struct {
+		chart, path, value string
+	}{
+		{"parent", "/image", ""},
+		{"parent", "/image/tag", "1.21"},
+		{"parent", "/image/pullPolicy", "Sometimes"},
+		{"parent", "/ports/1", "443"},
+		{"parent", "/sub/global/domain", "not a hostname"},
+		{"parent", "/sub/replicas", "0"},
+		{"sub", "/global/domain", "not a hostname"},
+		{"sub", "/sub/replicas", "0"},
+	}
+	if len(errs) != len(expectedPaths) {
+		t.Fatalf("expected %d errors, got %d", len(expectedPaths), len(errs))
+	}
+	for i, e := range expectedPaths {
+		value := ""
+		if _, isMap := errs[i].Value.(map[string]interface{}); !isMap {
+			value = fmt.Sprint(errs[i].Value)
+		}
+		if errs[i].Chart != e.chart || errs[i].Path != e.path || value != e.value {
+			t.Errorf("expected %s %s with value %q, got %s %s with value %q", e.chart, e.path, e.value, errs[i].Chart, errs[i].Path, value)
+		}
+	}
+}
+
+func TestValidateAgainstSchemaGlobals(t *testing.T) {
+	subchart := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "sub"},
+		Schema:   []byte(`{"properties": {"global": {"required": ["domain"]}}}`),
+	}
+	chrt := &chart.Chart{Metadata: &chart.Metadata{Name: "parent"}}
+	chrt.AddDependency(subchart)
+
+	// The subchart has no values, but its schema still validates the globals.
+	vals := map[string]interface{}{"global": map[string]interface{}{}}
+	err := ValidateAgainstSchema(chrt, vals)
+	expectedErrString := `sub:
+- global: domain is required
+`
+	if err == nil || err.Error() != expectedErrString {
+		t.Errorf("Error string :\n`%v`\ndoes not match expected\n`%s`", err, expectedErrString)
+	}
+}
+
+func TestValidateAgainstSchemaFiles(t *testing.T) {
+	schema := []byte(`{"properties": {"name": {"$ref": "schemas/name.json"}}}`)
+	readFile := func(name string) ([]byte, error) {
+		if name != "schemas/name.json" {
+			t.Fatalf("unexpected file %s", name)
+		}
+		return []byte(`{"type": "string"}`), nil
+	}
+
+	if err := ValidateAgainstSchemaFiles(map[string]interface{}{"name": "a"}, schema, readFile); err != nil {
+		t.Errorf("Error validating Values against Schema: %s", err)
+	}
+	err := ValidateAgainstSchemaFiles(map[string]interface{}{"name": 1}, schema, readFile)
+	if err == nil || err.Error() != "- name: Invalid type. Expected: string, given: integer\n" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := ValidateAgainstSingleSchema(map[string]interface{}{}, schema); err == nil {
+		t.Error("expected an error resolving a file without a loader")
+	}
+}
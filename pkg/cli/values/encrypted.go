@@ -0,0 +1,631 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Encrypted values files follow the layout of sops files: every leaf value
+// is encrypted with AES256-GCM by a random data key, using its key path as
+// additional data, and the data key is encrypted for OpenPGP and age
+// recipients in the metadata under the top-level "sops" key. A MAC of all the values
+// detects changes to the file.
+const (
+	encryptedMetadataKey = "sops"
+	unencryptedSuffix    = "_unencrypted"
+	encryptedVersion     = "3.7.1"
+)
+
+var encryptedValueRegex = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|int|float|bool|bytes)\]$`)
+
+// encryptedMetadata is the metadata of an encrypted values file.
+type encryptedMetadata struct {
+	Age               []ageDataKey `yaml:"age,omitempty"`
+	PGP               []pgpDataKey `yaml:"pgp,omitempty"`
+	LastModified      string       `yaml:"lastmodified"`
+	MAC               string       `yaml:"mac"`
+	UnencryptedSuffix string       `yaml:"unencrypted_suffix,omitempty"`
+	Version           string       `yaml:"version"`
+}
+
+// pgpDataKey is the data key of an encrypted values file encrypted for an
+// OpenPGP key.
+type pgpDataKey struct {
+	CreatedAt string `yaml:"created_at"`
+	Enc       string `yaml:"enc"`
+	FP        string `yaml:"fp"`
+}
+
+// ageDataKey is the data key of an encrypted values file encrypted for an age
+// key.
+type ageDataKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// Recipients are the public keys encrypted values files are encrypted for.
+type Recipients struct {
+	// PGP are OpenPGP keys, as returned by LoadRecipients.
+	PGP openpgp.EntityList
+	// Age are age keys, as returned by ParseAgeRecipients.
+	Age []*age.X25519Recipient
+}
+
+// Identities are the private keys decrypting encrypted values files.
+type Identities struct {
+	// PGP is an OpenPGP keyring, as returned by LoadSecretsKeyring.
+	PGP openpgp.EntityList
+	// Age are age keys, as returned by LoadAgeIdentities.
+	Age []age.Identity
+}
+
+// decryptedValue is a value of an encrypted values file and its key path.
+type decryptedValue struct {
+	path  []string
+	value interface{}
+}
+
+// IsEncrypted returns true if data is an encrypted values file.
+func IsEncrypted(data []byte) bool {
+	tree, err := parseValuesTree(data)
+	if err != nil {
+		return false
+	}
+	_, ok := encryptedMetadataItem(tree)
+	return ok
+}
+
+// EncryptValues encrypts the values of a values file for the OpenPGP and age
+// keys of recipients. The values under keys ending with "_unencrypted" are
+// left in plaintext.
+func EncryptValues(data []byte, recipients Recipients) ([]byte, error) {
+	tree, err := parseValuesTree(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := encryptedMetadataItem(tree); ok {
+		return nil, errors.New("the values are already encrypted")
+	}
+	if len(recipients.PGP) == 0 && len(recipients.Age) == 0 {
+		return nil, errors.New("no recipient to encrypt the values for")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	meta := encryptedMetadata{UnencryptedSuffix: unencryptedSuffix}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, recipient := range recipients.PGP {
+		enc, err := encryptDataKey(key, recipient)
+		if err != nil {
+			return nil, err
+		}
+		meta.PGP = append(meta.PGP, pgpDataKey{CreatedAt: now, Enc: enc, FP: fingerprint(recipient)})
+	}
+	for _, recipient := range recipients.Age {
+		enc, err := encryptAgeDataKey(key, recipient)
+		if err != nil {
+			return nil, err
+		}
+		meta.Age = append(meta.Age, ageDataKey{Recipient: recipient.String(), Enc: enc})
+	}
+	return encryptTree(tree, key, meta)
+}
+
+// DecryptValues decrypts an encrypted values file with the private keys of
+// identities.
+func DecryptValues(data []byte, identities Identities) ([]byte, error) {
+	out, _, err := decryptValues(data, identities)
+	return out, err
+}
+
+// ReencryptValues encrypts the values of plaintext with the data key and the
+// recipients of the encrypted values file encrypted, as when editing it.
+func ReencryptValues(encrypted, plaintext []byte, identities Identities) ([]byte, error) {
+	tree, err := parseValuesTree(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	_, meta, err := splitEncryptedTree(tree)
+	if err != nil {
+		return nil, err
+	}
+	key, err := meta.dataKey(identities)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := parseValuesTree(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := encryptedMetadataItem(plain); ok {
+		return nil, errors.Errorf("the values must not have a top-level %q key", encryptedMetadataKey)
+	}
+	return encryptTree(plain, key, meta)
+}
+
+// LoadSecretsKeyring reads an OpenPGP keyring, binary or armored, holding
+// private keys. Keys protected by passphrase are decrypted with it.
+func LoadSecretsKeyring(path string, passphrase []byte) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read keyring %s", path)
+	}
+	if len(passphrase) > 0 {
+		for _, entity := range keyring {
+			keys := []*packet.PrivateKey{entity.PrivateKey}
+			for _, subkey := range entity.Subkeys {
+				keys = append(keys, subkey.PrivateKey)
+			}
+			for _, key := range keys {
+				if key != nil && key.Encrypted {
+					// A key the passphrase does not unlock is left protected.
+					_ = key.Decrypt(passphrase)
+				}
+			}
+		}
+	}
+	return keyring, nil
+}
+
+// LoadRecipients returns the OpenPGP keys of a keyring matching ids, which
+// are fingerprints, key IDs or parts of a key identity such as an email.
+func LoadRecipients(path string, ids []string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read keyring %s", path)
+	}
+
+	var recipients openpgp.EntityList
+	for _, id := range ids {
+		entity := findEntity(keyring, id)
+		if entity == nil {
+			return nil, errors.Errorf("no key matches %q in keyring %s", id, path)
+		}
+		recipients = append(recipients, entity)
+	}
+	return recipients, nil
+}
+
+// LoadAgeIdentities reads the age private keys of an identities file, as
+// written by age-keygen.
+func LoadAgeIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read age keys %s", path)
+	}
+	return identities, nil
+}
+
+// ParseAgeRecipients parses age public keys, as in "age1...".
+func ParseAgeRecipients(keys []string) ([]*age.X25519Recipient, error) {
+	var recipients []*age.X25519Recipient
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid age key %q", key)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+func findEntity(keyring openpgp.EntityList, id string) *openpgp.Entity {
+	fp := strings.ToUpper(strings.ReplaceAll(id, " ", ""))
+	for _, entity := range keyring {
+		if fp != "" && strings.HasSuffix(fingerprint(entity), fp) {
+			return entity
+		}
+	}
+	for _, entity := range keyring {
+		for name := range entity.Identities {
+			if strings.Contains(name, id) {
+				return entity
+			}
+		}
+	}
+	return nil
+}
+
+func fingerprint(entity *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))
+}
+
+func encryptDataKey(key []byte, recipient *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", err
+	}
+	w, err := openpgp.Encrypt(aw, []*openpgp.Entity{recipient}, nil, nil, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot encrypt for key %s", fingerprint(recipient))
+	}
+	if _, err := w.Write(key); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String() + "\n", nil
+}
+
+func encryptAgeDataKey(key []byte, recipient *age.X25519Recipient) (string, error) {
+	var buf bytes.Buffer
+	aw := agearmor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot encrypt for key %s", recipient)
+	}
+	if _, err := w.Write(key); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String() + "\n", nil
+}
+
+// dataKey decrypts the data key with one of the private keys of identities.
+func (m encryptedMetadata) dataKey(identities Identities) ([]byte, error) {
+	var keys []string
+	for _, k := range m.PGP {
+		keys = append(keys, k.FP)
+		if len(identities.PGP) == 0 {
+			continue
+		}
+		block, err := armor.Decode(strings.NewReader(k.Enc))
+		if err != nil {
+			continue
+		}
+		md, err := openpgp.ReadMessage(block.Body, identities.PGP, nil, nil)
+		if err != nil {
+			continue
+		}
+		key, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	for _, k := range m.Age {
+		keys = append(keys, k.Recipient)
+		if len(identities.Age) == 0 {
+			continue
+		}
+		r, err := age.Decrypt(agearmor.NewReader(strings.NewReader(k.Enc)), identities.Age...)
+		if err != nil {
+			continue
+		}
+		key, err := ioutil.ReadAll(r)
+		if err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	return nil, errors.Errorf("no private key decrypts the values, which are encrypted for %s (protected OpenPGP keys need a passphrase)", strings.Join(keys, ", "))
+}
+
+// decryptValues returns the decrypted values file and its decrypted values.
+func decryptValues(data []byte, identities Identities) ([]byte, []decryptedValue, error) {
+	tree, err := parseValuesTree(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, meta, err := splitEncryptedTree(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := meta.dataKey(identities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var decrypted []decryptedValue
+	mac := sha512.New()
+	plain, err := walkValues(values, nil, func(v interface{}, path []string) (interface{}, error) {
+		if v == nil {
+			return nil, nil
+		}
+		if isUnencrypted(path, meta.UnencryptedSuffix) {
+			b, _, err := valueBytes(v)
+			mac.Write(b)
+			return v, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("the value of %s is not encrypted", strings.Join(path, "."))
+		}
+		value, b, err := decryptValue(key, s, additionalData(path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decrypt the value of %s", strings.Join(path, "."))
+		}
+		mac.Write(b)
+		decrypted = append(decrypted, decryptedValue{path: path, value: value})
+		return value, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected, _, err := decryptValue(key, meta.MAC, meta.LastModified)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot decrypt the MAC")
+	}
+	if expected != fmt.Sprintf("%X", mac.Sum(nil)) {
+		return nil, nil, errors.New("MAC mismatch: the encrypted values were modified")
+	}
+	out, err := yaml.Marshal(plain)
+	return out, decrypted, err
+}
+
+func encryptTree(tree yaml.MapSlice, key []byte, meta encryptedMetadata) ([]byte, error) {
+	mac := sha512.New()
+	encrypted, err := walkValues(tree, nil, func(v interface{}, path []string) (interface{}, error) {
+		if v == nil {
+			return nil, nil
+		}
+		b, typ, err := valueBytes(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot encrypt the value of %s", strings.Join(path, "."))
+		}
+		mac.Write(b)
+		if isUnencrypted(path, meta.UnencryptedSuffix) {
+			return v, nil
+		}
+		return encryptValue(key, b, typ, additionalData(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	meta.LastModified = time.Now().UTC().Format(time.RFC3339)
+	meta.Version = encryptedVersion
+	if meta.MAC, err = encryptValue(key, []byte(fmt.Sprintf("%X", mac.Sum(nil))), "str", meta.LastModified); err != nil {
+		return nil, err
+	}
+	out := append(encrypted.(yaml.MapSlice), yaml.MapItem{Key: encryptedMetadataKey, Value: meta})
+	return yaml.Marshal(out)
+}
+
+func encryptValue(key, plaintext []byte, typ, additionalData string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nil, iv, plaintext, []byte(additionalData))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		typ), nil
+}
+
+// decryptValue returns an encrypted value and its plaintext.
+func decryptValue(key []byte, value, additionalData string) (interface{}, []byte, error) {
+	m := encryptedValueRegex.FindStringSubmatch(value)
+	if m == nil {
+		return nil, nil, errors.New("invalid encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid encrypted value")
+		}
+		parts[i] = b
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := gcm.Open(nil, parts[1], append(parts[0], parts[2]...), []byte(additionalData))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := string(plaintext)
+	switch m[4] {
+	case "int":
+		v, err := strconv.Atoi(s)
+		return v, plaintext, err
+	case "float":
+		v, err := strconv.ParseFloat(s, 64)
+		return v, plaintext, err
+	case "bool":
+		v, err := strconv.ParseBool(s)
+		return v, plaintext, err
+	default:
+		return s, plaintext, nil
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, 32)
+}
+
+// valueBytes returns the plaintext of a value and its type.
+func valueBytes(v interface{}) ([]byte, string, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), "str", nil
+	case int:
+		return []byte(strconv.Itoa(v)), "int", nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), "int", nil
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10)), "int", nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), "float", nil
+	case bool:
+		if v {
+			return []byte("True"), "bool", nil
+		}
+		return []byte("False"), "bool", nil
+	default:
+		return nil, "", errors.Errorf("unsupported value type %T", v)
+	}
+}
+
+// additionalData authenticates the key path of a value.
+func additionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+func isUnencrypted(path []string, suffix string) bool {
+	if suffix == "" {
+		return false
+	}
+	for _, key := range path {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkValues returns a copy of v with every leaf value replaced by fn. The
+// values of lists have the path of the list.
+func walkValues(v interface{}, path []string, fn func(v interface{}, path []string) (interface{}, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			value, err := walkValues(item.Value, append(path[:len(path):len(path)], fmt.Sprint(item.Key)), fn)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = yaml.MapItem{Key: item.Key, Value: value}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := walkValues(item, path, fn)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+	default:
+		return fn(v, path)
+	}
+}
+
+func parseValuesTree(data []byte) (yaml.MapSlice, error) {
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func encryptedMetadataItem(tree yaml.MapSlice) (yaml.MapItem, bool) {
+	for _, item := range tree {
+		if item.Key == encryptedMetadataKey {
+			if _, ok := item.Value.(yaml.MapSlice); ok {
+				return item, true
+			}
+		}
+	}
+	return yaml.MapItem{}, false
+}
+
+// splitEncryptedTree returns the values and the metadata of an encrypted
+// values file.
+func splitEncryptedTree(tree yaml.MapSlice) (yaml.MapSlice, encryptedMetadata, error) {
+	var meta encryptedMetadata
+	item, ok := encryptedMetadataItem(tree)
+	if !ok {
+		return nil, meta, errors.New("the values are not encrypted")
+	}
+	b, err := yaml.Marshal(item.Value)
+	if err != nil {
+		return nil, meta, err
+	}
+	if err := yaml.Unmarshal(b, &meta); err != nil {
+		return nil, meta, errors.Wrap(err, "invalid encryption metadata")
+	}
+	if len(meta.PGP) == 0 && len(meta.Age) == 0 {
+		return nil, meta, errors.New("the values are not encrypted for any OpenPGP or age key")
+	}
+
+	values := make(yaml.MapSlice, 0, len(tree)-1)
+	for _, item := range tree {
+		if item.Key != encryptedMetadataKey {
+			values = append(values, item)
+		}
+	}
+	return values, meta, nil
+}
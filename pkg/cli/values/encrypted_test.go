@@ -0,0 +1,276 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"sigs.k8s.io/yaml"
+)
+
+const plainValues = `image:
+  repository: nginx
+  tag: "1.21"
+replicas: 3
+debug: false
+ratio: 0.5
+password: s3cr3t
+hosts:
+- a.example.com
+- b.example.com
+config_unencrypted:
+  level: info
+`
+
+// writeKeyrings writes the private and public keyrings of a new OpenPGP key.
+func writeKeyrings(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secring, pubring bytes.Buffer
+	if err := entity.SerializePrivate(&secring, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(&pubring); err != nil {
+		t.Fatal(err)
+	}
+	secPath := filepath.Join(dir, "secring.gpg")
+	pubPath := filepath.Join(dir, "pubring.gpg")
+	if err := ioutil.WriteFile(secPath, secring.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubPath, pubring.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return secPath, pubPath
+}
+
+func encryptForTest(t *testing.T) ([]byte, Identities, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "helm-encrypted-values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	secPath, pubPath := writeKeyrings(t, dir)
+	recipients, err := LoadRecipients(pubPath, []string{"test@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptValues([]byte(plainValues), Recipients{PGP: recipients})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := LoadSecretsKeyring(secPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted, Identities{PGP: keyring}, secPath
+}
+
+func TestEncryptValues(t *testing.T) {
+	encrypted, keyring, _ := encryptForTest(t)
+
+	if !IsEncrypted(encrypted) {
+		t.Fatal("expected the values to be encrypted")
+	}
+	if IsEncrypted([]byte(plainValues)) {
+		t.Fatal("expected the plain values not to be encrypted")
+	}
+	for _, s := range []string{"nginx", "s3cr3t", "a.example.com"} {
+		if strings.Contains(string(encrypted), s) {
+			t.Errorf("expected %q to be encrypted:\n%s", s, encrypted)
+		}
+	}
+	if !strings.Contains(string(encrypted), "level: info") {
+		t.Errorf("expected the unencrypted values to be left in plaintext:\n%s", encrypted)
+	}
+	if _, err := EncryptValues(encrypted, Recipients{PGP: keyring.PGP}); err == nil {
+		t.Error("expected an error encrypting encrypted values")
+	}
+
+	decrypted, err := DecryptValues(encrypted, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	if err := yaml.Unmarshal(decrypted, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(plainValues), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestEncryptValuesAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := ParseAgeRecipients([]string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptValues([]byte(plainValues), Recipients{Age: recipients})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "recipient: "+identity.Recipient().String()) {
+		t.Errorf("expected the data key to be encrypted for the age key:\n%s", encrypted)
+	}
+
+	keysPath := filepath.Join(t.TempDir(), "keys.txt")
+	if err := ioutil.WriteFile(keysPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the missing OpenPGP keyring is skipped when age keys are given
+	opts := &Options{SecretsKeyring: filepath.Join(t.TempDir(), "secring.gpg"), SecretsAgeKeys: keysPath}
+	identities, err := opts.SecretsIdentities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := DecryptValues(encrypted, identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decrypted), "password: s3cr3t") {
+		t.Errorf("expected the decrypted values, got:\n%s", decrypted)
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptValues(encrypted, Identities{Age: []age.Identity{other}}); err == nil || !strings.Contains(err.Error(), identity.Recipient().String()) {
+		t.Errorf("expected an error naming the age key, got %v", err)
+	}
+	if _, err := ParseAgeRecipients([]string{"age1invalid"}); err == nil {
+		t.Error("expected an error parsing an invalid age key")
+	}
+}
+
+func TestDecryptValuesTampered(t *testing.T) {
+	encrypted, keyring, _ := encryptForTest(t)
+
+	tampered := strings.Replace(string(encrypted), "level: info", "level: debug", 1)
+	if _, err := DecryptValues([]byte(tampered), keyring); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Errorf("expected a MAC mismatch, got %v", err)
+	}
+
+	// Moving an encrypted value to another key fails authentication.
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(encrypted, &tree); err != nil {
+		t.Fatal(err)
+	}
+	tree["replicas"] = tree["password"]
+	moved, err := yaml.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptValues(moved, keyring); err == nil || !strings.Contains(err.Error(), "replicas") {
+		t.Errorf("expected an error decrypting replicas, got %v", err)
+	}
+
+	if _, err := DecryptValues(encrypted, Identities{}); err == nil {
+		t.Error("expected an error decrypting without a private key")
+	}
+}
+
+func TestReencryptValues(t *testing.T) {
+	encrypted, keyring, _ := encryptForTest(t)
+
+	edited := strings.Replace(plainValues, "s3cr3t", "changed", 1)
+	reencrypted, err := ReencryptValues(encrypted, []byte(edited), keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := DecryptValues(reencrypted, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decrypted), "password: changed") {
+		t.Errorf("expected the edited password, got:\n%s", decrypted)
+	}
+}
+
+func TestMergeValuesEncrypted(t *testing.T) {
+	encrypted, _, secPath := encryptForTest(t)
+	path := filepath.Join(filepath.Dir(secPath), "secrets.yaml")
+	if err := ioutil.WriteFile(path, encrypted, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{ValueFiles: []string{path}, Values: []string{"replicas=1"}}
+	if _, err := opts.MergeValues(nil); err == nil {
+		t.Error("expected an error without a keyring")
+	}
+
+	opts.SecretsKeyring = secPath
+	vals, err := opts.MergeValues(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["password"] != "s3cr3t" || vals["replicas"] != int64(1) {
+		t.Errorf("unexpected values %v", vals)
+	}
+	if _, ok := vals[encryptedMetadataKey]; ok {
+		t.Error("expected the encryption metadata to be removed")
+	}
+
+	vals["user"] = "s3cr3t"
+	redacted, err := opts.RedactValues(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted["password"] != "<encrypted:password>" || redacted["image"].(map[string]interface{})["tag"] != "<encrypted:image.tag>" {
+		t.Errorf("expected the decrypted strings to be redacted, got %v", redacted)
+	}
+	if hosts := redacted["hosts"].([]interface{}); hosts[0] != "<encrypted:hosts>" || hosts[1] != "<encrypted:hosts>" {
+		t.Errorf("expected the decrypted hosts to be redacted, got %v", hosts)
+	}
+	if redacted["debug"] != "<encrypted:debug>" || redacted["ratio"] != "<encrypted:ratio>" {
+		t.Errorf("expected the decrypted booleans and numbers to be redacted, got %v", redacted)
+	}
+	// only the decrypted values are redacted, not the ones overriding them
+	if redacted["user"] != "s3cr3t" || redacted["replicas"] != int64(1) {
+		t.Errorf("expected the other values to be kept, got %v", redacted)
+	}
+	if vals["password"] != "s3cr3t" {
+		t.Errorf("expected the values to be left unchanged, got %v", vals)
+	}
+
+	if redacted, err := new(Options).RedactValues(map[string]interface{}{"password": "s3cr3t"}); redacted != nil || err != nil {
+		t.Errorf("expected no redaction without encrypted values, got %v, %v", redacted, err)
+	}
+	if _, err := opts.RedactValues(map[string]interface{}{"token": "<encrypted:token>"}); err == nil || !strings.Contains(err.Error(), "the value of token is the placeholder <encrypted:token>") {
+		t.Errorf("expected an error reusing a placeholder, got %v", err)
+	}
+}
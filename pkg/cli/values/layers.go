@@ -0,0 +1,346 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chart/loader"
+)
+
+// EnvironmentsFileName is the name of the file declaring the values layers of
+// the environments, in the working directory or in the chart.
+const EnvironmentsFileName = "environments.yaml"
+
+// Merge strategies of the lists and the maps of a values layer.
+const (
+	// ListsReplace replaces the lists of the previous layers. It is the
+	// default, as for -f/--values.
+	ListsReplace = "replace"
+	// ListsAppend appends the items of the lists to the lists of the previous
+	// layers.
+	ListsAppend = "append"
+	// ListsMerge merges the maps of the lists with the maps of the lists of
+	// the previous layers having the same "name" key, and appends the other
+	// items.
+	ListsMerge = "merge"
+
+	// MapsMerge merges the maps with the maps of the previous layers. It is
+	// the default, as for -f/--values.
+	MapsMerge = "merge"
+	// MapsReplace replaces the top-level values of the previous layers as a
+	// whole.
+	MapsReplace = "replace"
+)
+
+// Environments declares the values layers of the environments. The files of
+// the layers may refer to the variables of the selected environment with
+// ${name}; the variable "environment" is the name of the environment. A layer
+// referring to an unset variable is skipped.
+//
+//	layers:
+//	- name: base
+//	  files: [values-base.yaml]
+//	- name: env
+//	  files: ["values-${environment}.yaml"]
+//	- name: region
+//	  files: ["values-${environment}-${region}.yaml"]
+//	  optional: true
+//	  lists: append
+//	- name: cluster
+//	  files: ["clusters/${cluster}.yaml"]
+//	  optional: true
+//	environments:
+//	  prod:
+//	    region: eu-west-1
+//	    cluster: prod-eu-1
+type Environments struct {
+	Layers       []LayerConfig                `json:"layers"`
+	Environments map[string]map[string]string `json:"environments,omitempty"`
+}
+
+// LayerConfig declares a values layer.
+type LayerConfig struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+	// Optional layers may have missing files.
+	Optional bool `json:"optional,omitempty"`
+	// Lists is the merge strategy of the lists: replace, append or merge.
+	Lists string `json:"lists,omitempty"`
+	// Maps is the merge strategy of the maps: merge or replace.
+	Maps string `json:"maps,omitempty"`
+}
+
+// Layer is a values layer resolved for an environment.
+type Layer struct {
+	Name  string
+	Files []string
+	Lists string
+	Maps  string
+	// data are the contents of Files
+	data [][]byte
+}
+
+// Layers returns the values layers resolved by the last MergeValues, in the
+// order they were merged.
+func (opts *Options) Layers() []Layer {
+	return opts.layers
+}
+
+// LoadEnvironments reads an environments file.
+func LoadEnvironments(filename string) (*Environments, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvironments(data, filename)
+}
+
+func parseEnvironments(data []byte, filename string) (*Environments, error) {
+	envs := &Environments{}
+	if err := yaml.UnmarshalStrict(data, envs); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", filename)
+	}
+	for i, l := range envs.Layers {
+		if l.Name == "" {
+			return nil, errors.Errorf("%s: layer %d has no name", filename, i)
+		}
+		switch l.Lists {
+		case "", ListsReplace, ListsAppend, ListsMerge:
+		default:
+			return nil, errors.Errorf("%s: layer %s: unknown lists strategy %q", filename, l.Name, l.Lists)
+		}
+		switch l.Maps {
+		case "", MapsMerge, MapsReplace:
+		default:
+			return nil, errors.Errorf("%s: layer %s: unknown maps strategy %q", filename, l.Name, l.Maps)
+		}
+	}
+	return envs, nil
+}
+
+// layerSource is the directory or the chart archive holding an environments
+// file and the files of its layers.
+type layerSource struct {
+	// dir is the directory, or the path of the chart archive.
+	dir string
+	// archive are the files of the chart archive by name, or nil.
+	archive map[string][]byte
+}
+
+// chartLayerSource returns the layer source of a chart directory or archive.
+func chartLayerSource(chartPath string) (*layerSource, error) {
+	fi, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &layerSource{dir: chartPath}, nil
+	}
+	f, err := os.Open(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := loader.LoadArchiveFiles(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read chart archive %s", chartPath)
+	}
+	src := &layerSource{dir: chartPath, archive: map[string][]byte{}}
+	for _, file := range files {
+		src.archive[file.Name] = file.Data
+	}
+	return src, nil
+}
+
+// path returns the path of the file name of the source, for reporting it.
+func (s *layerSource) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// read reads the file name of the source.
+func (s *layerSource) read(name string) ([]byte, error) {
+	if s.archive == nil {
+		return ioutil.ReadFile(s.path(name))
+	}
+	data, ok := s.archive[path.Clean(filepath.ToSlash(name))]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: s.path(name), Err: os.ErrNotExist}
+	}
+	return data, nil
+}
+
+// findEnvironments returns the source of the environments file of the
+// working directory, or else of the chart, which may be archived.
+func (opts *Options) findEnvironments() (*layerSource, []byte, error) {
+	src := &layerSource{dir: "."}
+	data, err := src.read(EnvironmentsFileName)
+	if err == nil {
+		return src, data, nil
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if opts.ChartPath != "" {
+		if src, err = chartLayerSource(opts.ChartPath); err != nil {
+			return nil, nil, err
+		}
+		data, err = src.read(EnvironmentsFileName)
+		if err == nil {
+			return src, data, nil
+		} else if !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, errors.Errorf("environment %q: no %s in the working directory or in the chart", opts.Environment, EnvironmentsFileName)
+}
+
+// resolveLayers returns the values layers of the selected environment.
+func (opts *Options) resolveLayers() ([]Layer, error) {
+	src, data, err := opts.findEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	filename := src.path(EnvironmentsFileName)
+	envs, err := parseEnvironments(data, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	declared, ok := envs.Environments[opts.Environment]
+	if !ok && len(envs.Environments) > 0 {
+		return nil, errors.Errorf("environment %q is not declared in %s", opts.Environment, filename)
+	}
+	for k, v := range declared {
+		vars[k] = v
+	}
+	for _, kv := range opts.EnvironmentVars {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid environment variable %q, expected NAME=VALUE", kv)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	vars["environment"] = opts.Environment
+
+	var layers []Layer
+	for _, l := range envs.Layers {
+		layer := Layer{Name: l.Name, Lists: l.Lists, Maps: l.Maps}
+		if layer.Lists == "" {
+			layer.Lists = ListsReplace
+		}
+		if layer.Maps == "" {
+			layer.Maps = MapsMerge
+		}
+
+		skip := false
+		for _, f := range l.Files {
+			expanded := os.Expand(f, func(name string) string {
+				v, ok := vars[name]
+				if !ok {
+					skip = true
+				}
+				return v
+			})
+			if skip {
+				break
+			}
+			data, err := src.read(expanded)
+			if err != nil {
+				if l.Optional && os.IsNotExist(err) {
+					continue
+				}
+				return nil, errors.Wrapf(err, "layer %s", l.Name)
+			}
+			layer.Files = append(layer.Files, src.path(expanded))
+			layer.data = append(layer.data, data)
+		}
+		if !skip && len(layer.Files) > 0 {
+			layers = append(layers, layer)
+		}
+	}
+	return layers, nil
+}
+
+// mergeLayer merges the values of a layer into base with the strategies of
+// the layer.
+func mergeLayer(base, values map[string]interface{}, layer Layer) map[string]interface{} {
+	out := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range values {
+		if layer.Maps == MapsReplace {
+			out[k] = v
+			continue
+		}
+		out[k] = mergeLayerValue(out[k], v, layer)
+	}
+	return out
+}
+
+func mergeLayerValue(base, value interface{}, layer Layer) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if b, ok := base.(map[string]interface{}); ok {
+			return mergeLayer(b, value, Layer{Lists: layer.Lists, Maps: MapsMerge})
+		}
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok {
+			break
+		}
+		switch layer.Lists {
+		case ListsAppend:
+			return append(b[:len(b):len(b)], value...)
+		case ListsMerge:
+			return mergeNamedItems(b, value, layer)
+		}
+	}
+	return value
+}
+
+// mergeNamedItems merges the maps of items with the maps of base having the
+// same "name" key, and appends the other items.
+func mergeNamedItems(base, items []interface{}, layer Layer) []interface{} {
+	out := append([]interface{}{}, base...)
+	for _, item := range items {
+		merged := false
+		if m, ok := item.(map[string]interface{}); ok {
+			if name, ok := m["name"]; ok {
+				for i, b := range out {
+					if bm, ok := b.(map[string]interface{}); ok && bm["name"] == name {
+						out[i] = mergeLayer(bm, m, Layer{Lists: layer.Lists, Maps: MapsMerge})
+						merged = true
+						break
+					}
+				}
+			}
+		}
+		if !merged {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/getter"
+)
+
+func writeLayerFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMergeValuesEnvironment(t *testing.T) {
+	dir := writeLayerFiles(t, map[string]string{
+		EnvironmentsFileName: `
+layers:
+- name: base
+  files: [values-base.yaml]
+- name: env
+  files: ["values-${environment}.yaml"]
+- name: region
+  files: ["values-${region}.yaml"]
+  optional: true
+  lists: append
+- name: cluster
+  files: ["values-${cluster}.yaml"]
+  lists: merge
+environments:
+  prod:
+    region: eu
+  dev: {}
+`,
+		"values-base.yaml": `
+replicas: 1
+hosts: [a]
+containers:
+- name: app
+  image: app:1
+resources:
+  cpu: 100m
+  memory: 128Mi
+`,
+		"values-prod.yaml": `
+replicas: 3
+hosts: [b]
+`,
+		"values-eu.yaml": `
+hosts: [c]
+`,
+		"values-eu-1.yaml": `
+containers:
+- name: app
+  image: app:2
+- name: sidecar
+`,
+	})
+
+	opts := &Options{
+		Environment:     "prod",
+		EnvironmentVars: []string{"cluster=eu-1"},
+		ChartPath:       dir,
+		StringValues:    []string{"replicas=5"},
+	}
+	vals, err := opts.MergeValues(getter.Providers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(`
+replicas: "5"
+hosts: [b, c]
+containers:
+- name: app
+  image: app:2
+- name: sidecar
+resources:
+  cpu: 100m
+  memory: 128Mi
+`), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vals, expected) {
+		t.Errorf("Expected %v, got %v", expected, vals)
+	}
+
+	layers := opts.Layers()
+	var names []string
+	for _, l := range layers {
+		names = append(names, l.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"base", "env", "region", "cluster"}) {
+		t.Errorf("unexpected layers %v", names)
+	}
+	if layers[1].Files[0] != filepath.Join(dir, "values-prod.yaml") || layers[2].Lists != ListsAppend {
+		t.Errorf("unexpected layer %+v %+v", layers[1], layers[2])
+	}
+
+	// dev has no region or cluster: their layers are skipped, and its own
+	// file is missing.
+	opts = &Options{Environment: "dev", ChartPath: dir}
+	if _, err := opts.MergeValues(getter.Providers{}); err == nil {
+		t.Error("expected an error for the missing values-dev.yaml")
+	}
+
+	opts = &Options{Environment: "staging", ChartPath: dir}
+	if _, err := opts.MergeValues(getter.Providers{}); err == nil {
+		t.Error("expected an error for an undeclared environment")
+	}
+}
+
+func TestMergeValuesEnvironmentChartArchive(t *testing.T) {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "moby", Version: "1.2.3"},
+		Files: []*chart.File{
+			{Name: EnvironmentsFileName, Data: []byte("layers:\n- name: env\n  files: [\"envs/${environment}.yaml\"]\n")},
+			{Name: "envs/prod.yaml", Data: []byte("replicas: 3\n")},
+		},
+	}
+	archive, err := chartutil.Save(ch, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Environment: "prod", ChartPath: archive}
+	vals, err := opts.MergeValues(getter.Providers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["replicas"] != float64(3) {
+		t.Errorf("Expected the values of the archived layer, got %v", vals)
+	}
+	layers := opts.Layers()
+	if len(layers) != 1 || layers[0].Files[0] != filepath.Join(archive, "envs", "prod.yaml") {
+		t.Errorf("unexpected layers %+v", layers)
+	}
+
+	opts = &Options{Environment: "dev", ChartPath: archive}
+	if _, err := opts.MergeValues(getter.Providers{}); err == nil {
+		t.Error("expected an error for the missing envs/dev.yaml")
+	}
+}
+
+func TestMergeLayerMapsReplace(t *testing.T) {
+	base := map[string]interface{}{
+		"resources": map[string]interface{}{"cpu": "100m", "memory": "128Mi"},
+		"replicas":  1,
+	}
+	values := map[string]interface{}{
+		"resources": map[string]interface{}{"cpu": "1"},
+	}
+	out := mergeLayer(base, values, Layer{Lists: ListsReplace, Maps: MapsReplace})
+	expected := map[string]interface{}{
+		"resources": map[string]interface{}{"cpu": "1"},
+		"replicas":  1,
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestLoadEnvironmentsInvalidStrategy(t *testing.T) {
+	dir := writeLayerFiles(t, map[string]string{
+		EnvironmentsFileName: "layers:\n- name: base\n  files: [values.yaml]\n  lists: concat\n",
+	})
+	if _, err := LoadEnvironments(filepath.Join(dir, EnvironmentsFileName)); err == nil {
+		t.Error("expected an error for an unknown lists strategy")
+	}
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package values
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -34,24 +36,117 @@ type Options struct {
 	StringValues []string
 	Values       []string
 	FileValues   []string
+
+	// SecretsKeyring is the OpenPGP keyring holding the private keys that
+	// decrypt encrypted values files.
+	SecretsKeyring string
+	// SecretsPassphrase unlocks the protected keys of SecretsKeyring.
+	SecretsPassphrase []byte
+	// SecretsAgeKeys is the age identities file holding the private keys
+	// that decrypt encrypted values files.
+	SecretsAgeKeys string
+
+	// Environment selects the values layers declared for it in the
+	// environments file of the working directory or of ChartPath. The layers
+	// are merged before the -f/--values files.
+	Environment string
+	// EnvironmentVars are NAME=VALUE variables of the environment, overriding
+	// the declared ones.
+	EnvironmentVars []string
+	// ChartPath is the path of the chart directory or archive.
+	ChartPath string
+
+	identities *Identities
+	decrypted  map[string][]string
+	layers     []Layer
+}
+
+// encryptedRegex matches the placeholders of RedactValues.
+var encryptedRegex = regexp.MustCompile(`^<encrypted(-base64)?:[^<>\s]+>$`)
+
+// RedactValues returns a copy of vals with the values decrypted by
+// MergeValues replaced by placeholders naming their keys, as in
+// <encrypted:db.password>, or nil if vals hold none. Strings, numbers and
+// booleans are redacted alike. It fails if vals hold placeholders, as when
+// reusing the values of a release, since they would be used in place of the
+// decrypted values.
+func (opts *Options) RedactValues(vals map[string]interface{}) (map[string]interface{}, error) {
+	redacted := false
+	out, err := opts.redactValue(vals, nil, &redacted)
+	if err != nil || !redacted {
+		return nil, err
+	}
+	return out.(map[string]interface{}), nil
 }
 
-// MergeValues merges values from files specified via -f/--values and directly
-// via --set, --set-string, or --set-file, marshaling them to YAML
+func (opts *Options) redactValue(v interface{}, path []string, redacted *bool) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			r, err := opts.redactValue(value, append(path[:len(path):len(path)], k), redacted)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			r, err := opts.redactValue(value, path, redacted)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case nil:
+		return nil, nil
+	}
+	key := strings.Join(path, ".")
+	for _, s := range opts.decrypted[key] {
+		if s == fmt.Sprint(v) {
+			*redacted = true
+			return "<encrypted:" + key + ">", nil
+		}
+	}
+	if s, ok := v.(string); ok && encryptedRegex.MatchString(s) {
+		return nil, errors.Errorf("the value of %s is the placeholder %s of an encrypted value: pass its encrypted values file", key, s)
+	}
+	return v, nil
+}
+
+// MergeValues merges values from the layers of the environment, from files
+// specified via -f/--values and directly via --set, --set-string, or
+// --set-file, marshaling them to YAML
 func (opts *Options) MergeValues(p getter.Providers) (map[string]interface{}, error) {
 	base := map[string]interface{}{}
 
-	// User specified a values files via -f/--values
-	for _, filePath := range opts.ValueFiles {
-		currentMap := map[string]interface{}{}
-
-		bytes, err := readFile(filePath, p)
+	// User specified an environment via --environment
+	opts.layers = nil
+	if opts.Environment != "" {
+		layers, err := opts.resolveLayers()
 		if err != nil {
 			return nil, err
 		}
+		for _, layer := range layers {
+			for i, filePath := range layer.Files {
+				currentMap, err := opts.parseValuesFile(filePath, layer.data[i])
+				if err != nil {
+					return nil, err
+				}
+				base = mergeLayer(base, currentMap, layer)
+			}
+		}
+		opts.layers = layers
+	}
 
-		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
-			return nil, errors.Wrapf(err, "failed to parse %s", filePath)
+	// User specified a values files via -f/--values
+	for _, filePath := range opts.ValueFiles {
+		currentMap, err := opts.readValuesFile(filePath, p)
+		if err != nil {
+			return nil, err
 		}
 		// Merge with the previous map
 		base = mergeMaps(base, currentMap)
@@ -85,6 +180,82 @@ func (opts *Options) MergeValues(p getter.Providers) (map[string]interface{}, er
 	return base, nil
 }
 
+// readValuesFile reads and parses a values file, decrypting it if encrypted.
+func (opts *Options) readValuesFile(filePath string, p getter.Providers) (map[string]interface{}, error) {
+	bytes, err := readFile(filePath, p)
+	if err != nil {
+		return nil, err
+	}
+	return opts.parseValuesFile(filePath, bytes)
+}
+
+// parseValuesFile parses the values file filePath, decrypting it if
+// encrypted.
+func (opts *Options) parseValuesFile(filePath string, bytes []byte) (map[string]interface{}, error) {
+	currentMap := map[string]interface{}{}
+	if IsEncrypted(bytes) {
+		decrypted, err := opts.decrypt(bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt %s", filePath)
+		}
+		bytes = decrypted
+	}
+
+	if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", filePath)
+	}
+	return currentMap, nil
+}
+
+// decrypt decrypts an encrypted values file in memory and records its values
+// for RedactValues.
+func (opts *Options) decrypt(data []byte) ([]byte, error) {
+	if opts.identities == nil {
+		identities, err := opts.SecretsIdentities()
+		if err != nil {
+			return nil, err
+		}
+		opts.identities = &identities
+	}
+	out, decrypted, err := decryptValues(data, *opts.identities)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.decrypted == nil {
+		opts.decrypted = map[string][]string{}
+	}
+	for _, d := range decrypted {
+		key := strings.Join(d.path, ".")
+		opts.decrypted[key] = append(opts.decrypted[key], fmt.Sprint(d.value))
+	}
+	return out, nil
+}
+
+// SecretsIdentities loads the private keys of SecretsKeyring and
+// SecretsAgeKeys. A missing keyring is skipped when age keys are given.
+func (opts *Options) SecretsIdentities() (Identities, error) {
+	var identities Identities
+	if opts.SecretsKeyring == "" && opts.SecretsAgeKeys == "" {
+		return identities, errors.New("no keyring or age keys to decrypt the values with")
+	}
+	if opts.SecretsAgeKeys != "" {
+		keys, err := LoadAgeIdentities(opts.SecretsAgeKeys)
+		if err != nil {
+			return identities, err
+		}
+		identities.Age = keys
+	}
+	if opts.SecretsKeyring != "" {
+		keyring, err := LoadSecretsKeyring(opts.SecretsKeyring, opts.SecretsPassphrase)
+		if err != nil && !(os.IsNotExist(errors.Cause(err)) && len(identities.Age) > 0) {
+			return identities, err
+		}
+		identities.PGP = keyring
+	}
+	return identities, nil
+}
+
 func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 	out := make(map[string]interface{}, len(a))
 	for k, v := range a {
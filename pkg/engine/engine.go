@@ -42,6 +42,69 @@ type Engine struct {
 	LintMode bool
 	// the rest config to connect to the kubernetes api
 	config *rest.Config
+	// LookupSource, if set, provides the objects returned by the lookup
+	// function instead of the cluster, including in LintMode.
+	LookupSource LookupSource
+	// SourceMap, if not nil, is filled by Render with the origin of every
+	// rendered line, and render errors show the template lines around
+	// them. Tracing makes rendering slower.
+	SourceMap SourceMap
+	// Parallelism is the number of charts rendered at the same time. The
+	// templates of a chart are always rendered in order, and the output
+	// does not depend on it. Charts are rendered one by one if it is below
+	// 2 or when tracing.
+	Parallelism int
+	// SecretProviders resolve the secrets of the secretRef template
+	// function by provider name.
+	SecretProviders map[string]SecretProvider
+	// Sensitive, if not nil, is filled by Render with the secrets resolved
+	// by secretRef, by the placeholders redacting them.
+	Sensitive map[string]string
+	// funcs are the template functions added with RegisterFuncs
+	funcs template.FuncMap
+	// tracer maps the output to the templates when SourceMap is set
+	tracer *tracer
+	// sensitive records the secrets resolved during a render
+	sensitive *sensitiveValues
+	// tplCache holds the templates parsed by the tpl function during a render
+	tplCache *tplCache
+	// noTplCache parses the templates again on every tpl call, as before
+	// they were cached, for comparing the two in benchmarks
+	noTplCache bool
+}
+
+// contextFuncs are the built-in template functions added to funcMap() when
+// rendering.
+var contextFuncs = []string{"include", "tpl", "required", "fail", "lookup", "secretRef"}
+
+// RegisterFuncs adds template functions to the engine. A function may not
+// replace a built-in function or a function registered before.
+func (e *Engine) RegisterFuncs(funcs template.FuncMap) error {
+	builtins := funcMap()
+	for _, name := range contextFuncs {
+		builtins[name] = nil
+	}
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := builtins[name]; ok {
+			return errors.Errorf("template function %q collides with a built-in function", name)
+		}
+		if _, ok := e.funcs[name]; ok {
+			return errors.Errorf("template function %q is already registered", name)
+		}
+	}
+
+	if e.funcs == nil {
+		e.funcs = template.FuncMap{}
+	}
+	for name, fn := range funcs {
+		e.funcs[name] = fn
+	}
+	return nil
 }
 
 // Render takes a chart, optional values, and value overrides, and attempts to render the Go templates.
@@ -65,7 +128,19 @@ type Engine struct {
 // bar chart during render time.
 func (e Engine) Render(chrt *chart.Chart, values chartutil.Values) (map[string]string, error) {
 	tmap := allTemplates(chrt, values)
-	return e.render(tmap)
+	if e.Sensitive != nil {
+		e.sensitive = &sensitiveValues{values: e.Sensitive}
+	}
+	if e.SourceMap == nil {
+		return e.render(tmap)
+	}
+
+	e.tracer = newTracer()
+	rendered, err := e.render(tmap)
+	for name, lines := range e.tracer.lines {
+		e.SourceMap[name] = lines
+	}
+	return rendered, err
 }
 
 // Render takes a chart, optional values, and value overrides, and attempts to
@@ -78,9 +153,14 @@ func Render(chrt *chart.Chart, values chartutil.Values) (map[string]string, erro
 // render the Go templates using the default options. This engine is client aware and so can have template
 // functions that interact with the client
 func RenderWithClient(chrt *chart.Chart, values chartutil.Values, config *rest.Config) (map[string]string, error) {
-	return Engine{
-		config: config,
-	}.Render(chrt, values)
+	return Engine{}.RenderWithClient(chrt, values, config)
+}
+
+// RenderWithClient renders like Render, with the template functions that
+// interact with the cluster of config.
+func (e Engine) RenderWithClient(chrt *chart.Chart, values chartutil.Values, config *rest.Config) (map[string]string, error) {
+	e.config = config
+	return e.Render(chrt, values)
 }
 
 // renderable is an object that can be rendered.
@@ -121,6 +201,9 @@ func (e Engine) initFunMap(t *template.Template, referenceTpls map[string]render
 		}
 		err := t.ExecuteTemplate(&buf, name, data)
 		includedNames[name]--
+		if e.tracer != nil {
+			return e.tracer.include(buf.String()), err
+		}
 		return buf.String(), err
 	}
 
@@ -136,19 +219,14 @@ func (e Engine) initFunMap(t *template.Template, referenceTpls map[string]render
 			return "", errors.Wrapf(err, "cannot retrieve Template.Name from values inside tpl function: %s", tpl)
 		}
 
-		templates := map[string]renderable{
-			templateName.(string): {
-				tpl:      tpl,
-				vals:     vals,
-				basePath: basePath.(string),
-			},
-		}
-
-		result, err := e.renderWithReferences(templates, referenceTpls)
+		// the output of tpl is traced to its call
+		untraced := e
+		untraced.tracer = nil
+		result, err := untraced.renderTpl(tpl, templateName.(string), basePath.(string), vals, referenceTpls)
 		if err != nil {
 			return "", errors.Wrapf(err, "error during tpl function execution for %q", tpl)
 		}
-		return result[templateName.(string)], nil
+		return result, nil
 	}
 
 	// Add the `required` function here so we can use lintMode
@@ -183,15 +261,37 @@ func (e Engine) initFunMap(t *template.Template, referenceTpls map[string]render
 		return "", errors.New(warnWrap(msg))
 	}
 
+	funcMap["secretRef"] = e.secretRef
+
 	// If we are not linting and have a cluster connection, provide a Kubernetes-backed
 	// implementation.
-	if !e.LintMode && e.config != nil {
+	if e.LookupSource != nil {
+		funcMap["lookup"] = e.LookupSource.Lookup
+	} else if !e.LintMode && e.config != nil {
 		funcMap["lookup"] = NewLookupFunction(e.config)
 	}
 
+	// RegisterFuncs guarantees that these do not replace a built-in function.
+	for name, fn := range e.funcs {
+		funcMap[name] = fn
+	}
+
 	t.Funcs(funcMap)
 }
 
+// newTemplate returns the empty parent template the templates are parsed in.
+func (e Engine) newTemplate() *template.Template {
+	t := template.New("gotpl")
+	if e.Strict {
+		t.Option("missingkey=error")
+	} else {
+		// Not that zero will attempt to add default values for types it knows,
+		// but will still emit <no value> for others. We mitigate that later.
+		t.Option("missingkey=zero")
+	}
+	return t
+}
+
 // render takes a map of templates/values and renders them.
 func (e Engine) render(tpls map[string]renderable) (map[string]string, error) {
 	return e.renderWithReferences(tpls, tpls)
@@ -212,15 +312,10 @@ func (e Engine) renderWithReferences(tpls, referenceTpls map[string]renderable)
 			err = errors.Errorf("rendering template failed: %v", r)
 		}
 	}()
-	t := template.New("gotpl")
-	if e.Strict {
-		t.Option("missingkey=error")
-	} else {
-		// Not that zero will attempt to add default values for types it knows,
-		// but will still emit <no value> for others. We mitigate that later.
-		t.Option("missingkey=zero")
+	t := e.newTemplate()
+	if e.tplCache == nil {
+		e.tplCache = newTplCache()
 	}
-
 	e.initFunMap(t, referenceTpls)
 
 	// We want to parse the templates in a predictable order. The order favors
@@ -231,7 +326,7 @@ func (e Engine) renderWithReferences(tpls, referenceTpls map[string]renderable)
 	for _, filename := range keys {
 		r := tpls[filename]
 		if _, err := t.New(filename).Parse(r.tpl); err != nil {
-			return map[string]string{}, cleanupParseError(filename, err)
+			return map[string]string{}, e.withSourceContext(cleanupParseError(filename, err), tpls)
 		}
 	}
 
@@ -241,11 +336,19 @@ func (e Engine) renderWithReferences(tpls, referenceTpls map[string]renderable)
 		if t.Lookup(filename) == nil {
 			r := referenceTpls[filename]
 			if _, err := t.New(filename).Parse(r.tpl); err != nil {
-				return map[string]string{}, cleanupParseError(filename, err)
+				return map[string]string{}, e.withSourceContext(cleanupParseError(filename, err), referenceTpls)
 			}
 		}
 	}
 
+	if e.tracer != nil {
+		e.tracer.instrument(t)
+	}
+
+	if e.Parallelism > 1 && e.tracer == nil {
+		return e.renderParallel(t, keys, tpls, referenceTpls)
+	}
+
 	rendered = make(map[string]string, len(keys))
 	for _, filename := range keys {
 		// Don't render partials. We don't care out the direct output of partials.
@@ -253,23 +356,45 @@ func (e Engine) renderWithReferences(tpls, referenceTpls map[string]renderable)
 		if strings.HasPrefix(path.Base(filename), "_") {
 			continue
 		}
-		// At render time, add information about the template that is being rendered.
-		vals := tpls[filename].vals
-		vals["Template"] = chartutil.Values{"Name": filename, "BasePath": tpls[filename].basePath}
-		var buf strings.Builder
-		if err := t.ExecuteTemplate(&buf, filename, vals); err != nil {
-			return map[string]string{}, cleanupExecError(filename, err)
+		out, err := e.execute(t, filename, tpls[filename], referenceTpls)
+		if err != nil {
+			return map[string]string{}, err
 		}
-
-		// Work around the issue where Go will emit "<no value>" even if Options(missing=zero)
-		// is set. Since missing=error will never get here, we do not need to handle
-		// the Strict case.
-		rendered[filename] = strings.ReplaceAll(buf.String(), "<no value>", "")
+		rendered[filename] = out
 	}
 
 	return rendered, nil
 }
 
+// execute renders the template filename of t.
+func (e Engine) execute(t *template.Template, filename string, r renderable, referenceTpls map[string]renderable) (string, error) {
+	// At render time, add information about the template that is being rendered.
+	vals := r.vals
+	vals["Template"] = chartutil.Values{"Name": filename, "BasePath": r.basePath}
+	var buf strings.Builder
+	if err := t.ExecuteTemplate(&buf, filename, vals); err != nil {
+		return "", e.withSourceContext(cleanupExecError(filename, err), referenceTpls)
+	}
+
+	// Work around the issue where Go will emit "<no value>" even if Options(missing=zero)
+	// is set. Since missing=error will never get here, we do not need to handle
+	// the Strict case.
+	out := strings.ReplaceAll(buf.String(), "<no value>", "")
+	if e.tracer != nil {
+		out = e.tracer.rendered(filename, out)
+	}
+	return out, nil
+}
+
+// withSourceContext adds the template lines around the location of err
+// when tracing.
+func (e Engine) withSourceContext(err error, sources map[string]renderable) error {
+	if e.tracer == nil {
+		return err
+	}
+	return sourceContext(err, sources)
+}
+
 func cleanupParseError(filename string, err error) error {
 	tokens := strings.Split(err.Error(), ": ")
 	if len(tokens) == 1 {
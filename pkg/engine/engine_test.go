@@ -21,6 +21,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"text/template"
 
 	"github.com/huolunl/helm/v3/pkg/chart"
 	"github.com/huolunl/helm/v3/pkg/chartutil"
@@ -70,7 +71,7 @@ func TestFuncMap(t *testing.T) {
 	}
 
 	// Test for Engine-specific template functions.
-	expect := []string{"include", "required", "tpl", "toYaml", "fromYaml", "toToml", "toJson", "fromJson", "lookup"}
+	expect := []string{"include", "required", "tpl", "toYaml", "fromYaml", "toToml", "toJson", "fromJson", "lookup", "secretRef"}
 	for _, f := range expect {
 		if _, ok := fns[f]; !ok {
 			t.Errorf("Expected add-on function %q", f)
@@ -78,6 +79,41 @@ func TestFuncMap(t *testing.T) {
 	}
 }
 
+func TestRegisterFuncs(t *testing.T) {
+	var e Engine
+	if err := e.RegisterFuncs(template.FuncMap{"platformDomain": func() string { return "example.com" }}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"platformDomain": `template function "platformDomain" is already registered`,
+		"toYaml":         `template function "toYaml" collides with a built-in function`,
+		"include":        `template function "include" collides with a built-in function`,
+		"lookup":         `template function "lookup" collides with a built-in function`,
+	} {
+		err := e.RegisterFuncs(template.FuncMap{name: func() string { return "" }})
+		if err == nil || err.Error() != want {
+			t.Errorf("Expected error %q, got %v", want, err)
+		}
+	}
+
+	c := &chart.Chart{
+		Metadata:  &chart.Metadata{Name: "moby", Version: "1.2.3"},
+		Templates: []*chart.File{{Name: "templates/host", Data: []byte(`{{ .Values.name }}.{{ platformDomain }}`)}},
+	}
+	v, err := chartutil.CoalesceValues(c, map[string]interface{}{"name": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := e.Render(c, chartutil.Values{"Values": v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out["moby/templates/host"]; got != "web.example.com" {
+		t.Errorf("Expected %q, got %q", "web.example.com", got)
+	}
+}
+
 func TestRender(t *testing.T) {
 	c := &chart.Chart{
 		Metadata: &chart.Metadata{
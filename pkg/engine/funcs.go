@@ -37,6 +37,7 @@ import (
 //
 //	- "include"
 //	- "tpl"
+//	- "secretRef"
 //
 // These are late-bound in Engine.Render().  The
 // version included in the FuncMap is a placeholder.
@@ -67,6 +68,9 @@ func funcMap() template.FuncMap {
 		"lookup": func(string, string, string, string) (map[string]interface{}, error) {
 			return map[string]interface{}{}, nil
 		},
+		// Provide a placeholder for the "secretRef" function, which requires
+		// secret providers.
+		"secretRef": func(string, string) (string, error) { return "not implemented", nil },
 	}
 
 	for k, v := range extra {
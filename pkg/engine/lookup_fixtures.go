@@ -0,0 +1,145 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/copystructure"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/huolunl/helm/v3/pkg/releaseutil"
+)
+
+// FixtureLookup is a LookupSource serving a fixed set of objects, for
+// rendering charts using lookup without a cluster.
+type FixtureLookup struct {
+	objects []map[string]interface{}
+}
+
+// NewFixtureLookup creates a FixtureLookup serving objects.
+func NewFixtureLookup(objects ...map[string]interface{}) *FixtureLookup {
+	return &FixtureLookup{objects: objects}
+}
+
+// LoadLookupFixtures creates a FixtureLookup serving the objects of YAML
+// files. A file may hold several documents, and lists are expanded to their
+// items.
+func LoadLookupFixtures(filenames ...string) (*FixtureLookup, error) {
+	f := &FixtureLookup{}
+	for _, filename := range filenames {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		manifests := releaseutil.SplitManifests(string(b))
+		keys := make([]string, 0, len(manifests))
+		for k := range manifests {
+			keys = append(keys, k)
+		}
+		sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+		for _, k := range keys {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(manifests[k]), &obj); err != nil {
+				return nil, errors.Wrapf(err, "cannot parse lookup fixtures %s", filename)
+			}
+			if err := f.Add(obj); err != nil {
+				return nil, errors.Wrapf(err, "invalid lookup fixtures %s", filename)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Add serves more objects. Lists are expanded to their items.
+func (f *FixtureLookup) Add(objects ...map[string]interface{}) error {
+	for _, obj := range objects {
+		if len(obj) == 0 {
+			continue
+		}
+		if items, ok := obj["items"].([]interface{}); ok && strings.HasSuffix(stringField(obj, "kind"), "List") {
+			for _, item := range items {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					return errors.New("list items must be objects")
+				}
+				if err := f.Add(m); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if stringField(obj, "apiVersion") == "" || stringField(obj, "kind") == "" {
+			return errors.New("objects must have an apiVersion and a kind")
+		}
+		f.objects = append(f.objects, obj)
+	}
+	return nil
+}
+
+// Lookup implements LookupSource. Objects without a namespace match any
+// namespace, as cluster-scoped objects do. The objects returned are copies, so
+// that templates changing them do not change what later lookups return.
+func (f *FixtureLookup) Lookup(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	obj, err := f.lookup(apiVersion, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	copied, err := copystructure.Copy(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot copy lookup fixture")
+	}
+	return copied.(map[string]interface{}), nil
+}
+
+func (f *FixtureLookup) lookup(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	var items []interface{}
+	for _, obj := range f.objects {
+		if stringField(obj, "apiVersion") != apiVersion || stringField(obj, "kind") != kind {
+			continue
+		}
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		if ns := stringField(metadata, "namespace"); namespace != "" && ns != "" && ns != namespace {
+			continue
+		}
+		if name == "" {
+			items = append(items, obj)
+		} else if stringField(metadata, "name") == name {
+			return obj, nil
+		}
+	}
+	if name != "" {
+		return map[string]interface{}{}, nil
+	}
+	if items == nil {
+		items = []interface{}{}
+	}
+	return map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind + "List",
+		"metadata":   map[string]interface{}{},
+		"items":      items,
+	}, nil
+}
+
+func stringField(obj map[string]interface{}, field string) string {
+	s, _ := obj[field].(string)
+	return s
+}
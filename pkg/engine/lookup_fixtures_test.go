@@ -0,0 +1,151 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+const lookupFixtures = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: default
+data:
+  password: c2VjcmV0
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: cache
+    namespace: other
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: default
+`
+
+func loadTestFixtures(t *testing.T) *FixtureLookup {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "fixtures.yaml")
+	if err := ioutil.WriteFile(filename, []byte(lookupFixtures), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadLookupFixtures(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFixtureLookup(t *testing.T) {
+	f := loadTestFixtures(t)
+
+	obj, err := f.Lookup("v1", "Secret", "default", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj["data"].(map[string]interface{})["password"] != "c2VjcmV0" {
+		t.Errorf("Expected the db secret, got %v", obj)
+	}
+
+	for _, missing := range [][]string{
+		{"v1", "Secret", "other", "db"},
+		{"v1", "ConfigMap", "default", "db"},
+		{"apps/v1", "Secret", "default", "db"},
+	} {
+		obj, err := f.Lookup(missing[0], missing[1], missing[2], missing[3])
+		if err != nil || len(obj) != 0 {
+			t.Errorf("Expected no object for %v, got %v, %v", missing, obj, err)
+		}
+	}
+
+	// cluster-scoped objects match any namespace
+	if obj, _ := f.Lookup("v1", "Namespace", "other", "default"); len(obj) == 0 {
+		t.Error("Expected the default namespace")
+	}
+
+	list, err := f.Lookup("v1", "Secret", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list["kind"] != "SecretList" || len(list["items"].([]interface{})) != 2 {
+		t.Errorf("Expected a list of 2 secrets, got %v", list)
+	}
+	list, _ = f.Lookup("v1", "Secret", "other", "")
+	if len(list["items"].([]interface{})) != 1 {
+		t.Errorf("Expected a list of 1 secret, got %v", list)
+	}
+	list, _ = f.Lookup("v1", "Pod", "other", "")
+	if len(list["items"].([]interface{})) != 0 {
+		t.Errorf("Expected an empty list, got %v", list)
+	}
+
+	if err := f.Add(map[string]interface{}{"kind": "Secret"}); err == nil {
+		t.Error("Expected an error for an object without apiVersion")
+	}
+}
+
+func TestFixtureLookupReturnsCopies(t *testing.T) {
+	f := loadTestFixtures(t)
+
+	obj, _ := f.Lookup("v1", "Secret", "default", "db")
+	obj["data"].(map[string]interface{})["password"] = "changed"
+	list, _ := f.Lookup("v1", "Secret", "", "")
+	delete(list["items"].([]interface{})[0].(map[string]interface{}), "data")
+
+	obj, _ = f.Lookup("v1", "Secret", "default", "db")
+	if obj["data"].(map[string]interface{})["password"] != "c2VjcmV0" {
+		t.Errorf("Expected the fixture to be unchanged, got %v", obj)
+	}
+}
+
+func TestRenderWithLookupSource(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "moby", Version: "1.2.3"},
+		Templates: []*chart.File{
+			{Name: "templates/secret", Data: []byte(`{{ (lookup "v1" "Secret" "default" "db").data.password }}`)},
+			{Name: "templates/missing", Data: []byte(`{{ if not (lookup "v1" "Secret" "default" "none") }}generated{{ end }}`)},
+			{Name: "templates/mutate", Data: []byte(`{{ $_ := unset (lookup "v1" "Secret" "default" "db") "data" }}`)},
+		},
+	}
+	v, err := chartutil.CoalesceValues(c, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lint := range []bool{false, true} {
+		out, err := Engine{LookupSource: loadTestFixtures(t), LintMode: lint}.Render(c, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out["moby/templates/secret"]; got != "c2VjcmV0" {
+			t.Errorf("Expected the fixture password, got %q", got)
+		}
+		if got := out["moby/templates/missing"]; got != "generated" {
+			t.Errorf("Expected %q, got %q", "generated", got)
+		}
+	}
+}
@@ -32,6 +32,15 @@ import (
 
 type lookupFunc = func(apiversion string, resource string, namespace string, name string) (map[string]interface{}, error)
 
+// LookupSource provides the objects returned by the lookup template function.
+//
+// Lookup returns the object with the given name, or a list of the objects
+// of the namespace when name is empty. A missing object is an empty map, not
+// an error.
+type LookupSource interface {
+	Lookup(apiVersion, kind, namespace, name string) (map[string]interface{}, error)
+}
+
 // NewLookupFunction returns a function for looking up objects in the cluster.
 //
 // If the resource does not exist, no error is raised.
@@ -0,0 +1,109 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// renderParallel executes the templates keys of t with one goroutine per
+// chart, e.Parallelism at a time. The templates of a chart share their
+// values, so they are executed in order by the same goroutine. The error
+// returned is the one of the first failing template of keys, as when the
+// charts are rendered one by one.
+func (e Engine) renderParallel(t *template.Template, keys []string, tpls, referenceTpls map[string]renderable) (map[string]string, error) {
+	var charts [][]int
+	chartIndex := map[string]int{}
+	for i, filename := range keys {
+		// Don't render partials.
+		if strings.HasPrefix(path.Base(filename), "_") {
+			continue
+		}
+		basePath := tpls[filename].basePath
+		c, ok := chartIndex[basePath]
+		if !ok {
+			c = len(charts)
+			chartIndex[basePath] = c
+			charts = append(charts, nil)
+		}
+		charts[c] = append(charts[c], i)
+	}
+
+	outputs := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, e.Parallelism)
+	var wg sync.WaitGroup
+	for _, indexes := range charts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(indexes []int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			e.renderChart(t, keys, indexes, tpls, referenceTpls, outputs, errs)
+		}(indexes)
+	}
+	wg.Wait()
+
+	rendered := make(map[string]string, len(keys))
+	for _, indexes := range charts {
+		for _, i := range indexes {
+			rendered[keys[i]] = outputs[i]
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return map[string]string{}, err
+		}
+	}
+	return rendered, nil
+}
+
+// renderChart executes the templates keys[indexes] of a chart with a copy of
+// t, and stores the output or the error at the same index. It stops at the
+// first error.
+func (e Engine) renderChart(t *template.Template, keys []string, indexes []int, tpls, referenceTpls map[string]renderable, outputs []string, errs []error) {
+	i := indexes[0]
+	defer func() {
+		if r := recover(); r != nil {
+			errs[i] = errors.Errorf("rendering template failed: %v", r)
+		}
+	}()
+
+	// The functions close over the template they are added to, and count
+	// the includes being executed.
+	c, err := t.Clone()
+	if err != nil {
+		errs[i] = err
+		return
+	}
+	e.initFunMap(c, referenceTpls)
+
+	for _, i = range indexes {
+		filename := keys[i]
+		outputs[i], errs[i] = e.execute(c, filename, tpls[filename], referenceTpls)
+		if errs[i] != nil {
+			return
+		}
+	}
+}
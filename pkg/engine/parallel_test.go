@@ -0,0 +1,197 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+// umbrellaChart returns a chart with n subcharts, each with the given number
+// of templates using include and tpl.
+func umbrellaChart(n, templates int) (*chart.Chart, chartutil.Values) {
+	umbrella := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "umbrella", Version: "1.0.0"},
+		Templates: []*chart.File{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "umbrella.labels" -}}
+app: {{ .Chart.Name }}
+release: {{ .Release.Name }}
+{{- end -}}`)},
+			{Name: "templates/configmap.yaml", Data: []byte(`kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+{{ include "umbrella.labels" . | indent 4 }}`)},
+		},
+	}
+	values := map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("sub%02d", i)
+		sub := &chart.Chart{
+			Metadata: &chart.Metadata{Name: name, Version: "1.0.0"},
+			Templates: []*chart.File{
+				{Name: "templates/_helpers.tpl", Data: []byte(fmt.Sprintf(`{{- define "%[1]s.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name }}
+{{- end -}}
+{{- define "%[1]s.labels" -}}
+app: {{ include "%[1]s.fullname" . }}
+chart: {{ .Chart.Name }}-{{ .Chart.Version }}
+{{- end -}}`, name))},
+			},
+		}
+		for j := 0; j < templates; j++ {
+			sub.Templates = append(sub.Templates, &chart.File{
+				Name: fmt.Sprintf("templates/deployment%02d.yaml", j),
+				Data: []byte(fmt.Sprintf(`kind: Deployment
+metadata:
+  name: {{ include "%[1]s.fullname" . }}-%[2]d
+  labels:
+{{ include "%[1]s.labels" . | indent 4 }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    metadata:
+      annotations:
+        checksum: {{ tpl .Values.annotation . | sha256sum }}
+    spec:
+      containers:
+{{- range $i, $port := .Values.ports }}
+      - name: {{ tpl $.Values.container $ }}-{{ $i }}
+        ports:
+        - containerPort: {{ $port }}
+{{- end }}`, name, j)),
+			})
+		}
+		umbrella.AddDependency(sub)
+		values[name] = map[string]interface{}{
+			"replicas":   i,
+			"annotation": `{{ include "` + name + `.labels" . }}`,
+			"container":  `{{ include "` + name + `.fullname" . }}`,
+			"ports":      []interface{}{80, 443, 8080},
+		}
+	}
+
+	vals, err := chartutil.ToRenderValues(umbrella, values, chartutil.ReleaseOptions{Name: "rel", Namespace: "default"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return umbrella, vals
+}
+
+func TestRenderParallel(t *testing.T) {
+	c, vals := umbrellaChart(8, 4)
+	expect, err := Render(c, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		out, err := Engine{Parallelism: 4}.Render(c, vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != len(expect) {
+			t.Fatalf("expected %d templates, got %d", len(expect), len(out))
+		}
+		for name, data := range expect {
+			if out[name] != data {
+				t.Fatalf("%s: expected %q, got %q (iteration %d)", name, data, out[name], i+1)
+			}
+		}
+	}
+
+	if got := expect["umbrella/charts/sub03/templates/deployment01.yaml"]; !strings.Contains(got, "- name: rel-sub03-2") {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+}
+
+func TestRenderParallelError(t *testing.T) {
+	c, vals := umbrellaChart(8, 2)
+	for _, name := range []string{"sub02", "sub05"} {
+		for _, sub := range c.Dependencies() {
+			if sub.Name() == name {
+				sub.Templates = append(sub.Templates, &chart.File{
+					Name: "templates/fail.yaml",
+					Data: []byte(`{{ fail "` + name + ` failed" }}`),
+				})
+			}
+		}
+	}
+
+	_, expect := Render(c, vals)
+	if expect == nil {
+		t.Fatal("expected an error")
+	}
+	for i := 0; i < 20; i++ {
+		_, err := Engine{Parallelism: 8}.Render(c, vals)
+		if err == nil || err.Error() != expect.Error() {
+			t.Fatalf("expected error %q, got %v", expect, err)
+		}
+	}
+}
+
+// slowLookup serves no objects after the latency of an API server request.
+type slowLookup time.Duration
+
+func (l slowLookup) Lookup(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	time.Sleep(time.Duration(l))
+	return map[string]interface{}{}, nil
+}
+
+func benchmarkRender(b *testing.B, c *chart.Chart, vals chartutil.Values, e Engine) {
+	for _, bm := range []struct {
+		name        string
+		parallelism int
+	}{
+		{"serial", 0},
+		{"parallel", 8},
+	} {
+		e.Parallelism = bm.parallelism
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := e.Render(c, vals); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRender renders CPU-bound templates, which are only rendered
+// faster in parallel with several CPUs (-cpu).
+func BenchmarkRender(b *testing.B) {
+	c, vals := umbrellaChart(40, 5)
+	benchmarkRender(b, c, vals, Engine{})
+}
+
+// BenchmarkRenderLookup renders templates waiting for lookup, which are
+// rendered faster in parallel even with a single CPU.
+func BenchmarkRenderLookup(b *testing.B) {
+	c, vals := umbrellaChart(40, 1)
+	for _, sub := range c.Dependencies() {
+		sub.Templates = append(sub.Templates, &chart.File{
+			Name: "templates/secret.yaml",
+			Data: []byte(`{{ if not (lookup "v1" "Secret" .Release.Namespace "db") }}kind: Secret{{ end }}`),
+		})
+	}
+	benchmarkRender(b, c, vals, Engine{LookupSource: slowLookup(time.Millisecond)})
+}
@@ -0,0 +1,354 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+		"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// SecretProvider resolves the secrets referenced by the secretRef template
+// function, as in `secretRef "provider" "path"`.
+type SecretProvider interface {
+	// Secret returns the value of the secret at path.
+	Secret(path string) (string, error)
+}
+
+// EnvSecrets resolves the path of a secret as the name of an environment
+// variable.
+type EnvSecrets struct{}
+
+// Secret returns the value of the environment variable path.
+func (EnvSecrets) Secret(path string) (string, error) {
+	v, ok := os.LookupEnv(path)
+	if !ok {
+		return "", errors.Errorf("environment variable %s is not set", path)
+	}
+	return v, nil
+}
+
+// FileSecrets resolves the path of a secret as a file of Dir, such as a
+// mounted secret volume.
+type FileSecrets struct {
+	Dir string
+}
+
+// Secret returns the content of the file path, without a trailing newline.
+func (f FileSecrets) Secret(path string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("secret file %s is outside of %s", path, f.Dir)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(f.Dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// JSONSecrets resolves the path of a secret as a key of a JSON object.
+// The keys of nested objects are separated with "/".
+type JSONSecrets map[string]interface{}
+
+// LoadJSONSecrets reads the JSON object of a key/value file.
+func LoadJSONSecrets(filename string) (JSONSecrets, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var secrets JSONSecrets
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse secrets file %s", filename)
+	}
+	return secrets, nil
+}
+
+// Secret returns the string at path.
+func (j JSONSecrets) Secret(path string) (string, error) {
+	var v interface{} = map[string]interface{}(j)
+	for _, key := range strings.Split(path, "/") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return "", errors.Errorf("key %s not found", path)
+		}
+		if v, ok = obj[key]; !ok {
+			return "", errors.Errorf("key %s not found", path)
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.Errorf("key %s is not a string", path)
+	}
+	return s, nil
+}
+
+// redactedRegex matches the placeholders of redacted secrets.
+var redactedRegex = regexp.MustCompile(`<redacted(-base64)?:([^:<>\s]+):([^<>\s]+)>`)
+
+// redacted returns the placeholder of the secret at path of provider, or of
+// its base64 encoding.
+func redacted(provider, path string, b64 bool) string {
+	if b64 {
+		return "<redacted-base64:" + provider + ":" + path + ">"
+	}
+	return "<redacted:" + provider + ":" + path + ">"
+}
+
+// sensitiveValues records the secrets resolved during a render.
+type sensitiveValues struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// add records the value of the secret at path of provider by its
+// placeholder.
+func (s *sensitiveValues) add(provider, path, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[redacted(provider, path, false)] = value
+}
+
+// secretRef resolves the secret at path of provider.
+func (e Engine) secretRef(provider, path string) (string, error) {
+	p, ok := e.SecretProviders[provider]
+	if !ok {
+		if e.LintMode {
+			// Don't fail on secrets when linting
+			log.Printf("[INFO] Missing secret provider: %s", provider)
+			return redacted(provider, path, false), nil
+		}
+		return "", errors.Errorf("secret provider %q is not configured", provider)
+	}
+	v, err := p.Secret(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot resolve secret %q of provider %q", path, provider)
+	}
+	e.sensitive.add(provider, path, v)
+	return v, nil
+}
+
+// RedactSecrets replaces the scalar values of the YAML documents of manifest
+// that are secrets, verbatim or base64 encoded, with their placeholders.
+// placeholders maps the value of every secret to its placeholder, as in
+// <redacted:vault:db/password>, whose base64 encoding is replaced with
+// <redacted-base64:vault:db/password>.
+//
+// Only whole plain or quoted scalars are replaced, in place, so that the rest
+// of manifest is left as it is and restoring the placeholders gives it back:
+// a short secret such as "v1" does not alter "apps/v1". Secrets that are part
+// of a longer value, such as the password of a URL, or of a block scalar are
+// not redacted, nor are values derived from the secrets by other functions,
+// such as sha256sum. A manifest that cannot be parsed is redacted as with
+// RedactSecretWords.
+func RedactSecrets(manifest string, placeholders map[string]string) string {
+	secrets := secretPlaceholders(placeholders)
+	if len(secrets) == 0 {
+		return manifest
+	}
+	var scalars []*yaml.Node
+	dec := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return RedactSecretWords(manifest, placeholders)
+		}
+		scalars = appendValueScalars(scalars, &doc)
+	}
+
+	lines := lineOffsets(manifest)
+	var b strings.Builder
+	last := 0
+	for _, n := range scalars {
+		placeholder, ok := secrets[n.Value]
+		if !ok || n.Line < 1 || n.Line > len(lines) {
+			continue
+		}
+		start := columnOffset(manifest, lines[n.Line-1], n.Column)
+		quote := ""
+		switch n.Style {
+		case yaml.DoubleQuotedStyle:
+			quote = `"`
+		case yaml.SingleQuotedStyle:
+			quote = "'"
+		case 0:
+		default:
+			continue
+		}
+		// quoted scalars with escapes do not hold their value verbatim
+		if start < last || !strings.HasPrefix(manifest[start:], quote+n.Value+quote) {
+			continue
+		}
+		start += len(quote)
+		b.WriteString(manifest[last:start])
+		b.WriteString(placeholder)
+		last = start + len(n.Value)
+	}
+	b.WriteString(manifest[last:])
+	return b.String()
+}
+
+// wordRegex matches the words of a text, separated by spaces and quotes.
+var wordRegex = regexp.MustCompile(`[^\s"']+`)
+
+// RedactSecretWords replaces the words of text that are secrets, verbatim or
+// base64 encoded, with their placeholders, as RedactSecrets does for the
+// scalars of manifests. It redacts texts that are not YAML, such as notes.
+func RedactSecretWords(text string, placeholders map[string]string) string {
+	secrets := secretPlaceholders(placeholders)
+	if len(secrets) == 0 {
+		return text
+	}
+	return wordRegex.ReplaceAllStringFunc(text, func(word string) string {
+		if placeholder, ok := secrets[word]; ok {
+			return placeholder
+		}
+		return word
+	})
+}
+
+// secretPlaceholders maps the values of the secrets of placeholders and their
+// base64 encoding to their placeholders.
+func secretPlaceholders(placeholders map[string]string) map[string]string {
+	secrets := map[string]string{}
+	for v, placeholder := range placeholders {
+		if v == "" {
+			continue
+		}
+		secrets[v] = placeholder
+		secrets[base64.StdEncoding.EncodeToString([]byte(v))] = strings.Replace(placeholder, ":", "-base64:", 1)
+	}
+	return secrets
+}
+
+// appendValueScalars appends to scalars the scalars of n that are not mapping
+// keys, in document order.
+func appendValueScalars(scalars []*yaml.Node, n *yaml.Node) []*yaml.Node {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return append(scalars, n)
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			scalars = appendValueScalars(scalars, n.Content[i])
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			scalars = appendValueScalars(scalars, c)
+		}
+	}
+	return scalars
+}
+
+// lineOffsets returns the offsets of the lines of text.
+func lineOffsets(text string) []int {
+	offsets := []int{0}
+	for i, c := range text {
+		if c == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// columnOffset returns the offset of the 1-based column, counted in
+// characters, of the line of text starting at offset line.
+func columnOffset(text string, line, column int) int {
+	offset := line
+	for i := 1; i < column && offset < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// RestoreSecrets replaces the placeholders of redacted secrets in text with
+// the values resolved by providers.
+func RestoreSecrets(text string, providers map[string]SecretProvider) (string, error) {
+	var err error
+	restored := redactedRegex.ReplaceAllStringFunc(text, func(placeholder string) string {
+		m := redactedRegex.FindStringSubmatch(placeholder)
+		if err != nil {
+			return placeholder
+		}
+		p, ok := providers[m[2]]
+		if !ok {
+			err = errors.Errorf("secret provider %q of the redacted secret %q is not configured", m[2], m[3])
+			return placeholder
+		}
+		v, e := p.Secret(m[3])
+		if e != nil {
+			err = errors.Wrapf(e, "cannot resolve secret %q of provider %q", m[3], m[2])
+			return placeholder
+		}
+		if m[1] != "" {
+			return base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		return v
+	})
+	if err != nil {
+		return text, err
+	}
+	return restored, nil
+}
+
+// recordedSecrets resolves the secrets of a provider recorded by a render.
+type recordedSecrets map[string]string
+
+// Secret returns the recorded value of the secret at path.
+func (r recordedSecrets) Secret(path string) (string, error) {
+	v, ok := r[path]
+	if !ok {
+		return "", errors.Errorf("secret %s was not recorded", path)
+	}
+	return v, nil
+}
+
+// RecordedSecrets returns the providers of the secrets recorded in sensitive
+// by Render, for restoring the output of RedactSecrets without resolving the
+// secrets again.
+func RecordedSecrets(sensitive map[string]string) map[string]SecretProvider {
+	providers := map[string]SecretProvider{}
+	for placeholder, v := range sensitive {
+		m := redactedRegex.FindStringSubmatch(placeholder)
+		if m == nil {
+			continue
+		}
+		if providers[m[2]] == nil {
+			providers[m[2]] = recordedSecrets{}
+		}
+		providers[m[2]].(recordedSecrets)[m[3]] = v
+	}
+	return providers
+}
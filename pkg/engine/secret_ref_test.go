@@ -0,0 +1,207 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+func TestSecretProviders(t *testing.T) {
+	os.Setenv("HELM_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("HELM_TEST_SECRET")
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "password"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "secrets.json")
+	if err := ioutil.WriteFile(jsonFile, []byte(`{"db": {"password": "p4ss", "port": 5432}, "token": "t0k3n"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jsonSecrets, err := LoadJSONSecrets(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		provider SecretProvider
+		path     string
+		expect   string
+		err      string
+	}{
+		{provider: EnvSecrets{}, path: "HELM_TEST_SECRET", expect: "hunter2"},
+		{provider: EnvSecrets{}, path: "HELM_TEST_MISSING", err: "environment variable HELM_TEST_MISSING is not set"},
+		{provider: FileSecrets{Dir: dir}, path: "password", expect: "s3cr3t"},
+		{provider: FileSecrets{Dir: dir}, path: "../password", err: "is outside of"},
+		{provider: FileSecrets{Dir: dir}, path: "missing", err: "no such file"},
+		{provider: jsonSecrets, path: "token", expect: "t0k3n"},
+		{provider: jsonSecrets, path: "db/password", expect: "p4ss"},
+		{provider: jsonSecrets, path: "db/port", err: "key db/port is not a string"},
+		{provider: jsonSecrets, path: "db/user", err: "key db/user not found"},
+		{provider: jsonSecrets, path: "token/value", err: "key token/value not found"},
+	}
+	for _, tt := range tests {
+		got, err := tt.provider.Secret(tt.path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tt.path, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+		} else if got != tt.expect {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.expect, got)
+		}
+	}
+}
+
+func TestRenderSecretRef(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "moby", Version: "1.2.3"},
+		Templates: []*chart.File{
+			{Name: "templates/secret", Data: []byte(`password: {{ secretRef "vault" "db/password" | b64enc }}
+url: postgres://app:{{ secretRef "vault" "db/password" }}@db`)},
+		},
+	}
+	providers := map[string]SecretProvider{
+		"vault": JSONSecrets{"db": map[string]interface{}{"password": "p4ss"}},
+	}
+
+	sensitive := map[string]string{}
+	e := Engine{SecretProviders: providers, Sensitive: sensitive}
+	out, err := e.Render(c, chartutil.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "password: cDRzcw==\nurl: postgres://app:p4ss@db"; out["moby/templates/secret"] != expect {
+		t.Errorf("expected %q, got %q", expect, out["moby/templates/secret"])
+	}
+	if len(sensitive) != 1 || sensitive["<redacted:vault:db/password>"] != "p4ss" {
+		t.Errorf("expected the secret to be recorded by its placeholder, got %v", sensitive)
+	}
+
+	if _, err := new(Engine).Render(c, chartutil.Values{}); err == nil || !strings.Contains(err.Error(), `secret provider "vault" is not configured`) {
+		t.Errorf("expected a missing provider error, got %v", err)
+	}
+
+	out, err = Engine{LintMode: true}.Render(c, chartutil.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out["moby/templates/secret"], "postgres://app:<redacted:vault:db/password>@db") {
+		t.Errorf("expected a placeholder when linting, got %q", out["moby/templates/secret"])
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "moby", Version: "1.2.3"},
+		Templates: []*chart.File{
+			{Name: "templates/secret", Data: []byte(`apiVersion: apps/v1
+kind: Secret
+data:
+  password: {{ secretRef "vault" "db/password" | b64enc }}
+stringData:
+  url: postgres://app:{{ secretRef "vault" "db/password" }}@db
+  user: "{{ secretRef "vault" "db/user" }}"
+  port: {{ secretRef "vault" "db/port" }}
+  ports: [{{ secretRef "vault" "db/port" }}, 5433]
+  digest: {{ secretRef "vault" "db/password" | sha256sum }}
+  image: registry/{{ secretRef "vault" "db/user" }}-image:v1`)},
+		},
+	}
+	providers := map[string]SecretProvider{
+		"vault": JSONSecrets{"db": map[string]interface{}{"password": "app-p4ss", "user": "app", "port": "5432"}},
+	}
+
+	sensitive := map[string]string{}
+	out, err := Engine{SecretProviders: providers, Sensitive: sensitive}.Render(c, chartutil.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := out["moby/templates/secret"]
+	placeholders := map[string]string{}
+	for placeholder, v := range sensitive {
+		placeholders[v] = placeholder
+	}
+
+	// only whole scalars are replaced, not the secrets that are part of
+	// other text
+	got := RedactSecrets(rendered, placeholders)
+	expect := fmt.Sprintf(`apiVersion: apps/v1
+kind: Secret
+data:
+  password: <redacted-base64:vault:db/password>
+stringData:
+  url: postgres://app:app-p4ss@db
+  user: "<redacted:vault:db/user>"
+  port: <redacted:vault:db/port>
+  ports: [<redacted:vault:db/port>, 5433]
+  digest: %x
+  image: registry/app-image:v1`, sha256.Sum256([]byte("app-p4ss")))
+	if got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	restored, err := RestoreSecrets(got, RecordedSecrets(sensitive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != rendered {
+		t.Errorf("expected %q to be restored, got %q", rendered, restored)
+	}
+
+	// a manifest that is not YAML is redacted word by word
+	if got := RedactSecrets("user: name: app", placeholders); got != "user: name: <redacted:vault:db/user>" {
+		t.Errorf("unexpected redaction of invalid YAML %q", got)
+	}
+}
+
+func TestRedactSecretWords(t *testing.T) {
+	placeholders := map[string]string{"v1": "<redacted:vault:version>", "p4ss": "<redacted:vault:password>"}
+	got := RedactSecretWords("Log in with 'p4ss' or cDRzcw== to apps/v1 at v1.example.com, p4ssword v1", placeholders)
+	if expect := "Log in with '<redacted:vault:password>' or <redacted-base64:vault:password> to apps/v1 at v1.example.com, p4ssword <redacted:vault:version>"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestRestoreSecrets(t *testing.T) {
+	providers := map[string]SecretProvider{
+		"vault": JSONSecrets{"token": "t0k3n"},
+	}
+	got, err := RestoreSecrets("a: <redacted:vault:token>\nb: <redacted-base64:vault:token>\n", providers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "a: t0k3n\nb: dDBrM24=\n"; got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	if _, err := RestoreSecrets("a: <redacted:env:TOKEN>", providers); err == nil || !strings.Contains(err.Error(), `secret provider "env" of the redacted secret "TOKEN" is not configured`) {
+		t.Errorf("expected a missing provider error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+// tplCache holds the templates parsed by the tpl function during a render,
+// keyed by their content, so that tpl does not parse the chart templates
+// again on every call. It is shared by the charts rendered in parallel.
+type tplCache struct {
+	mu sync.Mutex
+	// references are the chart templates, parsed on the first tpl call.
+	references *template.Template
+	parsed     map[tplKey]*template.Template
+}
+
+type tplKey struct {
+	name string
+	tpl  string
+}
+
+func newTplCache() *tplCache {
+	return &tplCache{parsed: map[tplKey]*template.Template{}}
+}
+
+// renderTpl renders the tpl string as the template name, which can reference
+// the templates of referenceTpls.
+func (e Engine) renderTpl(tpl, name, basePath string, vals chartutil.Values, referenceTpls map[string]renderable) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("rendering template failed: %v", r)
+		}
+	}()
+	t, err := e.parseTpl(tpl, name, referenceTpls)
+	if err != nil {
+		return "", err
+	}
+
+	vals["Template"] = chartutil.Values{"Name": name, "BasePath": basePath}
+	var buf strings.Builder
+	if err := t.Execute(&buf, vals); err != nil {
+		return "", cleanupExecError(name, err)
+	}
+	return strings.ReplaceAll(buf.String(), "<no value>", ""), nil
+}
+
+// parseTpl returns the tpl string parsed as the template name along with
+// the templates of referenceTpls.
+func (e Engine) parseTpl(tpl, name string, referenceTpls map[string]renderable) (*template.Template, error) {
+	c := e.tplCache
+	if e.noTplCache {
+		c = newTplCache()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := tplKey{name: name, tpl: tpl}
+	if t, ok := c.parsed[key]; ok {
+		return t, nil
+	}
+
+	if c.references == nil {
+		references := e.newTemplate()
+		e.initFunMap(references, referenceTpls)
+		for _, filename := range sortTemplates(referenceTpls) {
+			if _, err := references.New(filename).Parse(referenceTpls[filename].tpl); err != nil {
+				return nil, cleanupParseError(filename, err)
+			}
+		}
+		c.references = references
+	}
+
+	references, err := c.references.Clone()
+	if err != nil {
+		return nil, err
+	}
+	// An empty tpl string does not replace the template of the same name,
+	// so the returned template is executed rather than looked up by name.
+	t, err := references.New(name).Parse(tpl)
+	if err != nil {
+		return nil, cleanupParseError(name, err)
+	}
+	// The functions close over the template they are added to. The template
+	// name belongs to a single chart, so the template is not executed by
+	// several charts rendered in parallel.
+	e.initFunMap(references, referenceTpls)
+	c.parsed[key] = t
+	return t, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+func TestRenderTplCache(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "moby", Version: "1.2.3"},
+		Templates: []*chart.File{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "name" -}}{{ .Values.name }}{{- end -}}`)},
+			{Name: "templates/repeat", Data: []byte(`{{- range .Values.items }}{{ tpl $.Values.item (dict "Template" $.Template "Values" (dict "name" .)) }};{{ end -}}`)},
+			{Name: "templates/nested", Data: []byte(`{{ tpl "{{ tpl .Values.item . }}" . }}`)},
+			{Name: "templates/empty", Data: []byte(`before{{ tpl "" . }}after`)},
+			{Name: "templates/define", Data: []byte(`{{ tpl "{{ define \"local\" }}local{{ end }}{{ include \"local\" . }}" . }}`)},
+		},
+	}
+	vals := chartutil.Values{
+		"Values": chartutil.Values{
+			"name":  "top",
+			"item":  `{{ include "name" . }}`,
+			"items": []interface{}{"a", "b", "c"},
+		},
+	}
+
+	e := Engine{}
+	for i := 0; i < 2; i++ {
+		out, err := e.Render(c, vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			"moby/templates/repeat": "a;b;c;",
+			"moby/templates/nested": "top",
+			"moby/templates/empty":  "beforeafter",
+			"moby/templates/define": "local",
+		}
+		for name, data := range expect {
+			if out[name] != data {
+				t.Errorf("%s: expected %q, got %q", name, data, out[name])
+			}
+		}
+	}
+}
+
+func BenchmarkRenderTpl(b *testing.B) {
+	c, vals := umbrellaChart(1, 40)
+	for _, bm := range []struct {
+		name string
+		e    Engine
+	}{
+		{"uncached", Engine{noTplCache: true}},
+		{"cached", Engine{}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.e.Render(c, vals); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
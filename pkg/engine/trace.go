@@ -0,0 +1,314 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Origin is the template source of a rendered line.
+type Origin struct {
+	// Template is the file of the template producing the line, or empty
+	// if unknown.
+	Template string `json:"template,omitempty"`
+	// Name is the name of the template, which differs from Template for
+	// templates declared with define.
+	Name string `json:"name,omitempty"`
+	// Line is the line of the template producing the line.
+	Line int `json:"line,omitempty"`
+	// Includes are the locations of the include calls producing the line,
+	// outermost first.
+	Includes []string `json:"includes,omitempty"`
+}
+
+// String implements fmt.Stringer
+func (o Origin) String() string {
+	if o.Template == "" {
+		return "?"
+	}
+	s := fmt.Sprintf("%s:%d", o.Template, o.Line)
+	if o.Name != o.Template {
+		s += fmt.Sprintf(" (%q)", o.Name)
+	}
+	for i := len(o.Includes) - 1; i >= 0; i-- {
+		s += " <- " + o.Includes[i]
+	}
+	return s
+}
+
+// TracedLine is a rendered line and its origin.
+type TracedLine struct {
+	Text   string `json:"text"`
+	Origin Origin `json:"origin"`
+}
+
+// SourceMap holds the lines of rendered templates, by template name, with
+// the template line and include chain producing each of them.
+type SourceMap map[string][]TracedLine
+
+// Context returns the lines of the named rendered template around line,
+// counted from 1, each followed by its origin.
+func (m SourceMap) Context(name string, line, around int) string {
+	lines := m[name]
+	var b strings.Builder
+	for i := line - around; i <= line+around; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %4d | %s\t# %s\n", marker, i, lines[i-1].Text, lines[i-1].Origin)
+	}
+	return b.String()
+}
+
+// Tracing marks up the parsed templates so that every piece of output is
+// preceded by the location producing it:
+//
+//	traceMarkStart 'T' id traceMarkEnd   literal text of a template line
+//	traceMarkStart 'A' id traceMarkEnd   output of an action
+//
+// The marks are removed from the output of include and of every rendered
+// template, keeping the origin of each line.
+const (
+	traceMarkStart = '\x1e'
+	traceMarkEnd   = '\x1f'
+)
+
+// sourceLocation matches the location of render errors, either
+// "(name:line:col)" or "template: name:line:col:"
+var sourceLocation = regexp.MustCompile(`(?:\(|template: )([^():\s]+):(\d+)`)
+
+type tracer struct {
+	origins []Origin
+	// fragments are the origins of the lines returned by include, by
+	// content, to follow them through the functions they are piped to.
+	fragments map[string]Origin
+	lines     SourceMap
+}
+
+func newTracer() *tracer {
+	return &tracer{
+		fragments: map[string]Origin{},
+		lines:     SourceMap{},
+	}
+}
+
+// instrument marks up every template of t.
+func (tr *tracer) instrument(t *template.Template) {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil && tmpl.Tree.Root != nil {
+			tr.instrumentList(tmpl.Tree, tmpl.Tree.Root)
+		}
+	}
+}
+
+func (tr *tracer) instrumentList(tree *parse.Tree, list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	nodes := make([]parse.Node, 0, len(list.Nodes))
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.TextNode:
+			line := tr.line(tree, n)
+			var b strings.Builder
+			b.WriteString(tr.mark('T', tree, line))
+			for i, text := range strings.Split(string(n.Text), "\n") {
+				if i > 0 {
+					b.WriteByte('\n')
+					b.WriteString(tr.mark('T', tree, line+i))
+				}
+				b.WriteString(text)
+			}
+			n.Text = []byte(b.String())
+		case *parse.ActionNode, *parse.TemplateNode:
+			nodes = append(nodes, &parse.TextNode{NodeType: parse.NodeText, Pos: n.Position(), Text: []byte(tr.mark('A', tree, tr.line(tree, n)))})
+		case *parse.IfNode:
+			tr.instrumentList(tree, n.List)
+			tr.instrumentList(tree, n.ElseList)
+		case *parse.RangeNode:
+			tr.instrumentList(tree, n.List)
+			tr.instrumentList(tree, n.ElseList)
+		case *parse.WithNode:
+			tr.instrumentList(tree, n.List)
+			tr.instrumentList(tree, n.ElseList)
+		case *parse.ListNode:
+			tr.instrumentList(tree, n)
+		}
+		nodes = append(nodes, n)
+	}
+	list.Nodes = nodes
+}
+
+func (tr *tracer) line(tree *parse.Tree, n parse.Node) int {
+	location, _ := tree.ErrorContext(n)
+	// location is "name:line:col"
+	parts := strings.Split(location, ":")
+	if len(parts) < 3 {
+		return 0
+	}
+	line, _ := strconv.Atoi(parts[len(parts)-2])
+	return line
+}
+
+func (tr *tracer) mark(kind byte, tree *parse.Tree, line int) string {
+	tr.origins = append(tr.origins, Origin{Template: tree.ParseName, Name: tree.Name, Line: line})
+	return fmt.Sprintf("%c%c%d%c", traceMarkStart, kind, len(tr.origins)-1, traceMarkEnd)
+}
+
+// resolve removes the marks from s and returns the origin of each of its
+// lines. A line takes the origin of the mark preceding its first
+// non-blank character. When that is the output of an action, the line is
+// looked up in the lines returned by include so far.
+func (tr *tracer) resolve(s string) (string, []Origin) {
+	var b strings.Builder
+	var origins []Origin
+
+	var active Origin
+	activeAction := false
+	var first *Origin
+	firstAction := false
+	fixed, fixedAction := false, false
+	var fixedOrigin Origin
+	lineStart := 0
+
+	finishLine := func() {
+		o, fromAction := active, activeAction
+		switch {
+		case fixed:
+			o, fromAction = fixedOrigin, fixedAction
+		case first != nil:
+			o, fromAction = *first, firstAction
+		}
+		if fromAction {
+			if f, ok := tr.fragments[strings.TrimSpace(b.String()[lineStart:])]; ok {
+				callSite := fmt.Sprintf("%s:%d", o.Template, o.Line)
+				o = Origin{Template: f.Template, Name: f.Name, Line: f.Line, Includes: append([]string{callSite}, f.Includes...)}
+			}
+		}
+		origins = append(origins, o)
+		first, fixed = nil, false
+	}
+	writeText := func(text string) {
+		for {
+			i := strings.IndexByte(text, '\n')
+			segment := text
+			if i >= 0 {
+				segment = text[:i]
+			}
+			if !fixed && strings.TrimSpace(segment) != "" {
+				fixed, fixedOrigin, fixedAction = true, active, activeAction
+			}
+			b.WriteString(segment)
+			if i < 0 {
+				return
+			}
+			finishLine()
+			b.WriteByte('\n')
+			lineStart = b.Len()
+			text = text[i+1:]
+		}
+	}
+
+	for {
+		i := strings.IndexByte(s, traceMarkStart)
+		if i < 0 {
+			writeText(s)
+			break
+		}
+		writeText(s[:i])
+		s = s[i+1:]
+		j := strings.IndexByte(s, traceMarkEnd)
+		if j < 1 {
+			// not a mark after all
+			writeText(string(traceMarkStart))
+			continue
+		}
+		id, err := strconv.Atoi(s[1:j])
+		if err != nil || id >= len(tr.origins) {
+			writeText(string(traceMarkStart))
+			continue
+		}
+		active, activeAction = tr.origins[id], s[0] == 'A'
+		if first == nil {
+			o := active
+			first, firstAction = &o, activeAction
+		}
+		s = s[j+1:]
+	}
+	finishLine()
+	return b.String(), origins
+}
+
+// include resolves the output of an include call, remembering the origin
+// of its lines.
+func (tr *tracer) include(s string) string {
+	clean, origins := tr.resolve(s)
+	for i, line := range strings.Split(clean, "\n") {
+		if key := strings.TrimSpace(line); key != "" && origins[i].Template != "" {
+			tr.fragments[key] = origins[i]
+		}
+	}
+	return clean
+}
+
+// rendered resolves the output of a rendered template.
+func (tr *tracer) rendered(name, s string) string {
+	clean, origins := tr.resolve(s)
+	lines := strings.Split(clean, "\n")
+	traced := make([]TracedLine, len(lines))
+	for i, line := range lines {
+		traced[i] = TracedLine{Text: line, Origin: origins[i]}
+	}
+	tr.lines[name] = traced
+	return clean
+}
+
+// sourceContext appends the template lines around the location of a render
+// error to it.
+func sourceContext(err error, sources map[string]renderable) error {
+	m := sourceLocation.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	src, ok := sources[m[1]]
+	if !ok {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	lines := strings.Split(src.tpl, "\n")
+	var b strings.Builder
+	for i := line - 2; i <= line+2; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %4d | %s\n", marker, i, lines[i-1])
+	}
+	return fmt.Errorf("%w\n\n%s:\n%s", err, m[1], b.String())
+}
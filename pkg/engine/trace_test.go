@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/huolunl/helm/v3/pkg/chart"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+)
+
+func traceChart(templates map[string]string) (*chart.Chart, chartutil.Values) {
+	c := &chart.Chart{Metadata: &chart.Metadata{Name: "moby", Version: "1.2.3"}}
+	for name, data := range templates {
+		c.Templates = append(c.Templates, &chart.File{Name: name, Data: []byte(data)})
+	}
+	v, _ := chartutil.CoalesceValues(c, map[string]interface{}{"name": "web", "port": 80})
+	return c, chartutil.Values{"Values": v}
+}
+
+func TestRenderSourceMap(t *testing.T) {
+	c, v := traceChart(map[string]string{
+		"templates/_helpers.tpl": `{{- define "moby.labels" -}}
+app: {{ .Values.name }}
+{{ include "moby.version" . }}
+{{- end }}
+{{- define "moby.version" -}}
+version: "1.2.3"
+{{- end }}`,
+		"templates/service.yaml": `kind: Service
+metadata:
+  name: {{ .Values.name }}
+  labels:
+    {{- include "moby.labels" . | nindent 4 }}
+{{- if .Values.port }}
+spec:
+  port: {{ .Values.port }}
+{{- end }}
+`,
+	})
+
+	sourceMap := SourceMap{}
+	out, err := Engine{SourceMap: sourceMap}.Render(c, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+    version: "1.2.3"
+spec:
+  port: 80
+`
+	if got := out["moby/templates/service.yaml"]; got != expect {
+		t.Errorf("Expected the output to be unchanged by tracing, got %q", got)
+	}
+	plain, err := Render(c, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain["moby/templates/service.yaml"] != expect {
+		t.Errorf("Expected %q, got %q", expect, plain["moby/templates/service.yaml"])
+	}
+
+	lines := sourceMap["moby/templates/service.yaml"]
+	if len(lines) != 9 {
+		t.Fatalf("Expected 9 traced lines, got %d", len(lines))
+	}
+	expectOrigins := []string{
+		"moby/templates/service.yaml:1",
+		"moby/templates/service.yaml:2",
+		"moby/templates/service.yaml:3",
+		"moby/templates/service.yaml:4",
+		`moby/templates/_helpers.tpl:2 ("moby.labels") <- moby/templates/service.yaml:5`,
+		`moby/templates/_helpers.tpl:6 ("moby.version") <- moby/templates/_helpers.tpl:3 <- moby/templates/service.yaml:5`,
+		"moby/templates/service.yaml:7",
+		"moby/templates/service.yaml:8",
+	}
+	for i, want := range expectOrigins {
+		if got := lines[i].Origin.String(); got != want {
+			t.Errorf("line %d %q: expected origin %s, got %s", i+1, lines[i].Text, want, got)
+		}
+	}
+
+	context := sourceMap.Context("moby/templates/service.yaml", 5, 1)
+	if !strings.Contains(context, ">    5 |     app: web\t# moby/templates/_helpers.tpl:2") {
+		t.Errorf("Unexpected context:\n%s", context)
+	}
+}
+
+func TestRenderSourceMapErrorContext(t *testing.T) {
+	c, v := traceChart(map[string]string{
+		"templates/bad.yaml": "kind: ConfigMap\ndata:\n  value: {{ .Values.name.nested.value }}\n",
+	})
+
+	_, err := Engine{SourceMap: SourceMap{}}.Render(c, v)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	expect := "moby/templates/bad.yaml:\n     1 | kind: ConfigMap\n     2 | data:\n>    3 |   value: {{ .Values.name.nested.value }}\n     4 | \n"
+	if !strings.Contains(err.Error(), expect) {
+		t.Errorf("Expected the error to show the template source, got:\n%s", err)
+	}
+
+	// the error is unchanged without tracing
+	_, err = Render(c, v)
+	if err == nil || strings.Contains(err.Error(), "|") {
+		t.Errorf("Expected a plain error, got:\n%v", err)
+	}
+}
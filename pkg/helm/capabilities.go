@@ -0,0 +1,91 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/action"
+	"github.com/huolunl/helm/v3/pkg/chartutil"
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+const capabilitiesHelp = `
+This command consists of multiple subcommands to work with the capabilities
+of a cluster: its Kubernetes version and the API versions it serves.
+
+Capabilities captured to a file can be replayed with '--capabilities-file' by
+'helm template' and 'helm lint', so that charts render offline exactly as they
+would against the cluster. The objects saved in the file are returned by the
+lookup function.
+`
+
+func newCapabilitiesCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Short: "capture the capabilities of a cluster",
+		Long:  capabilitiesHelp,
+		Args:  require.NoArgs,
+	}
+	cmd.AddCommand(
+		newCapabilitiesCaptureCmd(cfg, out),
+	)
+	return cmd
+}
+
+func addCapabilitiesFileFlag(f *pflag.FlagSet, path *string) {
+	f.StringVar(path, "capabilities-file", "", "render with the capabilities captured by 'helm capabilities capture' instead of the default ones")
+}
+
+// loadCapabilitiesFile returns the capabilities of a snapshot and the
+// objects it holds for the lookup function.
+func loadCapabilitiesFile(path string) (*chartutil.Capabilities, []map[string]interface{}, error) {
+	snapshot, err := chartutil.LoadCapabilitiesSnapshot(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	caps, err := snapshot.Capabilities()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid capabilities file %s", path)
+	}
+	return caps, snapshot.Objects, nil
+}
+
+func addLookupFixturesFlag(f *pflag.FlagSet, paths *[]string) {
+	f.StringArrayVar(paths, "lookup-fixtures", []string{}, "YAML files of the objects returned by the lookup function when rendering without a cluster (can specify multiple)")
+}
+
+// newLookupSource serves the objects of fixture files and of a capabilities
+// file to the lookup function. It returns nil if there are none.
+func newLookupSource(paths []string, objects []map[string]interface{}) (engine.LookupSource, error) {
+	if len(paths) == 0 && len(objects) == 0 {
+		return nil, nil
+	}
+	fixtures, err := engine.LoadLookupFixtures(paths...)
+	if err != nil {
+		return nil, err
+	}
+	if err := fixtures.Add(objects...); err != nil {
+		return nil, errors.Wrap(err, "invalid objects in capabilities file")
+	}
+	return fixtures, nil
+}
@@ -44,6 +44,9 @@ func addValueOptionsFlags(f *pflag.FlagSet, v *values.Options) {
 	f.StringArrayVar(&v.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	f.StringArrayVar(&v.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	f.StringArrayVar(&v.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	addSecretsKeyringFlag(f, v)
+	f.StringVar(&v.Environment, "environment", "", "merge the values layers declared for this environment in environments.yaml, in the working directory or in the chart, before the values files")
+	f.StringArrayVar(&v.EnvironmentVars, "environment-var", []string{}, "set a variable of the environment used in the values layers (can specify multiple: region=eu-west-1)")
 }
 
 func addChartPathOptionsFlags(f *pflag.FlagSet, c *action.ChartPathOptions) {
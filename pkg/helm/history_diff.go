@@ -0,0 +1,130 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/action"
+	"github.com/huolunl/helm/v3/pkg/cli/output"
+)
+
+var historyDiffHelp = `
+This command compares two stored revisions of a release.
+
+It shows the differences in the chart name and version, the user-supplied
+values, the computed values and the rendered manifest. Only the release
+storage is read, so no access to the Kubernetes API is needed.
+
+    $ helm history diff angry-bird 2 4
+
+Use '--redact-secrets' to replace the data of Secret manifests with a digest.
+
+To show the history of a release named "diff" rather than running this
+command, separate its name with '--':
+
+    $ helm history -- diff
+`
+
+func newHistoryDiffCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	client := action.NewRevisionDiff(cfg)
+	var outfmt output.Format
+
+	cmd := &cobra.Command{
+		Use:   "diff RELEASE_NAME REVISION1 REVISION2",
+		Short: "show the differences between two revisions of a release",
+		Long:  historyDiffHelp,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.Errorf("%q requires a release name and two revisions\n\nTo show the history of a release named \"diff\", run 'helm history -- diff'", cmd.CommandPath())
+			}
+			return require.ExactArgs(3)(cmd, args)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			switch len(args) {
+			case 0:
+				return compListReleases(toComplete, args, cfg)
+			case 1, 2:
+				return compListRevisions(toComplete, cfg, args[0])
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := strconv.Atoi(args[1])
+			if err != nil {
+				return errors.Wrapf(err, "could not convert revision to a number")
+			}
+			to, err := strconv.Atoi(args[2])
+			if err != nil {
+				return errors.Wrapf(err, "could not convert revision to a number")
+			}
+
+			res, err := client.Run(args[0], from, to)
+			if err != nil {
+				return err
+			}
+			return outfmt.Write(out, &revisionDiffWriter{res})
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVar(&client.RedactSecrets, "redact-secrets", false, "replace the data of Secret manifests with a digest")
+	f.IntVar(&client.Context, "context", 3, "number of unchanged lines to show around each change")
+	bindOutputFlag(cmd, &outfmt)
+
+	return cmd
+}
+
+type revisionDiffWriter struct {
+	res *action.RevisionDiffResult
+}
+
+func (w *revisionDiffWriter) WriteTable(out io.Writer) error {
+	if !w.res.HasChanges() {
+		fmt.Fprintf(out, "No differences between revisions %d and %d\n", w.res.From, w.res.To)
+		return nil
+	}
+	sections := []struct {
+		title, diff string
+	}{
+		{"CHART", w.res.Chart},
+		{"USER-SUPPLIED VALUES", w.res.Values},
+		{"COMPUTED VALUES", w.res.ComputedValues},
+		{"MANIFEST", w.res.Manifest},
+	}
+	for _, s := range sections {
+		if s.diff == "" {
+			continue
+		}
+		fmt.Fprintf(out, "%s:\n%s\n", s.title, s.diff)
+	}
+	return nil
+}
+
+func (w *revisionDiffWriter) WriteJSON(out io.Writer) error {
+	return output.EncodeJSON(out, w.res)
+}
+
+func (w *revisionDiffWriter) WriteYAML(out io.Writer) error {
+	return output.EncodeYAML(out, w.res)
+}
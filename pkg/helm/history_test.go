@@ -70,6 +70,63 @@ func TestHistoryCmd(t *testing.T) {
 	runTestCmd(t, tests)
 }
 
+func TestHistoryDiffCmd(t *testing.T) {
+	mk := func(name string, vers int, config map[string]interface{}) *release.Release {
+		rel := release.Mock(&release.MockReleaseOptions{
+			Name:    name,
+			Version: vers,
+			Status:  release.StatusSuperseded,
+		})
+		rel.Config = config
+		return rel
+	}
+
+	rels := []*release.Release{
+		mk("angry-bird", 1, map[string]interface{}{"name": "value"}),
+		mk("angry-bird", 2, map[string]interface{}{"name": "other"}),
+		mk("diff", 1, map[string]interface{}{"name": "value"}),
+	}
+
+	tests := []cmdTestCase{{
+		name:   "diff two revisions",
+		cmd:    "history diff angry-bird 1 2",
+		rels:   rels,
+		golden: "output/history-diff.txt",
+	}, {
+		name:   "diff identical revisions",
+		cmd:    "history diff angry-bird 2 2",
+		rels:   rels,
+		golden: "output/history-diff-none.txt",
+	}, {
+		name:      "diff with invalid revision",
+		cmd:       "history diff angry-bird one 2",
+		rels:      rels,
+		wantError: true,
+	}, {
+		name:      "diff with missing revision",
+		cmd:       "history diff angry-bird 1 3",
+		rels:      rels,
+		wantError: true,
+	}, {
+		name:      "diff without revisions",
+		cmd:       "history diff angry-bird",
+		rels:      rels,
+		wantError: true,
+	}, {
+		name:   "history of a release named diff",
+		cmd:    "history -- diff",
+		rels:   rels,
+		golden: "output/history-release-diff.txt",
+	}, {
+		name:      "diff without arguments",
+		cmd:       "history diff",
+		rels:      rels,
+		golden:    "output/history-diff-no-args.txt",
+		wantError: true,
+	}}
+	runTestCmd(t, tests)
+}
+
 func TestHistoryOutputCompletion(t *testing.T) {
 	outputFlagCompletionTest(t, "history")
 }
@@ -110,7 +167,24 @@ func revisionFlagCompletionTest(t *testing.T, cmdName string) {
 }
 
 func TestHistoryCompletion(t *testing.T) {
-	checkReleaseCompletion(t, "history", false)
+	rels := []*release.Release{
+		release.Mock(&release.MockReleaseOptions{Name: "athos"}),
+		release.Mock(&release.MockReleaseOptions{Name: "porthos"}),
+		release.Mock(&release.MockReleaseOptions{Name: "aramis"}),
+	}
+	tests := []cmdTestCase{{
+		// the diff subcommand is offered with the releases
+		name:   "completion for history",
+		cmd:    "__complete history ''",
+		golden: "output/history-comp.txt",
+		rels:   rels,
+	}, {
+		name:   "completion for history repetition",
+		cmd:    "__complete history porthos ''",
+		golden: "output/empty_nofile_comp.txt",
+		rels:   rels,
+	}}
+	runTestCmd(t, tests)
 }
 
 func TestHistoryFileCompletion(t *testing.T) {
@@ -19,6 +19,7 @@ package helm
 import (
 	"io"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -33,6 +34,7 @@ import (
 	"github.com/huolunl/helm/v3/pkg/cli/values"
 	"github.com/huolunl/helm/v3/pkg/downloader"
 	"github.com/huolunl/helm/v3/pkg/getter"
+	"github.com/huolunl/helm/v3/pkg/kube"
 	"github.com/huolunl/helm/v3/pkg/release"
 )
 
@@ -107,6 +109,8 @@ func newInstallCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	client := action.NewInstall(cfg)
 	valueOpts := &values.Options{}
 	var outfmt output.Format
+	var lookupFixtures []string
+	var secretProviders []string
 
 	cmd := &cobra.Command{
 		Use:   "install [NAME] [CHART]",
@@ -117,7 +121,22 @@ func newInstallCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 			return compInstall(args, toComplete, client)
 		},
 		RunE: func(_ *cobra.Command, args []string) error {
+			if len(lookupFixtures) > 0 {
+				if !client.DryRun {
+					return errors.New("--lookup-fixtures can only be used with --dry-run")
+				}
+				lookupSource, err := newLookupSource(lookupFixtures, nil)
+				if err != nil {
+					return err
+				}
+				cfg.LookupSource = lookupSource
+			}
+			if err := setSecretProviders(cfg, secretProviders); err != nil {
+				return err
+			}
+
 			rel, err := runInstall(args, client, valueOpts, out)
+			printDryRunWarnings(client.DryRunResult)
 			if err != nil {
 				return err
 			}
@@ -127,6 +146,10 @@ func newInstallCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 	}
 
 	addInstallFlags(cmd, cmd.Flags(), client, valueOpts)
+	cmd.Flags().BoolVar(&client.ServerDryRun, "server-dry-run", false, "simulate an install by sending every resource to the API server with dryRun=All. Admission and validation errors are reported and nothing is persisted")
+	cmd.Flags().StringToStringVar(&client.Labels, "labels", nil, "labels that will be added to the release metadata. Should be divided by comma")
+	addLookupFixturesFlag(cmd.Flags(), &lookupFixtures)
+	addSecretProviderFlag(cmd.Flags(), &secretProviders)
 	bindOutputFlag(cmd, &outfmt)
 	bindPostRenderFlag(cmd, &client.PostRenderer)
 
@@ -149,6 +172,7 @@ func addInstallFlags(cmd *cobra.Command, f *pflag.FlagSet, client *action.Instal
 	f.BoolVar(&client.DisableOpenAPIValidation, "disable-openapi-validation", false, "if set, the installation process will not validate rendered templates against the Kubernetes OpenAPI Schema")
 	f.BoolVar(&client.Atomic, "atomic", false, "if set, the installation process deletes the installation on failure. The --wait flag will be set automatically if --atomic is used")
 	f.BoolVar(&client.SkipCRDs, "skip-crds", false, "if set, no CRDs will be installed. By default, CRDs are installed if not already present")
+	f.BoolVar(&client.UpgradeCRDs, "upgrade-crds", false, "if set, CRDs already present are replaced by the chart's copy when the change keeps served versions and fields still in use")
 	f.BoolVar(&client.SubNotes, "render-subchart-notes", false, "if set, render subchart notes along with the parent")
 	addValueOptionsFlags(f, valueOpts)
 	addChartPathOptionsFlags(f, &client.ChartPathOptions)
@@ -190,10 +214,13 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 	debug("CHART PATH: %s\n", cp)
 
 	p := getter.All(settings)
+	valueOpts.ChartPath = cp
 	vals, err := valueOpts.MergeValues(p)
 	if err != nil {
 		return nil, err
 	}
+	client.ValuesLayers = valuesLayers(valueOpts)
+	client.RedactValues = valueOpts.RedactValues
 
 	// Check chart dependencies to make sure all are present in /charts
 	chartRequested, err := loader.Load(cp)
@@ -242,6 +269,28 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 	return client.Run(chartRequested, vals)
 }
 
+// valuesLayers returns the values layers of the environment merged by
+// valueOpts, for recording them on the release, and logs them.
+func valuesLayers(valueOpts *values.Options) []release.ValuesLayer {
+	var layers []release.ValuesLayer
+	for _, l := range valueOpts.Layers() {
+		debug("VALUES LAYER %s (lists: %s, maps: %s): %s\n", l.Name, l.Lists, l.Maps, strings.Join(l.Files, ", "))
+		layers = append(layers, release.ValuesLayer{Name: l.Name, Files: l.Files, Lists: l.Lists, Maps: l.Maps})
+	}
+	return layers
+}
+
+// printDryRunWarnings prints the warnings returned by the API server during a
+// server dry run. They go to stderr so that structured output stays valid.
+func printDryRunWarnings(res *kube.DryRunResult) {
+	if res == nil {
+		return
+	}
+	for _, w := range res.Warnings() {
+		warning("%s", w)
+	}
+}
+
 // checkIfInstallable validates if a chart can be installed
 //
 // Application chart type is only installable
@@ -0,0 +1,79 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaGenerateCmd(t *testing.T) {
+	dir := t.TempDir()
+	values := "# The image tag.\ntag: \"1.21\"\nreplicas: 1\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte(values), 0644); err != nil {
+		t.Fatal(err)
+	}
+	existing := `{"title": "Values", "properties": {"replicas": {"minimum": 1}}}`
+	schemaPath := filepath.Join(dir, "values.schema.json")
+	if err := ioutil.WriteFile(schemaPath, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, out, err := executeActionCommand("schema generate --stdout " + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(schemaPath); string(data) != existing {
+		t.Errorf("expected the schema not to be written with --stdout, got %s", data)
+	}
+
+	_, written, err := executeActionCommand("schema generate " + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(written, "Wrote "+schemaPath) {
+		t.Errorf("unexpected output %q", written)
+	}
+	data, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != out {
+		t.Errorf("expected the written schema to be the printed schema:\n%s\ngot:\n%s", out, data)
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	properties := schema["properties"].(map[string]interface{})
+	replicas := properties["replicas"].(map[string]interface{})
+	tag := properties["tag"].(map[string]interface{})
+	if schema["title"] != "Values" || replicas["minimum"] != 1.0 || replicas["type"] != "integer" {
+		t.Errorf("expected the existing schema to be merged, got %s", data)
+	}
+	if tag["description"] != "The image tag." || tag["type"] != "string" {
+		t.Errorf("unexpected tag schema %v", tag)
+	}
+
+	if _, _, err := executeActionCommand("schema generate " + t.TempDir()); err == nil {
+		t.Error("expected an error without values.yaml")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/huolunl/helm/v3/pkg/action"
+	"github.com/huolunl/helm/v3/pkg/engine"
+)
+
+func addSecretProviderFlag(f *pflag.FlagSet, specs *[]string) {
+	f.StringArrayVar(specs, "secret-provider", []string{}, `provider of the secretRef template function as [NAME=]TYPE[:ARG], where TYPE[:ARG] is "env", "file:DIR" or "json:FILE" and NAME defaults to TYPE (can specify multiple)`)
+}
+
+// setSecretProviders sets the providers of the secretRef template function.
+func setSecretProviders(cfg *action.Configuration, specs []string) error {
+	if len(specs) == 0 {
+		return nil
+	}
+	providers := make(map[string]engine.SecretProvider, len(specs))
+	for _, spec := range specs {
+		name, provider, err := parseSecretProvider(spec)
+		if err != nil {
+			return err
+		}
+		if _, ok := providers[name]; ok {
+			return errors.Errorf("secret provider %q is set more than once", name)
+		}
+		providers[name] = provider
+	}
+	cfg.SecretProviders = providers
+	return nil
+}
+
+// parseSecretProvider returns the provider of a [NAME=]TYPE[:ARG] flag value.
+func parseSecretProvider(spec string) (string, engine.SecretProvider, error) {
+	name, typ, arg := "", spec, ""
+	if i := strings.Index(spec, "="); i >= 0 && !strings.Contains(spec[:i], ":") {
+		name, typ = spec[:i], spec[i+1:]
+	}
+	if i := strings.Index(typ, ":"); i >= 0 {
+		typ, arg = typ[:i], typ[i+1:]
+	}
+	if name == "" {
+		name = typ
+	}
+
+	switch typ {
+	case "env":
+		if arg != "" {
+			return "", nil, errors.Errorf("secret provider %q: env takes no argument", spec)
+		}
+		return name, engine.EnvSecrets{}, nil
+	case "file":
+		if arg == "" {
+			return "", nil, errors.Errorf("secret provider %q: file requires a directory", spec)
+		}
+		return name, engine.FileSecrets{Dir: arg}, nil
+	case "json":
+		if arg == "" {
+			return "", nil, errors.Errorf("secret provider %q: json requires a file", spec)
+		}
+		secrets, err := engine.LoadJSONSecrets(arg)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "secret provider %q", spec)
+		}
+		return name, secrets, nil
+	default:
+		return "", nil, errors.Errorf("secret provider %q: unknown type %q", spec, typ)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/cli/values"
+)
+
+const secretsEncryptHelp = `
+This command encrypts the values of a values file for the OpenPGP keys given
+with --pgp, which are fingerprints, key IDs or parts of a key identity found in
+the keyring given with --keyring, and for the age public keys given with
+--age. The encrypted file is printed, or replaces the file with --in-place.
+
+    $ helm secrets encrypt --pgp ops@example.com --in-place secrets.yaml
+    $ helm secrets encrypt --age age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p secrets.yaml
+`
+
+func newSecretsEncryptCmd(out io.Writer) *cobra.Command {
+	var keys, ageKeys []string
+	var keyring string
+	var inPlace bool
+
+	cmd := &cobra.Command{
+		Use:   "encrypt FILE",
+		Short: "encrypt a values file",
+		Long:  secretsEncryptHelp,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(keys) == 0 && len(ageKeys) == 0 {
+				return errors.New("at least one key must be given with --pgp or --age")
+			}
+			var recipients values.Recipients
+			var err error
+			if len(keys) > 0 {
+				if recipients.PGP, err = values.LoadRecipients(keyring, keys); err != nil {
+					return err
+				}
+			}
+			if recipients.Age, err = values.ParseAgeRecipients(ageKeys); err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			encrypted, err := values.EncryptValues(data, recipients)
+			if err != nil {
+				return errors.Wrapf(err, "cannot encrypt %s", args[0])
+			}
+			if inPlace {
+				return writeFileKeepMode(args[0], encrypted)
+			}
+			_, err = out.Write(encrypted)
+			return err
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringSliceVar(&keys, "pgp", nil, "OpenPGP keys to encrypt the values for (can specify multiple)")
+	f.StringSliceVar(&ageKeys, "age", nil, "age public keys to encrypt the values for (can specify multiple)")
+	f.StringVar(&keyring, "keyring", defaultKeyring(), "location of the public keys to encrypt the values for")
+	f.BoolVarP(&inPlace, "in-place", "i", false, "replace the file with the encrypted file")
+
+	return cmd
+}
+
+// writeFileKeepMode replaces the content of a file, keeping its permissions.
+func writeFileKeepMode(filename string, data []byte) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, fi.Mode().Perm())
+}
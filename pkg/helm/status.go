@@ -102,14 +102,16 @@ type statusPrinter struct {
 }
 
 func (s statusPrinter) WriteJSON(out io.Writer) error {
-	return output.EncodeJSON(out, s.release)
+	return output.EncodeJSON(out, s.release.Redacted())
 }
 
 func (s statusPrinter) WriteYAML(out io.Writer) error {
-	return output.EncodeYAML(out, s.release)
+	return output.EncodeYAML(out, s.release.Redacted())
 }
 
 func (s statusPrinter) WriteTable(out io.Writer) error {
+	// secrets resolved by secretRef or decrypted from values files are not printed
+	s.release = s.release.Redacted()
 	if s.release == nil {
 		return nil
 	}
@@ -143,6 +145,18 @@ func (s statusPrinter) WriteTable(out io.Writer) error {
 	}
 
 	if s.debug {
+		if len(s.release.ValuesLayers) > 0 {
+			fmt.Fprintln(out, "VALUES LAYERS:")
+			for _, l := range s.release.ValuesLayers {
+				fmt.Fprintf(out, "%s (lists: %s, maps: %s)\n", l.Name, l.Lists, l.Maps)
+				for _, f := range l.Files {
+					fmt.Fprintf(out, "  %s\n", f)
+				}
+			}
+			// Print an extra newline
+			fmt.Fprintln(out)
+		}
+
 		fmt.Fprintln(out, "USER-SUPPLIED VALUES:")
 		err := output.EncodeYAML(out, s.release.Config)
 		if err != nil {
@@ -0,0 +1,84 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/huolunl/helm/v3/pkg/plugin"
+	"github.com/huolunl/helm/v3/pkg/storage/driver"
+)
+
+// builtinStorageDrivers are the values of HELM_DRIVER handled by
+// action.Configuration.Init itself.
+var builtinStorageDrivers = map[string]bool{
+	"": true, "secret": true, "secrets": true, "configmap": true, "configmaps": true, "memory": true, "sql": true, "filesystem": true,
+}
+
+// registerStorageDriverPlugin registers the storage driver named name if it
+// is provided by an installed plugin, so that it can be selected with
+// HELM_DRIVER.
+func registerStorageDriverPlugin(name string) {
+	if builtinStorageDrivers[name] || os.Getenv("HELM_NO_PLUGINS") == "1" {
+		return
+	}
+	if _, ok := driver.Lookup(name); ok {
+		return
+	}
+
+	found, err := plugin.FindPlugins(settings.PluginsDirectory)
+	if err != nil {
+		warning("failed to load plugins: %s", err)
+		return
+	}
+	for _, plug := range found {
+		for _, sd := range plug.Metadata.StorageDrivers {
+			if sd.Name != name {
+				continue
+			}
+			driver.Register(name, newStorageDriverPluginFactory(plug, sd))
+			return
+		}
+	}
+}
+
+func newStorageDriverPluginFactory(plug *plugin.Plugin, sd plugin.StorageDriver) driver.Factory {
+	return func(namespace string, log func(string, ...interface{})) (driver.Driver, error) {
+		plugin.SetupPluginEnv(settings, plug.Metadata.Name, plug.Dir)
+		command, args := storageDriverCommand(plug.Dir, os.ExpandEnv(sd.Command))
+		d := driver.NewPlugin(sd.Name, command, args, namespace)
+		d.Log = log
+		return d, nil
+	}
+}
+
+// storageDriverCommand splits the expanded command of a storage driver
+// plugin into its program, relative to the plugin directory dir unless
+// absolute, and its arguments.
+func storageDriverCommand(dir, command string) (string, []string) {
+	commands := strings.Fields(command)
+	if len(commands) == 0 {
+		return "", nil
+	}
+	prog := commands[0]
+	if !filepath.IsAbs(prog) {
+		prog = filepath.Join(dir, prog)
+	}
+	return prog, commands[1:]
+}
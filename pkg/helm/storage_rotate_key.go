@@ -0,0 +1,68 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/huolunl/helm/v3/cmd/helm/require"
+	"github.com/huolunl/helm/v3/pkg/action"
+)
+
+const storageRotateKeyHelp = `
+This command re-encrypts every stored revision of the releases in the current
+namespace with the current encryption key.
+
+The encryption keys are read from $HELM_DRIVER_ENCRYPTION_KEY, a comma-separated
+list of base64 encoded AES keys, or from the file named by
+$HELM_DRIVER_ENCRYPTION_KEY_FILE, holding one key per line. The first key is
+the current key; the others are only used to decrypt revisions written before
+the rotation. To rotate a key, put the new key first, keep the old key after
+it, run this command, then remove the old key:
+
+    $ export HELM_DRIVER_ENCRYPTION_KEY=$NEW_KEY,$OLD_KEY
+    $ helm storage rotate-key
+    $ export HELM_DRIVER_ENCRYPTION_KEY=$NEW_KEY
+
+The command fails, listing them, if some revisions cannot be decrypted with any
+of the configured keys. Do not remove the old key until it succeeds.
+
+When no key is configured, the revisions are stored unencrypted. Only the
+secret, configmap and sql storage drivers encrypt revisions; the others refuse
+to run with a key configured.
+`
+
+func newStorageRotateKeyCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	client := action.NewRotateKey(cfg)
+
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "re-encrypt stored releases with the current encryption key",
+		Long:  storageRotateKeyHelp,
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rels, err := client.Run()
+			fmt.Fprintf(out, "Re-encrypted %d revision(s)\n", len(rels))
+			return err
+		},
+	}
+
+	return cmd
+}
@@ -123,10 +123,13 @@ func (c *Client) IsReachable() error {
 	return nil
 }
 
-// Create creates Kubernetes resources specified in the resource list.
+// Create creates Kubernetes resources specified in the resource list. The
+// resources that already exist are updated as their update strategy annotation
+// selects, or left as they are with UpdateStrategySkipIfExists; creating the
+// others fails.
 func (c *Client) Create(resources ResourceList) (*Result, error) {
 	c.Log("creating %d resource(s)", len(resources))
-	if err := perform(resources, createResource); err != nil {
+	if err := perform(resources, c.createWithStrategy); err != nil {
 		return nil, err
 	}
 	return &Result{Created: resources}, nil
@@ -212,8 +215,14 @@ func (c *Client) Update(original, target ResourceList, force bool) (*Result, err
 			return err
 		}
 
+		strategy, err := UpdateStrategy(info)
+		if err != nil {
+			return err
+		}
+
 		helper := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager())
-		if _, err := helper.Get(info.Namespace, info.Name); err != nil {
+		currentObj, err := helper.Get(info.Namespace, info.Name)
+		if err != nil {
 			if !apierrors.IsNotFound(err) {
 				return errors.Wrap(err, "could not get information about the resource")
 			}
@@ -237,7 +246,13 @@ func (c *Client) Update(original, target ResourceList, force bool) (*Result, err
 			return errors.Errorf("no %s with the name %q found", kind, info.Name)
 		}
 
-		if err := updateResource(c, info, originalInfo.Object, force); err != nil {
+		if strategy == UpdateStrategySkipIfExists {
+			c.Log("Skipping update of %q due to annotation [%s=%s]", info.Name, UpdateStrategyAnno, strategy)
+			// keep the live object so that functions relying on its labels work
+			return info.Refresh(currentObj, true)
+		}
+
+		if err := updateResource(c, info, originalInfo.Object, force, strategy); err != nil {
 			c.Log("error updating the resource %q:\n\t %v", info.Name, err)
 			updateErrors = append(updateErrors, err.Error())
 		}
@@ -336,10 +351,10 @@ func (c *Client) watchTimeout(t time.Duration) func(*resource.Info) error {
 // For most kinds, it checks to see if the resource is marked as Added or Modified
 // by the Kubernetes event stream. For some kinds, it does more:
 //
-// - Jobs: A job is marked "Ready" when it has successfully completed. This is
-//   ascertained by watching the Status fields in a job's output.
-// - Pods: A pod is marked "Ready" when it has successfully completed. This is
-//   ascertained by watching the status.phase field in a pod's output.
+//   - Jobs: A job is marked "Ready" when it has successfully completed. This is
+//     ascertained by watching the Status fields in a job's output.
+//   - Pods: A pod is marked "Ready" when it has successfully completed. This is
+//     ascertained by watching the status.phase field in a pod's output.
 //
 // Handling for other kinds will be added as necessary.
 func (c *Client) WatchUntilReady(resources ResourceList, timeout time.Duration) error {
@@ -410,6 +425,40 @@ func createResource(info *resource.Info) error {
 	return info.Refresh(obj, true)
 }
 
+// createWithStrategy creates the resource described by info, or applies its
+// update strategy if it already exists.
+func (c *Client) createWithStrategy(info *resource.Info) error {
+	strategy, err := UpdateStrategy(info)
+	if err != nil {
+		return err
+	}
+	err = createResource(info)
+	if err == nil || !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	kind := info.Mapping.GroupVersionKind.Kind
+	switch strategy {
+	case UpdateStrategySkipIfExists:
+		c.Log("Skipping creation of existing %s %q due to annotation [%s=%s]", kind, info.Name, UpdateStrategyAnno, strategy)
+		// keep the live object so that functions relying on its labels work
+		return errors.Wrap(info.Get(), "failed to refresh resource information")
+	case UpdateStrategyReplace:
+		obj, err := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager()).Replace(info.Namespace, info.Name, true, info.Object)
+		if err != nil {
+			return errors.Wrap(err, "failed to replace object")
+		}
+		c.Log("Replaced existing %s %q", kind, info.Name)
+		return info.Refresh(obj, true)
+	case UpdateStrategyRecreate:
+		if err := recreateResource(info); err != nil {
+			return errors.Wrapf(err, "cannot recreate %q with kind %s", info.Name, kind)
+		}
+		c.Log("Recreated existing %s %q", kind, info.Name)
+		return nil
+	}
+	return err
+}
+
 func deleteResource(info *resource.Info) error {
 	policy := metav1.DeletePropagationBackground
 	opts := &metav1.DeleteOptions{PropagationPolicy: &policy}
@@ -467,7 +516,10 @@ func createPatch(target *resource.Info, current runtime.Object) ([]byte, types.P
 	return patch, types.StrategicMergePatchType, err
 }
 
-func updateResource(c *Client, target *resource.Info, currentObj runtime.Object, force bool) error {
+// updateResource updates the resource described by target with the given
+// update strategy. Resources without a strategy are replaced if force is set
+// and patched otherwise.
+func updateResource(c *Client, target *resource.Info, currentObj runtime.Object, force bool, strategy string) error {
 	var (
 		obj    runtime.Object
 		helper = resource.NewHelper(target.Client, target.Mapping).WithFieldManager(getManagedFieldsManager())
@@ -475,7 +527,7 @@ func updateResource(c *Client, target *resource.Info, currentObj runtime.Object,
 	)
 
 	// if --force is applied, attempt to replace the existing resource with the new object.
-	if force {
+	if strategy == UpdateStrategyReplace || (force && strategy == "") {
 		var err error
 		obj, err = helper.Replace(target.Namespace, target.Name, true, target.Object)
 		if err != nil {
@@ -497,6 +549,13 @@ func updateResource(c *Client, target *resource.Info, currentObj runtime.Object,
 			}
 			return nil
 		}
+		if strategy == UpdateStrategyRecreate {
+			if err := recreateResource(target); err != nil {
+				return errors.Wrapf(err, "cannot recreate %q with kind %s", target.Name, kind)
+			}
+			c.Log("Recreated %q with kind %s", target.Name, kind)
+			return nil
+		}
 		// send patch to server
 		obj, err = helper.Patch(target.Namespace, target.Name, patchType, patch, nil)
 		if err != nil {
@@ -25,10 +25,13 @@ import (
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/rest/fake"
 	cmdtesting "k8s.io/kubectl/pkg/cmd/testing"
 )
@@ -214,6 +217,262 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdateStrategies(t *testing.T) {
+	listA := newPodList("merged", "replaced", "recreated", "skipped")
+	listB := newPodList("merged", "replaced", "recreated", "skipped")
+	for i, strategy := range []string{UpdateStrategyMerge, UpdateStrategyReplace, UpdateStrategyRecreate, UpdateStrategySkipIfExists} {
+		listB.Items[i].Annotations = map[string]string{UpdateStrategyAnno: strategy}
+		listB.Items[i].Spec.Containers[0].Ports = []v1.ContainerPort{{Name: "https", ContainerPort: 443}}
+	}
+
+	var actions []string
+	deleted := false
+
+	c := newTestClient(t)
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			p, m := req.URL.Path, req.Method
+			actions = append(actions, p+":"+m)
+			t.Logf("got request %s %s", p, m)
+			switch {
+			case p == "/namespaces/default/pods/merged" && m == "GET":
+				return newResponse(200, &listA.Items[0])
+			case p == "/namespaces/default/pods/merged" && m == "PATCH":
+				return newResponse(200, &listB.Items[0])
+			case p == "/namespaces/default/pods/replaced" && m == "GET":
+				return newResponse(200, &listA.Items[1])
+			case p == "/namespaces/default/pods/replaced" && m == "PUT":
+				return newResponse(200, &listB.Items[1])
+			case p == "/namespaces/default/pods/recreated" && m == "GET":
+				if deleted {
+					return newResponse(404, notFoundBody())
+				}
+				return newResponse(200, &listA.Items[2])
+			case p == "/namespaces/default/pods/recreated" && m == "DELETE":
+				deleted = true
+				return newResponse(200, &listA.Items[2])
+			case p == "/namespaces/default/pods" && m == "POST":
+				return newResponse(201, &listB.Items[2])
+			case p == "/namespaces/default/pods/skipped" && m == "GET":
+				return newResponse(200, &listA.Items[3])
+			default:
+				t.Fatalf("unexpected request: %s %s", req.Method, req.URL.Path)
+				return nil, nil
+			}
+		}),
+	}
+	first, err := c.Build(objBody(&listA), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Build(objBody(&listB), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// force does not override the strategy of annotated resources
+	result, err := c.Update(first, second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 3 {
+		t.Errorf("expected 3 resources updated, got %d", len(result.Updated))
+	}
+
+	expectedActions := []string{
+		"/namespaces/default/pods/merged:GET",
+		"/namespaces/default/pods/merged:GET",
+		"/namespaces/default/pods/merged:PATCH",
+		"/namespaces/default/pods/replaced:GET",
+		"/namespaces/default/pods/replaced:GET",
+		"/namespaces/default/pods/replaced:PUT",
+		"/namespaces/default/pods/recreated:GET",
+		"/namespaces/default/pods/recreated:GET",
+		"/namespaces/default/pods/recreated:DELETE",
+		"/namespaces/default/pods/recreated:GET",
+		"/namespaces/default/pods:POST",
+		"/namespaces/default/pods/skipped:GET",
+	}
+	if len(expectedActions) != len(actions) {
+		t.Fatalf("unexpected requests, expected %v, got %v", expectedActions, actions)
+	}
+	for k, v := range expectedActions {
+		if actions[k] != v {
+			t.Errorf("expected %s request got %s", v, actions[k])
+		}
+	}
+
+	// unknown strategies are rejected
+	second[0].Object.(*unstructured.Unstructured).SetAnnotations(map[string]string{UpdateStrategyAnno: "overwrite"})
+	if _, err := c.Update(first, second, false); err == nil || !strings.Contains(err.Error(), "invalid value \"overwrite\"") {
+		t.Errorf("expected an invalid strategy error, got %v", err)
+	}
+}
+
+func TestCreateUpdateStrategies(t *testing.T) {
+	list := newPodList("plain", "replaced", "recreated", "skipped")
+	for i, strategy := range []string{"", UpdateStrategyReplace, UpdateStrategyRecreate, UpdateStrategySkipIfExists} {
+		if strategy != "" {
+			list.Items[i].Annotations = map[string]string{UpdateStrategyAnno: strategy}
+		}
+	}
+
+	var actions []string
+	deleted := false
+
+	c := newTestClient(t)
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			p, m := req.URL.Path, req.Method
+			actions = append(actions, p+":"+m)
+			t.Logf("got request %s %s", p, m)
+			switch {
+			case p == "/namespaces/default/pods" && m == "POST":
+				if deleted {
+					deleted = false
+					return newResponse(201, &list.Items[2])
+				}
+				return newResponse(409, &metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonAlreadyExists})
+			case p == "/namespaces/default/pods/replaced" && m == "GET":
+				return newResponse(200, &list.Items[1])
+			case p == "/namespaces/default/pods/replaced" && m == "PUT":
+				return newResponse(200, &list.Items[1])
+			case p == "/namespaces/default/pods/recreated" && m == "DELETE":
+				deleted = true
+				return newResponse(200, &list.Items[2])
+			case p == "/namespaces/default/pods/recreated" && m == "GET":
+				return newResponse(404, notFoundBody())
+			case p == "/namespaces/default/pods/skipped" && m == "GET":
+				return newResponse(200, &list.Items[3])
+			default:
+				t.Fatalf("unexpected request: %s %s", req.Method, req.URL.Path)
+				return nil, nil
+			}
+		}),
+	}
+	resources, err := c.Build(objBody(&list), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// existing resources without a strategy cannot be created
+	if _, err := c.Create(resources[:1]); err == nil || !apierrors.IsAlreadyExists(err) {
+		t.Errorf("expected an already exists error, got %v", err)
+	}
+	for _, info := range resources[1:] {
+		actions = nil
+		if _, err := c.Create(ResourceList{info}); err != nil {
+			t.Fatalf("failed to create %s: %s", info.Name, err)
+		}
+		expectedActions := map[string][]string{
+			"replaced":  {"/namespaces/default/pods:POST", "/namespaces/default/pods/replaced:GET", "/namespaces/default/pods/replaced:PUT"},
+			"recreated": {"/namespaces/default/pods:POST", "/namespaces/default/pods/recreated:DELETE", "/namespaces/default/pods/recreated:GET", "/namespaces/default/pods:POST"},
+			"skipped":   {"/namespaces/default/pods:POST", "/namespaces/default/pods/skipped:GET"},
+		}[info.Name]
+		if strings.Join(actions, " ") != strings.Join(expectedActions, " ") {
+			t.Errorf("expected requests %v for %s, got %v", expectedActions, info.Name, actions)
+		}
+	}
+}
+
+func TestServerDryRun(t *testing.T) {
+	listA := newPodList("starfish", "otter")
+	listB := newPodList("starfish", "otter", "dolphin", "whale")
+	listB.Items[0].Spec.Containers[0].Ports = []v1.ContainerPort{{Name: "https", ContainerPort: 443}}
+
+	var actions []string
+
+	c := newTestClient(t)
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			p, m := req.URL.Path, req.Method
+			actions = append(actions, p+":"+m)
+			if m != "GET" && req.URL.Query().Get("dryRun") != "All" {
+				t.Errorf("expected dryRun=All on %s %s, got %q", m, p, req.URL.RawQuery)
+			}
+			switch {
+			case p == "/namespaces/default/pods/starfish" && m == "GET":
+				return newResponse(200, &listA.Items[0])
+			case p == "/namespaces/default/pods/starfish" && m == "PATCH":
+				resp, err := newResponse(200, &listB.Items[0])
+				resp.Header.Add("Warning", `299 - "spec.containers[0].ports: deprecated"`)
+				return resp, err
+			case p == "/namespaces/default/pods/otter" && m == "GET":
+				return newResponse(200, &listA.Items[1])
+			case (p == "/namespaces/default/pods/dolphin" || p == "/namespaces/default/pods/whale") && m == "GET":
+				return newResponse(404, notFoundBody())
+			case p == "/namespaces/default/pods" && m == "POST":
+				data, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					t.Fatalf("could not dump request: %s", err)
+				}
+				req.Body.Close()
+				if strings.Contains(string(data), "whale") {
+					return newResponse(403, &metav1.Status{
+						Code:    http.StatusForbidden,
+						Status:  metav1.StatusFailure,
+						Reason:  metav1.StatusReasonForbidden,
+						Message: "admission webhook denied the request",
+					})
+				}
+				return newResponse(201, &listB.Items[2])
+			default:
+				t.Fatalf("unexpected request: %s %s", req.Method, req.URL.Path)
+				return nil, nil
+			}
+		}),
+	}
+	first, err := c.Build(objBody(&listA), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Build(objBody(&listB), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the warnings of the dry run do not go to the default handler
+	defaultHandler := &warningRecorder{}
+	rest.SetDefaultWarningHandler(defaultHandler)
+	defer rest.SetDefaultWarningHandler(rest.WarningLogger{})
+
+	result, err := c.ServerDryRun(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defaultHandler.warnings) != 0 {
+		t.Errorf("expected no warnings for the default handler, got %v", defaultHandler.warnings)
+	}
+
+	expectedOps := []string{DryRunPatch, DryRunUnchanged, DryRunCreate, DryRunCreate}
+	if len(result.Resources) != len(expectedOps) {
+		t.Fatalf("expected %d results, got %d", len(expectedOps), len(result.Resources))
+	}
+	for i, op := range expectedOps {
+		if result.Resources[i].Operation != op {
+			t.Errorf("expected %s for %s, got %s", op, result.Resources[i].Name, result.Resources[i].Operation)
+		}
+	}
+	if w := result.Warnings(); len(w) != 1 || !strings.Contains(w[0], "deprecated") {
+		t.Errorf("expected one deprecation warning, got %v", w)
+	}
+	failed := result.Failed()
+	if len(failed) != 1 || failed[0].Name != "whale" {
+		t.Fatalf("expected whale to be rejected, got %v", failed)
+	}
+	if err := result.Err(); err == nil || !strings.Contains(err.Error(), "admission webhook denied") {
+		t.Errorf("expected admission error, got %v", err)
+	}
+	for _, a := range actions {
+		if strings.HasSuffix(a, ":DELETE") {
+			t.Errorf("server dry run must not delete resources, got %s", a)
+		}
+	}
+}
+
 func TestBuild(t *testing.T) {
 	tests := []struct {
 		name      string
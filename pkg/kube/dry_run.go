@@ -0,0 +1,190 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube // import "github.com/huolunl/helm/v3/pkg/kube"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
+)
+
+// Operations reported for each resource of a server-side dry run.
+const (
+	DryRunCreate    = "create"
+	DryRunPatch     = "patch"
+	DryRunReplace   = "replace"
+	DryRunRecreate  = "recreate"
+	DryRunSkip      = "skip"
+	DryRunUnchanged = "unchanged"
+)
+
+// DryRunResourceResult is the outcome of a server-side dry run for a single resource.
+type DryRunResourceResult struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace,omitempty"`
+	Operation string   `json:"operation"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// DryRunResult contains the outcome of a server-side dry run for every resource.
+type DryRunResult struct {
+	Resources []DryRunResourceResult `json:"resources"`
+}
+
+// Failed returns the resources rejected by the API server.
+func (r *DryRunResult) Failed() []DryRunResourceResult {
+	var failed []DryRunResourceResult
+	for _, res := range r.Resources {
+		if res.Error != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Warnings returns every warning returned by the API server, prefixed with
+// the resource it applies to.
+func (r *DryRunResult) Warnings() []string {
+	var warnings []string
+	for _, res := range r.Resources {
+		for _, w := range res.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s %q: %s", res.Kind, res.Name, w))
+		}
+	}
+	return warnings
+}
+
+// Err returns an error describing every rejected resource, or nil if the
+// API server accepted all of them.
+func (r *DryRunResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(failed))
+	for _, res := range failed {
+		msgs = append(msgs, fmt.Sprintf("%s %q: %s", res.Kind, res.Name, res.Error))
+	}
+	return errors.Errorf("server dry run failed for %d resource(s): %s", len(failed), strings.Join(msgs, " && "))
+}
+
+// ServerDryRun sends the create or patch request that an update from
+// original to target would issue for every target resource, with dryRun=All
+// set so that nothing is persisted. Admission webhooks, quota and schema
+// validation run as they would for the real request.
+//
+// Resources annotated with an update strategy are dry run with the request
+// of that strategy; the creation of recreated resources cannot be dry run.
+//
+// Unlike Update, ServerDryRun does not stop at the first rejected resource;
+// per-resource errors and warnings are collected in the result. An error is
+// only returned if the dry run could not be performed at all.
+func (c *Client) ServerDryRun(original, target ResourceList) (*DryRunResult, error) {
+	c.Log("server dry run for %d resource(s)", len(target))
+	res := &DryRunResult{}
+	err := target.Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+		res.Resources = append(res.Resources, c.dryRunResource(info, original.Get(info)))
+		return nil
+	})
+	return res, err
+}
+
+// warningRecorder collects the warnings of the requests of a single resource.
+type warningRecorder struct {
+	warnings []string
+}
+
+func (w *warningRecorder) HandleWarningHeader(code int, _ string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	w.warnings = append(w.warnings, text)
+}
+
+func (c *Client) dryRunResource(target, original *resource.Info) DryRunResourceResult {
+	result := DryRunResourceResult{
+		Kind:      target.Mapping.GroupVersionKind.Kind,
+		Name:      target.Name,
+		Namespace: target.Namespace,
+	}
+
+	// The warnings are handled per request, leaving the default handler of
+	// the process alone.
+	recorder := &warningRecorder{}
+	client := resource.NewClientWithOptions(target.Client, func(req *rest.Request) {
+		req.WarningHandler(recorder)
+	})
+
+	helper := resource.NewHelper(client, target.Mapping).DryRun(true).WithFieldManager(getManagedFieldsManager())
+	current, err := helper.Get(target.Namespace, target.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+		result.Operation = DryRunCreate
+		_, err = helper.Create(target.Namespace, true, target.Object)
+	case err != nil:
+		err = errors.Wrap(err, "could not get information about the resource")
+	default:
+		strategy, serr := UpdateStrategy(target)
+		if serr != nil {
+			err = serr
+			break
+		}
+		if strategy == UpdateStrategySkipIfExists {
+			result.Operation = DryRunSkip
+			break
+		}
+		result.Operation = DryRunPatch
+		if original != nil {
+			current = original.Object
+		}
+		patch, patchType, perr := createPatch(target, current)
+		if perr != nil {
+			err = errors.Wrap(perr, "failed to create patch")
+			break
+		}
+		if patch == nil || string(patch) == "{}" {
+			result.Operation = DryRunUnchanged
+			break
+		}
+		switch strategy {
+		case UpdateStrategyReplace:
+			result.Operation = DryRunReplace
+			_, err = helper.Replace(target.Namespace, target.Name, true, target.Object)
+		case UpdateStrategyRecreate:
+			// the creation cannot be dry run while the resource exists
+			result.Operation = DryRunRecreate
+			_, err = helper.DeleteWithOptions(target.Namespace, target.Name, nil)
+		default:
+			_, err = helper.Patch(target.Namespace, target.Name, patchType, patch, nil)
+		}
+	}
+
+	result.Warnings = recorder.warnings
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -40,6 +40,7 @@ type FailingKubeClient struct {
 	BuildError                       error
 	BuildUnstructuredError           error
 	WaitAndGetCompletedPodPhaseError error
+	ServerDryRunError                error
 }
 
 // Create returns the configured error if set or prints
@@ -105,3 +106,11 @@ func (f *FailingKubeClient) WaitAndGetCompletedPodPhase(s string, d time.Duratio
 	}
 	return f.PrintingKubeClient.WaitAndGetCompletedPodPhase(s, d)
 }
+
+// ServerDryRun returns the configured error if set or prints
+func (f *FailingKubeClient) ServerDryRun(original, target kube.ResourceList) (*kube.DryRunResult, error) {
+	if f.ServerDryRunError != nil {
+		return nil, f.ServerDryRunError
+	}
+	return f.PrintingKubeClient.ServerDryRun(original, target)
+}
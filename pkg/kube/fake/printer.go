@@ -96,6 +96,29 @@ func (p *PrintingKubeClient) WaitAndGetCompletedPodPhase(_ string, _ time.Durati
 	return v1.PodSucceeded, nil
 }
 
+// ServerDryRun implements KubeClient ServerDryRun.
+//
+// It prints the target resources and reports every one of them as accepted.
+func (p *PrintingKubeClient) ServerDryRun(original, target kube.ResourceList) (*kube.DryRunResult, error) {
+	_, err := io.Copy(p.Out, bufferize(target))
+	if err != nil {
+		return nil, err
+	}
+	res := &kube.DryRunResult{}
+	for _, info := range target {
+		op := kube.DryRunCreate
+		if original.Get(info) != nil {
+			op = kube.DryRunPatch
+		}
+		res.Resources = append(res.Resources, kube.DryRunResourceResult{
+			Name:      info.Name,
+			Namespace: info.Namespace,
+			Operation: op,
+		})
+	}
+	return res, nil
+}
+
 func bufferize(resources kube.ResourceList) io.Reader {
 	var builder strings.Builder
 	for _, info := range resources {
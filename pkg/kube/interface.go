@@ -71,3 +71,13 @@ type Interface interface {
 }
 
 var _ Interface = (*Client)(nil)
+
+// ServerDryRunner is implemented by clients that can validate resources
+// against the API server without persisting them.
+type ServerDryRunner interface {
+	// ServerDryRun sends the requests needed to update original to target
+	// with dryRun=All and reports the outcome for every resource.
+	ServerDryRun(original, target ResourceList) (*DryRunResult, error)
+}
+
+var _ ServerDryRunner = (*Client)(nil)
@@ -0,0 +1,177 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube // import "github.com/huolunl/helm/v3/pkg/kube"
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// PhaseAnno is the annotation name for the phase a resource is applied in.
+//
+// Phases are integers applied in ascending order; resources without the
+// annotation belong to phase 0. Helm waits for the resources of a phase to
+// be ready before applying the next one, and deletes phases in reverse order.
+const PhaseAnno = "helm.sh/phase"
+
+// DependsOnAnno is the annotation name for the resources of the release a
+// resource depends on, as a comma-separated list of Kind/name references.
+// A resource is applied in a later phase than all of its dependencies.
+const DependsOnAnno = "helm.sh/depends-on"
+
+// Phases groups resources into the ordered phases selected by their
+// PhaseAnno and DependsOnAnno annotations. The order of the resources is
+// preserved within a phase. Resources without these annotations all belong
+// to a single phase.
+//
+// An error is returned if an annotation is malformed, references a resource
+// that is not part of resources, or if dependencies form a cycle.
+func Phases(resources ResourceList) ([]ResourceList, error) {
+	if len(resources) == 0 {
+		return nil, nil
+	}
+
+	p := &phaser{
+		resources: resources,
+		phases:    make(map[*resource.Info]int, len(resources)),
+		visiting:  make(map[*resource.Info]bool),
+	}
+	byPhase := map[int]ResourceList{}
+	for _, info := range resources {
+		phase, err := p.phase(info)
+		if err != nil {
+			return nil, err
+		}
+		byPhase[phase] = append(byPhase[phase], info)
+	}
+
+	order := make([]int, 0, len(byPhase))
+	for phase := range byPhase {
+		order = append(order, phase)
+	}
+	sort.Ints(order)
+	phases := make([]ResourceList, 0, len(order))
+	for _, phase := range order {
+		phases = append(phases, byPhase[phase])
+	}
+	return phases, nil
+}
+
+// phaser computes the phases of resources, memoizing the ones computed.
+type phaser struct {
+	resources ResourceList
+	phases    map[*resource.Info]int
+	visiting  map[*resource.Info]bool
+}
+
+// phase returns the phase of info: the phase of its annotation, or the one
+// following the latest phase of its dependencies if it is later.
+func (p *phaser) phase(info *resource.Info) (int, error) {
+	if phase, ok := p.phases[info]; ok {
+		return phase, nil
+	}
+	if p.visiting[info] {
+		return 0, errors.Errorf("%s has a circular dependency", resourceRef(info))
+	}
+	p.visiting[info] = true
+	defer delete(p.visiting, info)
+
+	annotations, err := metadataAccessor.Annotations(info.Object)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to get annotations on %s", resourceRef(info))
+	}
+
+	phase := 0
+	if v, ok := annotations[PhaseAnno]; ok {
+		if phase, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+			return 0, errors.Errorf("invalid value %q for annotation %s on %s, must be an integer", v, PhaseAnno, resourceRef(info))
+		}
+	}
+	for _, ref := range strings.Split(annotations[DependsOnAnno], ",") {
+		if ref = strings.TrimSpace(ref); ref == "" {
+			continue
+		}
+		dep, err := p.lookup(ref)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid annotation %s on %s", DependsOnAnno, resourceRef(info))
+		}
+		depPhase, err := p.phase(dep)
+		if err != nil {
+			return 0, err
+		}
+		if depPhase >= phase {
+			phase = depPhase + 1
+		}
+	}
+
+	p.phases[info] = phase
+	return phase, nil
+}
+
+// lookup returns the resource named by a Kind/name reference.
+func (p *phaser) lookup(ref string) (*resource.Info, error) {
+	i := strings.Index(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return nil, errors.Errorf("reference %q must have the form Kind/name", ref)
+	}
+	kind, name := ref[:i], ref[i+1:]
+	for _, info := range p.resources {
+		if info.Name == name && info.Mapping.GroupVersionKind.Kind == kind {
+			return info, nil
+		}
+	}
+	return nil, errors.Errorf("%s is not part of the release", ref)
+}
+
+func resourceRef(info *resource.Info) string {
+	return info.Mapping.GroupVersionKind.Kind + "/" + info.Name
+}
+
+// DeletionWaiter is implemented by clients that can wait for deleted
+// resources to be gone.
+type DeletionWaiter interface {
+	// WaitForDelete waits up to the given timeout for the specified
+	// resources to no longer exist.
+	WaitForDelete(resources ResourceList, timeout time.Duration) error
+}
+
+var _ DeletionWaiter = (*Client)(nil)
+
+// WaitForDelete waits up to the given timeout for the specified resources to
+// no longer exist, e.g. once their finalizers have run.
+func (c *Client) WaitForDelete(resources ResourceList, timeout time.Duration) error {
+	c.Log("beginning wait for deletion of %d resources with timeout of %v", len(resources), timeout)
+	return wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		for _, info := range resources {
+			_, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name)
+			if err == nil {
+				return false, nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,138 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+func phaseTestInfo(kind, name string, annotations map[string]string) *resource.Info {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetAnnotations(annotations)
+	return &resource.Info{
+		Name:      name,
+		Namespace: "default",
+		Mapping:   &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Kind: kind}},
+		Object:    obj,
+	}
+}
+
+func phaseNames(phases []ResourceList) string {
+	var s []string
+	for _, phase := range phases {
+		var names []string
+		for _, info := range phase {
+			names = append(names, info.Name)
+		}
+		s = append(s, strings.Join(names, ","))
+	}
+	return strings.Join(s, " | ")
+}
+
+func TestPhases(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources ResourceList
+		expect    string
+		err       string
+	}{
+		{
+			name: "no annotations",
+			resources: ResourceList{
+				phaseTestInfo("Secret", "creds", nil),
+				phaseTestInfo("Deployment", "app", nil),
+			},
+			expect: "creds,app",
+		},
+		{
+			name: "phase annotations",
+			resources: ResourceList{
+				phaseTestInfo("Deployment", "app", map[string]string{PhaseAnno: "2"}),
+				phaseTestInfo("Secret", "creds", nil),
+				phaseTestInfo("Job", "migrate", map[string]string{PhaseAnno: "1"}),
+				phaseTestInfo("Service", "db", map[string]string{PhaseAnno: "-1"}),
+			},
+			expect: "db | creds | migrate | app",
+		},
+		{
+			name: "dependencies",
+			resources: ResourceList{
+				phaseTestInfo("Deployment", "app", map[string]string{DependsOnAnno: "Job/migrate, Service/db"}),
+				phaseTestInfo("Job", "migrate", map[string]string{DependsOnAnno: "StatefulSet/db"}),
+				phaseTestInfo("StatefulSet", "db", nil),
+				phaseTestInfo("Service", "db", nil),
+				phaseTestInfo("ConfigMap", "late", map[string]string{PhaseAnno: "1"}),
+			},
+			expect: "db,db | migrate,late | app",
+		},
+		{
+			name: "invalid phase",
+			resources: ResourceList{
+				phaseTestInfo("Job", "migrate", map[string]string{PhaseAnno: "first"}),
+			},
+			err: `invalid value "first" for annotation helm.sh/phase on Job/migrate`,
+		},
+		{
+			name: "unknown dependency",
+			resources: ResourceList{
+				phaseTestInfo("Job", "migrate", map[string]string{DependsOnAnno: "StatefulSet/db"}),
+			},
+			err: "StatefulSet/db is not part of the release",
+		},
+		{
+			name: "malformed dependency",
+			resources: ResourceList{
+				phaseTestInfo("Job", "migrate", map[string]string{DependsOnAnno: "db"}),
+			},
+			err: `reference "db" must have the form Kind/name`,
+		},
+		{
+			name: "circular dependency",
+			resources: ResourceList{
+				phaseTestInfo("Job", "a", map[string]string{DependsOnAnno: "Job/b"}),
+				phaseTestInfo("Job", "b", map[string]string{DependsOnAnno: "Job/a"}),
+			},
+			err: "circular dependency",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phases, err := Phases(tt.resources)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := phaseNames(phases); got != tt.expect {
+				t.Errorf("expected phases %q, got %q", tt.expect, got)
+			}
+		})
+	}
+}
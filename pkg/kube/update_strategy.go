@@ -0,0 +1,96 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube // import "github.com/huolunl/helm/v3/pkg/kube"
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// UpdateStrategyAnno is the annotation name for the strategy used to update
+// a resource that already exists in the cluster.
+const UpdateStrategyAnno = "helm.sh/update-strategy"
+
+// Update strategies of the UpdateStrategyAnno annotation.
+const (
+	// UpdateStrategyMerge patches the resource with a three-way merge, even
+	// when the update is forced. It is the default for resources without
+	// the annotation.
+	UpdateStrategyMerge = "merge"
+	// UpdateStrategyReplace replaces the resource with a PUT request.
+	UpdateStrategyReplace = "replace"
+	// UpdateStrategyRecreate deletes the resource and creates it again when
+	// it was changed, e.g. for Jobs whose spec is immutable.
+	UpdateStrategyRecreate = "recreate"
+	// UpdateStrategySkipIfExists creates the resource if it is missing and
+	// never modifies it afterwards, e.g. for bootstrap Secrets.
+	UpdateStrategySkipIfExists = "skip-if-exists"
+)
+
+// recreateTimeout bounds the wait for the deletion of a resource recreated
+// by the UpdateStrategyRecreate strategy.
+var recreateTimeout = 2 * time.Minute
+
+// UpdateStrategy returns the strategy the UpdateStrategyAnno annotation of
+// info selects, or an empty string if it has none.
+func UpdateStrategy(info *resource.Info) (string, error) {
+	annotations, err := metadataAccessor.Annotations(info.Object)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get annotations on %q", info.Name)
+	}
+	strategy := annotations[UpdateStrategyAnno]
+	if err := ValidateUpdateStrategy(strategy); err != nil {
+		return "", errors.Wrapf(err, "%s %q", info.Mapping.GroupVersionKind.Kind, info.Name)
+	}
+	return strategy, nil
+}
+
+// ValidateUpdateStrategy returns an error if strategy is not a valid value
+// of the UpdateStrategyAnno annotation. An empty strategy is valid.
+func ValidateUpdateStrategy(strategy string) error {
+	switch strategy {
+	case "", UpdateStrategyMerge, UpdateStrategyReplace, UpdateStrategyRecreate, UpdateStrategySkipIfExists:
+		return nil
+	}
+	return errors.Errorf("invalid value %q for annotation %s, must be one of %s, %s, %s or %s",
+		strategy, UpdateStrategyAnno,
+		UpdateStrategyMerge, UpdateStrategyReplace, UpdateStrategyRecreate, UpdateStrategySkipIfExists)
+}
+
+// recreateResource deletes the resource described by target and creates it
+// again once the deletion has completed.
+func recreateResource(target *resource.Info) error {
+	if err := deleteResource(target); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete object")
+	}
+	helper := resource.NewHelper(target.Client, target.Mapping)
+	err := wait.PollImmediate(time.Second, recreateTimeout, func() (bool, error) {
+		_, err := helper.Get(target.Namespace, target.Name)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to wait for the deletion of the object")
+	}
+	return errors.Wrap(createResource(target), "failed to create object")
+}
@@ -25,13 +25,19 @@ import (
 
 // All runs all of the available linters on the given base directory.
 func All(basedir string, values map[string]interface{}, namespace string, strict bool) support.Linter {
+	return AllWithOptions(basedir, values, namespace, strict, rules.TemplateOptions{})
+}
+
+// AllWithOptions runs all of the available linters on the given base
+// directory, rendering the templates with the given options.
+func AllWithOptions(basedir string, values map[string]interface{}, namespace string, strict bool, opts rules.TemplateOptions) support.Linter {
 	// Using abs path to get directory context
 	chartDir, _ := filepath.Abs(basedir)
 
 	linter := support.Linter{ChartDir: chartDir}
 	rules.Chartfile(&linter)
 	rules.ValuesWithOverrides(&linter, values)
-	rules.Templates(&linter, values, namespace, strict)
+	rules.TemplatesWithOptions(&linter, values, namespace, strict, opts)
 	rules.Dependencies(&linter)
 	return linter
 }
@@ -25,6 +25,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -36,6 +37,7 @@ import (
 	"github.com/huolunl/helm/v3/pkg/chart/loader"
 	"github.com/huolunl/helm/v3/pkg/chartutil"
 	"github.com/huolunl/helm/v3/pkg/engine"
+	"github.com/huolunl/helm/v3/pkg/kube"
 	"github.com/huolunl/helm/v3/pkg/lint/support"
 )
 
@@ -46,6 +48,22 @@ var (
 
 // Templates lints the templates in the Linter.
 func Templates(linter *support.Linter, values map[string]interface{}, namespace string, strict bool) {
+	TemplatesWithOptions(linter, values, namespace, strict, TemplateOptions{})
+}
+
+// TemplateOptions changes how the templates are rendered for linting.
+type TemplateOptions struct {
+	// Capabilities replace the default capabilities, for example with a
+	// snapshot of a cluster.
+	Capabilities *chartutil.Capabilities
+	// LookupSource provides the objects returned by the lookup function,
+	// which otherwise returns nothing when linting.
+	LookupSource engine.LookupSource
+}
+
+// TemplatesWithOptions lints the templates in the Linter, rendering them
+// with the given options.
+func TemplatesWithOptions(linter *support.Linter, values map[string]interface{}, namespace string, strict bool, opts TemplateOptions) {
 	fpath := "templates/"
 	templatesPath := filepath.Join(linter.ChartDir, fpath)
 
@@ -74,13 +92,14 @@ func Templates(linter *support.Linter, values map[string]interface{}, namespace
 	if err != nil {
 		return
 	}
-	valuesToRender, err := chartutil.ToRenderValues(chart, cvals, options, nil)
+	valuesToRender, err := chartutil.ToRenderValues(chart, cvals, options, opts.Capabilities)
 	if err != nil {
 		linter.RunLinterRule(support.ErrorSev, fpath, err)
 		return
 	}
 	var e engine.Engine
 	e.LintMode = true
+	e.LookupSource = opts.LookupSource
 	renderedContentMap, err := e.Render(chart, valuesToRender)
 
 	renderOk := linter.RunLinterRule(support.ErrorSev, fpath, err)
@@ -146,6 +165,8 @@ func Templates(linter *support.Linter, values map[string]interface{}, namespace
 					linter.RunLinterRule(support.WarningSev, fpath, validateNoDeprecations(yamlStruct))
 
 					linter.RunLinterRule(support.ErrorSev, fpath, validateMatchSelector(yamlStruct, renderedContent))
+					linter.RunLinterRule(support.ErrorSev, fpath, validateUpdateStrategy(yamlStruct))
+					linter.RunLinterRule(support.ErrorSev, fpath, validatePhase(yamlStruct))
 				}
 			}
 		}
@@ -294,6 +315,36 @@ func validateMatchSelector(yamlStruct *K8sYamlStruct, manifest string) error {
 	return nil
 }
 
+// validateUpdateStrategy ensures that the update strategy annotation of a
+// resource, if any, names a known strategy.
+func validateUpdateStrategy(yamlStruct *K8sYamlStruct) error {
+	if err := kube.ValidateUpdateStrategy(yamlStruct.Metadata.Annotations[kube.UpdateStrategyAnno]); err != nil {
+		return fmt.Errorf("%s %q: %s", yamlStruct.Kind, yamlStruct.Metadata.Name, err)
+	}
+	return nil
+}
+
+// validatePhase ensures that the phase annotation of a resource is an integer
+// and that its dependencies are Kind/name references.
+func validatePhase(yamlStruct *K8sYamlStruct) error {
+	annotations := yamlStruct.Metadata.Annotations
+	if v, ok := annotations[kube.PhaseAnno]; ok {
+		if _, err := strconv.Atoi(strings.TrimSpace(v)); err != nil {
+			return fmt.Errorf("%s %q: annotation %s must be an integer, got %q", yamlStruct.Kind, yamlStruct.Metadata.Name, kube.PhaseAnno, v)
+		}
+	}
+	for _, ref := range strings.Split(annotations[kube.DependsOnAnno], ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		if i := strings.Index(ref, "/"); i <= 0 || i == len(ref)-1 {
+			return fmt.Errorf("%s %q: annotation %s must list Kind/name references, got %q", yamlStruct.Kind, yamlStruct.Metadata.Name, kube.DependsOnAnno, ref)
+		}
+	}
+	return nil
+}
+
 // K8sYamlStruct stubs a Kubernetes YAML file.
 //
 // DEPRECATED: In Helm 4, this will be made a private type, as it is for use only within
@@ -305,6 +356,7 @@ type K8sYamlStruct struct {
 }
 
 type k8sYamlMetadata struct {
-	Namespace string
-	Name      string
+	Namespace   string
+	Name        string
+	Annotations map[string]string
 }
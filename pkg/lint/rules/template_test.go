@@ -374,6 +374,50 @@ spec:
 	}
 }
 
+func TestValidateUpdateStrategy(t *testing.T) {
+	md := &K8sYamlStruct{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: k8sYamlMetadata{
+			Name:        "migrate",
+			Annotations: map[string]string{"helm.sh/update-strategy": "recreate"},
+		},
+	}
+	if err := validateUpdateStrategy(md); err != nil {
+		t.Error(err)
+	}
+	md.Metadata.Annotations = nil
+	if err := validateUpdateStrategy(md); err != nil {
+		t.Error(err)
+	}
+	md.Metadata.Annotations = map[string]string{"helm.sh/update-strategy": "overwrite"}
+	if err := validateUpdateStrategy(md); err == nil {
+		t.Error("expected an unknown update strategy to fail")
+	}
+}
+
+func TestValidatePhase(t *testing.T) {
+	md := &K8sYamlStruct{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata: k8sYamlMetadata{
+			Name:        "app",
+			Annotations: map[string]string{"helm.sh/phase": "2", "helm.sh/depends-on": "Job/migrate, StatefulSet/db"},
+		},
+	}
+	if err := validatePhase(md); err != nil {
+		t.Error(err)
+	}
+	md.Metadata.Annotations = map[string]string{"helm.sh/phase": "last"}
+	if err := validatePhase(md); err == nil {
+		t.Error("expected a non-integer phase to fail")
+	}
+	md.Metadata.Annotations = map[string]string{"helm.sh/depends-on": "migrate"}
+	if err := validatePhase(md); err == nil {
+		t.Error("expected a malformed dependency to fail")
+	}
+}
+
 func TestValidateTopIndentLevel(t *testing.T) {
 	for doc, shouldFail := range map[string]bool{
 		// Should not fail
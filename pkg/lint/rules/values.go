@@ -49,7 +49,12 @@ func ValuesWithOverrides(linter *support.Linter, values map[string]interface{})
 		return
 	}
 
-	linter.RunLinterRule(support.ErrorSev, file, validateValuesFile(vf, values))
+	if !linter.RunLinterRule(support.ErrorSev, file, validateValuesFile(vf, values)) {
+		return
+	}
+	for _, err := range validateValuesSchemaDrift(vf) {
+		linter.RunLinterRule(support.WarningSev, file, err)
+	}
 }
 
 func validateValuesFileExistence(valuesPath string) error {
@@ -83,5 +88,38 @@ func validateValuesFile(valuesPath string, overrides map[string]interface{}) err
 	if err != nil {
 		return err
 	}
-	return chartutil.ValidateAgainstSingleSchema(coalescedValues, schema)
+	chartDir := filepath.Dir(valuesPath)
+	return chartutil.ValidateAgainstSchemaFiles(coalescedValues, schema, func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(chartDir, filepath.FromSlash(name)))
+	})
+}
+
+// validateValuesSchemaDrift reports the values of values.yaml that the schema
+// does not declare, as when the schema was not regenerated after a change.
+func validateValuesSchemaDrift(valuesPath string) []error {
+	ext := filepath.Ext(valuesPath)
+	schemaPath := valuesPath[:len(valuesPath)-len(ext)] + ".schema.json"
+	schema, err := ioutil.ReadFile(schemaPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []error{err}
+	}
+	if len(schema) == 0 {
+		return nil
+	}
+	values, err := chartutil.ReadValuesFile(valuesPath)
+	if err != nil {
+		return []error{err}
+	}
+	drift, err := chartutil.ValuesSchemaDrift(values, schema)
+	if err != nil {
+		return []error{err}
+	}
+	errs := make([]error, len(drift))
+	for i, key := range drift {
+		errs[i] = errors.Errorf("%s is not declared in %s", key, filepath.Base(schemaPath))
+	}
+	return errs
 }
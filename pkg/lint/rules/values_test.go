@@ -165,6 +165,62 @@ func TestValidateValuesFile(t *testing.T) {
 	}
 }
 
+func TestValidateValuesSchemaDrift(t *testing.T) {
+	yaml := "username: admin\npassword: swordfish\nimage:\n  tag: latest\n"
+	tmpdir := ensure.TempFile(t, "values.yaml", []byte(yaml))
+	defer os.RemoveAll(tmpdir)
+	valfile := filepath.Join(tmpdir, "values.yaml")
+
+	assert.Empty(t, validateValuesSchemaDrift(valfile), "no schema, no drift")
+
+	schema := `{"type": "object", "properties": {"username": {"type": "string"}, "image": {"type": "object", "properties": {}}}}`
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "values.schema.json"), []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := validateValuesSchemaDrift(valfile)
+	if assert.Len(t, errs, 2) {
+		assert.EqualError(t, errs[0], "image.tag is not declared in values.schema.json")
+		assert.EqualError(t, errs[1], "password is not declared in values.schema.json")
+	}
+}
+
+func TestValidateValuesSchemaDriftReadError(t *testing.T) {
+	tmpdir := ensure.TempFile(t, "values.yaml", []byte("username: admin\n"))
+	defer os.RemoveAll(tmpdir)
+
+	// A directory cannot be read as a schema file.
+	if err := os.Mkdir(filepath.Join(tmpdir, "values.schema.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	errs := validateValuesSchemaDrift(filepath.Join(tmpdir, "values.yaml"))
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].Error(), "values.schema.json")
+	}
+}
+
+func TestValidateValuesFileSchemaRef(t *testing.T) {
+	yaml := "username: admin\npassword: 1234"
+	tmpdir := ensure.TempFile(t, "values.yaml", []byte(yaml))
+	defer os.RemoveAll(tmpdir)
+
+	schema := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "$ref": "schemas/credentials.json"}`
+	credentials := `{"properties": {"password": {"type": "string"}}}`
+	if err := os.Mkdir(filepath.Join(tmpdir, "schemas"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "values.schema.json"), []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "schemas", "credentials.json"), []byte(credentials), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := validateValuesFile(filepath.Join(tmpdir, "values.yaml"), map[string]interface{}{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "password: Invalid type. Expected: string, given: integer")
+	}
+}
+
 func createTestingSchema(t *testing.T, dir string) string {
 	t.Helper()
 	schemafile := filepath.Join(dir, "values.schema.json")
@@ -43,6 +43,18 @@ type Downloaders struct {
 	Command string `json:"command"`
 }
 
+// StorageDriver represents the plugins capability if it can store
+// releases, selected with HELM_DRIVER.
+type StorageDriver struct {
+	// Name is the value of HELM_DRIVER selecting the driver.
+	Name string `json:"name"`
+	// Command is the executable path with which the plugin performs the
+	// storage operations, speaking the protocol of driver.Plugin, and its
+	// arguments. Environment variables such as $HELM_PLUGIN_DIR are
+	// expanded, and a relative path is relative to the plugin directory.
+	Command string `json:"command"`
+}
+
 // PlatformCommand represents a command for a particular operating system and architecture
 type PlatformCommand struct {
 	OperatingSystem string `json:"os"`
@@ -98,6 +110,10 @@ type Metadata struct {
 	// for special protocols.
 	Downloaders []Downloaders `json:"downloaders"`
 
+	// StorageDrivers field is used if the plugin supply release storage
+	// drivers.
+	StorageDrivers []StorageDriver `json:"storageDrivers"`
+
 	// UseTunnelDeprecated indicates that this command needs a tunnel.
 	// Setting this will cause a number of side effects, such as the
 	// automatic setting of HELM_HOST.
@@ -0,0 +1,62 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+// Redaction holds the parts of a release with placeholders in place of its
+// secrets, which are stored and printed instead of the release's.
+type Redaction struct {
+	// Config, if not nil, are the redacted user-supplied values.
+	Config map[string]interface{}
+	// Manifest is the redacted manifest.
+	Manifest string
+	// Notes are the redacted notes.
+	Notes string
+	// Hooks are the redacted manifests of the hooks, in the order of the
+	// hooks of the release.
+	Hooks []string
+}
+
+// Redacted returns a copy of the release with the values, manifest, hooks and
+// notes of its redaction. The release itself is returned if it has no redaction.
+func (r *Release) Redacted() *Release {
+	if r == nil || r.Redaction == nil {
+		return r
+	}
+	rel := *r
+	rel.Redaction = nil
+	if r.Redaction.Config != nil {
+		rel.Config = r.Redaction.Config
+	}
+	rel.Manifest = r.Redaction.Manifest
+	if r.Info != nil {
+		info := *r.Info
+		info.Notes = r.Redaction.Notes
+		rel.Info = &info
+	}
+	if r.Hooks != nil {
+		rel.Hooks = make([]*Hook, len(r.Hooks))
+		for i, h := range r.Hooks {
+			hook := *h
+			hook.Manifest = ""
+			if i < len(r.Redaction.Hooks) {
+				hook.Manifest = r.Redaction.Hooks[i]
+			}
+			rel.Hooks[i] = &hook
+		}
+	}
+	return &rel
+}
@@ -29,6 +29,9 @@ type Release struct {
 	// Config is the set of extra Values added to the chart.
 	// These values override the default values inside of the chart.
 	Config map[string]interface{} `json:"config,omitempty"`
+	// ValuesLayers are the values layers of the environment merged into
+	// Config, in order.
+	ValuesLayers []ValuesLayer `json:"values_layers,omitempty"`
 	// Manifest is the string representation of the rendered template.
 	Manifest string `json:"manifest,omitempty"`
 	// Hooks are all of the hooks declared for this release.
@@ -40,6 +43,18 @@ type Release struct {
 	// Labels of the release.
 	// Disabled encoding into Json cause labels are stored in storage driver metadata field.
 	Labels map[string]string `json:"-"`
+	// StorageVersion is an opaque token identifying the version of the stored
+	// record the release was read from. Storage drivers supporting optimistic
+	// concurrency reject updates of a release whose token is no longer the
+	// one of the stored record. It is never encoded with the release.
+	StorageVersion string `json:"-"`
+	// Redaction, if set, is stored and printed in place of the parts of the
+	// release containing secrets. It is never encoded with the release.
+	Redaction *Redaction `json:"-"`
+	// Unrestorable is set when the secrets of the stored release cannot be
+	// restored from their placeholders, as when it uses values decrypted
+	// from encrypted values files. Such a release cannot be rolled back to.
+	Unrestorable bool `json:"unrestorable,omitempty"`
 }
 
 // SetStatus is a helper for setting the status on a release.
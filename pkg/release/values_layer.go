@@ -0,0 +1,30 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+// ValuesLayer is a values layer of an environment merged into the values of
+// a release.
+type ValuesLayer struct {
+	// Name is the name of the layer, such as base, env, region or cluster.
+	Name string `json:"name"`
+	// Files are the values files of the layer, in the order they were merged.
+	Files []string `json:"files,omitempty"`
+	// Lists is the merge strategy of the lists of the layer.
+	Lists string `json:"lists,omitempty"`
+	// Maps is the merge strategy of the maps of the layer.
+	Maps string `json:"maps,omitempty"`
+}
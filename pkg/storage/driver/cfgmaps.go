@@ -34,6 +34,8 @@ import (
 )
 
 var _ Driver = (*ConfigMaps)(nil)
+var _ KeyLister = (*ConfigMaps)(nil)
+var _ Appender = (*ConfigMaps)(nil)
 
 // ConfigMapsDriverName is the string name of the driver.
 const ConfigMapsDriverName = "ConfigMap"
@@ -43,14 +45,23 @@ const ConfigMapsDriverName = "ConfigMap"
 type ConfigMaps struct {
 	impl corev1.ConfigMapInterface
 	Log  func(string, ...interface{})
+
+	// ChunkSize is the maximum size in bytes of the encoded release stored
+	// in a single ConfigMap. Larger releases are split across several linked
+	// ConfigMaps. A value of zero or less disables chunking.
+	ChunkSize int
+	// Encryption encrypts the stored releases when set. Releases stored
+	// unencrypted can still be read.
+	Encryption KeyProvider
 }
 
 // NewConfigMaps initializes a new ConfigMaps wrapping an implementation of
 // the kubernetes ConfigMapsInterface.
 func NewConfigMaps(impl corev1.ConfigMapInterface) *ConfigMaps {
 	return &ConfigMaps{
-		impl: impl,
-		Log:  func(_ string, _ ...interface{}) {},
+		impl:      impl,
+		Log:       func(_ string, _ ...interface{}) {},
+		ChunkSize: DefaultChunkSize,
 	}
 }
 
@@ -72,12 +83,19 @@ func (cfgmaps *ConfigMaps) Get(key string) (*rspb.Release, error) {
 		cfgmaps.Log("get: failed to get %q: %s", key, err)
 		return nil, err
 	}
-	// found the configmap, decode the base64 data string
-	r, err := decodeRelease(obj.Data["release"])
+	// found the configmap, collect the chunks and decode the base64 data string
+	data, err := cfgmaps.releaseData(obj)
+	if err != nil {
+		cfgmaps.Log("get: failed to get %q: %s", key, err)
+		return nil, err
+	}
+	r, err := decodeRelease(data)
 	if err != nil {
 		cfgmaps.Log("get: failed to decode data %q: %s", key, err)
 		return nil, err
 	}
+	r.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	r.StorageVersion = obj.ResourceVersion
 	// return the release object
 	return r, nil
 }
@@ -99,14 +117,21 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 
 	// iterate over the configmaps object list
 	// and decode each release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(item.Data["release"])
+	for i := range list.Items {
+		item := &list.Items[i]
+		data, err := cfgmaps.releaseData(item)
+		if err != nil {
+			cfgmaps.Log("list: failed to read release %q: %s", item.Name, err)
+			continue
+		}
+		rls, err := decodeRelease(data)
 		if err != nil {
 			cfgmaps.Log("list: failed to decode release: %v: %s", item, err)
 			continue
 		}
 
-		rls.Labels = item.ObjectMeta.Labels
+		rls.Labels = filterSystemLabels(item.ObjectMeta.Labels)
+		rls.StorageVersion = item.ResourceVersion
 
 		if filter(rls) {
 			results = append(results, rls)
@@ -115,6 +140,24 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 	return results, nil
 }
 
+// Keys returns the names of the ConfigMaps holding releases, whether or not
+// the releases can be decoded.
+func (cfgmaps *ConfigMaps) Keys() ([]string, error) {
+	lsel := kblabels.Set{"owner": "helm"}.AsSelector()
+	opts := metav1.ListOptions{LabelSelector: lsel.String()}
+
+	list, err := cfgmaps.impl.List(context.Background(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "keys: failed to list")
+	}
+
+	keys := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		keys = append(keys, item.Name)
+	}
+	return keys, nil
+}
+
 // Query fetches all releases that match the provided map of labels.
 // An error is returned if the configmap fails to retrieve the releases.
 func (cfgmaps *ConfigMaps) Query(labels map[string]string) ([]*rspb.Release, error) {
@@ -139,12 +182,20 @@ func (cfgmaps *ConfigMaps) Query(labels map[string]string) ([]*rspb.Release, err
 	}
 
 	var results []*rspb.Release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(item.Data["release"])
+	for i := range list.Items {
+		item := &list.Items[i]
+		data, err := cfgmaps.releaseData(item)
+		if err != nil {
+			cfgmaps.Log("query: failed to read release %q: %s", item.Name, err)
+			continue
+		}
+		rls, err := decodeRelease(data)
 		if err != nil {
 			cfgmaps.Log("query: failed to decode release: %s", err)
 			continue
 		}
+		rls.Labels = filterSystemLabels(item.ObjectMeta.Labels)
+		rls.StorageVersion = item.ResourceVersion
 		results = append(results, rls)
 	}
 	return results, nil
@@ -165,8 +216,20 @@ func (cfgmaps *ConfigMaps) Create(key string, rls *rspb.Release) error {
 		cfgmaps.Log("create: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	if err := cfgmaps.encrypt(obj, rls.Namespace); err != nil {
+		cfgmaps.Log("create: failed to encrypt release %q: %s", rls.Name, err)
+		return err
+	}
+	chunks := cfgmaps.chunk(obj)
+	if err := cfgmaps.writeChunks(chunks); err != nil {
+		cfgmaps.deleteChunks(chunks)
+		cfgmaps.Log("create: failed to create chunks: %s", err)
+		return err
+	}
 	// push the configmap object out into the kubiverse
-	if _, err := cfgmaps.impl.Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+	created, err := cfgmaps.impl.Create(context.Background(), obj, metav1.CreateOptions{})
+	if err != nil {
+		cfgmaps.deleteChunks(chunks)
 		if apierrors.IsAlreadyExists(err) {
 			return ErrReleaseExists
 		}
@@ -174,11 +237,24 @@ func (cfgmaps *ConfigMaps) Create(key string, rls *rspb.Release) error {
 		cfgmaps.Log("create: failed to create: %s", err)
 		return err
 	}
+	rls.StorageVersion = created.ResourceVersion
 	return nil
 }
 
+// Append creates a new ConfigMap holding the record data of kind.
+func (cfgmaps *ConfigMaps) Append(kind, key string, labels map[string]string, data []byte) error {
+	obj := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: key, Labels: labels},
+		Data:       map[string]string{kind: string(data)},
+	}
+	_, err := cfgmaps.impl.Create(context.Background(), obj, metav1.CreateOptions{})
+	return errors.Wrapf(err, "append: failed to create %q", key)
+}
+
 // Update updates the ConfigMap holding the release. If not found
-// the ConfigMap is created to hold the release.
+// the ConfigMap is created to hold the release. If the release was read
+// from a version of the ConfigMap that was since modified,
+// ErrReleaseConflict is returned.
 func (cfgmaps *ConfigMaps) Update(key string, rls *rspb.Release) error {
 	// set labels for configmaps object meta data
 	var lbs labels
@@ -192,28 +268,219 @@ func (cfgmaps *ConfigMaps) Update(key string, rls *rspb.Release) error {
 		cfgmaps.Log("update: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	if err := cfgmaps.encrypt(obj, rls.Namespace); err != nil {
+		cfgmaps.Log("update: failed to encrypt release %q: %s", rls.Name, err)
+		return err
+	}
+	// only update the version of the configmap the release was read from
+	obj.ResourceVersion = rls.StorageVersion
+	// fetch the current configmap to learn the generation of its chunks,
+	// garbage collected once the release no longer refers to them
+	current, err := cfgmaps.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		current = nil
+	} else if err != nil {
+		cfgmaps.Log("update: failed to get %q: %s", key, err)
+		return err
+	}
+	if current != nil && rls.StorageVersion != "" && current.ResourceVersion != rls.StorageVersion {
+		return ErrReleaseConflict
+	}
+	chunks := cfgmaps.chunk(obj)
+	if err := cfgmaps.writeChunks(chunks); err != nil {
+		cfgmaps.deleteChunks(chunks)
+		cfgmaps.Log("update: failed to create chunks: %s", err)
+		return err
+	}
 	// push the configmap object out into the kubiverse
-	_, err = cfgmaps.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
+	updated, err := cfgmaps.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
 	if err != nil {
+		cfgmaps.deleteChunks(chunks)
+		if apierrors.IsConflict(err) {
+			return ErrReleaseConflict
+		}
 		cfgmaps.Log("update: failed to update: %s", err)
 		return err
 	}
+	rls.StorageVersion = updated.ResourceVersion
+	if current == nil {
+		return nil
+	}
+	// remove the chunks of the previous version
+	stale, err := chunkKeys(key, current.ObjectMeta.Annotations)
+	if err == nil {
+		err = cfgmaps.deleteKeys(stale)
+	}
+	if err != nil {
+		cfgmaps.Log("update: failed to delete stale chunks: %s", err)
+		return err
+	}
 	return nil
 }
 
-// Delete deletes the ConfigMap holding the release named by key.
+// Delete deletes the ConfigMap holding the release named by key, along with
+// the ConfigMaps holding its chunks. The release is deleted even if it
+// cannot be read, e.g. because one of its chunks is missing; nil is returned
+// for it then.
 func (cfgmaps *ConfigMaps) Delete(key string) (rls *rspb.Release, err error) {
-	// fetch the release to check existence
-	if rls, err = cfgmaps.Get(key); err != nil {
+	// fetch the configmap to check existence
+	obj, err := cfgmaps.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrReleaseNotFound
+		}
+
+		cfgmaps.Log("delete: failed to get %q: %s", key, err)
 		return nil, err
 	}
+	if rls, err = cfgmaps.decode(obj); err != nil {
+		cfgmaps.Log("delete: failed to read release %q: %s", key, err)
+	}
 	// delete the release
 	if err = cfgmaps.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
 		return rls, err
 	}
+	if err = cfgmaps.deleteReleaseChunks(key); err != nil {
+		cfgmaps.Log("delete: failed to delete chunks: %s", err)
+		return rls, err
+	}
 	return rls, nil
 }
 
+// decode returns the release held by obj.
+func (cfgmaps *ConfigMaps) decode(obj *v1.ConfigMap) (*rspb.Release, error) {
+	data, err := cfgmaps.releaseData(obj)
+	if err != nil {
+		return nil, err
+	}
+	rls, err := decodeRelease(data)
+	if err != nil {
+		return nil, err
+	}
+	rls.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	rls.StorageVersion = obj.ResourceVersion
+	return rls, nil
+}
+
+// releaseData returns the encoded release held by obj, joining the chunks
+// stored in additional ConfigMaps if the release was split.
+func (cfgmaps *ConfigMaps) releaseData(obj *v1.ConfigMap) (string, error) {
+	keys, err := chunkKeys(obj.ObjectMeta.Name, obj.ObjectMeta.Annotations)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return decryptPayload(cfgmaps.Encryption, obj.Data["release"], releaseAAD(obj.Namespace, obj.Name))
+	}
+	var b strings.Builder
+	b.WriteString(obj.Data["release"])
+	for i, key := range keys {
+		chunk, err := cfgmaps.impl.Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get chunk %d of %d", i+2, len(keys)+1)
+		}
+		b.WriteString(chunk.Data["release"])
+	}
+	return decryptPayload(cfgmaps.Encryption, b.String(), releaseAAD(obj.Namespace, obj.Name))
+}
+
+// encrypt encrypts the encoded release held by obj if Encryption is set,
+// binding it to obj and to the namespace of the release. obj is placed in
+// that namespace, which the API server refuses if it is not the namespace
+// of the driver, rather than storing a payload that cannot be decrypted.
+func (cfgmaps *ConfigMaps) encrypt(obj *v1.ConfigMap, namespace string) error {
+	if cfgmaps.Encryption == nil {
+		return nil
+	}
+	if namespace == "" {
+		return errors.New("releases without a namespace cannot be encrypted")
+	}
+	obj.Namespace = namespace
+	data, err := encryptPayload(cfgmaps.Encryption, obj.Data["release"], releaseAAD(namespace, obj.Name))
+	if err != nil {
+		return err
+	}
+	obj.Data["release"] = data
+	return nil
+}
+
+// chunk splits the encoded release held by obj if it exceeds ChunkSize. obj
+// keeps the first chunk and records the number and the new generation of the
+// chunks; the ConfigMaps holding the remaining chunks are returned.
+func (cfgmaps *ConfigMaps) chunk(obj *v1.ConfigMap) []*v1.ConfigMap {
+	parts := splitChunks(obj.Data["release"], cfgmaps.ChunkSize)
+	if len(parts) == 1 {
+		return nil
+	}
+	obj.Data["release"] = parts[0]
+	generation := newChunkGeneration()
+	obj.ObjectMeta.Annotations = map[string]string{
+		releaseChunksAnnotation:          strconv.Itoa(len(parts)),
+		releaseChunkGenerationAnnotation: generation,
+	}
+
+	chunks := make([]*v1.ConfigMap, 0, len(parts)-1)
+	for i, part := range parts[1:] {
+		chunks = append(chunks, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        chunkKey(obj.ObjectMeta.Name, generation, i+2),
+				Labels:      map[string]string{releaseChunkOfLabel: chunkOfLabel(obj.ObjectMeta.Name)},
+				Annotations: map[string]string{releaseChunkOfAnnotation: obj.ObjectMeta.Name},
+			},
+			Data: map[string]string{"release": part},
+		})
+	}
+	return chunks
+}
+
+// writeChunks creates the ConfigMaps holding chunks.
+func (cfgmaps *ConfigMaps) writeChunks(chunks []*v1.ConfigMap) error {
+	for _, chunk := range chunks {
+		if _, err := cfgmaps.impl.Create(context.Background(), chunk, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChunks deletes the ConfigMaps holding chunks, as written by a failed
+// Create or Update. Errors are ignored.
+func (cfgmaps *ConfigMaps) deleteChunks(chunks []*v1.ConfigMap) {
+	for _, chunk := range chunks {
+		cfgmaps.impl.Delete(context.Background(), chunk.ObjectMeta.Name, metav1.DeleteOptions{})
+	}
+}
+
+// deleteKeys deletes the ConfigMaps named by keys, ignoring the ones not
+// found.
+func (cfgmaps *ConfigMaps) deleteKeys(keys []string) error {
+	for _, key := range keys {
+		err := cfgmaps.impl.Delete(context.Background(), key, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReleaseChunks deletes the ConfigMaps holding the chunks of any
+// generation of the release stored under key.
+func (cfgmaps *ConfigMaps) deleteReleaseChunks(key string) error {
+	lsel := kblabels.Set{releaseChunkOfLabel: chunkOfLabel(key)}.AsSelector()
+	list, err := cfgmaps.impl.List(context.Background(), metav1.ListOptions{LabelSelector: lsel.String()})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		// hashed label values could in theory match the chunks of another release
+		if item.ObjectMeta.Annotations[releaseChunkOfAnnotation] == key {
+			keys = append(keys, item.ObjectMeta.Name)
+		}
+	}
+	return cfgmaps.deleteKeys(keys)
+}
+
 // newConfigMapsObject constructs a kubernetes ConfigMap object
 // to store a release. Each configmap data entry is the base64
 // encoded gzipped string of a release.
@@ -227,6 +494,7 @@ func (cfgmaps *ConfigMaps) Delete(key string) (rls *rspb.Release, err error) {
 //    "owner"          - owner of the configmap, currently "helm".
 //    "name"           - name of the release.
 //
+// The user-defined labels of the release are stored alongside them.
 func newConfigMapsObject(key string, rls *rspb.Release, lbs labels) (*v1.ConfigMap, error) {
 	const owner = "helm"
 
@@ -240,6 +508,9 @@ func newConfigMapsObject(key string, rls *rspb.Release, lbs labels) (*v1.ConfigM
 		lbs.init()
 	}
 
+	// apply user-defined labels first so they cannot override the system labels
+	lbs.fromMap(filterSystemLabels(rls.Labels))
+
 	// apply labels
 	lbs.set("name", rls.Name)
 	lbs.set("owner", owner)
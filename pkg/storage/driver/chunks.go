@@ -0,0 +1,127 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultChunkSize is the default maximum size in bytes of the encoded
+// release stored in a single Secret or ConfigMap. It stays below the 1MiB
+// limit Kubernetes enforces on the data of these objects, leaving room for
+// the object metadata.
+const DefaultChunkSize = 1000 * 1024
+
+const (
+	// releaseChunksAnnotation records on the object holding a release the
+	// total number of chunks the encoded release was split into. Objects
+	// without it hold the whole release.
+	releaseChunksAnnotation = "helm.sh/release-chunks"
+	// releaseChunkGenerationAnnotation records on the object holding a
+	// release the generation of its additional chunks. Every write of a
+	// release creates a new generation, so that a writer losing the race
+	// for the object holding the release never touches the chunks of the
+	// winner.
+	releaseChunkGenerationAnnotation = "helm.sh/release-chunk-generation"
+	// releaseChunkOfAnnotation records on every additional chunk object the
+	// name of the object holding the release it belongs to.
+	releaseChunkOfAnnotation = "helm.sh/release-chunk-of"
+	// releaseChunkOfLabel selects the additional chunk objects of a release,
+	// whatever their generation. See chunkOfLabel for its value.
+	releaseChunkOfLabel = "helm.sh/release-chunk-of"
+)
+
+// newChunkGeneration returns a new random chunk generation.
+func newChunkGeneration() string {
+	return rand.String(8)
+}
+
+// chunkKey returns the name of the object holding the chunk with the given
+// generation and index of the release stored under key. The first chunk is
+// always stored in the object named by key itself, so index starts at 2.
+//
+// Release keys always end in ".v<version>", so chunk names never collide
+// with the key of another release.
+func chunkKey(key, generation string, index int) string {
+	return fmt.Sprintf("%s.chunk-%s-%d", key, generation, index)
+}
+
+// chunkKeys returns the names of the objects holding the additional chunks
+// of the release stored under key, as recorded in the annotations of the
+// object holding the release.
+func chunkKeys(key string, annotations map[string]string) ([]string, error) {
+	n, err := chunkCount(annotations)
+	if err != nil || n == 1 {
+		return nil, err
+	}
+	generation := annotations[releaseChunkGenerationAnnotation]
+	if generation == "" {
+		return nil, errors.Errorf("missing annotation %s", releaseChunkGenerationAnnotation)
+	}
+	keys := make([]string, 0, n-1)
+	for i := 2; i <= n; i++ {
+		keys = append(keys, chunkKey(key, generation, i))
+	}
+	return keys, nil
+}
+
+// chunkOfLabel returns the value of the releaseChunkOfLabel label of the
+// chunks of the release stored under key. Keys too long for a label value
+// are replaced by their hash.
+func chunkOfLabel(key string) string {
+	if len(key) <= validation.LabelValueMaxLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:validation.LabelValueMaxLength]
+}
+
+// splitChunks splits data into chunks of at most size bytes. Data no larger
+// than size, or a size of zero or less, yields a single chunk.
+func splitChunks(data string, size int) []string {
+	if size <= 0 || len(data) <= size {
+		return []string{data}
+	}
+	chunks := make([]string, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
+
+// chunkCount returns the number of chunks recorded in the annotations of the
+// object holding a release. Releases stored before chunking was introduced
+// have no annotation and are held by a single object.
+func chunkCount(annotations map[string]string) (int, error) {
+	v, ok := annotations[releaseChunksAnnotation]
+	if !ok {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("invalid value %q for annotation %s", v, releaseChunksAnnotation)
+	}
+	return n, nil
+}
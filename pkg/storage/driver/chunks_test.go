@@ -0,0 +1,82 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		data   string
+		size   int
+		expect []string
+	}{
+		{"", 4, []string{""}},
+		{"abc", 4, []string{"abc"}},
+		{"abcd", 4, []string{"abcd"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"abcdefgh", 4, []string{"abcd", "efgh"}},
+		{"abcdefgh", 0, []string{"abcdefgh"}},
+	}
+	for _, tt := range tests {
+		if got := splitChunks(tt.data, tt.size); !reflect.DeepEqual(tt.expect, got) {
+			t.Errorf("splitChunks(%q, %d): expected %q, got %q", tt.data, tt.size, tt.expect, got)
+		}
+	}
+}
+
+func TestChunkCount(t *testing.T) {
+	if n, err := chunkCount(nil); err != nil || n != 1 {
+		t.Errorf("Expected releases without annotation to have 1 chunk, got %d (error %v)", n, err)
+	}
+	if n, err := chunkCount(map[string]string{releaseChunksAnnotation: "3"}); err != nil || n != 3 {
+		t.Errorf("Expected 3 chunks, got %d (error %v)", n, err)
+	}
+	for _, v := range []string{"", "0", "three"} {
+		if _, err := chunkCount(map[string]string{releaseChunksAnnotation: v}); err == nil {
+			t.Errorf("Expected error for annotation value %q", v)
+		}
+	}
+}
+
+func TestChunkKeys(t *testing.T) {
+	if keys, err := chunkKeys("key.v1", nil); err != nil || keys != nil {
+		t.Errorf("Expected releases without annotation to have no chunks, got %q (error %v)", keys, err)
+	}
+	keys, err := chunkKeys("key.v1", map[string]string{releaseChunksAnnotation: "3", releaseChunkGenerationAnnotation: "abc"})
+	if expect := []string{"key.v1.chunk-abc-2", "key.v1.chunk-abc-3"}; err != nil || !reflect.DeepEqual(expect, keys) {
+		t.Errorf("Expected %q, got %q (error %v)", expect, keys, err)
+	}
+	if _, err := chunkKeys("key.v1", map[string]string{releaseChunksAnnotation: "3"}); err == nil {
+		t.Error("Expected error for chunks without generation")
+	}
+}
+
+func TestChunkOfLabel(t *testing.T) {
+	if got := chunkOfLabel("sh.helm.release.v1.smug-pigeon.v1"); got != "sh.helm.release.v1.smug-pigeon.v1" {
+		t.Errorf("Expected short keys to be kept, got %q", got)
+	}
+	key := "sh.helm.release.v1." + strings.Repeat("a", 53) + ".v1"
+	if got := chunkOfLabel(key); len(validation.IsValidLabelValue(got)) != 0 || got == chunkOfLabel(key+"0") {
+		t.Errorf("Expected a valid label value distinct per key, got %q", got)
+	}
+}
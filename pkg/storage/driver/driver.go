@@ -31,6 +31,9 @@ var (
 	ErrReleaseExists = errors.New("release: already exists")
 	// ErrInvalidKey indicates that a release key could not be parsed.
 	ErrInvalidKey = errors.New("release: invalid key")
+	// ErrReleaseConflict indicates that a release was modified by another
+	// client since it was read, so that updating it would lose that change.
+	ErrReleaseConflict = errors.New("release: conflict, modified concurrently")
 	// ErrNoDeployedReleases indicates that there are no releases with the given key in the deployed state
 	ErrNoDeployedReleases = errors.New("has no deployed releases")
 )
@@ -66,6 +69,11 @@ type Creator interface {
 //
 // Update updates an existing release or returns
 // ErrReleaseNotFound if the release does not exist.
+//
+// Drivers supporting optimistic concurrency record the version of the
+// stored record in the StorageVersion of the releases they return, and
+// Update returns ErrReleaseConflict if the record was modified since.
+// Releases without a StorageVersion overwrite the stored record.
 type Updator interface {
 	Update(key string, rls *rspb.Release) error
 }
@@ -103,3 +111,50 @@ type Driver interface {
 	Queryor
 	Name() string
 }
+
+// ListOptions are the filters, ordering and pagination of a release listing.
+type ListOptions struct {
+	// Statuses restricts the listing to releases in one of these statuses.
+	// Releases in any status are listed if empty.
+	Statuses []rspb.Status
+	// NamePattern is a regular expression release names must match.
+	NamePattern string
+	// LatestOnly restricts the listing to the latest revision of every
+	// release. It is applied before Statuses.
+	LatestOnly bool
+	// SortDescending reverses the default ordering by name, namespace and
+	// revision.
+	SortDescending bool
+	// Limit is the maximum number of releases returned. 0 means no limit.
+	Limit int
+	// Offset is the number of releases skipped before the first returned one.
+	Offset int
+}
+
+// Paginator is implemented by drivers that can filter, order and paginate
+// releases in the storage backend itself.
+//
+// ListPage returns the releases matching opts ordered by name, namespace and
+// revision.
+type Paginator interface {
+	ListPage(opts ListOptions) ([]*rspb.Release, error)
+}
+
+// KeyLister is implemented by drivers that can list the keys of the stored
+// releases without decoding them. List skips the releases it fails to
+// decode, e.g. ones encrypted with a key no longer configured.
+//
+// Keys returns the keys of every release List would consider.
+type KeyLister interface {
+	Keys() ([]string, error)
+}
+
+// Appender is implemented by drivers that can also keep records other than
+// releases, such as the audit trail of the operations on releases.
+//
+// Append stores data, the JSON encoding of a record of kind, under key in the
+// namespace of the driver, labeled with labels. Records are never updated nor
+// deleted, and are not returned by the release queries.
+type Appender interface {
+	Append(kind, key string, labels map[string]string, data []byte) error
+}
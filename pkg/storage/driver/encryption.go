@@ -0,0 +1,272 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Environment variables used to configure the encryption of stored releases.
+const (
+	// EncryptionKeyEnvVar holds one or more base64 encoded AES keys,
+	// separated by commas. The first key encrypts new payloads; the others
+	// are only used to decrypt payloads written before a key rotation.
+	EncryptionKeyEnvVar = "HELM_DRIVER_ENCRYPTION_KEY"
+	// EncryptionKeyFileEnvVar holds the path to a file with one base64
+	// encoded AES key per line, the first being the current key.
+	EncryptionKeyFileEnvVar = "HELM_DRIVER_ENCRYPTION_KEY_FILE"
+)
+
+// encryptedPrefix starts every encrypted payload. The character '$' is not
+// part of the base64 alphabet, so encrypted payloads are never mistaken for
+// plain encoded releases.
+const encryptedPrefix = "$HELMENC;v1;"
+
+// KeyProvider wraps and unwraps the data keys used to encrypt stored release
+// payloads.
+//
+// Every payload is encrypted with a fresh data key. The data key is wrapped
+// by the provider and stored next to the payload together with the ID of
+// the key that wrapped it, so that providers can keep decrypting payloads
+// written with previous keys after a rotation.
+type KeyProvider interface {
+	// KeyID returns the ID of the key used to wrap new data keys.
+	KeyID() string
+	// WrapKey encrypts a data key with the current key.
+	WrapKey(dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped with the key named by keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// AESKeyProvider is a KeyProvider wrapping data keys with AES-GCM.
+type AESKeyProvider struct {
+	ids   []string
+	aeads map[string]cipher.AEAD
+}
+
+var _ KeyProvider = (*AESKeyProvider)(nil)
+
+// NewAESKeyProvider creates an AESKeyProvider from raw AES keys of 16, 24 or
+// 32 bytes. The first key wraps new data keys; all keys unwrap them.
+func NewAESKeyProvider(keys ...[]byte) (*AESKeyProvider, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key provided")
+	}
+	p := &AESKeyProvider{aeads: make(map[string]cipher.AEAD, len(keys))}
+	for i, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %d", i+1)
+		}
+		sum := sha256.Sum256(key)
+		id := "aes:" + hex.EncodeToString(sum[:4])
+		if _, ok := p.aeads[id]; ok {
+			continue
+		}
+		p.ids = append(p.ids, id)
+		p.aeads[id] = aead
+	}
+	return p, nil
+}
+
+// ParseAESKeyProvider creates an AESKeyProvider from base64 encoded keys
+// separated by commas or newlines. Blank entries and lines starting with
+// '#' are ignored.
+func ParseAESKeyProvider(data string) (*AESKeyProvider, error) {
+	fields := strings.FieldsFunc(data, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	var keys [][]byte
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.HasPrefix(f, "#") {
+			continue
+		}
+		key, err := b64.DecodeString(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "encryption keys must be base64 encoded")
+		}
+		keys = append(keys, key)
+	}
+	return NewAESKeyProvider(keys...)
+}
+
+// KeyProviderFromEnv returns the AESKeyProvider configured by the
+// HELM_DRIVER_ENCRYPTION_KEY or HELM_DRIVER_ENCRYPTION_KEY_FILE environment
+// variables. If neither is set, nil is returned and releases are stored
+// unencrypted.
+func KeyProviderFromEnv() (KeyProvider, error) {
+	if keys := os.Getenv(EncryptionKeyEnvVar); keys != "" {
+		p, err := ParseAESKeyProvider(keys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", EncryptionKeyEnvVar)
+		}
+		return p, nil
+	}
+	if path := os.Getenv(EncryptionKeyFileEnvVar); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read encryption key file")
+		}
+		p, err := ParseAESKeyProvider(string(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key file %s", path)
+		}
+		return p, nil
+	}
+	return nil, nil
+}
+
+// KeyID returns the ID of the first key of the provider.
+func (p *AESKeyProvider) KeyID() string {
+	return p.ids[0]
+}
+
+// WrapKey encrypts a data key with the first key of the provider.
+func (p *AESKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return sealGCM(p.aeads[p.ids[0]], dataKey, nil)
+}
+
+// UnwrapKey decrypts a data key wrapped with the key named by keyID.
+func (p *AESKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.aeads[keyID]
+	if !ok {
+		return nil, errors.Errorf("encryption key %q is not available", keyID)
+	}
+	return openGCM(aead, wrapped, nil)
+}
+
+// releaseAAD returns the additional data authenticated with the payload of
+// the release stored under key in namespace. Keys name the release and its
+// version, so a payload cannot be passed off as that of another release or
+// revision.
+func releaseAAD(namespace, key string) []byte {
+	return []byte(namespace + "/" + key)
+}
+
+// encryptPayload encrypts an encoded release with a fresh data key wrapped by
+// kp, authenticating aad with it. The payload is returned unchanged if kp is
+// nil.
+//
+// Encrypted payloads have the form
+//
+//	$HELMENC;v1;<key id>;<base64 wrapped data key>;<base64 nonce and ciphertext>
+func encryptPayload(kp KeyProvider, data string, aad []byte) (string, error) {
+	if kp == nil {
+		return data, nil
+	}
+	plain, err := b64.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealGCM(aead, plain, aad)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := kp.WrapKey(dataKey)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to wrap data key")
+	}
+	return encryptedPrefix + strings.Join([]string{
+		kp.KeyID(),
+		b64.EncodeToString(wrapped),
+		b64.EncodeToString(ciphertext),
+	}, ";"), nil
+}
+
+// decryptPayload returns the encoded release held by an encrypted payload,
+// which must have been encrypted with the same aad. Payloads without the
+// encryption header are returned unchanged, so releases stored before
+// encryption was enabled can still be read.
+func decryptPayload(kp KeyProvider, data string, aad []byte) (string, error) {
+	if !strings.HasPrefix(data, encryptedPrefix) {
+		return data, nil
+	}
+	if kp == nil {
+		return "", errors.New("release is encrypted but no encryption key is configured")
+	}
+	parts := strings.Split(strings.TrimPrefix(data, encryptedPrefix), ";")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted release")
+	}
+	wrapped, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.Wrap(err, "malformed encrypted release")
+	}
+	ciphertext, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.Wrap(err, "malformed encrypted release")
+	}
+	dataKey, err := kp.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to unwrap data key")
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := openGCM(aead, ciphertext, aad)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt release")
+	}
+	return b64.EncodeToString(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGCM encrypts plaintext and authenticates it with aad, prepending the
+// random nonce to the ciphertext.
+func sealGCM(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openGCM decrypts the output of sealGCM for the same aad.
+func openGCM(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
@@ -0,0 +1,229 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	rspb "github.com/huolunl/helm/v3/pkg/release"
+)
+
+func testKeyProvider(t *testing.T, keys ...string) *AESKeyProvider {
+	t.Helper()
+	var raw [][]byte
+	for _, k := range keys {
+		raw = append(raw, bytes.Repeat([]byte(k), 32)[:32])
+	}
+	p, err := NewAESKeyProvider(raw...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestEncryptPayload(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	data, err := encodeRelease(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aad := releaseAAD("default", "sh.helm.release.v1.smug-pigeon.v1")
+	oldKey := testKeyProvider(t, "a")
+	encrypted, err := encryptPayload(oldKey, data, aad)
+	if err != nil {
+		t.Fatalf("Failed to encrypt payload: %s", err)
+	}
+	if !strings.HasPrefix(encrypted, encryptedPrefix) {
+		t.Fatalf("Expected encrypted payload to start with %q, got %q", encryptedPrefix, encrypted)
+	}
+
+	// the previous key is still used for decryption after a rotation
+	rotated := testKeyProvider(t, "b", "a")
+	if rotated.KeyID() == oldKey.KeyID() {
+		t.Fatalf("Expected the first key to be the current key")
+	}
+	got, err := decryptPayload(rotated, encrypted, aad)
+	if err != nil || got != data {
+		t.Errorf("Expected payload to be decrypted with the previous key, got error %v", err)
+	}
+
+	if _, err := decryptPayload(testKeyProvider(t, "b"), encrypted, aad); err == nil {
+		t.Error("Expected error decrypting with an unknown key")
+	}
+	if _, err := decryptPayload(nil, encrypted, aad); err == nil {
+		t.Error("Expected error decrypting without a key")
+	}
+
+	// the payload of a release cannot be passed off as that of another
+	// revision or namespace
+	for _, other := range [][]byte{
+		releaseAAD("default", "sh.helm.release.v1.smug-pigeon.v2"),
+		releaseAAD("kube-system", "sh.helm.release.v1.smug-pigeon.v1"),
+	} {
+		if _, err := decryptPayload(oldKey, encrypted, other); err == nil {
+			t.Errorf("Expected error decrypting the payload as %s", other)
+		}
+	}
+
+	// unencrypted payloads are read as they are
+	if got, err := decryptPayload(oldKey, data, aad); err != nil || got != data {
+		t.Errorf("Expected unencrypted payload to be returned unchanged, got error %v", err)
+	}
+	if got, err := encryptPayload(nil, data, aad); err != nil || got != data {
+		t.Errorf("Expected payload to be left unencrypted without a key, got error %v", err)
+	}
+}
+
+func TestKeyProviderFromEnv(t *testing.T) {
+	for _, v := range []string{EncryptionKeyEnvVar, EncryptionKeyFileEnvVar} {
+		defer os.Setenv(v, os.Getenv(v))
+		os.Unsetenv(v)
+	}
+
+	if p, err := KeyProviderFromEnv(); err != nil || p != nil {
+		t.Errorf("Expected no key provider, got %v (error %v)", p, err)
+	}
+
+	newKey := b64.EncodeToString(bytes.Repeat([]byte("n"), 32))
+	oldKey := b64.EncodeToString(bytes.Repeat([]byte("o"), 16))
+
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := ioutil.WriteFile(path, []byte("# current key\n"+newKey+"\n"+oldKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EncryptionKeyFileEnvVar, path)
+	p, err := KeyProviderFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to load keys from file: %s", err)
+	}
+	if ids := p.(*AESKeyProvider).ids; len(ids) != 2 {
+		t.Errorf("Expected 2 keys, got %v", ids)
+	}
+
+	os.Setenv(EncryptionKeyEnvVar, newKey+","+oldKey)
+	fromEnv, err := KeyProviderFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to load keys from environment: %s", err)
+	}
+	if fromEnv.KeyID() != p.KeyID() {
+		t.Errorf("Expected current key %s, got %s", p.KeyID(), fromEnv.KeyID())
+	}
+
+	os.Setenv(EncryptionKeyEnvVar, "bm90IGEga2V5")
+	if _, err := KeyProviderFromEnv(); err == nil {
+		t.Error("Expected error for a key of invalid size")
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+
+	// releases stored before encryption was enabled stay readable
+	secrets := newTestFixtureSecrets(t, rel)
+	secrets.Encryption = testKeyProvider(t, "a")
+	if got, err := secrets.Get(key); err != nil || !reflect.DeepEqual(rel, got) {
+		t.Fatalf("Expected unencrypted release to be read, got error %v", err)
+	}
+
+	if err := secrets.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release: %s", err)
+	}
+	mock := secrets.impl.(*MockSecretsInterface)
+	if !bytes.HasPrefix(mock.objects[key].Data["release"], []byte(encryptedPrefix)) {
+		t.Errorf("Expected release to be stored encrypted")
+	}
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get encrypted release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+
+	// the payload cannot be moved to another revision
+	moved := releaseStub(name, vers+1, namespace, rspb.StatusDeployed)
+	if err := secrets.Create(testKey(name, vers+1), moved); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+	mock.objects[testKey(name, vers+1)].Data["release"] = mock.objects[key].Data["release"]
+	if _, err := secrets.Get(testKey(name, vers+1)); err == nil {
+		t.Error("Expected error reading a payload moved to another revision")
+	}
+
+	// releases must be stored in their namespace to be encrypted
+	if err := secrets.Create(testKey(name, vers+2), releaseStub(name, vers+2, "", rspb.StatusDeployed)); err == nil {
+		t.Error("Expected error encrypting a release without a namespace")
+	}
+
+	secrets.Encryption = nil
+	if _, err := secrets.Get(key); err == nil {
+		t.Error("Expected error reading an encrypted release without a key")
+	}
+}
+
+func TestSQLEncryption(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+
+	sqlDriver := newTestFixtureSQLite(t)
+	sqlDriver.Encryption = testKeyProvider(t, "a")
+	if err := sqlDriver.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+
+	var body string
+	if err := sqlDriver.db.Get(&body, "SELECT body FROM releases_v1 WHERE key = ?", key); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(body, encryptedPrefix) {
+		t.Errorf("Expected release to be stored encrypted")
+	}
+
+	got, err := sqlDriver.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get encrypted release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+
+	// the payload cannot be moved to another revision
+	movedKey := testKey(name, vers+1)
+	if err := sqlDriver.Create(movedKey, releaseStub(name, vers+1, namespace, rspb.StatusDeployed)); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+	if _, err := sqlDriver.db.Exec("UPDATE releases_v1 SET body = ? WHERE key = ?", body, movedKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDriver.Get(movedKey); err == nil {
+		t.Error("Expected error reading a payload moved to another revision")
+	}
+}
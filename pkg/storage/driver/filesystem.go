@@ -0,0 +1,411 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	rspb "github.com/huolunl/helm/v3/pkg/release"
+)
+
+var _ Driver = (*Filesystem)(nil)
+var _ Appender = (*Filesystem)(nil)
+
+// FilesystemDriverName is the string name of the driver.
+const FilesystemDriverName = "Filesystem"
+
+// Formats of the files written by the Filesystem driver.
+const (
+	FilesystemFormatYAML = "yaml"
+	FilesystemFormatJSON = "json"
+)
+
+// filesystemRecordsDir is the directory of the records other than releases
+// in the root directory of a Filesystem driver, laid out as
+//
+//	<root>/.records/<kind>/<namespace>/<key>.json
+//
+// It is deeper than the release files, so that they are never mistaken for
+// releases.
+const filesystemRecordsDir = ".records"
+
+// filesystemLockFile is the name of the lock file in the root directory of
+// a Filesystem driver.
+const filesystemLockFile = ".lock"
+
+// Filesystem is a storage driver keeping every release revision in its own
+// human-readable file, laid out as
+//
+//	<root>/<namespace>/<release name>/<revision>.yaml
+//
+// Access to the directory tree is serialized with a lock file in the root
+// directory, so that several Helm processes can share it.
+type Filesystem struct {
+	root      string
+	namespace string
+
+	// Format is the format of the files written, FilesystemFormatYAML or
+	// FilesystemFormatJSON. Files of both formats are read.
+	Format string
+	Log    func(string, ...interface{})
+}
+
+// filesystemRecord is the content of a release file. Release labels are not
+// part of the release JSON, so the user-defined labels are stored next to it.
+type filesystemRecord struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Release *rspb.Release     `json:"release"`
+}
+
+// filesystemAppended is the content of a record file written by Append.
+type filesystemAppended struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Record json.RawMessage   `json:"record"`
+}
+
+// NewFilesystem initializes a Filesystem driver storing the releases of
+// namespace in the directory root, which is created if needed. An empty
+// namespace lists the releases of all namespaces.
+func NewFilesystem(root, namespace string) (*Filesystem, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create the release directory")
+	}
+	return &Filesystem{
+		root:      root,
+		namespace: namespace,
+		Format:    FilesystemFormatYAML,
+		Log:       func(_ string, _ ...interface{}) {},
+	}, nil
+}
+
+// Name returns the name of the driver.
+func (fs *Filesystem) Name() string {
+	return FilesystemDriverName
+}
+
+// Get returns the release named by key or returns ErrReleaseNotFound.
+func (fs *Filesystem) Get(key string) (*rspb.Release, error) {
+	name, version, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(fs.rlock())
+
+	path, ok := fs.find(fs.readNamespace(), name, version)
+	if !ok {
+		return nil, ErrReleaseNotFound
+	}
+	rec, err := readFilesystemRecord(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get: failed to read %q", key)
+	}
+	return rec.release(), nil
+}
+
+// List returns the list of all releases such that filter(release) == true
+func (fs *Filesystem) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	defer unlock(fs.rlock())
+
+	var ls []*rspb.Release
+	err := fs.walk(func(rec *filesystemRecord) {
+		if rls := rec.release(); filter(rls) {
+			ls = append(ls, rls)
+		}
+	})
+	return ls, err
+}
+
+// Query returns the set of releases that match the provided set of labels
+func (fs *Filesystem) Query(keyvals map[string]string) ([]*rspb.Release, error) {
+	defer unlock(fs.rlock())
+
+	var ls []*rspb.Release
+	err := fs.walk(func(rec *filesystemRecord) {
+		if rec.labels().match(keyvals) {
+			ls = append(ls, rec.release())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ls) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return ls, nil
+}
+
+// Create creates a new release or returns ErrReleaseExists.
+func (fs *Filesystem) Create(key string, rls *rspb.Release) error {
+	defer unlock(fs.wlock())
+
+	if _, ok := fs.find(fs.writeNamespace(rls), rls.Name, rls.Version); ok {
+		return ErrReleaseExists
+	}
+	return errors.Wrapf(fs.write(rls), "create: failed to write %q", key)
+}
+
+// Append writes the record data of kind to a new file.
+func (fs *Filesystem) Append(kind, key string, labels map[string]string, data []byte) error {
+	namespace := fs.namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	b, err := json.Marshal(filesystemAppended{Labels: labels, Record: data})
+	if err != nil {
+		return err
+	}
+	defer unlock(fs.wlock())
+
+	dir := filepath.Join(fs.root, filesystemRecordsDir, kind, namespace)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "append: failed to create %q", dir)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, key+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "append: failed to create %q", key)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "append: failed to write %q", key)
+	}
+	return errors.Wrapf(f.Close(), "append: failed to write %q", key)
+}
+
+// Update updates a release or returns ErrReleaseNotFound.
+func (fs *Filesystem) Update(key string, rls *rspb.Release) error {
+	defer unlock(fs.wlock())
+
+	path, ok := fs.find(fs.writeNamespace(rls), rls.Name, rls.Version)
+	if !ok {
+		return ErrReleaseNotFound
+	}
+	if err := fs.write(rls); err != nil {
+		return errors.Wrapf(err, "update: failed to write %q", key)
+	}
+	// the format may have changed since the release was written
+	if path != fs.path(fs.writeNamespace(rls), rls.Name, rls.Version) {
+		return errors.Wrapf(os.Remove(path), "update: failed to remove %q", path)
+	}
+	return nil
+}
+
+// Delete deletes a release or returns ErrReleaseNotFound.
+func (fs *Filesystem) Delete(key string) (*rspb.Release, error) {
+	name, version, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(fs.wlock())
+
+	path, ok := fs.find(fs.readNamespace(), name, version)
+	if !ok {
+		return nil, ErrReleaseNotFound
+	}
+	rec, err := readFilesystemRecord(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "delete: failed to read %q", key)
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, errors.Wrapf(err, "delete: failed to remove %q", key)
+	}
+	// remove the release directory once its last revision is gone; this
+	// fails harmlessly while other revisions remain
+	os.Remove(filepath.Dir(path))
+	return rec.release(), nil
+}
+
+// readNamespace returns the namespace read by Get and Delete.
+func (fs *Filesystem) readNamespace() string {
+	if fs.namespace == "" {
+		return defaultNamespace
+	}
+	return fs.namespace
+}
+
+// writeNamespace returns the namespace rls is written to.
+func (fs *Filesystem) writeNamespace(rls *rspb.Release) string {
+	// For backwards compatibility, we protect against an unset namespace
+	if rls.Namespace == "" {
+		return fs.readNamespace()
+	}
+	return rls.Namespace
+}
+
+// path returns the path of the file written for a release revision.
+func (fs *Filesystem) path(namespace, name string, version int) string {
+	ext := ".yaml"
+	if fs.Format == FilesystemFormatJSON {
+		ext = ".json"
+	}
+	return filepath.Join(fs.root, namespace, name, strconv.Itoa(version)+ext)
+}
+
+// find returns the path of the existing file of a release revision,
+// whatever its format.
+func (fs *Filesystem) find(namespace, name string, version int) (string, bool) {
+	base := filepath.Join(fs.root, namespace, name, strconv.Itoa(version))
+	for _, ext := range []string{".yaml", ".json"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, true
+		}
+	}
+	return "", false
+}
+
+// write writes the file of rls atomically, replacing any previous content.
+func (fs *Filesystem) write(rls *rspb.Release) error {
+	rec := &filesystemRecord{Labels: filterSystemLabels(rls.Labels), Release: rls}
+	var (
+		b   []byte
+		err error
+	)
+	if fs.Format == FilesystemFormatJSON {
+		b, err = json.MarshalIndent(rec, "", "  ")
+	} else {
+		b, err = yaml.Marshal(rec)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := fs.path(fs.writeNamespace(rls), rls.Name, rls.Version)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// walk calls fn with the record of every release revision of the namespace
+// of the driver, or of all namespaces if it is empty. Files that cannot be
+// read are logged and skipped.
+func (fs *Filesystem) walk(fn func(*filesystemRecord)) error {
+	pattern := filepath.Join(fs.root, "*", "*", "*")
+	if fs.namespace != "" {
+		pattern = filepath.Join(fs.root, fs.namespace, "*", "*")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if ext := filepath.Ext(path); (ext != ".yaml" && ext != ".json") || strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		rec, err := readFilesystemRecord(path)
+		if err != nil {
+			fs.Log("failed to read release file %s: %s", path, err)
+			continue
+		}
+		fn(rec)
+	}
+	return nil
+}
+
+// wlock locks the release directory for writing
+func (fs *Filesystem) wlock() func() {
+	return fs.lock((*flock.Flock).Lock)
+}
+
+// rlock locks the release directory for reading
+func (fs *Filesystem) rlock() func() {
+	return fs.lock((*flock.Flock).RLock)
+}
+
+func (fs *Filesystem) lock(lockFn func(*flock.Flock) error) func() {
+	l := flock.New(filepath.Join(fs.root, filesystemLockFile))
+	if err := lockFn(l); err != nil {
+		// proceed unlocked rather than failing every operation, e.g. on
+		// file systems without locking support
+		fs.Log("failed to lock the release directory %s: %s", fs.root, err)
+		return func() {}
+	}
+	return func() { l.Unlock() }
+}
+
+func readFilesystemRecord(path string) (*filesystemRecord, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec filesystemRecord
+	// JSON is a subset of YAML, so both formats are read the same way
+	if err := yaml.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Release == nil {
+		return nil, errors.New("file holds no release")
+	}
+	return &rec, nil
+}
+
+func (rec *filesystemRecord) release() *rspb.Release {
+	rec.Release.Labels = rec.Labels
+	return rec.Release
+}
+
+// labels returns the labels the release would have on the objects of the
+// Secrets and ConfigMaps drivers.
+func (rec *filesystemRecord) labels() labels {
+	var lbs labels
+	lbs.init()
+	lbs.fromMap(rec.Labels)
+	lbs.set("name", rec.Release.Name)
+	lbs.set("owner", "helm")
+	lbs.set("version", strconv.Itoa(rec.Release.Version))
+	if rec.Release.Info != nil {
+		lbs.set("status", rec.Release.Info.Status.String())
+	}
+	return lbs
+}
+
+// parseKey returns the release name and version of a release key of the
+// form [sh.helm.release.v1.]<name>.v<version>.
+func parseKey(key string) (string, int, error) {
+	keyWithoutPrefix := strings.TrimPrefix(key, "sh.helm.release.v1.")
+	i := strings.LastIndex(keyWithoutPrefix, ".v")
+	if i <= 0 {
+		return "", 0, ErrInvalidKey
+	}
+	version, err := strconv.Atoi(keyWithoutPrefix[i+2:])
+	if err != nil {
+		return "", 0, ErrInvalidKey
+	}
+	return keyWithoutPrefix[:i], version, nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rspb "github.com/huolunl/helm/v3/pkg/release"
+)
+
+func TestFilesystemName(t *testing.T) {
+	fs := newTestFixtureFilesystem(t)
+	if fs.Name() != FilesystemDriverName {
+		t.Errorf("Expected name to be %q, got %q", FilesystemDriverName, fs.Name())
+	}
+}
+
+func TestFilesystemGet(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue"}
+
+	fs := newTestFixtureFilesystem(t, rel)
+
+	got, err := fs.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+	if _, err := os.Stat(filepath.Join(fs.root, namespace, name, "1.yaml")); err != nil {
+		t.Errorf("Expected release file to exist: %s", err)
+	}
+
+	if _, err := fs.Get(testKey(name, 2)); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+	if _, err := fs.Get("invalid"); err != ErrInvalidKey {
+		t.Errorf("Expected %v, got %v", ErrInvalidKey, err)
+	}
+}
+
+func TestFilesystemList(t *testing.T) {
+	fs := newTestFixtureFilesystem(t, []*rspb.Release{
+		releaseStub("key-1", 1, "default", rspb.StatusUninstalled),
+		releaseStub("key-2", 1, "default", rspb.StatusUninstalled),
+		releaseStub("key-3", 1, "default", rspb.StatusDeployed),
+		releaseStub("key-4", 1, "default", rspb.StatusDeployed),
+		releaseStub("key-5", 1, "default", rspb.StatusSuperseded),
+		releaseStub("key-6", 1, "default", rspb.StatusSuperseded),
+		releaseStub("key-7", 1, "other", rspb.StatusDeployed),
+	}...)
+
+	for status, expected := range map[rspb.Status]int{
+		rspb.StatusUninstalled: 2,
+		rspb.StatusDeployed:    2,
+		rspb.StatusSuperseded:  2,
+	} {
+		rels, err := fs.List(func(rel *rspb.Release) bool {
+			return rel.Info.Status == status
+		})
+		if err != nil {
+			t.Errorf("Failed to list %s releases: %s", status, err)
+		}
+		if len(rels) != expected {
+			t.Errorf("Expected %d %s releases, got %d", expected, status, len(rels))
+		}
+	}
+
+	// an empty namespace lists all namespaces
+	fs.namespace = ""
+	rels, err := fs.List(func(_ *rspb.Release) bool { return true })
+	if err != nil {
+		t.Errorf("Failed to list releases: %s", err)
+	}
+	if len(rels) != 7 {
+		t.Errorf("Expected 7 releases, got %d", len(rels))
+	}
+}
+
+func TestFilesystemQuery(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue"}
+	fs := newTestFixtureFilesystem(t,
+		rel,
+		releaseStub("smug-pigeon", 2, "default", rspb.StatusSuperseded),
+	)
+
+	rls, err := fs.Query(map[string]string{"name": "smug-pigeon", "owner": "helm"})
+	if err != nil || len(rls) != 2 {
+		t.Errorf("Expected 2 releases, got %d (error %v)", len(rls), err)
+	}
+	rls, err = fs.Query(map[string]string{"status": "deployed", "team": "blue"})
+	if err != nil || len(rls) != 1 {
+		t.Errorf("Expected 1 release, got %d (error %v)", len(rls), err)
+	}
+	if _, err := fs.Query(map[string]string{"name": "unknown"}); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+}
+
+func TestFilesystemCreateUpdateDelete(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+
+	fs := newTestFixtureFilesystem(t)
+	if err := fs.Update(key, rel); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+	if err := fs.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+	if err := fs.Create(key, rel); err != ErrReleaseExists {
+		t.Errorf("Expected %v, got %v", ErrReleaseExists, err)
+	}
+
+	// switching format replaces the file of the release
+	fs.Format = FilesystemFormatJSON
+	rel.Info.Status = rspb.StatusSuperseded
+	if err := fs.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release: %s", err)
+	}
+	dir := filepath.Join(fs.root, namespace, name)
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 || filepath.Base(files[0]) != "1.json" {
+		t.Errorf("Expected a single JSON release file, got %v", files)
+	}
+	got, err := fs.Get(key)
+	if err != nil || got.Info.Status != rspb.StatusSuperseded {
+		t.Errorf("Expected updated release, got %v (error %v)", got, err)
+	}
+
+	if _, err := fs.Delete(key); err != nil {
+		t.Fatalf("Failed to delete release: %s", err)
+	}
+	if _, err := fs.Get(key); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected empty release directory to be removed, got %v", err)
+	}
+	if _, err := fs.Delete(key); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	name, version, err := parseKey("sh.helm.release.v1.my.vault.v12")
+	if err != nil || name != "my.vault" || version != 12 {
+		t.Errorf("Expected my.vault v12, got %s v%d (error %v)", name, version, err)
+	}
+	for _, key := range []string{"invalid", "sh.helm.release.v1.name.vX", ".v1"} {
+		if _, _, err := parseKey(key); err != ErrInvalidKey {
+			t.Errorf("Expected %v for %q, got %v", ErrInvalidKey, key, err)
+		}
+	}
+}
+
+func TestFilesystemAppend(t *testing.T) {
+	fs := newTestFixtureFilesystem(t, releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed))
+	fs.namespace = ""
+
+	labels := map[string]string{"owner": "helm-audit"}
+	key := "sh.helm.audit.v1.smug-pigeon.1"
+	if err := fs.Append("audit", key, labels, []byte(`{"operation":"install"}`)); err != nil {
+		t.Fatalf("Failed to append record: %s", err)
+	}
+	if err := fs.Append("audit", key, labels, []byte(`{}`)); err == nil {
+		t.Error("Expected error appending an existing record")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(fs.root, filesystemRecordsDir, "audit", "default", key+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"labels":{"owner":"helm-audit"},"record":{"operation":"install"}}`; string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+	rels, err := fs.List(func(*rspb.Release) bool { return true })
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected the release only, got %v (error %v)", rels, err)
+	}
+}
@@ -46,3 +46,49 @@ func (lbs *labels) fromMap(kvs map[string]string) {
 		lbs.set(k, v)
 	}
 }
+
+// systemLabels are the labels set by the storage drivers themselves. They
+// cannot be set by users and are stripped from the user-defined labels of a
+// release.
+var systemLabels = []string{"name", "owner", "status", "version", "createdAt", "modifiedAt"}
+
+// GetSystemLabels returns the labels reserved by the storage drivers.
+func GetSystemLabels() []string {
+	return append([]string(nil), systemLabels...)
+}
+
+// ContainsSystemLabels reports whether lbs sets any of the labels reserved by
+// the storage drivers.
+func ContainsSystemLabels(lbs map[string]string) bool {
+	for k := range lbs {
+		if isSystemLabel(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSystemLabel(key string) bool {
+	for _, k := range systemLabels {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSystemLabels returns a copy of lbs without the system labels, or nil
+// if no user-defined label is left.
+func filterSystemLabels(lbs map[string]string) map[string]string {
+	var result map[string]string
+	for k, v := range lbs {
+		if isSystemLabel(k) {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[k] = v
+	}
+	return result
+}
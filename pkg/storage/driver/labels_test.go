@@ -47,3 +47,28 @@ func TestLabelsMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterSystemLabels(t *testing.T) {
+	in := map[string]string{
+		"name":       "smug-pigeon",
+		"owner":      "helm",
+		"status":     "deployed",
+		"version":    "1",
+		"createdAt":  "1",
+		"modifiedAt": "2",
+		"team":       "blue",
+	}
+	got := filterSystemLabels(in)
+	if len(got) != 1 || got["team"] != "blue" {
+		t.Fatalf("Expected only user-defined labels, got %v", got)
+	}
+	if filterSystemLabels(map[string]string{"owner": "helm"}) != nil {
+		t.Fatal("Expected nil when no user-defined label is left")
+	}
+	if !ContainsSystemLabels(in) {
+		t.Fatal("Expected system labels to be detected")
+	}
+	if ContainsSystemLabels(got) {
+		t.Fatal("Expected no system labels")
+	}
+}
@@ -25,6 +25,7 @@ import (
 )
 
 var _ Driver = (*Memory)(nil)
+var _ Appender = (*Memory)(nil)
 
 const (
 	// MemoryDriverName is the string name of this driver.
@@ -42,6 +43,17 @@ type Memory struct {
 	namespace string
 	// A map of namespaces to releases
 	cache map[string]memReleases
+	// The records stored by Append
+	appended []*memRecord
+}
+
+// memRecord is a record other than a release.
+type memRecord struct {
+	kind      string
+	key       string
+	namespace string
+	labels    map[string]string
+	data      []byte
 }
 
 // NewMemory initializes a new memory driver.
@@ -175,6 +187,24 @@ func (mem *Memory) Create(key string, rls *rspb.Release) error {
 	return nil
 }
 
+// Append stores the record data of kind.
+func (mem *Memory) Append(kind, key string, labels map[string]string, data []byte) error {
+	defer unlock(mem.wlock())
+
+	namespace := mem.namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	mem.appended = append(mem.appended, &memRecord{
+		kind:      kind,
+		key:       key,
+		namespace: namespace,
+		labels:    labels,
+		data:      data,
+	})
+	return nil
+}
+
 // Update updates a release or returns ErrReleaseNotFound.
 func (mem *Memory) Update(key string, rls *rspb.Release) error {
 	defer unlock(mem.wlock())
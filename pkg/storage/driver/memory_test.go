@@ -287,3 +287,16 @@ func TestMemoryDelete(t *testing.T) {
 	}
 
 }
+
+func TestMemoryAppend(t *testing.T) {
+	mem := tsFixtureMemory(t)
+	mem.SetNamespace("")
+
+	labels := map[string]string{"owner": "helm-audit"}
+	if err := mem.Append("audit", "sh.helm.audit.v1.rls-a.1", labels, []byte(`{}`)); err != nil {
+		t.Fatalf("Failed to append record: %s", err)
+	}
+	if len(mem.appended) != 1 || mem.appended[0].namespace != defaultNamespace || mem.appended[0].labels["owner"] != "helm-audit" {
+		t.Errorf("Unexpected records %v", mem.appended)
+	}
+}
@@ -18,7 +18,10 @@ package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
@@ -139,16 +142,26 @@ func (mock *MockConfigMapsInterface) Create(_ context.Context, cfgmap *v1.Config
 	if object, ok := mock.objects[name]; ok {
 		return object, apierrors.NewAlreadyExists(v1.Resource("tests"), name)
 	}
+	cfgmap = cfgmap.DeepCopy()
+	cfgmap.ResourceVersion = "1"
 	mock.objects[name] = cfgmap
 	return cfgmap, nil
 }
 
-// Update updates a ConfigMap.
+// Update updates a ConfigMap. Like the API server, it rejects updates of a
+// resource version other than the current one.
 func (mock *MockConfigMapsInterface) Update(_ context.Context, cfgmap *v1.ConfigMap, _ metav1.UpdateOptions) (*v1.ConfigMap, error) {
 	name := cfgmap.ObjectMeta.Name
-	if _, ok := mock.objects[name]; !ok {
+	object, ok := mock.objects[name]
+	if !ok {
 		return nil, apierrors.NewNotFound(v1.Resource("tests"), name)
 	}
+	if cfgmap.ResourceVersion != "" && cfgmap.ResourceVersion != object.ResourceVersion {
+		return nil, apierrors.NewConflict(v1.Resource("tests"), name, errors.New("stale resource version"))
+	}
+	version, _ := strconv.Atoi(object.ResourceVersion)
+	cfgmap = cfgmap.DeepCopy()
+	cfgmap.ResourceVersion = strconv.Itoa(version + 1)
 	mock.objects[name] = cfgmap
 	return cfgmap, nil
 }
@@ -225,16 +238,26 @@ func (mock *MockSecretsInterface) Create(_ context.Context, secret *v1.Secret, _
 	if object, ok := mock.objects[name]; ok {
 		return object, apierrors.NewAlreadyExists(v1.Resource("tests"), name)
 	}
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = "1"
 	mock.objects[name] = secret
 	return secret, nil
 }
 
-// Update updates a Secret.
+// Update updates a Secret. Like the API server, it rejects updates of a
+// resource version other than the current one.
 func (mock *MockSecretsInterface) Update(_ context.Context, secret *v1.Secret, _ metav1.UpdateOptions) (*v1.Secret, error) {
 	name := secret.ObjectMeta.Name
-	if _, ok := mock.objects[name]; !ok {
+	object, ok := mock.objects[name]
+	if !ok {
 		return nil, apierrors.NewNotFound(v1.Resource("tests"), name)
 	}
+	if secret.ResourceVersion != "" && secret.ResourceVersion != object.ResourceVersion {
+		return nil, apierrors.NewConflict(v1.Resource("tests"), name, errors.New("stale resource version"))
+	}
+	version, _ := strconv.Atoi(object.ResourceVersion)
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = strconv.Itoa(version + 1)
 	mock.objects[name] = secret
 	return secret, nil
 }
@@ -258,8 +281,44 @@ func newTestFixtureSQL(t *testing.T, releases ...*rspb.Release) (*SQL, sqlmock.S
 	sqlxDB := sqlx.NewDb(sqlDB, "sqlmock")
 	return &SQL{
 		db:               sqlxDB,
+		dialect:          postgreSQLDialect,
 		Log:              func(a string, b ...interface{}) {},
 		namespace:        "default",
 		statementBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
 	}, mock
 }
+
+// newTestFixtureSQLite creates an SQL driver backed by a SQLite database in a
+// temporary directory. The releases provided are created in it.
+func newTestFixtureSQLite(t *testing.T, releases ...*rspb.Release) *SQL {
+	t.Helper()
+
+	connectionString := "sqlite://" + filepath.Join(t.TempDir(), "helm.db")
+	sqlDriver, err := NewSQL(connectionString, func(a string, b ...interface{}) {}, "default")
+	if err != nil {
+		t.Fatalf("error when opening test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDriver.db.Close() })
+
+	for _, rls := range releases {
+		if err := sqlDriver.Create(testKey(rls.Name, rls.Version), rls); err != nil {
+			t.Fatalf("Test setup failed to create: %s\n", err)
+		}
+	}
+	return sqlDriver
+}
+
+// newTestFixtureFilesystem creates a Filesystem driver storing releases in a
+// temporary directory. The releases provided are created in it.
+func newTestFixtureFilesystem(t *testing.T, releases ...*rspb.Release) *Filesystem {
+	fs, err := NewFilesystem(t.TempDir(), "default")
+	if err != nil {
+		t.Fatalf("Failed to create filesystem driver: %s", err)
+	}
+	for _, rls := range releases {
+		if err := fs.Create(testKey(rls.Name, rls.Version), rls); err != nil {
+			t.Fatalf("Failed to create release: %s", err)
+		}
+	}
+	return fs
+}
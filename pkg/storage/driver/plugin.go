@@ -0,0 +1,275 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	rspb "github.com/huolunl/helm/v3/pkg/release"
+)
+
+var _ Driver = (*Plugin)(nil)
+
+// PluginProtocolVersion is the version of the protocol spoken with storage
+// driver plugins.
+const PluginProtocolVersion = "v1"
+
+// Operations requested from storage driver plugins.
+const (
+	PluginOperationGet    = "get"
+	PluginOperationList   = "list"
+	PluginOperationQuery  = "query"
+	PluginOperationCreate = "create"
+	PluginOperationUpdate = "update"
+	PluginOperationDelete = "delete"
+)
+
+// Error codes returned by storage driver plugins that map to the errors of
+// this package.
+const (
+	PluginErrorNotFound      = "NotFound"
+	PluginErrorAlreadyExists = "AlreadyExists"
+)
+
+// PluginRequest is written as JSON to the standard input of a storage driver
+// plugin. The plugin is executed once per request.
+//
+//	get     - return the record named by Key.
+//	list    - return every record of Namespace, or of all namespaces if
+//	          Namespace is empty.
+//	query   - return the records of Namespace whose labels contain Labels.
+//	create  - store Record, failing with AlreadyExists if Key is taken.
+//	update  - replace the record named by Key, failing with NotFound if
+//	          it does not exist.
+//	delete  - delete the record named by Key and return it.
+type PluginRequest struct {
+	APIVersion string            `json:"apiVersion"`
+	Operation  string            `json:"operation"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Key        string            `json:"key,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Record     *PluginRecord     `json:"record,omitempty"`
+}
+
+// PluginRecord is a release as exchanged with a storage driver plugin.
+//
+// Labels holds the labels the Secrets and ConfigMaps drivers would set on the
+// object holding the release, including the user-defined labels.
+type PluginRecord struct {
+	Key     string            `json:"key"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Release *rspb.Release     `json:"release"`
+}
+
+// PluginResponse is read as JSON from the standard output of a storage driver
+// plugin. Records holds the records returned by get, list, query and delete.
+type PluginResponse struct {
+	Records []*PluginRecord `json:"records,omitempty"`
+	Error   *PluginError    `json:"error,omitempty"`
+}
+
+// PluginError is an error reported by a storage driver plugin.
+type PluginError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Plugin is a storage driver delegating to an external program, typically
+// provided by a helm plugin, that speaks the JSON protocol described by
+// PluginRequest and PluginResponse over its standard input and output.
+type Plugin struct {
+	name      string
+	command   string
+	args      []string
+	namespace string
+
+	// Env is the environment of the plugin program. If nil, the program
+	// inherits the environment of the current process.
+	Env []string
+	Log func(string, ...interface{})
+}
+
+// NewPlugin initializes a storage driver named name that runs command with
+// args for every operation on the releases of namespace.
+func NewPlugin(name, command string, args []string, namespace string) *Plugin {
+	return &Plugin{
+		name:      name,
+		command:   command,
+		args:      args,
+		namespace: namespace,
+		Log:       func(_ string, _ ...interface{}) {},
+	}
+}
+
+// Name returns the name of the driver.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Get fetches the release named by key. The corresponding release is returned
+// or error if not found.
+func (p *Plugin) Get(key string) (*rspb.Release, error) {
+	resp, err := p.call(&PluginRequest{Operation: PluginOperationGet, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Records) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return resp.Records[0].release()
+}
+
+// List fetches all releases and returns the list releases such
+// that filter(release) == true.
+func (p *Plugin) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	resp, err := p.call(&PluginRequest{Operation: PluginOperationList})
+	if err != nil {
+		return nil, err
+	}
+	var results []*rspb.Release
+	for _, record := range resp.Records {
+		rls, err := record.release()
+		if err != nil {
+			p.Log("list: %s", err)
+			continue
+		}
+		if filter(rls) {
+			results = append(results, rls)
+		}
+	}
+	return results, nil
+}
+
+// Query fetches all releases that match the provided map of labels.
+func (p *Plugin) Query(labels map[string]string) ([]*rspb.Release, error) {
+	resp, err := p.call(&PluginRequest{Operation: PluginOperationQuery, Labels: labels})
+	if err != nil {
+		return nil, err
+	}
+	var results []*rspb.Release
+	for _, record := range resp.Records {
+		rls, err := record.release()
+		if err != nil {
+			p.Log("query: %s", err)
+			continue
+		}
+		results = append(results, rls)
+	}
+	if len(results) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return results, nil
+}
+
+// Create creates a new release or returns ErrReleaseExists.
+func (p *Plugin) Create(key string, rls *rspb.Release) error {
+	var lbs labels
+	lbs.init()
+	lbs.set("createdAt", strconv.Itoa(int(time.Now().Unix())))
+	_, err := p.call(&PluginRequest{Operation: PluginOperationCreate, Key: key, Record: newPluginRecord(key, rls, lbs)})
+	return err
+}
+
+// Update updates a release or returns ErrReleaseNotFound.
+func (p *Plugin) Update(key string, rls *rspb.Release) error {
+	var lbs labels
+	lbs.init()
+	lbs.set("modifiedAt", strconv.Itoa(int(time.Now().Unix())))
+	_, err := p.call(&PluginRequest{Operation: PluginOperationUpdate, Key: key, Record: newPluginRecord(key, rls, lbs)})
+	return err
+}
+
+// Delete deletes a release or returns ErrReleaseNotFound.
+func (p *Plugin) Delete(key string) (*rspb.Release, error) {
+	resp, err := p.call(&PluginRequest{Operation: PluginOperationDelete, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Records) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return resp.Records[0].release()
+}
+
+// call runs the plugin program with req on its standard input and decodes
+// its response.
+func (p *Plugin) call(req *PluginRequest) (*PluginResponse, error) {
+	req.APIVersion = PluginProtocolVersion
+	req.Namespace = p.namespace
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to encode request", req.Operation)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Env = p.Env
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "%s: storage driver plugin %q failed: %s", req.Operation, p.name, msg)
+		}
+		return nil, errors.Wrapf(err, "%s: storage driver plugin %q failed", req.Operation, p.name)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, errors.Wrapf(err, "%s: invalid response from storage driver plugin %q", req.Operation, p.name)
+	}
+	if resp.Error != nil {
+		switch resp.Error.Code {
+		case PluginErrorNotFound:
+			return nil, ErrReleaseNotFound
+		case PluginErrorAlreadyExists:
+			return nil, ErrReleaseExists
+		}
+		return nil, errors.Errorf("%s: storage driver plugin %q: %s", req.Operation, p.name, resp.Error.Message)
+	}
+	return &resp, nil
+}
+
+// newPluginRecord constructs the record sent to a plugin to store a release.
+// It carries the same labels as the objects of the Secrets and ConfigMaps
+// drivers.
+func newPluginRecord(key string, rls *rspb.Release, lbs labels) *PluginRecord {
+	// apply user-defined labels first so they cannot override the system labels
+	lbs.fromMap(filterSystemLabels(rls.Labels))
+
+	lbs.set("name", rls.Name)
+	lbs.set("owner", "helm")
+	lbs.set("status", rls.Info.Status.String())
+	lbs.set("version", strconv.Itoa(rls.Version))
+
+	return &PluginRecord{Key: key, Labels: lbs.toMap(), Release: rls}
+}
+
+func (r *PluginRecord) release() (*rspb.Release, error) {
+	if r.Release == nil {
+		return nil, errors.Errorf("record %q holds no release", r.Key)
+	}
+	r.Release.Labels = filterSystemLabels(r.Labels)
+	return r.Release, nil
+}
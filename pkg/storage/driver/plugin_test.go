@@ -0,0 +1,172 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rspb "github.com/huolunl/helm/v3/pkg/release"
+)
+
+const pluginStateEnvVar = "HELM_TEST_STORAGE_PLUGIN_STATE"
+
+// TestPluginHelperProcess is not a real test. It is run by the Plugin driver
+// under test as a storage driver plugin keeping its records in a JSON file.
+func TestPluginHelperProcess(t *testing.T) {
+	path := os.Getenv(pluginStateEnvVar)
+	if path == "" {
+		return
+	}
+
+	var req PluginRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		os.Exit(2)
+	}
+	state := map[string]*PluginRecord{}
+	if b, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(b, &state)
+	}
+
+	var resp PluginResponse
+	id := req.Namespace + "/" + req.Key
+	switch req.Operation {
+	case PluginOperationGet, PluginOperationDelete:
+		if r, ok := state[id]; ok {
+			resp.Records = []*PluginRecord{r}
+			if req.Operation == PluginOperationDelete {
+				delete(state, id)
+			}
+		} else {
+			resp.Error = &PluginError{Code: PluginErrorNotFound, Message: "not found"}
+		}
+	case PluginOperationList, PluginOperationQuery:
+		for _, r := range state {
+			if labels(r.Labels).match(req.Labels) {
+				resp.Records = append(resp.Records, r)
+			}
+		}
+	case PluginOperationCreate:
+		if _, ok := state[id]; ok {
+			resp.Error = &PluginError{Code: PluginErrorAlreadyExists, Message: "exists"}
+		} else {
+			state[id] = req.Record
+		}
+	case PluginOperationUpdate:
+		if _, ok := state[id]; !ok {
+			resp.Error = &PluginError{Code: PluginErrorNotFound, Message: "not found"}
+		} else {
+			state[id] = req.Record
+		}
+	default:
+		resp.Error = &PluginError{Message: "unknown operation " + req.Operation}
+	}
+
+	b, _ := json.Marshal(state)
+	ioutil.WriteFile(path, b, 0600)
+	json.NewEncoder(os.Stdout).Encode(resp)
+	os.Exit(0)
+}
+
+func newTestFixturePlugin(t *testing.T) *Plugin {
+	p := NewPlugin("test", os.Args[0], []string{"-test.run=TestPluginHelperProcess"}, "default")
+	p.Env = append(os.Environ(), pluginStateEnvVar+"="+filepath.Join(t.TempDir(), "state.json"))
+	return p
+}
+
+func TestPlugin(t *testing.T) {
+	p := newTestFixturePlugin(t)
+
+	vers := 1
+	name := "smug-pigeon"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, "default", rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue"}
+
+	if err := p.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release with key %q: %s", key, err)
+	}
+	if err := p.Create(key, rel); err != ErrReleaseExists {
+		t.Errorf("Expected %v, got %v", ErrReleaseExists, err)
+	}
+
+	got, err := p.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+
+	rels, err := p.Query(map[string]string{"name": name, "owner": "helm", "team": "blue"})
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected 1 release, got %v (error %v)", rels, err)
+	}
+	if _, err := p.Query(map[string]string{"name": "unknown"}); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+
+	rel.Info.Status = rspb.StatusSuperseded
+	if err := p.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release with key %q: %s", key, err)
+	}
+	rels, err = p.List(func(rls *rspb.Release) bool { return rls.Info.Status == rspb.StatusSuperseded })
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected 1 superseded release, got %v (error %v)", rels, err)
+	}
+
+	if _, err := p.Delete(key); err != nil {
+		t.Fatalf("Failed to delete release with key %q: %s", key, err)
+	}
+	if _, err := p.Get(key); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+	if err := p.Update(key, rel); err != ErrReleaseNotFound {
+		t.Errorf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	p := NewPlugin("broken", "false", nil, "default")
+	if _, err := p.Get("key"); err == nil {
+		t.Error("Expected error from failing plugin")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	factory := func(namespace string, _ func(string, ...interface{})) (Driver, error) {
+		return NewMemory(), nil
+	}
+	Register("test-register", factory)
+	if _, ok := Lookup("test-register"); !ok {
+		t.Error("Expected registered driver to be found")
+	}
+	if _, ok := Lookup("unknown"); ok {
+		t.Error("Expected unknown driver not to be found")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a driver twice to panic")
+		}
+	}()
+	Register("test-register", factory)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
+
+import (
+	"sort"
+	"sync"
+)
+
+// Factory creates a Driver storing the releases of namespace. An empty
+// namespace means all namespaces.
+type Factory func(namespace string, log func(string, ...interface{})) (Driver, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes an external storage driver available under name, so that it
+// can be selected like the built-in drivers, for example with $HELM_DRIVER.
+// The built-in drivers take precedence over registered drivers of the same
+// name.
+//
+// Register panics if name is empty, factory is nil or a driver is already
+// registered under name.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if name == "" {
+		panic("storage driver: Register name is empty")
+	}
+	if factory == nil {
+		panic("storage driver: Register factory is nil for " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("storage driver: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Lookup returns the factory of the external storage driver registered under
+// name.
+func Lookup(name string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Registered returns the sorted names of the registered external storage
+// drivers.
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -34,6 +34,8 @@ import (
 )
 
 var _ Driver = (*Secrets)(nil)
+var _ KeyLister = (*Secrets)(nil)
+var _ Appender = (*Secrets)(nil)
 
 // SecretsDriverName is the string name of the driver.
 const SecretsDriverName = "Secret"
@@ -43,14 +45,23 @@ const SecretsDriverName = "Secret"
 type Secrets struct {
 	impl corev1.SecretInterface
 	Log  func(string, ...interface{})
+
+	// ChunkSize is the maximum size in bytes of the encoded release stored
+	// in a single Secret. Larger releases are split across several linked
+	// Secrets. A value of zero or less disables chunking.
+	ChunkSize int
+	// Encryption encrypts the stored releases when set. Releases stored
+	// unencrypted can still be read.
+	Encryption KeyProvider
 }
 
 // NewSecrets initializes a new Secrets wrapping an implementation of
 // the kubernetes SecretsInterface.
 func NewSecrets(impl corev1.SecretInterface) *Secrets {
 	return &Secrets{
-		impl: impl,
-		Log:  func(_ string, _ ...interface{}) {},
+		impl:      impl,
+		Log:       func(_ string, _ ...interface{}) {},
+		ChunkSize: DefaultChunkSize,
 	}
 }
 
@@ -70,9 +81,18 @@ func (secrets *Secrets) Get(key string) (*rspb.Release, error) {
 		}
 		return nil, errors.Wrapf(err, "get: failed to get %q", key)
 	}
-	// found the secret, decode the base64 data string
-	r, err := decodeRelease(string(obj.Data["release"]))
-	return r, errors.Wrapf(err, "get: failed to decode data %q", key)
+	// found the secret, collect the chunks and decode the base64 data string
+	data, err := secrets.releaseData(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get: failed to get %q", key)
+	}
+	r, err := decodeRelease(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get: failed to decode data %q", key)
+	}
+	r.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	r.StorageVersion = obj.ResourceVersion
+	return r, nil
 }
 
 // List fetches all releases and returns the list releases such
@@ -91,14 +111,21 @@ func (secrets *Secrets) List(filter func(*rspb.Release) bool) ([]*rspb.Release,
 
 	// iterate over the secrets object list
 	// and decode each release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(string(item.Data["release"]))
+	for i := range list.Items {
+		item := &list.Items[i]
+		data, err := secrets.releaseData(item)
+		if err != nil {
+			secrets.Log("list: failed to read release %q: %s", item.Name, err)
+			continue
+		}
+		rls, err := decodeRelease(data)
 		if err != nil {
 			secrets.Log("list: failed to decode release: %v: %s", item, err)
 			continue
 		}
 
-		rls.Labels = item.ObjectMeta.Labels
+		rls.Labels = filterSystemLabels(item.ObjectMeta.Labels)
+		rls.StorageVersion = item.ResourceVersion
 
 		if filter(rls) {
 			results = append(results, rls)
@@ -107,6 +134,24 @@ func (secrets *Secrets) List(filter func(*rspb.Release) bool) ([]*rspb.Release,
 	return results, nil
 }
 
+// Keys returns the names of the Secrets holding releases, whether or not
+// the releases can be decoded.
+func (secrets *Secrets) Keys() ([]string, error) {
+	lsel := kblabels.Set{"owner": "helm"}.AsSelector()
+	opts := metav1.ListOptions{LabelSelector: lsel.String()}
+
+	list, err := secrets.impl.List(context.Background(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "keys: failed to list")
+	}
+
+	keys := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		keys = append(keys, item.Name)
+	}
+	return keys, nil
+}
+
 // Query fetches all releases that match the provided map of labels.
 // An error is returned if the secret fails to retrieve the releases.
 func (secrets *Secrets) Query(labels map[string]string) ([]*rspb.Release, error) {
@@ -130,12 +175,20 @@ func (secrets *Secrets) Query(labels map[string]string) ([]*rspb.Release, error)
 	}
 
 	var results []*rspb.Release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(string(item.Data["release"]))
+	for i := range list.Items {
+		item := &list.Items[i]
+		data, err := secrets.releaseData(item)
+		if err != nil {
+			secrets.Log("query: failed to read release %q: %s", item.Name, err)
+			continue
+		}
+		rls, err := decodeRelease(data)
 		if err != nil {
 			secrets.Log("query: failed to decode release: %s", err)
 			continue
 		}
+		rls.Labels = filterSystemLabels(item.ObjectMeta.Labels)
+		rls.StorageVersion = item.ResourceVersion
 		results = append(results, rls)
 	}
 	return results, nil
@@ -155,19 +208,43 @@ func (secrets *Secrets) Create(key string, rls *rspb.Release) error {
 	if err != nil {
 		return errors.Wrapf(err, "create: failed to encode release %q", rls.Name)
 	}
+	if err := secrets.encrypt(obj, rls.Namespace); err != nil {
+		return errors.Wrapf(err, "create: failed to encrypt release %q", rls.Name)
+	}
+	chunks := secrets.chunk(obj)
+	if err := secrets.writeChunks(chunks); err != nil {
+		secrets.deleteChunks(chunks)
+		return errors.Wrap(err, "create: failed to create chunks")
+	}
 	// push the secret object out into the kubiverse
-	if _, err := secrets.impl.Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+	created, err := secrets.impl.Create(context.Background(), obj, metav1.CreateOptions{})
+	if err != nil {
+		secrets.deleteChunks(chunks)
 		if apierrors.IsAlreadyExists(err) {
 			return ErrReleaseExists
 		}
 
 		return errors.Wrap(err, "create: failed to create")
 	}
+	rls.StorageVersion = created.ResourceVersion
 	return nil
 }
 
+// Append creates a new Secret holding the record data of kind.
+func (secrets *Secrets) Append(kind, key string, labels map[string]string, data []byte) error {
+	obj := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: key, Labels: labels},
+		Type:       v1.SecretType("helm.sh/" + kind + ".v1"),
+		Data:       map[string][]byte{kind: data},
+	}
+	_, err := secrets.impl.Create(context.Background(), obj, metav1.CreateOptions{})
+	return errors.Wrapf(err, "append: failed to create %q", key)
+}
+
 // Update updates the Secret holding the release. If not found
-// the Secret is created to hold the release.
+// the Secret is created to hold the release. If the release was read
+// from a version of the Secret that was since modified,
+// ErrReleaseConflict is returned.
 func (secrets *Secrets) Update(key string, rls *rspb.Release) error {
 	// set labels for secrets object meta data
 	var lbs labels
@@ -180,20 +257,203 @@ func (secrets *Secrets) Update(key string, rls *rspb.Release) error {
 	if err != nil {
 		return errors.Wrapf(err, "update: failed to encode release %q", rls.Name)
 	}
+	if err := secrets.encrypt(obj, rls.Namespace); err != nil {
+		return errors.Wrapf(err, "update: failed to encrypt release %q", rls.Name)
+	}
+	// only update the version of the secret the release was read from
+	obj.ResourceVersion = rls.StorageVersion
+	// fetch the current secret to learn the generation of its chunks,
+	// garbage collected once the release no longer refers to them
+	current, err := secrets.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		current = nil
+	} else if err != nil {
+		return errors.Wrapf(err, "update: failed to get %q", key)
+	}
+	if current != nil && rls.StorageVersion != "" && current.ResourceVersion != rls.StorageVersion {
+		return ErrReleaseConflict
+	}
+	chunks := secrets.chunk(obj)
+	if err := secrets.writeChunks(chunks); err != nil {
+		secrets.deleteChunks(chunks)
+		return errors.Wrap(err, "update: failed to create chunks")
+	}
 	// push the secret object out into the kubiverse
-	_, err = secrets.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
-	return errors.Wrap(err, "update: failed to update")
+	updated, err := secrets.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
+	if err != nil {
+		secrets.deleteChunks(chunks)
+		if apierrors.IsConflict(err) {
+			return ErrReleaseConflict
+		}
+		return errors.Wrap(err, "update: failed to update")
+	}
+	rls.StorageVersion = updated.ResourceVersion
+	if current == nil {
+		return nil
+	}
+	// remove the chunks of the previous version
+	stale, err := chunkKeys(key, current.ObjectMeta.Annotations)
+	if err != nil {
+		return errors.Wrap(err, "update: failed to delete stale chunks")
+	}
+	return errors.Wrap(secrets.deleteKeys(stale), "update: failed to delete stale chunks")
 }
 
-// Delete deletes the Secret holding the release named by key.
+// Delete deletes the Secret holding the release named by key, along with
+// the Secrets holding its chunks. The release is deleted even if it cannot
+// be read, e.g. because one of its chunks is missing; nil is returned for
+// it then.
 func (secrets *Secrets) Delete(key string) (rls *rspb.Release, err error) {
-	// fetch the release to check existence
-	if rls, err = secrets.Get(key); err != nil {
-		return nil, err
+	// fetch the secret to check existence
+	obj, err := secrets.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, errors.Wrapf(err, "delete: failed to get %q", key)
+	}
+	if rls, err = secrets.decode(obj); err != nil {
+		secrets.Log("delete: failed to read release %q: %s", key, err)
 	}
 	// delete the release
-	err = secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{})
-	return rls, err
+	if err = secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
+		return rls, err
+	}
+	return rls, errors.Wrap(secrets.deleteReleaseChunks(key), "delete: failed to delete chunks")
+}
+
+// decode returns the release held by obj.
+func (secrets *Secrets) decode(obj *v1.Secret) (*rspb.Release, error) {
+	data, err := secrets.releaseData(obj)
+	if err != nil {
+		return nil, err
+	}
+	rls, err := decodeRelease(data)
+	if err != nil {
+		return nil, err
+	}
+	rls.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	rls.StorageVersion = obj.ResourceVersion
+	return rls, nil
+}
+
+// releaseData returns the encoded release held by obj, joining the chunks
+// stored in additional Secrets if the release was split.
+func (secrets *Secrets) releaseData(obj *v1.Secret) (string, error) {
+	keys, err := chunkKeys(obj.ObjectMeta.Name, obj.ObjectMeta.Annotations)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return decryptPayload(secrets.Encryption, string(obj.Data["release"]), releaseAAD(obj.Namespace, obj.Name))
+	}
+	var b strings.Builder
+	b.Write(obj.Data["release"])
+	for i, key := range keys {
+		chunk, err := secrets.impl.Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get chunk %d of %d", i+2, len(keys)+1)
+		}
+		b.Write(chunk.Data["release"])
+	}
+	return decryptPayload(secrets.Encryption, b.String(), releaseAAD(obj.Namespace, obj.Name))
+}
+
+// encrypt encrypts the encoded release held by obj if Encryption is set,
+// binding it to obj and to the namespace of the release. obj is placed in
+// that namespace, which the API server refuses if it is not the namespace
+// of the driver, rather than storing a payload that cannot be decrypted.
+func (secrets *Secrets) encrypt(obj *v1.Secret, namespace string) error {
+	if secrets.Encryption == nil {
+		return nil
+	}
+	if namespace == "" {
+		return errors.New("releases without a namespace cannot be encrypted")
+	}
+	obj.Namespace = namespace
+	data, err := encryptPayload(secrets.Encryption, string(obj.Data["release"]), releaseAAD(namespace, obj.Name))
+	if err != nil {
+		return err
+	}
+	obj.Data["release"] = []byte(data)
+	return nil
+}
+
+// chunk splits the encoded release held by obj if it exceeds ChunkSize. obj
+// keeps the first chunk and records the number and the new generation of the
+// chunks; the Secrets holding the remaining chunks are returned.
+func (secrets *Secrets) chunk(obj *v1.Secret) []*v1.Secret {
+	parts := splitChunks(string(obj.Data["release"]), secrets.ChunkSize)
+	if len(parts) == 1 {
+		return nil
+	}
+	obj.Data["release"] = []byte(parts[0])
+	generation := newChunkGeneration()
+	obj.ObjectMeta.Annotations = map[string]string{
+		releaseChunksAnnotation:          strconv.Itoa(len(parts)),
+		releaseChunkGenerationAnnotation: generation,
+	}
+
+	chunks := make([]*v1.Secret, 0, len(parts)-1)
+	for i, part := range parts[1:] {
+		chunks = append(chunks, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        chunkKey(obj.ObjectMeta.Name, generation, i+2),
+				Labels:      map[string]string{releaseChunkOfLabel: chunkOfLabel(obj.ObjectMeta.Name)},
+				Annotations: map[string]string{releaseChunkOfAnnotation: obj.ObjectMeta.Name},
+			},
+			Type: "helm.sh/release-chunk.v1",
+			Data: map[string][]byte{"release": []byte(part)},
+		})
+	}
+	return chunks
+}
+
+// writeChunks creates the Secrets holding chunks.
+func (secrets *Secrets) writeChunks(chunks []*v1.Secret) error {
+	for _, chunk := range chunks {
+		if _, err := secrets.impl.Create(context.Background(), chunk, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChunks deletes the Secrets holding chunks, as written by a failed
+// Create or Update. Errors are ignored.
+func (secrets *Secrets) deleteChunks(chunks []*v1.Secret) {
+	for _, chunk := range chunks {
+		secrets.impl.Delete(context.Background(), chunk.ObjectMeta.Name, metav1.DeleteOptions{})
+	}
+}
+
+// deleteKeys deletes the Secrets named by keys, ignoring the ones not found.
+func (secrets *Secrets) deleteKeys(keys []string) error {
+	for _, key := range keys {
+		err := secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReleaseChunks deletes the Secrets holding the chunks of any
+// generation of the release stored under key.
+func (secrets *Secrets) deleteReleaseChunks(key string) error {
+	lsel := kblabels.Set{releaseChunkOfLabel: chunkOfLabel(key)}.AsSelector()
+	list, err := secrets.impl.List(context.Background(), metav1.ListOptions{LabelSelector: lsel.String()})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		// hashed label values could in theory match the chunks of another release
+		if item.ObjectMeta.Annotations[releaseChunkOfAnnotation] == key {
+			keys = append(keys, item.ObjectMeta.Name)
+		}
+	}
+	return secrets.deleteKeys(keys)
 }
 
 // newSecretsObject constructs a kubernetes Secret object
@@ -209,6 +469,7 @@ func (secrets *Secrets) Delete(key string) (rls *rspb.Release, err error) {
 //    "owner"          - owner of the secret, currently "helm".
 //    "name"           - name of the release.
 //
+// The user-defined labels of the release are stored alongside them.
 func newSecretsObject(key string, rls *rspb.Release, lbs labels) (*v1.Secret, error) {
 	const owner = "helm"
 
@@ -222,6 +483,9 @@ func newSecretsObject(key string, rls *rspb.Release, lbs labels) (*v1.Secret, er
 		lbs.init()
 	}
 
+	// apply user-defined labels first so they cannot override the system labels
+	lbs.fromMap(filterSystemLabels(rls.Labels))
+
 	// apply labels
 	lbs.set("name", rls.Name)
 	lbs.set("owner", owner)
@@ -14,12 +14,15 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	rspb "github.com/huolunl/helm/v3/pkg/release"
 )
@@ -180,6 +183,40 @@ func TestSecretCreate(t *testing.T) {
 	}
 }
 
+func TestSecretCreateWithCustomLabels(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue", "owner": "someone-else"}
+
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release with key %q: %s", key, err)
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+
+	// system labels can not be overridden and are not returned
+	expected := map[string]string{"team": "blue"}
+	if !reflect.DeepEqual(expected, got.Labels) {
+		t.Errorf("Expected labels {%v}, got {%v}", expected, got.Labels)
+	}
+
+	rels, err := secrets.Query(map[string]string{"team": "blue", "owner": "helm"})
+	if err != nil {
+		t.Fatalf("Failed to query releases by custom label: %s", err)
+	}
+	if len(rels) != 1 {
+		t.Errorf("Expected 1 release, got %d", len(rels))
+	}
+}
+
 func TestSecretUpdate(t *testing.T) {
 	vers := 1
 	name := "smug-pigeon"
@@ -209,6 +246,57 @@ func TestSecretUpdate(t *testing.T) {
 	}
 }
 
+func TestSecretUpdateConflict(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+
+	secrets := newTestFixtureSecrets(t)
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+
+	// two clients read the same version of the release
+	first, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	second, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+
+	first.Info.Status = rspb.StatusSuperseded
+	if err := secrets.Update(key, first); err != nil {
+		t.Fatalf("Failed to update release: %s", err)
+	}
+	second.Info.Status = rspb.StatusUninstalled
+	if err := secrets.Update(key, second); err != ErrReleaseConflict {
+		t.Fatalf("Expected %v, got %v", ErrReleaseConflict, err)
+	}
+
+	// the release carries the version written, so it can be updated again
+	first.Info.Status = rspb.StatusFailed
+	if err := secrets.Update(key, first); err != nil {
+		t.Fatalf("Failed to update release again: %s", err)
+	}
+
+	// releases without a version overwrite the stored one
+	second.StorageVersion = ""
+	if err := secrets.Update(key, second); err != nil {
+		t.Fatalf("Failed to overwrite release: %s", err)
+	}
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	if got.Info.Status != rspb.StatusUninstalled {
+		t.Errorf("Expected status %s, got status %s", rspb.StatusUninstalled, got.Info.Status)
+	}
+}
+
 func TestSecretDelete(t *testing.T) {
 	vers := 1
 	name := "smug-pigeon"
@@ -239,3 +327,183 @@ func TestSecretDelete(t *testing.T) {
 		t.Errorf("Expected {%v}, got {%v}", ErrReleaseNotFound, err)
 	}
 }
+
+func TestSecretChunkedRelease(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 64
+	mock := secrets.impl.(*MockSecretsInterface)
+
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+	rel.Manifest = strings.Repeat("kind: ConfigMap\n", 64)
+
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release with key %q: %s", key, err)
+	}
+	if len(mock.objects) < 3 {
+		t.Fatalf("Expected release to be split across several secrets, got %d", len(mock.objects))
+	}
+	if err := secrets.Create(key, rel); err != ErrReleaseExists {
+		t.Errorf("Expected %v, got %v", ErrReleaseExists, err)
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+
+	// chunks are not listed as releases
+	rels, err := secrets.List(func(_ *rspb.Release) bool { return true })
+	if err != nil {
+		t.Fatalf("Failed to list releases: %s", err)
+	}
+	if len(rels) != 1 || !reflect.DeepEqual(rel, rels[0]) {
+		t.Errorf("Expected only the chunked release, got %v", rels)
+	}
+
+	// an update fitting in a single object removes the chunks no longer needed
+	secrets.ChunkSize = DefaultChunkSize
+	rel.Manifest = ""
+	rel.Info.Status = rspb.StatusSuperseded
+	if err := secrets.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release with key %q: %s", key, err)
+	}
+	if len(mock.objects) != 1 {
+		t.Errorf("Expected stale chunks to be deleted, got %d secrets", len(mock.objects))
+	}
+	if got, err = secrets.Get(key); err != nil || got.Info.Status != rspb.StatusSuperseded {
+		t.Errorf("Expected updated release, got %v (error %v)", got, err)
+	}
+
+	secrets.ChunkSize = 64
+	rel.Manifest = strings.Repeat("kind: Secret\n", 64)
+	if err := secrets.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release with key %q: %s", key, err)
+	}
+	if _, err := secrets.Delete(key); err != nil {
+		t.Fatalf("Failed to delete release with key %q: %s", key, err)
+	}
+	if len(mock.objects) != 0 {
+		t.Errorf("Expected chunks to be deleted with the release, got %d secrets", len(mock.objects))
+	}
+}
+
+// racingSecrets runs race before the first update of the Secret holding a
+// release, as if another client updated it concurrently.
+type racingSecrets struct {
+	*MockSecretsInterface
+	race func()
+}
+
+func (r *racingSecrets) Update(ctx context.Context, obj *v1.Secret, opts metav1.UpdateOptions) (*v1.Secret, error) {
+	if race := r.race; race != nil && obj.Name == testKey("smug-pigeon", 1) {
+		r.race = nil
+		race()
+	}
+	return r.MockSecretsInterface.Update(ctx, obj, opts)
+}
+
+func TestSecretChunkedUpdateConflict(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 64
+	mock := secrets.impl.(*MockSecretsInterface)
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = strings.Repeat("kind: ConfigMap\n", 64)
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release with key %q: %s", key, err)
+	}
+
+	// two clients read the same version of the release
+	first, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	second, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+
+	// the first one wins the race after the second one wrote its chunks
+	first.Info.Status = rspb.StatusSuperseded
+	first.Manifest = strings.Repeat("kind: Secret\n", 64)
+	secrets.impl = &racingSecrets{mock, func() {
+		if err := secrets.Update(key, first); err != nil {
+			t.Fatalf("Failed to update release with key %q: %s", key, err)
+		}
+	}}
+	second.Info.Status = rspb.StatusUninstalled
+	second.Manifest = strings.Repeat("kind: Pod\n", 64)
+	if err := secrets.Update(key, second); err != ErrReleaseConflict {
+		t.Fatalf("Expected %v, got %v", ErrReleaseConflict, err)
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release with key %q: %s", key, err)
+	}
+	if !reflect.DeepEqual(first, got) {
+		t.Errorf("Expected {%v}, got {%v}", first, got)
+	}
+	// only the chunks of the winner are left
+	chunks, err := chunkKeys(key, mock.objects[key].ObjectMeta.Annotations)
+	if err != nil {
+		t.Fatalf("Failed to get the chunks of release with key %q: %s", key, err)
+	}
+	if len(mock.objects) != len(chunks)+1 {
+		t.Errorf("Expected %d secrets, got %d", len(chunks)+1, len(mock.objects))
+	}
+}
+
+func TestSecretDeleteMissingChunk(t *testing.T) {
+	secrets := newTestFixtureSecrets(t, releaseStub("rls-a", 1, "default", rspb.StatusDeployed))
+	secrets.ChunkSize = 64
+	mock := secrets.impl.(*MockSecretsInterface)
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = strings.Repeat("kind: ConfigMap\n", 64)
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release with key %q: %s", key, err)
+	}
+	chunks, err := chunkKeys(key, mock.objects[key].ObjectMeta.Annotations)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("Expected release to be split across several secrets, got %v (error %v)", chunks, err)
+	}
+	delete(mock.objects, chunks[0])
+
+	if _, err := secrets.Get(key); err == nil {
+		t.Fatalf("Expected an error getting a release with a missing chunk")
+	}
+	if _, err := secrets.Delete(key); err != nil {
+		t.Fatalf("Failed to delete release with key %q: %s", key, err)
+	}
+	if _, ok := mock.objects[testKey("rls-a", 1)]; len(mock.objects) != 1 || !ok {
+		t.Errorf("Expected only the other release to be left, got %d secrets", len(mock.objects))
+	}
+}
+
+func TestSecretAppend(t *testing.T) {
+	secrets := newTestFixtureSecrets(t, releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed))
+
+	labels := map[string]string{"owner": "helm-audit"}
+	if err := secrets.Append("audit", "sh.helm.audit.v1.smug-pigeon.1", labels, []byte(`{"operation":"install"}`)); err != nil {
+		t.Fatalf("Failed to append record: %s", err)
+	}
+
+	obj := secrets.impl.(*MockSecretsInterface).objects["sh.helm.audit.v1.smug-pigeon.1"]
+	if obj == nil || obj.Type != "helm.sh/audit.v1" || string(obj.Data["audit"]) != `{"operation":"install"}` {
+		t.Errorf("Unexpected record Secret %v", obj)
+	}
+	rels, err := secrets.List(func(*rspb.Release) bool { return true })
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected the release only, got %v (error %v)", rels, err)
+	}
+}
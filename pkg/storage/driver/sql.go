@@ -17,11 +17,15 @@ limitations under the License.
 package driver // import "github.com/huolunl/helm/v3/pkg/storage/driver"
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	migrate "github.com/rubenv/sql-migrate"
 
 	sq "github.com/Masterminds/squirrel"
@@ -33,6 +37,9 @@ import (
 )
 
 var _ Driver = (*SQL)(nil)
+var _ Paginator = (*SQL)(nil)
+var _ KeyLister = (*SQL)(nil)
+var _ Appender = (*SQL)(nil)
 
 var labelMap = map[string]struct{}{
 	"modifiedAt": {},
@@ -43,8 +50,6 @@ var labelMap = map[string]struct{}{
 	"name":       {},
 }
 
-const postgreSQLDialect = "postgres"
-
 // SQLDriverName is the string name of this driver.
 const SQLDriverName = "SQL"
 
@@ -61,6 +66,35 @@ const (
 	sqlReleaseTableOwnerColumn      = "owner"
 	sqlReleaseTableCreatedAtColumn  = "createdAt"
 	sqlReleaseTableModifiedAtColumn = "modifiedAt"
+	sqlReleaseTableGenerationColumn = "generation"
+)
+
+const sqlCustomLabelsTableName = "custom_labels_v1"
+
+const (
+	sqlCustomLabelsTableReleaseKeyColumn       = "releaseKey"
+	sqlCustomLabelsTableReleaseNamespaceColumn = "releaseNamespace"
+	sqlCustomLabelsTableKeyColumn              = "key"
+	sqlCustomLabelsTableValueColumn            = "value"
+)
+
+// sqlRecordsTableName is the table of the records other than releases,
+// stored by Append.
+const sqlRecordsTableName = "records_v1"
+
+const (
+	sqlRecordsTableKeyColumn       = "recordKey"
+	sqlRecordsTableKindColumn      = "kind"
+	sqlRecordsTableNamespaceColumn = "namespace"
+	sqlRecordsTableLabelsColumn    = "labels"
+	sqlRecordsTableBodyColumn      = "body"
+	sqlRecordsTableCreatedAtColumn = "createdAt"
+)
+
+// Following limits based on k8s labels limits - https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#syntax-and-character-set
+const (
+	sqlCustomLabelsTableKeyMaxLength   = 253 + 1 + 63
+	sqlCustomLabelsTableValueMaxLength = 63
 )
 
 const (
@@ -71,10 +105,14 @@ const (
 // SQL is the sql storage driver implementation.
 type SQL struct {
 	db               *sqlx.DB
+	dialect          string
 	namespace        string
 	statementBuilder sq.StatementBuilderType
 
 	Log func(string, ...interface{})
+	// Encryption encrypts the stored releases when set. Releases stored
+	// unencrypted can still be read.
+	Encryption KeyProvider
 }
 
 // Name returns the name of the driver.
@@ -85,75 +123,10 @@ func (s *SQL) Name() string {
 func (s *SQL) ensureDBSetup() error {
 	// Populate the database with the relations we need if they don't exist yet
 	migrations := &migrate.MemoryMigrationSource{
-		Migrations: []*migrate.Migration{
-			{
-				Id: "init",
-				Up: []string{
-					fmt.Sprintf(`
-						CREATE TABLE %s (
-							%s VARCHAR(67),
-							%s VARCHAR(64) NOT NULL,
-							%s TEXT NOT NULL,
-							%s VARCHAR(64) NOT NULL,
-							%s VARCHAR(64) NOT NULL,
-							%s INTEGER NOT NULL,
-							%s TEXT NOT NULL,
-							%s TEXT NOT NULL,
-							%s INTEGER NOT NULL,
-							%s INTEGER NOT NULL DEFAULT 0,
-							PRIMARY KEY(%s, %s)
-						);
-						CREATE INDEX ON %s (%s, %s);
-						CREATE INDEX ON %s (%s);
-						CREATE INDEX ON %s (%s);
-						CREATE INDEX ON %s (%s);
-						CREATE INDEX ON %s (%s);
-						CREATE INDEX ON %s (%s);
-						
-						GRANT ALL ON %s TO PUBLIC;
-
-						ALTER TABLE %s ENABLE ROW LEVEL SECURITY;
-					`,
-						sqlReleaseTableName,
-						sqlReleaseTableKeyColumn,
-						sqlReleaseTableTypeColumn,
-						sqlReleaseTableBodyColumn,
-						sqlReleaseTableNameColumn,
-						sqlReleaseTableNamespaceColumn,
-						sqlReleaseTableVersionColumn,
-						sqlReleaseTableStatusColumn,
-						sqlReleaseTableOwnerColumn,
-						sqlReleaseTableCreatedAtColumn,
-						sqlReleaseTableModifiedAtColumn,
-						sqlReleaseTableKeyColumn,
-						sqlReleaseTableNamespaceColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableKeyColumn,
-						sqlReleaseTableNamespaceColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableVersionColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableStatusColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableOwnerColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableCreatedAtColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableModifiedAtColumn,
-						sqlReleaseTableName,
-						sqlReleaseTableName,
-					),
-				},
-				Down: []string{
-					fmt.Sprintf(`
-						DROP TABLE %s;
-					`, sqlReleaseTableName),
-				},
-			},
-		},
-	}
-
-	_, err := migrate.Exec(s.db.DB, postgreSQLDialect, migrations, migrate.Up)
+		Migrations: sqlMigrations(s.dialect),
+	}
+
+	_, err := migrate.Exec(s.db.DB, s.dialect, migrations, migrate.Up)
 	return err
 }
 
@@ -178,19 +151,47 @@ type SQLReleaseWrapper struct {
 	Owner      string `db:"owner"`
 	CreatedAt  int    `db:"createdAt"`
 	ModifiedAt int    `db:"modifiedAt"`
+
+	// Generation is incremented by every update of the release. It serves
+	// as the StorageVersion of the release.
+	Generation int `db:"generation"`
+}
+
+// SQLReleaseCustomLabelWrapper describes how the user-defined labels of a
+// Helm release are stored in an SQL database
+type SQLReleaseCustomLabelWrapper struct {
+	ReleaseKey       string `db:"releaseKey"`
+	ReleaseNamespace string `db:"releaseNamespace"`
+	Key              string `db:"key"`
+	Value            string `db:"value"`
 }
 
 // NewSQL initializes a new sql driver.
+//
+// The SQL dialect is selected from the connection string, see
+// parseSQLConnectionString.
 func NewSQL(connectionString string, logger func(string, ...interface{}), namespace string) (*SQL, error) {
-	db, err := sqlx.Connect(postgreSQLDialect, connectionString)
+	dialect, dataSourceName, err := parseSQLConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Connect(sqlDriverName(dialect), dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 
+	if dialect == sqliteDialect {
+		// SQLite locks the whole database for writes, serialize all access
+		// through a single connection instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+
 	driver := &SQL{
 		db:               db,
+		dialect:          dialect,
 		Log:              logger,
-		statementBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+		statementBuilder: sq.StatementBuilder.PlaceholderFormat(sqlPlaceholderFormat(dialect)),
 	}
 
 	if err := driver.ensureDBSetup(); err != nil {
@@ -207,9 +208,9 @@ func (s *SQL) Get(key string) (*rspb.Release, error) {
 	var record SQLReleaseWrapper
 
 	qb := s.statementBuilder.
-		Select(sqlReleaseTableBodyColumn).
+		Select(sqlReleaseTableBodyColumn, sqlReleaseTableGenerationColumn).
 		From(sqlReleaseTableName).
-		Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
+		Where(sq.Eq{s.quote(sqlReleaseTableKeyColumn): key}).
 		Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace})
 
 	query, args, err := qb.ToSql()
@@ -224,11 +225,17 @@ func (s *SQL) Get(key string) (*rspb.Release, error) {
 		return nil, ErrReleaseNotFound
 	}
 
-	release, err := decodeRelease(record.Body)
+	release, err := s.decodeRelease(key, s.namespace, record.Body)
 	if err != nil {
 		s.Log("get: failed to decode data %q: %v", key, err)
 		return nil, err
 	}
+	release.StorageVersion = strconv.Itoa(record.Generation)
+
+	if release.Labels, err = s.getReleaseCustomLabels(s.db, key, s.namespace); err != nil {
+		s.Log("failed to get release %s/%s custom labels: %v", s.namespace, key, err)
+		return nil, err
+	}
 
 	return release, nil
 }
@@ -236,7 +243,7 @@ func (s *SQL) Get(key string) (*rspb.Release, error) {
 // List returns the list of all releases such that filter(release) == true
 func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 	sb := s.statementBuilder.
-		Select(sqlReleaseTableBodyColumn).
+		Select(s.quote(sqlReleaseTableKeyColumn), sqlReleaseTableNamespaceColumn, sqlReleaseTableBodyColumn, sqlReleaseTableGenerationColumn).
 		From(sqlReleaseTableName).
 		Where(sq.Eq{sqlReleaseTableOwnerColumn: sqlReleaseDefaultOwner})
 
@@ -259,11 +266,18 @@ func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 
 	var releases []*rspb.Release
 	for _, record := range records {
-		release, err := decodeRelease(record.Body)
+		release, err := s.decodeRelease(record.Key, record.Namespace, record.Body)
 		if err != nil {
 			s.Log("list: failed to decode release: %v: %v", record, err)
 			continue
 		}
+		release.StorageVersion = strconv.Itoa(record.Generation)
+
+		if release.Labels, err = s.getReleaseCustomLabels(s.db, record.Key, record.Namespace); err != nil {
+			s.Log("failed to get release %s/%s custom labels: %v", record.Namespace, record.Key, err)
+			return nil, err
+		}
+
 		if filter(release) {
 			releases = append(releases, release)
 		}
@@ -272,10 +286,127 @@ func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 	return releases, nil
 }
 
+// ListPage returns the releases matching opts. Unlike List, the filtering,
+// ordering and pagination are evaluated by the database.
+func (s *SQL) ListPage(opts ListOptions) ([]*rspb.Release, error) {
+	sb := s.statementBuilder.
+		Select(s.quote(sqlReleaseTableKeyColumn), sqlReleaseTableNamespaceColumn, sqlReleaseTableBodyColumn, sqlReleaseTableGenerationColumn).
+		From(sqlReleaseTableName).
+		Where(sq.Eq{sqlReleaseTableOwnerColumn: sqlReleaseDefaultOwner})
+
+	// If a namespace was specified, we only list releases from that namespace
+	if s.namespace != "" {
+		sb = sb.Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace})
+	}
+
+	if opts.LatestOnly {
+		sb = sb.Where(fmt.Sprintf(
+			"%[2]s = (SELECT MAX(latest.%[2]s) FROM %[1]s latest WHERE latest.%[3]s = %[1]s.%[3]s AND latest.%[4]s = %[1]s.%[4]s AND latest.%[5]s = ?)",
+			sqlReleaseTableName,
+			sqlReleaseTableVersionColumn,
+			sqlReleaseTableNameColumn,
+			sqlReleaseTableNamespaceColumn,
+			sqlReleaseTableOwnerColumn,
+		), sqlReleaseDefaultOwner)
+	}
+
+	if len(opts.Statuses) > 0 {
+		statuses := make([]string, 0, len(opts.Statuses))
+		for _, status := range opts.Statuses {
+			statuses = append(statuses, status.String())
+		}
+		sb = sb.Where(sq.Eq{sqlReleaseTableStatusColumn: statuses})
+	}
+
+	if opts.NamePattern != "" {
+		sb = sb.Where(s.regexpMatch(sqlReleaseTableNameColumn), opts.NamePattern)
+	}
+
+	order := "ASC"
+	if opts.SortDescending {
+		order = "DESC"
+	}
+	sb = sb.OrderBy(
+		sqlReleaseTableNameColumn+" "+order,
+		sqlReleaseTableNamespaceColumn+" "+order,
+		sqlReleaseTableVersionColumn+" "+order,
+	)
+
+	if opts.Limit > 0 {
+		sb = sb.Limit(uint64(opts.Limit))
+	} else if opts.Offset > 0 {
+		// MySQL and SQLite only accept OFFSET along with LIMIT
+		sb = sb.Limit(math.MaxInt64)
+	}
+	if opts.Offset > 0 {
+		sb = sb.Offset(uint64(opts.Offset))
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		s.Log("failed to build query: %v", err)
+		return nil, err
+	}
+
+	var records = []SQLReleaseWrapper{}
+	if err := s.db.Select(&records, query, args...); err != nil {
+		s.Log("list: failed to list: %v", err)
+		return nil, err
+	}
+
+	var releases []*rspb.Release
+	for _, record := range records {
+		release, err := s.decodeRelease(record.Key, record.Namespace, record.Body)
+		if err != nil {
+			s.Log("list: failed to decode release: %v: %v", record, err)
+			continue
+		}
+		release.StorageVersion = strconv.Itoa(record.Generation)
+
+		if release.Labels, err = s.getReleaseCustomLabels(s.db, record.Key, record.Namespace); err != nil {
+			s.Log("failed to get release %s/%s custom labels: %v", record.Namespace, record.Key, err)
+			return nil, err
+		}
+
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
+
+// Keys returns the keys of the stored releases, whether or not they can be
+// decoded.
+func (s *SQL) Keys() ([]string, error) {
+	sb := s.statementBuilder.
+		Select(s.quote(sqlReleaseTableKeyColumn)).
+		From(sqlReleaseTableName).
+		Where(sq.Eq{sqlReleaseTableOwnerColumn: sqlReleaseDefaultOwner})
+
+	// If a namespace was specified, we only list releases from that namespace
+	if s.namespace != "" {
+		sb = sb.Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace})
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		s.Log("failed to build query: %v", err)
+		return nil, err
+	}
+
+	var keys []string
+	if err := s.db.Select(&keys, query, args...); err != nil {
+		s.Log("keys: failed to list: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
 // Query returns the set of releases that match the provided set of labels.
+// Labels other than the system labels are matched against the user-defined
+// labels of the releases.
 func (s *SQL) Query(labels map[string]string) ([]*rspb.Release, error) {
 	sb := s.statementBuilder.
-		Select(sqlReleaseTableBodyColumn).
+		Select(s.quote(sqlReleaseTableKeyColumn), sqlReleaseTableNamespaceColumn, sqlReleaseTableBodyColumn, sqlReleaseTableGenerationColumn).
 		From(sqlReleaseTableName)
 
 	keys := make([]string, 0, len(labels))
@@ -287,8 +418,18 @@ func (s *SQL) Query(labels map[string]string) ([]*rspb.Release, error) {
 		if _, ok := labelMap[key]; ok {
 			sb = sb.Where(sq.Eq{key: labels[key]})
 		} else {
-			s.Log("unknown label %s", key)
-			return nil, fmt.Errorf("unknown label %s", key)
+			sb = sb.Where(sq.Expr(
+				fmt.Sprintf("(%s, %s) IN (SELECT %s, %s FROM %s WHERE %s = ? AND %s = ?)",
+					s.quote(sqlReleaseTableKeyColumn),
+					sqlReleaseTableNamespaceColumn,
+					sqlCustomLabelsTableReleaseKeyColumn,
+					sqlCustomLabelsTableReleaseNamespaceColumn,
+					sqlCustomLabelsTableName,
+					s.quote(sqlCustomLabelsTableKeyColumn),
+					sqlCustomLabelsTableValueColumn,
+				),
+				key, labels[key],
+			))
 		}
 	}
 
@@ -316,11 +457,18 @@ func (s *SQL) Query(labels map[string]string) ([]*rspb.Release, error) {
 
 	var releases []*rspb.Release
 	for _, record := range records {
-		release, err := decodeRelease(record.Body)
+		release, err := s.decodeRelease(record.Key, record.Namespace, record.Body)
 		if err != nil {
 			s.Log("list: failed to decode release: %v: %v", record, err)
 			continue
 		}
+		release.StorageVersion = strconv.Itoa(record.Generation)
+
+		if release.Labels, err = s.getReleaseCustomLabels(s.db, record.Key, record.Namespace); err != nil {
+			s.Log("failed to get release %s/%s custom labels: %v", record.Namespace, record.Key, err)
+			return nil, err
+		}
+
 		releases = append(releases, release)
 	}
 
@@ -339,7 +487,7 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 	}
 	s.namespace = namespace
 
-	body, err := encodeRelease(rls)
+	body, err := s.encodeRelease(key, namespace, rls)
 	if err != nil {
 		s.Log("failed to encode release: %v", err)
 		return err
@@ -354,7 +502,7 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 	insertQuery, args, err := s.statementBuilder.
 		Insert(sqlReleaseTableName).
 		Columns(
-			sqlReleaseTableKeyColumn,
+			s.quote(sqlReleaseTableKeyColumn),
 			sqlReleaseTableTypeColumn,
 			sqlReleaseTableBodyColumn,
 			sqlReleaseTableNameColumn,
@@ -384,9 +532,9 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 		defer transaction.Rollback()
 
 		selectQuery, args, buildErr := s.statementBuilder.
-			Select(sqlReleaseTableKeyColumn).
+			Select(s.quote(sqlReleaseTableKeyColumn)).
 			From(sqlReleaseTableName).
-			Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
+			Where(sq.Eq{s.quote(sqlReleaseTableKeyColumn): key}).
 			Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace}).
 			ToSql()
 		if buildErr != nil {
@@ -403,12 +551,62 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 		s.Log("failed to store release %s in SQL database: %v", key, err)
 		return err
 	}
+
+	if err := s.insertCustomLabels(transaction, key, namespace, rls.Labels); err != nil {
+		defer transaction.Rollback()
+		s.Log("failed to store release %s custom labels in SQL database: %v", key, err)
+		return err
+	}
 	defer transaction.Commit()
 
+	// the generation of new records is 0
+	rls.StorageVersion = "0"
 	return nil
 }
 
-// Update updates a release.
+// Append stores the record data of kind in the records table. Its labels are
+// stored JSON-encoded.
+func (s *SQL) Append(kind, key string, labels map[string]string, data []byte) error {
+	namespace := s.namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	lbs, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	insertQuery, args, err := s.statementBuilder.
+		Insert(sqlRecordsTableName).
+		Columns(
+			sqlRecordsTableKeyColumn,
+			sqlRecordsTableKindColumn,
+			sqlRecordsTableNamespaceColumn,
+			sqlRecordsTableLabelsColumn,
+			sqlRecordsTableBodyColumn,
+			sqlRecordsTableCreatedAtColumn,
+		).
+		Values(
+			key,
+			kind,
+			namespace,
+			string(lbs),
+			string(data),
+			int(time.Now().Unix()),
+		).ToSql()
+	if err != nil {
+		s.Log("failed to build insert query: %v", err)
+		return err
+	}
+	if _, err := s.db.Exec(insertQuery, args...); err != nil {
+		s.Log("failed to store record %s in SQL database: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// Update updates a release. If the release was read from a record that was
+// since updated, ErrReleaseConflict is returned.
 func (s *SQL) Update(key string, rls *rspb.Release) error {
 	namespace := rls.Namespace
 	if namespace == "" {
@@ -416,13 +614,13 @@ func (s *SQL) Update(key string, rls *rspb.Release) error {
 	}
 	s.namespace = namespace
 
-	body, err := encodeRelease(rls)
+	body, err := s.encodeRelease(key, namespace, rls)
 	if err != nil {
 		s.Log("failed to encode release: %v", err)
 		return err
 	}
 
-	query, args, err := s.statementBuilder.
+	ub := s.statementBuilder.
 		Update(sqlReleaseTableName).
 		Set(sqlReleaseTableBodyColumn, body).
 		Set(sqlReleaseTableNameColumn, rls.Name).
@@ -430,20 +628,72 @@ func (s *SQL) Update(key string, rls *rspb.Release) error {
 		Set(sqlReleaseTableStatusColumn, rls.Info.Status.String()).
 		Set(sqlReleaseTableOwnerColumn, sqlReleaseDefaultOwner).
 		Set(sqlReleaseTableModifiedAtColumn, int(time.Now().Unix())).
-		Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
-		Where(sq.Eq{sqlReleaseTableNamespaceColumn: namespace}).
-		ToSql()
+		Set(sqlReleaseTableGenerationColumn, sq.Expr(sqlReleaseTableGenerationColumn+" + 1")).
+		Where(sq.Eq{s.quote(sqlReleaseTableKeyColumn): key}).
+		Where(sq.Eq{sqlReleaseTableNamespaceColumn: namespace})
+
+	// only update the generation of the record the release was read from
+	generation := -1
+	if rls.StorageVersion != "" {
+		if generation, err = strconv.Atoi(rls.StorageVersion); err != nil {
+			return errors.Wrapf(err, "invalid storage version %q", rls.StorageVersion)
+		}
+		ub = ub.Where(sq.Eq{sqlReleaseTableGenerationColumn: generation})
+	}
 
+	query, args, err := ub.ToSql()
 	if err != nil {
 		s.Log("failed to build update query: %v", err)
 		return err
 	}
 
-	if _, err := s.db.Exec(query, args...); err != nil {
+	transaction, err := s.db.Beginx()
+	if err != nil {
+		s.Log("failed to start SQL transaction: %v", err)
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+
+	result, err := transaction.Exec(query, args...)
+	if err != nil {
+		defer transaction.Rollback()
+		s.Log("failed to update release %s in SQL database: %v", key, err)
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		defer transaction.Rollback()
 		s.Log("failed to update release %s in SQL database: %v", key, err)
 		return err
 	}
+	if n == 0 {
+		transaction.Rollback()
+		if generation < 0 {
+			return ErrReleaseNotFound
+		}
+		if _, err := s.Get(key); err != nil {
+			return ErrReleaseNotFound
+		}
+		return ErrReleaseConflict
+	}
 
+	if err := s.deleteCustomLabels(transaction, key, namespace); err != nil {
+		defer transaction.Rollback()
+		s.Log("failed to delete release %s custom labels in SQL database: %v", key, err)
+		return err
+	}
+
+	if err := s.insertCustomLabels(transaction, key, namespace, rls.Labels); err != nil {
+		defer transaction.Rollback()
+		s.Log("failed to store release %s custom labels in SQL database: %v", key, err)
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	if generation >= 0 {
+		rls.StorageVersion = strconv.Itoa(generation + 1)
+	}
 	return nil
 }
 
@@ -456,9 +706,9 @@ func (s *SQL) Delete(key string) (*rspb.Release, error) {
 	}
 
 	selectQuery, args, err := s.statementBuilder.
-		Select(sqlReleaseTableBodyColumn).
+		Select(sqlReleaseTableBodyColumn, sqlReleaseTableGenerationColumn).
 		From(sqlReleaseTableName).
-		Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
+		Where(sq.Eq{s.quote(sqlReleaseTableKeyColumn): key}).
 		Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace}).
 		ToSql()
 	if err != nil {
@@ -473,17 +723,23 @@ func (s *SQL) Delete(key string) (*rspb.Release, error) {
 		return nil, ErrReleaseNotFound
 	}
 
-	release, err := decodeRelease(record.Body)
+	release, err := s.decodeRelease(key, s.namespace, record.Body)
 	if err != nil {
 		s.Log("failed to decode release %s: %v", key, err)
 		transaction.Rollback()
 		return nil, err
 	}
+	release.StorageVersion = strconv.Itoa(record.Generation)
 	defer transaction.Commit()
 
+	if release.Labels, err = s.getReleaseCustomLabels(transaction, key, s.namespace); err != nil {
+		s.Log("failed to get release %s/%s custom labels: %v", s.namespace, key, err)
+		return nil, err
+	}
+
 	deleteQuery, args, err := s.statementBuilder.
 		Delete(sqlReleaseTableName).
-		Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
+		Where(sq.Eq{s.quote(sqlReleaseTableKeyColumn): key}).
 		Where(sq.Eq{sqlReleaseTableNamespaceColumn: s.namespace}).
 		ToSql()
 	if err != nil {
@@ -491,6 +747,110 @@ func (s *SQL) Delete(key string) (*rspb.Release, error) {
 		return nil, err
 	}
 
+	if _, err := transaction.Exec(deleteQuery, args...); err != nil {
+		s.Log("failed to delete release %s: %v", key, err)
+		return release, err
+	}
+
+	if err := s.deleteCustomLabels(transaction, key, s.namespace); err != nil {
+		s.Log("failed to delete release %s custom labels: %v", key, err)
+		return release, err
+	}
+
+	return release, nil
+}
+
+// getReleaseCustomLabels returns the user-defined labels of the release
+// stored under key in namespace.
+func (s *SQL) getReleaseCustomLabels(q sqlx.Queryer, key string, namespace string) (map[string]string, error) {
+	query, args, err := s.statementBuilder.
+		Select(s.quote(sqlCustomLabelsTableKeyColumn), sqlCustomLabelsTableValueColumn).
+		From(sqlCustomLabelsTableName).
+		Where(sq.Eq{sqlCustomLabelsTableReleaseKeyColumn: key}).
+		Where(sq.Eq{sqlCustomLabelsTableReleaseNamespaceColumn: namespace}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var records = []SQLReleaseCustomLabelWrapper{}
+	if err := sqlx.Select(q, &records, query, args...); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(records))
+	for _, record := range records {
+		labels[record.Key] = record.Value
+	}
+	return labels, nil
+}
+
+// insertCustomLabels stores the user-defined labels of the release stored
+// under key in namespace. System labels are ignored.
+func (s *SQL) insertCustomLabels(transaction *sqlx.Tx, key string, namespace string, labels map[string]string) error {
+	labels = filterSystemLabels(labels)
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		insertQuery, args, err := s.statementBuilder.
+			Insert(sqlCustomLabelsTableName).
+			Columns(
+				sqlCustomLabelsTableReleaseKeyColumn,
+				sqlCustomLabelsTableReleaseNamespaceColumn,
+				s.quote(sqlCustomLabelsTableKeyColumn),
+				sqlCustomLabelsTableValueColumn,
+			).
+			Values(key, namespace, k, labels[k]).
+			ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := transaction.Exec(insertQuery, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCustomLabels removes the user-defined labels of the release stored
+// under key in namespace.
+func (s *SQL) deleteCustomLabels(transaction *sqlx.Tx, key string, namespace string) error {
+	deleteQuery, args, err := s.statementBuilder.
+		Delete(sqlCustomLabelsTableName).
+		Where(sq.Eq{sqlCustomLabelsTableReleaseKeyColumn: key}).
+		Where(sq.Eq{sqlCustomLabelsTableReleaseNamespaceColumn: namespace}).
+		ToSql()
+	if err != nil {
+		return err
+	}
 	_, err = transaction.Exec(deleteQuery, args...)
-	return release, err
+	return err
+}
+
+// encodeRelease encodes the release stored in the body column of the record
+// with key in namespace, encrypting it if Encryption is set.
+func (s *SQL) encodeRelease(key, namespace string, rls *rspb.Release) (string, error) {
+	body, err := encodeRelease(rls)
+	if err != nil {
+		return "", err
+	}
+	return encryptPayload(s.Encryption, body, releaseAAD(namespace, key))
+}
+
+// decodeRelease decodes the release stored in the body column of the record
+// with key in namespace.
+func (s *SQL) decodeRelease(key, namespace, body string) (*rspb.Release, error) {
+	data, err := decryptPayload(s.Encryption, body, releaseAAD(namespace, key))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRelease(data)
 }
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"testing"
 	"time"
 
@@ -40,12 +41,14 @@ func TestSQLGet(t *testing.T) {
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
 	body, _ := encodeRelease(rel)
+	rel.StorageVersion = "0"
 
 	sqlDriver, mock := newTestFixtureSQL(t)
 
 	query := fmt.Sprintf(
-		regexp.QuoteMeta("SELECT %s FROM %s WHERE %s = $1 AND %s = $2"),
+		regexp.QuoteMeta("SELECT %s, %s FROM %s WHERE %s = $1 AND %s = $2"),
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableGenerationColumn,
 		sqlReleaseTableName,
 		sqlReleaseTableKeyColumn,
 		sqlReleaseTableNamespaceColumn,
@@ -57,11 +60,14 @@ func TestSQLGet(t *testing.T) {
 		WillReturnRows(
 			mock.NewRows([]string{
 				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
 			}).AddRow(
-				body,
+				body, 0,
 			),
 		).RowsWillBeClosed()
 
+	mockGetReleaseCustomLabels(mock, key, namespace, rel.Labels)
+
 	got, err := sqlDriver.Get(key)
 	if err != nil {
 		t.Fatalf("Failed to get release: %v", err)
@@ -88,8 +94,11 @@ func TestSQLList(t *testing.T) {
 
 	for i := 0; i < 3; i++ {
 		query := fmt.Sprintf(
-			"SELECT %s FROM %s WHERE %s = $1 AND %s = $2",
+			"SELECT %s, %s, %s, %s FROM %s WHERE %s = $1 AND %s = $2",
+			sqlReleaseTableKeyColumn,
+			sqlReleaseTableNamespaceColumn,
 			sqlReleaseTableBodyColumn,
+			sqlReleaseTableGenerationColumn,
 			sqlReleaseTableName,
 			sqlReleaseTableOwnerColumn,
 			sqlReleaseTableNamespaceColumn,
@@ -100,15 +109,22 @@ func TestSQLList(t *testing.T) {
 			WithArgs(sqlReleaseDefaultOwner, sqlDriver.namespace).
 			WillReturnRows(
 				mock.NewRows([]string{
+					sqlReleaseTableKeyColumn,
+					sqlReleaseTableNamespaceColumn,
 					sqlReleaseTableBodyColumn,
+					sqlReleaseTableGenerationColumn,
 				}).
-					AddRow(body1).
-					AddRow(body2).
-					AddRow(body3).
-					AddRow(body4).
-					AddRow(body5).
-					AddRow(body6),
+					AddRow(testKey("key-1", 1), "default", body1, 0).
+					AddRow(testKey("key-2", 1), "default", body2, 0).
+					AddRow(testKey("key-3", 1), "default", body3, 0).
+					AddRow(testKey("key-4", 1), "default", body4, 0).
+					AddRow(testKey("key-5", 1), "default", body5, 0).
+					AddRow(testKey("key-6", 1), "default", body6, 0),
 			).RowsWillBeClosed()
+
+		for j := 1; j <= 6; j++ {
+			mockGetReleaseCustomLabels(mock, testKey(fmt.Sprintf("key-%d", j), 1), "default", nil)
+		}
 	}
 
 	// list all deleted releases
@@ -264,7 +280,7 @@ func TestSqlUpdate(t *testing.T) {
 	body, _ := encodeRelease(rel)
 
 	query := fmt.Sprintf(
-		"UPDATE %s SET %s = $1, %s = $2, %s = $3, %s = $4, %s = $5, %s = $6 WHERE %s = $7 AND %s = $8",
+		"UPDATE %s SET %s = $1, %s = $2, %s = $3, %s = $4, %s = $5, %s = $6, %s = %s + 1 WHERE %s = $7 AND %s = $8",
 		sqlReleaseTableName,
 		sqlReleaseTableBodyColumn,
 		sqlReleaseTableNameColumn,
@@ -272,14 +288,20 @@ func TestSqlUpdate(t *testing.T) {
 		sqlReleaseTableStatusColumn,
 		sqlReleaseTableOwnerColumn,
 		sqlReleaseTableModifiedAtColumn,
+		sqlReleaseTableGenerationColumn,
+		sqlReleaseTableGenerationColumn,
 		sqlReleaseTableKeyColumn,
 		sqlReleaseTableNamespaceColumn,
 	)
 
+	mock.ExpectBegin()
 	mock.
 		ExpectExec(regexp.QuoteMeta(query)).
 		WithArgs(body, rel.Name, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix()), key, namespace).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDeleteReleaseCustomLabels(mock, key, namespace)
+	mockInsertReleaseCustomLabels(mock, key, namespace, rel.Labels)
+	mock.ExpectCommit()
 
 	if err := sqlDriver.Update(key, rel); err != nil {
 		t.Fatalf("failed to update release with key %s: %v", key, err)
@@ -290,6 +312,47 @@ func TestSqlUpdate(t *testing.T) {
 	}
 }
 
+func TestSqlUpdateNotFound(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue"}
+
+	sqlDriver, mock := newTestFixtureSQL(t)
+
+	query := fmt.Sprintf(
+		"UPDATE %s SET %s = $1, %s = $2, %s = $3, %s = $4, %s = $5, %s = $6, %s = %s + 1 WHERE %s = $7 AND %s = $8",
+		sqlReleaseTableName,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableNameColumn,
+		sqlReleaseTableVersionColumn,
+		sqlReleaseTableStatusColumn,
+		sqlReleaseTableOwnerColumn,
+		sqlReleaseTableModifiedAtColumn,
+		sqlReleaseTableGenerationColumn,
+		sqlReleaseTableGenerationColumn,
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
+	)
+
+	// no custom labels are written for a release that does not exist
+	mock.ExpectBegin()
+	mock.
+		ExpectExec(regexp.QuoteMeta(query)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := sqlDriver.Update(key, rel); err != ErrReleaseNotFound {
+		t.Fatalf("Expected %v, got %v", ErrReleaseNotFound, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("sql expectations weren't met: %v", err)
+	}
+}
+
 func TestSqlQuery(t *testing.T) {
 	// Reflect actual use cases in ../storage.go
 	labelSetUnknown := map[string]string{
@@ -311,13 +374,18 @@ func TestSqlQuery(t *testing.T) {
 	supersededReleaseBody, _ := encodeRelease(supersededRelease)
 	deployedRelease := releaseStub("smug-pigeon", 2, "default", rspb.StatusDeployed)
 	deployedReleaseBody, _ := encodeRelease(deployedRelease)
+	supersededRelease.StorageVersion = "0"
+	deployedRelease.StorageVersion = "0"
 
 	// Let's actually start our test
 	sqlDriver, mock := newTestFixtureSQL(t)
 
 	query := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s = $1 AND %s = $2 AND %s = $3 AND %s = $4",
+		"SELECT %s, %s, %s, %s FROM %s WHERE %s = $1 AND %s = $2 AND %s = $3 AND %s = $4",
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableGenerationColumn,
 		sqlReleaseTableName,
 		sqlReleaseTableNameColumn,
 		sqlReleaseTableOwnerColumn,
@@ -330,7 +398,10 @@ func TestSqlQuery(t *testing.T) {
 		WithArgs("smug-pigeon", sqlReleaseDefaultOwner, "unknown", "default").
 		WillReturnRows(
 			mock.NewRows([]string{
+				sqlReleaseTableKeyColumn,
+				sqlReleaseTableNamespaceColumn,
 				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
 			}),
 		).RowsWillBeClosed()
 
@@ -339,15 +410,22 @@ func TestSqlQuery(t *testing.T) {
 		WithArgs("smug-pigeon", sqlReleaseDefaultOwner, "deployed", "default").
 		WillReturnRows(
 			mock.NewRows([]string{
+				sqlReleaseTableKeyColumn,
+				sqlReleaseTableNamespaceColumn,
 				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
 			}).AddRow(
-				deployedReleaseBody,
+				testKey("smug-pigeon", 2), "default", deployedReleaseBody, 0,
 			),
 		).RowsWillBeClosed()
+	mockGetReleaseCustomLabels(mock, testKey("smug-pigeon", 2), "default", nil)
 
 	query = fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s = $1 AND %s = $2 AND %s = $3",
+		"SELECT %s, %s, %s, %s FROM %s WHERE %s = $1 AND %s = $2 AND %s = $3",
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableGenerationColumn,
 		sqlReleaseTableName,
 		sqlReleaseTableNameColumn,
 		sqlReleaseTableOwnerColumn,
@@ -359,13 +437,18 @@ func TestSqlQuery(t *testing.T) {
 		WithArgs("smug-pigeon", sqlReleaseDefaultOwner, "default").
 		WillReturnRows(
 			mock.NewRows([]string{
+				sqlReleaseTableKeyColumn,
+				sqlReleaseTableNamespaceColumn,
 				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
 			}).AddRow(
-				supersededReleaseBody,
+				testKey("smug-pigeon", 1), "default", supersededReleaseBody, 0,
 			).AddRow(
-				deployedReleaseBody,
+				testKey("smug-pigeon", 2), "default", deployedReleaseBody, 0,
 			),
 		).RowsWillBeClosed()
+	mockGetReleaseCustomLabels(mock, testKey("smug-pigeon", 1), "default", nil)
+	mockGetReleaseCustomLabels(mock, testKey("smug-pigeon", 2), "default", nil)
 
 	_, err := sqlDriver.Query(labelSetUnknown)
 	if err == nil {
@@ -413,12 +496,14 @@ func TestSqlDelete(t *testing.T) {
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
 	body, _ := encodeRelease(rel)
+	rel.StorageVersion = "0"
 
 	sqlDriver, mock := newTestFixtureSQL(t)
 
 	selectQuery := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s = $1 AND %s = $2",
+		"SELECT %s, %s FROM %s WHERE %s = $1 AND %s = $2",
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableGenerationColumn,
 		sqlReleaseTableName,
 		sqlReleaseTableKeyColumn,
 		sqlReleaseTableNamespaceColumn,
@@ -431,10 +516,12 @@ func TestSqlDelete(t *testing.T) {
 		WillReturnRows(
 			mock.NewRows([]string{
 				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
 			}).AddRow(
-				body,
+				body, 0,
 			),
 		).RowsWillBeClosed()
+	mockGetReleaseCustomLabels(mock, key, namespace, rel.Labels)
 
 	deleteQuery := fmt.Sprintf(
 		"DELETE FROM %s WHERE %s = $1 AND %s = $2",
@@ -447,6 +534,7 @@ func TestSqlDelete(t *testing.T) {
 		ExpectExec(regexp.QuoteMeta(deleteQuery)).
 		WithArgs(key, namespace).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDeleteReleaseCustomLabels(mock, key, namespace)
 	mock.ExpectCommit()
 
 	deletedRelease, err := sqlDriver.Delete(key)
@@ -461,3 +549,154 @@ func TestSqlDelete(t *testing.T) {
 		t.Errorf("Expected release {%v}, got {%v}", rel, deletedRelease)
 	}
 }
+
+func TestSqlCustomLabels(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+	rel.Labels = map[string]string{"team": "blue", "tier": "backend"}
+
+	sqlDriver, mock := newTestFixtureSQL(t)
+	body, _ := encodeRelease(rel)
+
+	insertQuery := fmt.Sprintf(
+		"INSERT INTO %s (%s,%s,%s,%s,%s,%s,%s,%s,%s) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)",
+		sqlReleaseTableName,
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableTypeColumn,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableNameColumn,
+		sqlReleaseTableNamespaceColumn,
+		sqlReleaseTableVersionColumn,
+		sqlReleaseTableStatusColumn,
+		sqlReleaseTableOwnerColumn,
+		sqlReleaseTableCreatedAtColumn,
+	)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec(regexp.QuoteMeta(insertQuery)).
+		WithArgs(key, sqlReleaseDefaultType, body, rel.Name, rel.Namespace, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix())).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockInsertReleaseCustomLabels(mock, key, namespace, rel.Labels)
+	mock.ExpectCommit()
+
+	if err := sqlDriver.Create(key, rel); err != nil {
+		t.Fatalf("failed to create release with key %s: %v", key, err)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s, %s, %s, %s FROM %s WHERE %s = $1 AND (%s, %s) IN (SELECT %s, %s FROM %s WHERE %s = $2 AND %s = $3) AND %s = $4",
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableGenerationColumn,
+		sqlReleaseTableName,
+		sqlReleaseTableOwnerColumn,
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
+		sqlCustomLabelsTableReleaseKeyColumn,
+		sqlCustomLabelsTableReleaseNamespaceColumn,
+		sqlCustomLabelsTableName,
+		sqlCustomLabelsTableKeyColumn,
+		sqlCustomLabelsTableValueColumn,
+		sqlReleaseTableNamespaceColumn,
+	)
+
+	mock.
+		ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs(sqlReleaseDefaultOwner, "team", "blue", namespace).
+		WillReturnRows(
+			mock.NewRows([]string{
+				sqlReleaseTableKeyColumn,
+				sqlReleaseTableNamespaceColumn,
+				sqlReleaseTableBodyColumn,
+				sqlReleaseTableGenerationColumn,
+			}).AddRow(
+				key, namespace, body, 0,
+			),
+		).RowsWillBeClosed()
+	mockGetReleaseCustomLabels(mock, key, namespace, rel.Labels)
+
+	results, err := sqlDriver.Query(map[string]string{"team": "blue", "owner": sqlReleaseDefaultOwner})
+	if err != nil {
+		t.Fatalf("failed to query releases with custom labels: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected a resultset of size 1, got %d", len(results))
+	}
+	if !reflect.DeepEqual(rel.Labels, results[0].Labels) {
+		t.Errorf("Expected labels {%v}, got {%v}", rel.Labels, results[0].Labels)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("sql expectations weren't met: %v", err)
+	}
+}
+
+func mockGetReleaseCustomLabels(mock sqlmock.Sqlmock, key string, namespace string, labels map[string]string) {
+	query := fmt.Sprintf(
+		regexp.QuoteMeta("SELECT %s, %s FROM %s WHERE %s = $1 AND %s = $2"),
+		sqlCustomLabelsTableKeyColumn,
+		sqlCustomLabelsTableValueColumn,
+		sqlCustomLabelsTableName,
+		sqlCustomLabelsTableReleaseKeyColumn,
+		sqlCustomLabelsTableReleaseNamespaceColumn,
+	)
+
+	rows := mock.NewRows([]string{
+		sqlCustomLabelsTableKeyColumn,
+		sqlCustomLabelsTableValueColumn,
+	})
+	for _, k := range sortedKeys(labels) {
+		rows.AddRow(k, labels[k])
+	}
+	mock.
+		ExpectQuery(query).
+		WithArgs(key, namespace).
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+}
+
+func mockInsertReleaseCustomLabels(mock sqlmock.Sqlmock, key string, namespace string, labels map[string]string) {
+	query := fmt.Sprintf(
+		regexp.QuoteMeta("INSERT INTO %s (%s,%s,%s,%s) VALUES ($1,$2,$3,$4)"),
+		sqlCustomLabelsTableName,
+		sqlCustomLabelsTableReleaseKeyColumn,
+		sqlCustomLabelsTableReleaseNamespaceColumn,
+		sqlCustomLabelsTableKeyColumn,
+		sqlCustomLabelsTableValueColumn,
+	)
+
+	for _, k := range sortedKeys(labels) {
+		mock.
+			ExpectExec(query).
+			WithArgs(key, namespace, k, labels[k]).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+}
+
+func mockDeleteReleaseCustomLabels(mock sqlmock.Sqlmock, key string, namespace string) {
+	query := fmt.Sprintf(
+		regexp.QuoteMeta("DELETE FROM %s WHERE %s = $1 AND %s = $2"),
+		sqlCustomLabelsTableName,
+		sqlCustomLabelsTableReleaseKeyColumn,
+		sqlCustomLabelsTableReleaseNamespaceColumn,
+	)
+
+	mock.
+		ExpectExec(query).
+		WithArgs(key, namespace).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -56,7 +56,8 @@ func (s *Storage) Get(name string, version int) (*rspb.Release, error) {
 
 // Create creates a new storage entry holding the release. An
 // error is returned if the storage driver failed to store the
-// release, or a release with identical an key already exists.
+// release, or a release with identical an key already exists. The
+// sensitive values of the release are redacted in the stored copy.
 func (s *Storage) Create(rls *rspb.Release) error {
 	s.Log("creating release %q", makeKey(rls.Name, rls.Version))
 	if s.MaxHistory > 0 {
@@ -66,15 +67,22 @@ func (s *Storage) Create(rls *rspb.Release) error {
 			return err
 		}
 	}
-	return s.Driver.Create(makeKey(rls.Name, rls.Version), rls)
+	stored := rls.Redacted()
+	err := s.Driver.Create(makeKey(rls.Name, rls.Version), stored)
+	rls.StorageVersion = stored.StorageVersion
+	return err
 }
 
 // Update updates the release in storage. An error is returned if the
 // storage backend fails to update the release or if the release
-// does not exist.
+// does not exist. The sensitive values of the release are redacted in the
+// stored copy.
 func (s *Storage) Update(rls *rspb.Release) error {
 	s.Log("updating release %q", makeKey(rls.Name, rls.Version))
-	return s.Driver.Update(makeKey(rls.Name, rls.Version), rls)
+	stored := rls.Redacted()
+	err := s.Driver.Update(makeKey(rls.Name, rls.Version), stored)
+	rls.StorageVersion = stored.StorageVersion
+	return err
 }
 
 // Delete deletes the release from storage. An error is returned if
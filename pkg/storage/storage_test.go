@@ -76,6 +76,45 @@ func TestStorageUpdate(t *testing.T) {
 	}
 }
 
+func TestStorageRedactsSensitiveValues(t *testing.T) {
+	storage := Init(driver.NewMemory())
+
+	rls := ReleaseTestData{
+		Name:     "angry-beaver",
+		Version:  1,
+		Manifest: "password: hunter2\nencoded: aHVudGVyMg==\n",
+	}.ToRelease()
+	rls.Hooks = []*rspb.Hook{{Name: "hook", Manifest: "token: hunter2"}}
+	rls.Info.Notes = "the password is hunter2"
+	rls.Redaction = &rspb.Redaction{
+		Manifest: "password: <redacted:env:PASSWORD>\nencoded: <redacted-base64:env:PASSWORD>\n",
+		Notes:    "the password is <redacted:env:PASSWORD>",
+		Hooks:    []string{"token: <redacted:env:PASSWORD>"},
+	}
+
+	assertErrNil(t.Fatal, storage.Create(rls), "StoreRelease")
+	res, err := storage.Get(rls.Name, rls.Version)
+	assertErrNil(t.Fatal, err, "QueryRelease")
+
+	if expect := "password: <redacted:env:PASSWORD>\nencoded: <redacted-base64:env:PASSWORD>\n"; res.Manifest != expect {
+		t.Errorf("Expected manifest %q, got %q", expect, res.Manifest)
+	}
+	if expect := "token: <redacted:env:PASSWORD>"; res.Hooks[0].Manifest != expect {
+		t.Errorf("Expected hook manifest %q, got %q", expect, res.Hooks[0].Manifest)
+	}
+	if expect := "the password is <redacted:env:PASSWORD>"; res.Info.Notes != expect {
+		t.Errorf("Expected notes %q, got %q", expect, res.Info.Notes)
+	}
+	if res.Redaction != nil {
+		t.Errorf("Expected no redaction in the stored release, got %v", res.Redaction)
+	}
+
+	// the release being stored keeps its values
+	if rls.Hooks[0].Manifest != "token: hunter2" || rls.Info.Notes != "the password is hunter2" {
+		t.Errorf("Expected the release to be left unchanged, got %v", rls)
+	}
+}
+
 func TestStorageDelete(t *testing.T) {
 	// initialize storage
 	storage := Init(driver.NewMemory())